@@ -26,10 +26,17 @@ ChartJS.register(
     Legend
 );
 
+// How many points we ask the backend for; it picks a rollup resolution coarse enough to stay within it
+const MAX_POINTS = 1000;
+// How many of the most recent anomalies we list
+const RECENT_ANOMALIES = 10;
+
 const HistoricalGraph = () => {
     const [historicalData, setHistoricalData] = useState([]);
+    const [recentAnomalies, setRecentAnomalies] = useState([]);
     const [startDate, setStartDate] = useState(new Date(new Date().setDate(new Date().getDate() - 7))); // Default to 7 days ago
     const [endDate, setEndDate] = useState(new Date()); // Default to today
+    const [resolution, setResolution] = useState('');
     const [loading, setLoading] = useState(false);
 
     // Fetch data when startDate or endDate changes
@@ -39,7 +46,7 @@ const HistoricalGraph = () => {
             const startISO = startDate.toISOString();
             const endISO = endDate.toISOString();
             try {
-                const response = await fetch(`http://localhost:4000/api/v1/telemetry?start_time=${startISO}&end_time=${endISO}`);
+                const response = await fetch(`http://localhost:4000/api/v1/telemetry/series?start_time=${startISO}&end_time=${endISO}&max_points=${MAX_POINTS}`);
                 if (!response.ok) {
                     throw new Error('Failed to fetch telemetry data');
                 }
@@ -48,6 +55,7 @@ const HistoricalGraph = () => {
                 // Extract the data field
                 if (Array.isArray(jsonResponse.data)) {
                     setHistoricalData(jsonResponse.data);
+                    setResolution(jsonResponse.resolution);
                 } else {
                     console.error('Unexpected response format:', jsonResponse);
                     setHistoricalData([]); // Default to an empty array
@@ -60,7 +68,25 @@ const HistoricalGraph = () => {
             }
         };
 
+        // The series only counts anomalies per bucket, so the packets themselves, with what tripped, come from here
+        const fetchAnomalies = async () => {
+            const startISO = startDate.toISOString();
+            const endISO = endDate.toISOString();
+            try {
+                const response = await fetch(`http://localhost:4000/api/v1/telemetry/anomalies?start_time=${startISO}&end_time=${endISO}&limit=${RECENT_ANOMALIES}`);
+                if (!response.ok) {
+                    throw new Error('Failed to fetch anomalies');
+                }
+                const jsonResponse = await response.json();
+                setRecentAnomalies(Array.isArray(jsonResponse.data) ? jsonResponse.data : []);
+            } catch (error) {
+                console.error('Error fetching anomalies:', error);
+                setRecentAnomalies([]);
+            }
+        };
+
         fetchData();
+        fetchAnomalies();
     }, [startDate, endDate]);
 
     // Prepare data for the chart
@@ -69,25 +95,25 @@ const HistoricalGraph = () => {
         datasets: [
             {
                 label: 'Temperature',
-                data: historicalData.map((entry) => entry.temperature),
+                data: historicalData.map((entry) => entry.temperature.avg),
                 borderColor: 'rgba(75, 192, 192, 1)',
                 borderWidth: 2,
             },
             {
                 label: 'Battery',
-                data: historicalData.map((entry) => entry.battery),
+                data: historicalData.map((entry) => entry.battery.avg),
                 borderColor: 'rgba(255, 99, 132, 1)',
                 borderWidth: 2,
             },
             {
                 label: 'Altitude',
-                data: historicalData.map((entry) => entry.altitude),
+                data: historicalData.map((entry) => entry.altitude.avg),
                 borderColor: 'rgb(130,116,255)',
                 borderWidth: 2,
             },
             {
                 label: 'Signal',
-                data: historicalData.map((entry) => entry.signal),
+                data: historicalData.map((entry) => entry.signal.avg),
                 borderColor: 'rgb(251,213,122)',
                 borderWidth: 2,
             },
@@ -127,19 +153,19 @@ const HistoricalGraph = () => {
             {loading ? (
                 <p>Loading historical data...</p>
             ) : (
-                <Line data={chartData} />
+                <>
+                    <p>Resolution: {resolution === 'raw' ? 'every packet' : `${resolution} averages`}</p>
+                    <Line data={chartData} />
+                </>
             )}
             <div>
-                <h2>Recent Anomalies (Most Recent 10)</h2>
+                <h2>Recent Anomalies (Most Recent {RECENT_ANOMALIES})</h2>
                 <ul>
-                    {historicalData
-                        .filter((entry) => entry.anomalies && entry.anomalies.length > 0)
-                        .slice(-10) // Display the last 10 anomalies
-                        .map((entry, index) => (
-                            <li key={index}>
-                                {new Date(entry.timestamp).toLocaleString()}: {entry.anomalies.join(', ')}
-                            </li>
-                        ))}
+                    {recentAnomalies.map((entry, index) => (
+                        <li key={index}>
+                            {new Date(entry.timestamp).toLocaleString()}: {(entry.anomalies || []).join(', ')}
+                        </li>
+                    ))}
                 </ul>
             </div>
         </div>
@@ -14,6 +14,18 @@ const TelemetryTable = () => {
                 // Parse the incoming message
                 const data = JSON.parse(event.data);
 
+                // Link state changes are wrapped with a type, telemetry is sent as is
+                if (data.type === 'link_state') {
+                    const link = data.data;
+                    const message = `${link.spacecraft} APID ${link.apid}: ${link.state === 'LOS' ? 'Loss of signal' : 'Signal acquired'}`;
+                    if (link.state === 'LOS') {
+                        toast.warn(message, { position: "top-right", autoClose: 5000 });
+                    } else {
+                        toast.info(message, { position: "top-right", autoClose: 5000 });
+                    }
+                    return;
+                }
+
                 // Update the table with the new telemetry data
                 setTelemetryData((prevData) => [data, ...prevData].slice(0, 10)); // Keep only the last 10 entries
 
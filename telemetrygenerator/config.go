@@ -0,0 +1,199 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// telemetry models
+const (
+	modelRandom  = "random"
+	modelPhysics = "physics"
+)
+
+// config is everything the generator can be told, read from the environment and then its flags
+type config struct {
+	//telemetry goes to every target, or to outputPath instead if it's set
+	targets     []string
+	outputPath  string
+	packetDelay time.Duration
+	workers     int
+	//housekeeping packets a second between all the workers, sent in bursts of burst packets. Zero is as fast as
+	//possible
+	rate  float64
+	burst int
+	//stop after count housekeeping packets or after duration, whichever comes first. Zero is never
+	count    uint64
+	duration time.Duration
+	//the fraction of random model packets that are anomalous
+	anomalyRate float64
+	//where to write ground truth labels for every housekeeping packet, none if empty
+	labelsPath string
+	//the HTTP control API, off if empty. It's unauthenticated, so it only listens locally unless told otherwise
+	controlAddr string
+	//telemetry model: random, or physics for a vehicle in orbit. timeScale runs the vehicle faster than the clock
+	model     string
+	timeScale float64
+	//simulator mode: telemetry comes from a vehicle model that reacts to telecommands received on uplinkAddr
+	simulator  bool
+	uplinkAddr string
+	//scenario mode: telemetry plays back a scripted timeline, one scenarioStep of scenario time per packet. A seeded
+	//physics model moves on a scenarioStep a packet too
+	scenarioPath string
+	seed         int64
+	scenarioStep time.Duration
+	//packet types besides housekeeping: attitude packets a second, and on board events. The ingestion service doesn't
+	//ingest these, it counts and drops them
+	attitudeRate float64
+	events       bool
+	//transport faults injected into telemetry on its way out
+	faults faultConfig
+	//every packet sent is logged at debug
+	logLevel string
+}
+
+func configFromEnv() config {
+	cfg := config{
+		targets:      strings.Split(stringFromEnv("TARGET_ADDR", "telemetryingestion:8089"), ","),
+		outputPath:   stringFromEnv("OUTPUT_FILE", ""),
+		packetDelay:  durationFromEnv("PACKET_DELAY", 500*time.Millisecond),
+		workers:      intFromEnv("WORKERS", 10),
+		rate:         floatFromEnv("RATE", 0),
+		burst:        intFromEnv("BURST", 1),
+		count:        uint64(int64FromEnv("COUNT", 0)),
+		duration:     durationFromEnv("DURATION", 0),
+		anomalyRate:  floatFromEnv("ANOMALY_RATE", 0.2),
+		labelsPath:   stringFromEnv("LABELS_FILE", ""),
+		controlAddr:  stringFromEnv("CONTROL_ADDR", "127.0.0.1:8091"),
+		model:        stringFromEnv("TELEMETRY_MODEL", modelRandom),
+		timeScale:    floatFromEnv("TIME_SCALE", 1),
+		simulator:    boolFromEnv("SIMULATOR", false),
+		uplinkAddr:   stringFromEnv("UPLINK_LISTEN_ADDR", "0.0.0.0:8090"),
+		scenarioPath: stringFromEnv("SCENARIO", ""),
+		seed:         int64FromEnv("SEED", 0),
+		attitudeRate: floatFromEnv("ATTITUDE_RATE", 0),
+		events:       boolFromEnv("EVENTS", false),
+		logLevel:     stringFromEnv("LOG_LEVEL", "info"),
+		faults: faultConfig{
+			bitFlip:       floatFromEnv("FAULT_BIT_FLIP", 0),
+			truncate:      floatFromEnv("FAULT_TRUNCATE", 0),
+			badLength:     floatFromEnv("FAULT_BAD_LENGTH", 0),
+			duplicate:     floatFromEnv("FAULT_DUPLICATE", 0),
+			reorder:       floatFromEnv("FAULT_REORDER", 0),
+			reorderWindow: intFromEnv("FAULT_REORDER_WINDOW", 5),
+			lossBurst:     floatFromEnv("FAULT_LOSS_BURST", 0),
+			lossBurstMax:  intFromEnv("FAULT_LOSS_BURST_MAX", 10),
+			garbage:       floatFromEnv("FAULT_GARBAGE", 0),
+			logPath:       stringFromEnv("FAULT_LOG", ""),
+		},
+	}
+	return cfg
+}
+
+// parseFlags overrides the config with any flags given. Whatever the environment said is the default for each
+func (cfg *config) parseFlags(args []string) error {
+	flags := flag.NewFlagSet("telemetrygenerator", flag.ContinueOnError)
+	targets := flags.String("target", strings.Join(cfg.targets, ","), "comma separated addresses to send telemetry to")
+	flags.StringVar(&cfg.outputPath, "out", cfg.outputPath, "write packets to this file instead of sending them")
+	flags.IntVar(&cfg.workers, "workers", cfg.workers, "number of housekeeping workers")
+	flags.Float64Var(&cfg.rate, "rate", cfg.rate, "housekeeping packets per second, defaults to workers every packet delay")
+	flags.IntVar(&cfg.burst, "burst", cfg.burst, "send packets in bursts of this many, keeping to the rate on average")
+	flags.Uint64Var(&cfg.count, "count", cfg.count, "stop after this many housekeeping packets")
+	flags.DurationVar(&cfg.duration, "duration", cfg.duration, "stop after this long")
+	flags.Int64Var(&cfg.seed, "seed", cfg.seed, "seed for telemetry, attitude, scenarios and faults, random if zero")
+	flags.Float64Var(&cfg.anomalyRate, "anomaly-rate", cfg.anomalyRate, "fraction of random telemetry that's anomalous")
+	flags.StringVar(&cfg.labelsPath, "labels", cfg.labelsPath, "write ground truth anomaly labels for every packet to this file")
+	flags.StringVar(&cfg.controlAddr, "control", cfg.controlAddr, "address for the HTTP control API, empty for none")
+	flags.StringVar(&cfg.scenarioPath, "scenario", cfg.scenarioPath, "scenario file to play back")
+	flags.Float64Var(&cfg.attitudeRate, "attitude-rate", cfg.attitudeRate, "attitude packets per second on APID 2, none if zero; ingestion counts and drops them")
+	flags.BoolVar(&cfg.events, "events", cfg.events, "send on board events on APID 3; ingestion counts and drops them")
+	flags.StringVar(&cfg.logLevel, "log-level", cfg.logLevel, "log level, debug to log every packet")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	cfg.targets = strings.Split(*targets, ",")
+
+	//the rate defaults to what the workers used to manage each sleeping the packet delay
+	rateSet := false
+	flags.Visit(func(f *flag.Flag) {
+		rateSet = rateSet || f.Name == "rate"
+	})
+	if !rateSet && os.Getenv("RATE") == "" && cfg.packetDelay > 0 {
+		cfg.rate = float64(cfg.workers) / cfg.packetDelay.Seconds()
+	}
+	if cfg.rate < 0 || cfg.burst < 1 || cfg.workers < 1 {
+		return fmt.Errorf("rate can't be negative, and burst and workers must be at least 1")
+	}
+	//a scenario, or a seeded vehicle, is played back by a single worker, so every sample lands on the same sequence
+	//count run to run. The pacer still keeps to the rate the workers would have
+	if cfg.scenarioPath != "" || (cfg.seed != 0 && (cfg.model == modelPhysics || cfg.simulator)) {
+		cfg.workers = 1
+	}
+	if cfg.anomalyRate < 0 || cfg.anomalyRate > 1 {
+		return fmt.Errorf("anomaly rate must be between 0 and 1")
+	}
+
+	//by default scenario time keeps pace with the packets
+	var step time.Duration
+	if cfg.rate > 0 {
+		step = time.Duration(float64(time.Second) / cfg.rate)
+	}
+	cfg.scenarioStep = durationFromEnv("SCENARIO_STEP", step)
+	return nil
+}
+
+func stringFromEnv(name string, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	if value := os.Getenv(name); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+func intFromEnv(name string, def int) int {
+	if value := os.Getenv(name); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+func int64FromEnv(name string, def int64) int64 {
+	if value := os.Getenv(name); value != "" {
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+func floatFromEnv(name string, def float64) float64 {
+	if value := os.Getenv(name); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+func boolFromEnv(name string, def bool) bool {
+	if value := os.Getenv(name); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return def
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pacer sets the pace for the housekeeping workers between them: rate packets a second, released burst at a time,
+// until count have gone. Rate, burst and the anomaly rate can all change while it runs
+type pacer struct {
+	mu           sync.Mutex
+	rate         float64
+	burst        int
+	windowStart  time.Time
+	sentInWindow int
+	limited      bool
+	remaining    uint64
+	//float64 bits, read by every worker for every packet
+	anomalyRate uint64
+}
+
+func newPacer(rate float64, burst int, count uint64, anomalyRate float64) *pacer {
+	return &pacer{rate: rate, burst: burst, limited: count > 0, remaining: count, anomalyRate: math.Float64bits(anomalyRate)}
+}
+
+// wait blocks until it's time for the next packet, giving back false when there are no more to send
+func (p *pacer) wait(ctx context.Context) bool {
+	p.mu.Lock()
+	if p.limited {
+		if p.remaining == 0 {
+			p.mu.Unlock()
+			return false
+		}
+		p.remaining--
+	}
+	var delay time.Duration
+	if p.rate > 0 {
+		now := time.Now()
+		window := time.Duration(float64(p.burst) / p.rate * float64(time.Second))
+		if p.sentInWindow >= p.burst {
+			p.windowStart = p.windowStart.Add(window)
+			p.sentInWindow = 0
+		}
+		//after a stall, or a rate change, carry on from now rather than rushing to catch up
+		if p.windowStart.Before(now.Add(-window)) {
+			p.windowStart = now
+		}
+		p.sentInWindow++
+		delay = p.windowStart.Sub(now)
+	}
+	p.mu.Unlock()
+
+	if delay <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (p *pacer) getAnomalyRate() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&p.anomalyRate))
+}
+
+// settings are what the control API can change. Anything left out of an update stays as it is
+type settings struct {
+	Rate        *float64 `json:"rate_pps,omitempty"`
+	Burst       *int     `json:"burst,omitempty"`
+	AnomalyRate *float64 `json:"anomaly_rate,omitempty"`
+}
+
+func (p *pacer) settings() settings {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rate, burst, anomalyRate := p.rate, p.burst, p.getAnomalyRate()
+	return settings{Rate: &rate, Burst: &burst, AnomalyRate: &anomalyRate}
+}
+
+// update applies a change from the control API. A rate of zero, as fast as possible, can only be asked for on the
+// command line; over the API it's much more likely to be a mistake than a request to flood the ingestion service
+func (p *pacer) update(s settings) error {
+	if (s.Rate != nil && *s.Rate <= 0) || (s.Burst != nil && *s.Burst < 1) ||
+		(s.AnomalyRate != nil && (*s.AnomalyRate < 0 || *s.AnomalyRate > 1)) {
+		return errors.New("rate_pps must be positive, burst at least 1 and anomaly_rate between 0 and 1")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s.Rate != nil {
+		p.rate = *s.Rate
+	}
+	if s.Burst != nil {
+		p.burst = *s.Burst
+		p.sentInWindow = 0
+	}
+	if s.AnomalyRate != nil {
+		atomic.StoreUint64(&p.anomalyRate, math.Float64bits(*s.AnomalyRate))
+	}
+	return nil
+}
+
+// stats are the generator's live numbers, as the control API serves them
+type stats struct {
+	Uptime     string            `json:"uptime"`
+	Sent       uint64            `json:"sent"`
+	SendErrors uint64            `json:"send_errors"`
+	Streams    map[string]uint64 `json:"streams"`
+	ActualRate float64           `json:"actual_rate_pps"`
+	Settings   settings          `json:"settings"`
+}
+
+// controller serves the control API, and measures the actual send rate once a second for it
+type controller struct {
+	pacer   *pacer
+	streams []*packetStream
+	started time.Time
+
+	mu         sync.Mutex
+	lastCount  uint64
+	lastTime   time.Time
+	actualRate float64
+}
+
+func newController(p *pacer, streams []*packetStream) *controller {
+	now := time.Now()
+	return &controller{pacer: p, streams: streams, started: now, lastTime: now}
+}
+
+func (c *controller) run(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", c.handleStats)
+	mux.HandleFunc("/settings", c.handleSettings)
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		log.Infof("control API listening on %s", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("control API failed: %v", err)
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			server.Shutdown(shutdownCtx)
+			cancel()
+			return
+		case now := <-ticker.C:
+			count := atomic.LoadUint64(&packetCount)
+			c.mu.Lock()
+			c.actualRate = float64(count-c.lastCount) / now.Sub(c.lastTime).Seconds()
+			c.lastCount, c.lastTime = count, now
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *controller) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c.mu.Lock()
+	actualRate := c.actualRate
+	c.mu.Unlock()
+
+	s := stats{
+		Uptime:     time.Since(c.started).Round(time.Second).String(),
+		Sent:       atomic.LoadUint64(&packetCount),
+		SendErrors: atomic.LoadUint64(&sendErrors),
+		Streams:    make(map[string]uint64),
+		ActualRate: actualRate,
+		Settings:   c.pacer.settings(),
+	}
+	for _, stream := range c.streams {
+		s.Streams[stream.name] = atomic.LoadUint64(&stream.sent)
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (c *controller) handleSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPatch:
+		var update settings
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "invalid settings: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := c.pacer.update(update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Infof("settings changed: %s", describeSettings(c.pacer.settings()))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, c.pacer.settings())
+}
+
+func describeSettings(s settings) string {
+	contents, _ := json.Marshal(s)
+	return string(contents)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPacerCount(t *testing.T) {
+	p := newPacer(0, 1, 3, 0)
+	for i := 0; i < 3; i++ {
+		if !p.wait(context.Background()) {
+			t.Fatalf("stopped after %d packets, expected 3", i)
+		}
+	}
+	if p.wait(context.Background()) {
+		t.Error("carried on past the count")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if newPacer(0, 1, 0, 0).wait(ctx) || newPacer(1, 1, 0, 0).wait(ctx) {
+		t.Error("carried on after ctx was done")
+	}
+}
+
+func TestPacerBursts(t *testing.T) {
+	//bursts of five, four times a second
+	p := newPacer(20, 5, 0, 0)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		p.wait(context.Background())
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("the first burst took %v, expected it straight away", elapsed)
+	}
+	p.wait(context.Background())
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Errorf("the next burst started after %v, expected 250ms", elapsed)
+	}
+}
+
+func TestPacerRate(t *testing.T) {
+	p := newPacer(100, 1, 0, 0)
+	start := time.Now()
+	for i := 0; i < 21; i++ {
+		p.wait(context.Background())
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Errorf("21 packets at 100 a second took %v, expected 200ms", elapsed)
+	}
+}
+
+func TestPacerUpdate(t *testing.T) {
+	rate, burst, anomalyRate := 50.0, 10, 0.5
+	zero, negative, tooHigh := 0.0, -1.0, 1.5
+	noBurst := 0
+
+	p := newPacer(10, 1, 0, 0.2)
+	invalid := []settings{{Rate: &zero}, {Rate: &negative}, {Burst: &noBurst}, {AnomalyRate: &tooHigh},
+		{AnomalyRate: &negative}, {Rate: &rate, Burst: &noBurst}}
+	for _, update := range invalid {
+		if err := p.update(update); err == nil {
+			t.Errorf("accepted %s", describeSettings(update))
+		}
+	}
+	if s := p.settings(); *s.Rate != 10 || *s.Burst != 1 || *s.AnomalyRate != 0.2 {
+		t.Errorf("invalid updates changed the settings to %s", describeSettings(s))
+	}
+
+	//anything left out stays as it was
+	if err := p.update(settings{Rate: &rate}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.update(settings{Burst: &burst, AnomalyRate: &anomalyRate}); err != nil {
+		t.Fatal(err)
+	}
+	if s := p.settings(); *s.Rate != rate || *s.Burst != burst || *s.AnomalyRate != anomalyRate ||
+		p.getAnomalyRate() != anomalyRate {
+		t.Errorf("settings are %s", describeSettings(s))
+	}
+}
+
+func TestHandleSettings(t *testing.T) {
+	p := newPacer(10, 1, 0, 0.2)
+	c := newController(p, nil)
+
+	tests := []struct {
+		method, body string
+		status       int
+		rate         float64
+		burst        int
+	}{
+		{http.MethodGet, "", http.StatusOK, 10, 1},
+		{http.MethodPut, `{"rate_pps": 250}`, http.StatusOK, 250, 1},
+		{http.MethodPatch, `{"burst": 25}`, http.StatusOK, 250, 25},
+		//flooding ingestion can only be asked for on the command line
+		{http.MethodPut, `{"rate_pps": 0}`, http.StatusBadRequest, 250, 25},
+		{http.MethodPut, `{"burst": 0}`, http.StatusBadRequest, 250, 25},
+		{http.MethodPut, `{"rate_pps": "fast"}`, http.StatusBadRequest, 250, 25},
+		{http.MethodDelete, "", http.StatusMethodNotAllowed, 250, 25},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		c.handleSettings(recorder, httptest.NewRequest(test.method, "/settings", strings.NewReader(test.body)))
+		if recorder.Code != test.status {
+			t.Errorf("%s %s: got %d, expected %d", test.method, test.body, recorder.Code, test.status)
+		}
+		if s := p.settings(); *s.Rate != test.rate || *s.Burst != test.burst {
+			t.Errorf("%s %s: settings are %s", test.method, test.body, describeSettings(s))
+		}
+		if recorder.Code != http.StatusOK {
+			continue
+		}
+		var served settings
+		if err := json.NewDecoder(recorder.Body).Decode(&served); err != nil {
+			t.Fatal(err)
+		}
+		if served.Rate == nil || *served.Rate != test.rate || served.Burst == nil || *served.Burst != test.burst {
+			t.Errorf("%s %s: served %s", test.method, test.body, describeSettings(served))
+		}
+	}
+}
+
+func TestHandleStats(t *testing.T) {
+	housekeeping := newPacketStream("housekeeping", APID, SUBSYSTEM_ID, &captureDownlink{})
+	for i := 0; i < 3; i++ {
+		housekeeping.send(housekeeping.nextSeqCount(), nil)
+	}
+	c := newController(newPacer(10, 1, 0, 0.2), []*packetStream{housekeeping})
+
+	recorder := httptest.NewRecorder()
+	c.handleStats(recorder, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got %d", recorder.Code)
+	}
+	var served stats
+	if err := json.NewDecoder(recorder.Body).Decode(&served); err != nil {
+		t.Fatal(err)
+	}
+	if served.Streams["housekeeping"] != 3 || *served.Settings.Rate != 10 {
+		t.Errorf("served %+v", served)
+	}
+
+	recorder = httptest.NewRecorder()
+	c.handleStats(recorder, httptest.NewRequest(http.MethodPost, "/stats", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// transport faults
+const (
+	faultBitFlip   = "bit_flip"
+	faultTruncate  = "truncate"
+	faultBadLength = "bad_length"
+	faultDuplicate = "duplicate"
+	faultReorder   = "reorder"
+	faultLoss      = "loss"
+	faultGarbage   = "garbage"
+)
+
+// what the ingestion service should do with a faulted packet
+const (
+	expectRejected = "rejected"
+	expectAccepted = "accepted"
+	expectDropped  = "dropped"
+	expectLost     = "lost"
+)
+
+const primaryHeaderSize = 6
+
+// faultConfig is how often each fault happens, as a probability per packet
+type faultConfig struct {
+	bitFlip   float64
+	truncate  float64
+	badLength float64
+	duplicate float64
+	reorder   float64
+	//how many packets a reordered packet is held back behind
+	reorderWindow int
+	//a loss burst starts with probability lossBurst and drops up to lossBurstMax packets
+	lossBurst    float64
+	lossBurstMax int
+	garbage      float64
+	logPath      string
+}
+
+func (f faultConfig) enabled() bool {
+	return f.bitFlip > 0 || f.truncate > 0 || f.badLength > 0 || f.duplicate > 0 || f.reorder > 0 ||
+		f.lossBurst > 0 || f.garbage > 0
+}
+
+// faultRecord is a line of the ground truth log: one injected fault and what it should have done to the packet.
+// Timestamp is the packet's secondary header timestamp, so a record can be matched with the packet's label
+type faultRecord struct {
+	Time      time.Time `json:"time"`
+	Fault     string    `json:"fault"`
+	APID      uint16    `json:"apid"`
+	Timestamp int64     `json:"timestamp"`
+	SeqCount  uint16    `json:"seq_count"`
+	Length    int       `json:"length"`
+	Detail    string    `json:"detail,omitempty"`
+	Expect    string    `json:"expect"`
+}
+
+type heldPacket struct {
+	packet []byte
+	after  int
+}
+
+// faultInjector corrupts, drops, repeats and reorders telemetry on its way to the downlink, logging every fault it
+// injects. Packets go through one at a time, so the log is in the order the datagrams were sent
+type faultInjector struct {
+	mu         sync.Mutex
+	config     faultConfig
+	downlink   downlink
+	randSource *rand.Rand
+	//the ground truth log, if it's going to a file
+	groundLog *json.Encoder
+	closer    io.Closer
+	//packets left to drop in the current loss burst
+	lossRemaining int
+	held          []heldPacket
+}
+
+func newFaultInjector(config faultConfig, next downlink, seed int64) (*faultInjector, error) {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	f := &faultInjector{config: config, downlink: next, randSource: rand.New(rand.NewSource(seed))}
+	if config.reorderWindow < 1 {
+		f.config.reorderWindow = 1
+	}
+	if config.lossBurstMax < 1 {
+		f.config.lossBurstMax = 1
+	}
+
+	if config.logPath != "" {
+		file, err := os.Create(config.logPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create fault log: %w", err)
+		}
+		f.groundLog = json.NewEncoder(file)
+		f.closer = file
+	}
+	return f, nil
+}
+
+func (f *faultInjector) send(packet []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	//packets already held count down with every packet that comes through after them, lost or not
+	waiting := len(f.held)
+	err := f.inject(packet)
+	if releaseErr := f.release(waiting); err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+func (f *faultInjector) inject(packet []byte) error {
+	if f.lossRemaining == 0 && f.hit(f.config.lossBurst) {
+		f.lossRemaining = 1 + f.randSource.Intn(f.config.lossBurstMax)
+	}
+	if f.lossRemaining > 0 {
+		f.lossRemaining--
+		f.record(faultLoss, packet, 0, fmt.Sprintf("%d more to drop in this burst", f.lossRemaining), expectLost)
+		return nil
+	}
+
+	if f.hit(f.config.garbage) {
+		garbage := make([]byte, 1+f.randSource.Intn(64))
+		f.randSource.Read(garbage)
+		f.record(faultGarbage, packet, len(garbage), "sent ahead of this packet", outcome(garbage))
+		if err := f.downlink.send(garbage); err != nil {
+			return err
+		}
+	}
+
+	//a packet can take more than one of these, so they're all logged with where the packet ended up
+	original := packet
+	packet = append([]byte(nil), packet...)
+	var mutations [][2]string
+	if f.hit(f.config.bitFlip) {
+		bit := f.randSource.Intn(len(packet) * 8)
+		packet[bit/8] ^= 1 << (7 - bit%8)
+		mutations = append(mutations, [2]string{faultBitFlip, fmt.Sprintf("bit %d", bit)})
+	}
+	if f.hit(f.config.badLength) {
+		declared := binary.BigEndian.Uint16(packet[4:6])
+		wrong := declared + uint16(1+f.randSource.Intn(0xFFFF))
+		binary.BigEndian.PutUint16(packet[4:6], wrong)
+		mutations = append(mutations, [2]string{faultBadLength, fmt.Sprintf("PacketLength %d instead of %d", wrong, declared)})
+	}
+	if len(packet) > 1 && f.hit(f.config.truncate) {
+		packet = packet[:1+f.randSource.Intn(len(packet)-1)]
+		mutations = append(mutations, [2]string{faultTruncate, fmt.Sprintf("%d of %d bytes", len(packet), len(original))})
+	}
+	expect := outcome(packet)
+	for _, mutation := range mutations {
+		f.record(mutation[0], original, len(packet), mutation[1], expect)
+	}
+
+	if f.hit(f.config.reorder) {
+		after := 1 + f.randSource.Intn(f.config.reorderWindow)
+		f.held = append(f.held, heldPacket{packet: packet, after: after})
+		f.record(faultReorder, original, len(packet), fmt.Sprintf("sent %d packets late", after), expect)
+		return nil
+	}
+	if err := f.downlink.send(packet); err != nil {
+		return err
+	}
+	if f.hit(f.config.duplicate) {
+		f.record(faultDuplicate, original, len(packet), "", expect)
+		return f.downlink.send(packet)
+	}
+	return nil
+}
+
+// release counts down the first waiting held packets, and sends those whose turn has come
+func (f *faultInjector) release(waiting int) error {
+	var err error
+	held := f.held[:0]
+	for i, h := range f.held {
+		if i < waiting {
+			h.after--
+		}
+		if h.after > 0 {
+			held = append(held, h)
+			continue
+		}
+		if sendErr := f.downlink.send(h.packet); sendErr != nil && err == nil {
+			err = sendErr
+		}
+	}
+	f.held = held
+	return err
+}
+
+// close sends anything still held back and closes the ground truth log
+func (f *faultInjector) close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var err error
+	for _, h := range f.held {
+		if sendErr := f.downlink.send(h.packet); sendErr != nil && err == nil {
+			err = sendErr
+		}
+	}
+	f.held = nil
+	if f.closer != nil {
+		if closeErr := f.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (f *faultInjector) hit(rate float64) bool {
+	return rate > 0 && f.randSource.Float64() < rate
+}
+
+func (f *faultInjector) record(fault string, packet []byte, length int, detail string, expect string) {
+	record := faultRecord{Time: time.Now(), Fault: fault, Length: length, Detail: detail, Expect: expect}
+	if len(packet) >= primaryHeaderSize {
+		record.APID = binary.BigEndian.Uint16(packet[0:2]) & 0x07FF
+		record.SeqCount = binary.BigEndian.Uint16(packet[2:4]) & 0x3FFF
+	}
+	if len(packet) >= primaryHeaderSize+8 {
+		record.Timestamp = int64(binary.BigEndian.Uint64(packet[primaryHeaderSize : primaryHeaderSize+8]))
+	}
+	//without a log of its own the ground truth goes in with everything else the generator logs
+	if f.groundLog == nil {
+		log.WithFields(logrus.Fields{"fault": record.Fault, "apid": record.APID, "timestamp": record.Timestamp,
+			"seq_count": record.SeqCount, "length": record.Length, "detail": record.Detail, "expect": record.Expect}).
+			Info("injected fault")
+		return
+	}
+	if err := f.groundLog.Encode(record); err != nil {
+		log.Errorf("failed to write fault log: %v", err)
+	}
+}
+
+// outcome is what the ingestion service should make of sent. It goes by the APID sent with, corrupted or not: it
+// counts and drops attitude and events, and decodes everything else as housekeeping, throwing out anything whose
+// PacketLength isn't housekeeping's or that's too short to hold what it says it does. There's no checksum, so
+// anything else gets through. Garbage that lands on the ack APID is as good as never a well formed ack
+func outcome(sent []byte) string {
+	if len(sent) >= 2 {
+		switch binary.BigEndian.Uint16(sent[0:2]) & 0x07FF {
+		case ATTITUDE_APID, EVENT_APID:
+			return expectDropped
+		case ACK_APID:
+			return expectRejected
+		}
+	}
+	length := binary.Size(CCSDSSecondaryHeader{}) + binary.Size(TelemetryPayload{})
+	if len(sent) < primaryHeaderSize+length || int(binary.BigEndian.Uint16(sent[4:6])) != length-1 {
+		return expectRejected
+	}
+	return expectAccepted
+}
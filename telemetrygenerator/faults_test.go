@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// housekeepingPacket is a housekeeping packet with seqCount
+func housekeepingPacket(seqCount uint16) []byte {
+	return createPacket(APID, SUBSYSTEM_ID, seqCount, testStart, encodeTelemetryPayload(TelemetryPayload{20, 80, 500, -50}))
+}
+
+// ingests is what the ingestion service's decoder does with a datagram. Acks and the APIDs it doesn't ingest are
+// routed off first, and everything else has to be 25 in PacketLength and long enough for both headers, 10 bytes of
+// secondary header and 16 of payload
+func ingests(datagram []byte) string {
+	var apid uint16
+	if len(datagram) >= 2 {
+		apid = binary.BigEndian.Uint16(datagram[0:2]) & 0x7FF
+	}
+	switch apid {
+	case 0x7F0:
+		return expectRejected
+	case 0x02, 0x03:
+		return expectDropped
+	}
+	if len(datagram) < 6 || binary.BigEndian.Uint16(datagram[4:6]) != 25 || len(datagram) < 6+10+16 {
+		return expectRejected
+	}
+	return expectAccepted
+}
+
+// readFaultLog reads the ground truth log at path
+func readFaultLog(t *testing.T, path string) []faultRecord {
+	t.Helper()
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []faultRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+		if line == "" {
+			continue
+		}
+		var record faultRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestOutcome(t *testing.T) {
+	flipped := housekeepingPacket(1)
+	flipped[20] ^= 0x01
+	longer := housekeepingPacket(1)
+	binary.BigEndian.PutUint16(longer[4:6], 26)
+	event := housekeepingPacket(1)
+	event[1] ^= 0x02
+
+	tests := []struct {
+		name     string
+		sent     []byte
+		expected string
+	}{
+		{"housekeeping", housekeepingPacket(1), expectAccepted},
+		{"flipped payload bit", flipped, expectAccepted},
+		{"trailing byte", append(housekeepingPacket(1), 0), expectAccepted},
+		{"truncated", housekeepingPacket(1)[:31], expectRejected},
+		{"bad length", longer, expectRejected},
+		{"too short for a header", []byte{0x08}, expectRejected},
+		{"attitude", createPacket(ATTITUDE_APID, ATTITUDE_SUBSYSTEM_ID, 1, testStart, make([]byte, 28)), expectDropped},
+		{"event", createPacket(EVENT_APID, EVENT_SUBSYSTEM_ID, 1, testStart, []byte("\x00\x01\x00\x05hello")), expectDropped},
+		{"housekeeping flipped to the event APID", event, expectDropped},
+		{"truncated attitude", []byte{0x08, ATTITUDE_APID, 0xC0}, expectDropped},
+		{"on the ack APID", createPacket(ACK_APID, 0, 1, testStart, make([]byte, 16)), expectRejected},
+	}
+	for _, test := range tests {
+		if got := outcome(test.sent); got != test.expected {
+			t.Errorf("%s: got %s, expected %s", test.name, got, test.expected)
+		}
+		if got := ingests(test.sent); got != test.expected {
+			t.Errorf("%s: ingestion would make it %s, expected %s", test.name, got, test.expected)
+		}
+	}
+}
+
+// TestFaultInjectorExpectations runs every fault over a fixed seed, and checks that what actually arrives, decoded as
+// the ingestion service would, is what the ground truth log said to expect
+func TestFaultInjectorExpectations(t *testing.T) {
+	config := faultConfig{bitFlip: 0.05, truncate: 0.05, badLength: 0.05, duplicate: 0.05, reorder: 0.05,
+		reorderWindow: 5, lossBurst: 0.01, lossBurstMax: 5, garbage: 0.05,
+		logPath: filepath.Join(t.TempDir(), "faults.jsonl")}
+	out := &captureDownlink{}
+	injector, err := newFaultInjector(config, out, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const packets = 2000
+	for i := 0; i < packets; i++ {
+		packet := housekeepingPacket(uint16(i))
+		if i%4 == 3 {
+			packet = createPacket(ATTITUDE_APID, ATTITUDE_SUBSYSTEM_ID, uint16(i), testStart, make([]byte, 28))
+		}
+		if err := injector.send(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := injector.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	arrived := make(map[string]int)
+	for _, datagram := range out.packets {
+		arrived[ingests(datagram)]++
+	}
+
+	//every packet arrives once, unless it's lost or duplicated, as the log expects or, if it wasn't corrupted, as
+	//it was sent. Garbage arrives as well
+	type packetKey struct{ apid, seqCount uint16 }
+	expect := make(map[packetKey]string)
+	copies := make(map[packetKey]int)
+	for i := 0; i < packets; i++ {
+		key := packetKey{APID, uint16(i)}
+		expect[key] = expectAccepted
+		if i%4 == 3 {
+			key.apid = ATTITUDE_APID
+			expect[key] = expectDropped
+		}
+		copies[key] = 1
+	}
+	expected := make(map[string]int)
+	faults := make(map[string]int)
+	for _, record := range readFaultLog(t, config.logPath) {
+		faults[record.Fault]++
+		key := packetKey{record.APID, record.SeqCount}
+		switch record.Fault {
+		case faultGarbage:
+			expected[record.Expect]++
+		case faultLoss:
+			copies[key] = 0
+		case faultDuplicate:
+			copies[key] = 2
+			expect[key] = record.Expect
+		default:
+			expect[key] = record.Expect
+		}
+	}
+	for key, n := range copies {
+		expected[expect[key]] += n
+	}
+
+	for _, fault := range []string{faultBitFlip, faultTruncate, faultBadLength, faultDuplicate, faultReorder, faultLoss, faultGarbage} {
+		if faults[fault] == 0 {
+			t.Errorf("no %s faults in %d packets", fault, packets)
+		}
+	}
+	for _, result := range []string{expectAccepted, expectRejected, expectDropped} {
+		if arrived[result] != expected[result] {
+			t.Errorf("%d datagrams were %s, expected %d", arrived[result], result, expected[result])
+		}
+	}
+}
+
+func TestFaultInjectorReorders(t *testing.T) {
+	for seed := int64(1); seed <= 10; seed++ {
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			config := faultConfig{reorder: 1, reorderWindow: 3, logPath: filepath.Join(t.TempDir(), "faults.jsonl")}
+			out := &captureDownlink{}
+			injector, err := newFaultInjector(config, out, seed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			//only the first packet is held back
+			if err := injector.send(housekeepingPacket(0)); err != nil {
+				t.Fatal(err)
+			}
+			injector.config.reorder = 0
+			for i := 1; i <= 5; i++ {
+				if err := injector.send(housekeepingPacket(uint16(i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			injector.close()
+
+			records := readFaultLog(t, config.logPath)
+			var late int
+			if len(records) != 1 || records[0].Fault != faultReorder {
+				t.Fatalf("logged %+v, expected one reorder", records)
+			}
+			fmt.Sscanf(records[0].Detail, "sent %d packets late", &late)
+			if late < 1 || late > config.reorderWindow {
+				t.Fatalf("held back %d packets, outside the window", late)
+			}
+			if len(out.packets) != 6 {
+				t.Fatalf("sent %d packets, expected 6", len(out.packets))
+			}
+			for i, packet := range out.packets {
+				seqCount := binary.BigEndian.Uint16(packet[2:4]) & 0x3FFF
+				expected := uint16(i + 1)
+				if i == late {
+					expected = 0
+				} else if i > late {
+					expected = uint16(i)
+				}
+				if seqCount != expected {
+					t.Errorf("packet %d is %d, expected %d with the first %d late", i, seqCount, expected, late)
+				}
+			}
+		})
+	}
+}
+
+func TestFaultInjectorCloseSendsHeld(t *testing.T) {
+	out := &captureDownlink{}
+	injector, err := newFaultInjector(faultConfig{reorder: 1, reorderWindow: 5}, out, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := injector.send(housekeepingPacket(uint16(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := len(out.packets)
+	if err := injector.close(); err != nil {
+		t.Fatal(err)
+	}
+	if sent == 3 || len(out.packets) != 3 || len(injector.held) != 0 {
+		t.Errorf("sent %d packets before closing and %d after, expected the held ones to go on close", sent, len(out.packets))
+	}
+}
+
+func TestFaultInjectorDuplicatesAndLoses(t *testing.T) {
+	out := &captureDownlink{}
+	injector, err := newFaultInjector(faultConfig{duplicate: 1}, out, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		injector.send(housekeepingPacket(uint16(i)))
+	}
+	injector.config = faultConfig{lossBurst: 1, lossBurstMax: 1}
+	for i := 3; i < 6; i++ {
+		injector.send(housekeepingPacket(uint16(i)))
+	}
+	injector.close()
+
+	if len(out.packets) != 6 {
+		t.Fatalf("sent %d packets, expected each of the first three twice", len(out.packets))
+	}
+	for i, packet := range out.packets {
+		if seqCount := binary.BigEndian.Uint16(packet[2:4]) & 0x3FFF; seqCount != uint16(i/2) {
+			t.Errorf("packet %d is %d, expected %d", i, seqCount, i/2)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// metrics, which are also the anomaly types the ground flags
+const (
+	metricTemperature = "temperature"
+	metricBattery     = "battery"
+	metricAltitude    = "altitude"
+	metricSignal      = "signal"
+)
+
+// limit is where the ground's anomaly detection draws the line for a metric. These mirror the thresholds in
+// telemetryingestion's validator, which this module can't import, so the generator can label what it means to be
+// anomalous
+type limit struct {
+	metric    string
+	above     bool
+	threshold float64
+	describe  string
+}
+
+var limits = []limit{
+	{metricTemperature, true, 35, "above 35C"},
+	{metricBattery, false, 40, "below 40%"},
+	{metricAltitude, false, 400, "below 400km"},
+	{metricSignal, false, -80, "below -80dB"},
+}
+
+// anomalyLabel is the ground truth for one anomalous metric in a packet: what's anomalous and why
+type anomalyLabel struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// labelsFor labels the metrics whose true values, before any sensor noise, are past their limits. values are in
+// payload order
+func labelsFor(values [4]float64, cause string) []anomalyLabel {
+	var labels []anomalyLabel
+	for i, l := range limits {
+		if (l.above && values[i] > l.threshold) || (!l.above && values[i] < l.threshold) {
+			labels = append(labels, anomalyLabel{Type: l.metric, Reason: fmt.Sprintf("%s %s in %s", l.metric, l.describe, cause)})
+		}
+	}
+	return labels
+}
+
+// packetLabel is a line of the labels file, one for every housekeeping packet sent, anomalous or not, so an
+// evaluation can count false positives as well as misses. Timestamp is the packet's secondary header timestamp,
+// which with the sequence count identifies the packet on the ground. A label is of the packet as it was made, before
+// any fault injected on the way down, which the fault log records against the same key
+type packetLabel struct {
+	SentAt    time.Time      `json:"sent_at"`
+	Timestamp int64          `json:"timestamp"`
+	APID      uint16         `json:"apid"`
+	SeqCount  uint16         `json:"seq_count"`
+	Anomalies []anomalyLabel `json:"anomalies"`
+}
+
+// labelLog writes the ground truth labels. A nil labelLog writes nothing
+type labelLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+func createLabelLog(path string) (*labelLog, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create labels file: %w", err)
+	}
+	writer := bufio.NewWriter(file)
+	return &labelLog{file: file, writer: writer, encoder: json.NewEncoder(writer)}, nil
+}
+
+func (l *labelLog) record(label packetLabel) {
+	if l == nil {
+		return
+	}
+	if label.Anomalies == nil {
+		label.Anomalies = []anomalyLabel{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.encoder.Encode(label); err != nil {
+		log.Errorf("failed to write label: %v", err)
+	}
+}
+
+func (l *labelLog) close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.writer.Flush(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,138 @@
+package main
+
+import "math"
+
+// orbit and ground segment constants
+const (
+	earthRadius       = 6371.0       // km
+	earthMu           = 398600.4418  // km^3/s^2
+	earthRotationRate = 7.2921159e-5 // rad/s
+
+	orbitEccentricity = 0.0012
+	orbitInclination  = 97.6 * math.Pi / 180 // sun synchronous
+	orbitArgPerigee   = 90 * math.Pi / 180
+)
+
+// the sun's direction, fixed for the length of a run. The orbit's line of nodes points along it, so every orbit has
+// its longest eclipse
+var sunDirection = vec3{1, 0, 0}
+
+// groundStations are where signal strength is measured from. The nearest one is used, horizon or not, so the link
+// never drops out and only its strength changes
+var groundStations = []struct {
+	name     string
+	lat, lon float64 // degrees
+}{
+	{"svalbard", 78.2, 15.4},
+	{"fairbanks", 64.8, -147.7},
+	{"wallops", 37.9, -75.5},
+	{"hawaii", 19.0, -155.7},
+	{"kourou", 5.2, -52.8},
+	{"santiago", -33.1, -70.7},
+	{"hartebeesthoek", -25.9, 27.7},
+	{"singapore", 1.4, 103.8},
+	{"perth", -31.8, 115.9},
+	{"tokyo", 35.7, 139.7},
+	{"troll", -72.0, 2.5},
+	{"mcmurdo", -77.8, 166.7},
+}
+
+type vec3 [3]float64
+
+func (a vec3) sub(b vec3) vec3 {
+	return vec3{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func (a vec3) scale(s float64) vec3 {
+	return vec3{a[0] * s, a[1] * s, a[2] * s}
+}
+
+func (a vec3) dot(b vec3) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func (a vec3) norm() float64 {
+	return math.Sqrt(a.dot(a))
+}
+
+// orbit is a two body Keplerian orbit. Only the mean anomaly moves as it propagates; drag and burns change the
+// semi-major axis directly
+type orbit struct {
+	semiMajorAxis float64 // km
+	eccentricity  float64
+	inclination   float64 // rad
+	raan          float64 // rad
+	argPerigee    float64 // rad
+	meanAnomaly   float64 // rad
+}
+
+func newOrbit(altitude float64) orbit {
+	return orbit{
+		semiMajorAxis: earthRadius + altitude,
+		eccentricity:  orbitEccentricity,
+		inclination:   orbitInclination,
+		argPerigee:    orbitArgPerigee,
+	}
+}
+
+// propagate moves the orbit on dt seconds
+func (o *orbit) propagate(dt float64) {
+	meanMotion := math.Sqrt(earthMu / (o.semiMajorAxis * o.semiMajorAxis * o.semiMajorAxis))
+	o.meanAnomaly = math.Mod(o.meanAnomaly+meanMotion*dt, 2*math.Pi)
+}
+
+// position is where the vehicle is in an earth centred inertial frame, in km
+func (o orbit) position() vec3 {
+	//Kepler's equation, M = E - e sin E, by Newton's method
+	eccentricAnomaly := o.meanAnomaly
+	for i := 0; i < 10; i++ {
+		delta := (eccentricAnomaly - o.eccentricity*math.Sin(eccentricAnomaly) - o.meanAnomaly) /
+			(1 - o.eccentricity*math.Cos(eccentricAnomaly))
+		eccentricAnomaly -= delta
+		if math.Abs(delta) < 1e-10 {
+			break
+		}
+	}
+	trueAnomaly := 2 * math.Atan2(math.Sqrt(1+o.eccentricity)*math.Sin(eccentricAnomaly/2),
+		math.Sqrt(1-o.eccentricity)*math.Cos(eccentricAnomaly/2))
+	radius := o.semiMajorAxis * (1 - o.eccentricity*math.Cos(eccentricAnomaly))
+
+	//perifocal to inertial
+	u := o.argPerigee + trueAnomaly
+	cosRaan, sinRaan := math.Cos(o.raan), math.Sin(o.raan)
+	cosI, sinI := math.Cos(o.inclination), math.Sin(o.inclination)
+	cosU, sinU := math.Cos(u), math.Sin(u)
+	return vec3{
+		radius * (cosRaan*cosU - sinRaan*sinU*cosI),
+		radius * (sinRaan*cosU + cosRaan*sinU*cosI),
+		radius * (sinU * sinI),
+	}
+}
+
+// inEclipse is whether position is in the earth's shadow, taken as a cylinder behind it
+func inEclipse(position vec3) bool {
+	alongSun := position.dot(sunDirection)
+	if alongSun > 0 {
+		return false
+	}
+	return position.sub(sunDirection.scale(alongSun)).norm() < earthRadius
+}
+
+// slantRange is the distance in km from position to the nearest ground station, elapsed seconds of vehicle time in
+func slantRange(position vec3, elapsed float64) float64 {
+	rotation := earthRotationRate * elapsed
+	nearest := math.Inf(1)
+	for _, station := range groundStations {
+		lat := station.lat * math.Pi / 180
+		lon := station.lon*math.Pi/180 + rotation
+		stationPosition := vec3{
+			earthRadius * math.Cos(lat) * math.Cos(lon),
+			earthRadius * math.Cos(lat) * math.Sin(lon),
+			earthRadius * math.Sin(lat),
+		}
+		if distance := position.sub(stationPosition).norm(); distance < nearest {
+			nearest = distance
+		}
+	}
+	return nearest
+}
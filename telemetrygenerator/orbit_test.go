@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestOrbitPosition(t *testing.T) {
+	o := newOrbit(nominalAltitude)
+	period := 2 * math.Pi * math.Sqrt(math.Pow(o.semiMajorAxis, 3)/earthMu)
+	if period < 94*60 || period > 96*60 {
+		t.Errorf("orbit period is %v minutes, expected about 95", period/60)
+	}
+
+	//starting at perigee, over the pole
+	start := o.position()
+	if altitude := start.norm() - earthRadius; math.Abs(altitude-(nominalAltitude-o.semiMajorAxis*o.eccentricity)) > 0.001 {
+		t.Errorf("perigee altitude is %v", altitude)
+	}
+	if start[2] < start.norm()*0.99 {
+		t.Errorf("perigee is at %v, expected over the north pole", start)
+	}
+
+	//half an orbit on is apogee, and a whole orbit brings it back
+	o.propagate(period / 2)
+	if altitude := o.position().norm() - earthRadius; math.Abs(altitude-(nominalAltitude+o.semiMajorAxis*o.eccentricity)) > 0.001 {
+		t.Errorf("apogee altitude is %v", altitude)
+	}
+	o.propagate(period / 2)
+	if distance := o.position().sub(start).norm(); distance > 0.001 {
+		t.Errorf("an orbit on, the vehicle is %vkm from where it started", distance)
+	}
+}
+
+func TestEclipseTransitions(t *testing.T) {
+	o := newOrbit(nominalAltitude)
+	period := 2 * math.Pi * math.Sqrt(math.Pow(o.semiMajorAxis, 3)/earthMu)
+
+	//over the pole we're on the terminator, so we start sunlit and go into the shadow on the way down the night side
+	if inEclipse(o.position()) {
+		t.Fatal("in eclipse at the start")
+	}
+	var entries, exits []float64
+	sunlit := true
+	for elapsed := 1.0; elapsed <= period; elapsed++ {
+		o.propagate(1)
+		if lit := !inEclipse(o.position()); lit != sunlit {
+			sunlit = lit
+			if lit {
+				exits = append(exits, elapsed)
+			} else {
+				entries = append(entries, elapsed)
+			}
+		}
+	}
+	if len(entries) != 1 || len(exits) != 1 {
+		t.Fatalf("entered eclipse at %v and left at %v, expected once each an orbit", entries, exits)
+	}
+	//the middle of the eclipse is straight behind the earth, a quarter orbit on
+	if middle := (entries[0] + exits[0]) / 2; math.Abs(middle-period/4) > 60 {
+		t.Errorf("eclipse from %vs to %vs, expected it either side of %vs", entries[0], exits[0], period/4)
+	}
+	if duration := (exits[0] - entries[0]) / 60; duration < 30 || duration > 40 {
+		t.Errorf("eclipse lasted %v minutes, expected about 35", duration)
+	}
+}
+
+func TestInEclipse(t *testing.T) {
+	r := earthRadius + nominalAltitude
+	tests := []struct {
+		position vec3
+		expected bool
+	}{
+		{vec3{r, 0, 0}, false},
+		{vec3{-r, 0, 0}, true},
+		{vec3{0, r, 0}, false},
+		{vec3{-r, earthRadius - 1, 0}, true},
+		{vec3{-r, earthRadius + 1, 0}, false},
+		{vec3{-r, 0, earthRadius + 1}, false},
+	}
+	for _, test := range tests {
+		if got := inEclipse(test.position); got != test.expected {
+			t.Errorf("inEclipse(%v) = %v, expected %v", test.position, got, test.expected)
+		}
+	}
+}
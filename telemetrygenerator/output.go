@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+)
+
+// downlink is where packets go
+type downlink interface {
+	send(packet []byte) error
+}
+
+// udpDownlink sends every packet to each of its targets
+type udpDownlink struct {
+	conns []net.Conn
+}
+
+func dialTargets(targets []string) (*udpDownlink, error) {
+	d := &udpDownlink{}
+	for _, target := range targets {
+		conn, err := net.Dial("udp", target)
+		if err != nil {
+			d.close()
+			return nil, err
+		}
+		d.conns = append(d.conns, conn)
+	}
+	if len(d.conns) == 0 {
+		return nil, errors.New("no targets to send to")
+	}
+	return d, nil
+}
+
+func (d *udpDownlink) send(packet []byte) error {
+	var err error
+	for _, conn := range d.conns {
+		if _, writeErr := conn.Write(packet); writeErr != nil && err == nil {
+			err = writeErr
+		}
+	}
+	return err
+}
+
+func (d *udpDownlink) close() error {
+	for _, conn := range d.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// fileDownlink writes packets to a file instead of sending them, each one after its length as a big endian uint32.
+// Datagrams don't have to be well formed, so the length can't come from the packet
+type fileDownlink struct {
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func createFileDownlink(path string) (*fileDownlink, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileDownlink{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (d *fileDownlink) send(packet []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.writer.Write(binary.BigEndian.AppendUint32(nil, uint32(len(packet)))); err != nil {
+		return err
+	}
+	_, err := d.writer.Write(packet)
+	return err
+}
+
+func (d *fileDownlink) close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.writer.Flush(); err != nil {
+		d.file.Close()
+		return err
+	}
+	return d.file.Close()
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// metrics in payload order
+var metricNames = []string{metricTemperature, metricBattery, metricAltitude, metricSignal}
+
+// phase types
+const (
+	phaseNominal            = "nominal"
+	phaseEclipse            = "eclipse"
+	phaseBatteryDegradation = "battery_degradation"
+	phaseTemperatureRamp    = "temperature_ramp"
+	phaseSignalFade         = "signal_fade"
+	phaseStepFault          = "step_fault"
+)
+
+// noise models
+const (
+	noiseNone     = "none"
+	noiseGaussian = "gaussian"
+	noiseUniform  = "uniform"
+	noiseSpikes   = "spikes"
+)
+
+// what a metric sits at when the vehicle is healthy, unless the scenario says otherwise
+var defaultNominal = map[string]float64{"temperature": 25, "battery": 85, "altitude": 525, "signal": -50}
+
+// the noise each metric has when the vehicle is healthy
+var nominalNoise = map[string]noiseModel{
+	"temperature": {Model: noiseGaussian, Sigma: 0.2},
+	"battery":     {Model: noiseGaussian, Sigma: 0.1},
+	"altitude":    {Model: noiseGaussian, Sigma: 0.5},
+	"signal":      {Model: noiseGaussian, Sigma: 1},
+}
+
+// scenarioFile is a scenario as it's written. A scenario is a timeline of phases; each phase type gives its metrics a
+// profile, and the phase's metrics override any part of it
+type scenarioFile struct {
+	Seed    int64              `json:"seed"`
+	Repeat  bool               `json:"repeat"`
+	Nominal map[string]float64 `json:"nominal"`
+	Phases  []phaseSpec        `json:"phases"`
+}
+
+type phaseSpec struct {
+	Name     string                   `json:"name"`
+	Type     string                   `json:"type"`
+	Duration string                   `json:"duration"`
+	Metrics  map[string]metricProfile `json:"metrics"`
+}
+
+// metricProfile is how a metric moves over a phase: from From to To in a straight line, or held at Value, plus noise.
+// Without From a metric carries on from where the last phase left it
+type metricProfile struct {
+	From  *float64    `json:"from,omitempty"`
+	To    *float64    `json:"to,omitempty"`
+	Value *float64    `json:"value,omitempty"`
+	Noise *noiseModel `json:"noise,omitempty"`
+}
+
+type noiseModel struct {
+	Model       string  `json:"model"`
+	Sigma       float64 `json:"sigma,omitempty"`       // gaussian and spikes
+	Amplitude   float64 `json:"amplitude,omitempty"`   // uniform, and the size of a spike
+	Probability float64 `json:"probability,omitempty"` // how often spikes happen, per sample
+}
+
+func (n noiseModel) sample(randSource *rand.Rand) float64 {
+	switch n.Model {
+	case noiseGaussian:
+		return randSource.NormFloat64() * n.Sigma
+	case noiseUniform:
+		return (randSource.Float64()*2 - 1) * n.Amplitude
+	case noiseSpikes:
+		noise := randSource.NormFloat64() * n.Sigma
+		if randSource.Float64() < n.Probability {
+			if randSource.Intn(2) == 0 {
+				return noise - n.Amplitude
+			}
+			return noise + n.Amplitude
+		}
+		return noise
+	default:
+		return 0
+	}
+}
+
+// phase is a phase with its profiles resolved against the phases before it
+type phase struct {
+	name     string
+	kind     string
+	start    time.Duration
+	duration time.Duration
+	from     [4]float64
+	to       [4]float64
+	noise    [4]noiseModel
+}
+
+// scenario plays a timeline back. Scenario time advances by step for every packet rather than with the clock, and
+// noise comes from the scenario's own seeded source, so the same scenario and seed always send the same telemetry
+type scenario struct {
+	mu         sync.Mutex
+	phases     []phase
+	total      time.Duration
+	repeat     bool
+	step       time.Duration
+	randSource *rand.Rand
+	samples    int64
+	current    int
+}
+
+// loadScenario reads a scenario file. A seed other than zero overrides the file's
+func loadScenario(path string, seed int64, step time.Duration) (*scenario, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file scenarioFile
+	if err := json.Unmarshal(contents, &file); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	if seed != 0 {
+		file.Seed = seed
+	}
+	if step <= 0 {
+		return nil, errors.New("scenario step must be positive")
+	}
+
+	phases, err := compilePhases(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	last := phases[len(phases)-1]
+	return &scenario{
+		phases:     phases,
+		total:      last.start + last.duration,
+		repeat:     file.Repeat,
+		step:       step,
+		randSource: rand.New(rand.NewSource(file.Seed)),
+		current:    -1,
+	}, nil
+}
+
+func compilePhases(file scenarioFile) ([]phase, error) {
+	if len(file.Phases) == 0 {
+		return nil, errors.New("scenario has no phases")
+	}
+
+	var nominal [4]float64
+	for i, metric := range metricNames {
+		nominal[i] = defaultNominal[metric]
+		if value, ok := file.Nominal[metric]; ok {
+			nominal[i] = value
+		}
+	}
+	for metric := range file.Nominal {
+		if metricIndex(metric) < 0 {
+			return nil, fmt.Errorf("unknown metric %q", metric)
+		}
+	}
+
+	//where each metric was left by the phase before
+	carried := nominal
+	phases := make([]phase, 0, len(file.Phases))
+	var start time.Duration
+	for n, spec := range file.Phases {
+		duration, err := time.ParseDuration(spec.Duration)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("phase %d has invalid duration %q", n+1, spec.Duration)
+		}
+		for metric := range spec.Metrics {
+			if metricIndex(metric) < 0 {
+				return nil, fmt.Errorf("phase %d has unknown metric %q", n+1, metric)
+			}
+		}
+
+		p := phase{name: spec.Name, kind: spec.Type, start: start, duration: duration}
+		if p.name == "" {
+			p.name = fmt.Sprintf("%s-%d", spec.Type, n+1)
+		}
+		for i, metric := range metricNames {
+			profile, err := defaultProfile(spec.Type, metric, carried[i], nominal[i])
+			if err != nil {
+				return nil, fmt.Errorf("phase %d: %w", n+1, err)
+			}
+			override := spec.Metrics[metric]
+			if override.From != nil {
+				profile.From = override.From
+			}
+			if override.To != nil {
+				profile.To = override.To
+			}
+			if override.Value != nil {
+				profile.From, profile.To = override.Value, override.Value
+			}
+			if override.Noise != nil {
+				profile.Noise = override.Noise
+			}
+			if err := profile.Noise.validate(); err != nil {
+				return nil, fmt.Errorf("phase %d %s: %w", n+1, metric, err)
+			}
+
+			p.from[i], p.to[i], p.noise[i] = *profile.From, *profile.To, *profile.Noise
+			//a step fault is temporary, whatever comes next picks up from before it
+			if spec.Type != phaseStepFault {
+				carried[i] = p.to[i]
+			}
+		}
+		if spec.Type == phaseStepFault && !hasValue(spec.Metrics) {
+			return nil, fmt.Errorf("phase %d is a step fault without a value for any metric", n+1)
+		}
+
+		phases = append(phases, p)
+		start += duration
+	}
+	return phases, nil
+}
+
+// defaultProfile is the profile a phase type gives a metric. Metrics a phase type doesn't touch hold where they were
+func defaultProfile(kind string, metric string, carried float64, nominal float64) (metricProfile, error) {
+	noise := nominalNoise[metric]
+	profile := metricProfile{From: &carried, To: &carried, Noise: &noise}
+	ramp := func(to float64) {
+		profile.To = &to
+	}
+
+	switch kind {
+	case phaseNominal:
+		profile.From, profile.To = &nominal, &nominal
+	case phaseEclipse:
+		//out of the sun the bus cools and the battery carries the whole load
+		switch metric {
+		case "temperature":
+			ramp(carried - 8)
+		case "battery":
+			ramp(carried - 12)
+		}
+	case phaseBatteryDegradation:
+		if metric == "battery" {
+			ramp(30)
+			noise = noiseModel{Model: noiseGaussian, Sigma: 0.3}
+		}
+	case phaseTemperatureRamp:
+		if metric == "temperature" {
+			ramp(40)
+		}
+	case phaseSignalFade:
+		if metric == "signal" {
+			ramp(-90)
+			noise = noiseModel{Model: noiseGaussian, Sigma: 2}
+		}
+	case phaseStepFault:
+	default:
+		return metricProfile{}, fmt.Errorf("unknown phase type %q", kind)
+	}
+	return profile, nil
+}
+
+func (n noiseModel) validate() error {
+	switch n.Model {
+	case noiseNone, noiseGaussian, noiseUniform, noiseSpikes:
+	default:
+		return fmt.Errorf("unknown noise model %q", n.Model)
+	}
+	if n.Sigma < 0 || n.Amplitude < 0 || n.Probability < 0 || n.Probability > 1 {
+		return errors.New("noise sigma and amplitude can't be negative, and probability must be between 0 and 1")
+	}
+	return nil
+}
+
+func hasValue(metrics map[string]metricProfile) bool {
+	for _, profile := range metrics {
+		if profile.Value != nil || profile.To != nil || profile.From != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func metricIndex(metric string) int {
+	for i, name := range metricNames {
+		if name == metric {
+			return i
+		}
+	}
+	return -1
+}
+
+// next gives back the telemetry for the next packet, or false once a scenario that doesn't repeat has finished
+func (s *scenario) next(seqCount uint16, _ *rand.Rand) (sample, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at := time.Duration(s.samples) * s.step
+	if at >= s.total {
+		if !s.repeat {
+			return sample{}, false
+		}
+		at %= s.total
+	}
+	s.samples++
+
+	index := 0
+	for index < len(s.phases)-1 && at >= s.phases[index+1].start {
+		index++
+	}
+	p := s.phases[index]
+	if index != s.current {
+		s.current = index
+		log.Printf("scenario entering phase %s (%s) at %v", p.name, p.kind, at)
+		emitEvent(eventScenarioPhase, severityInfo, p.name)
+	}
+
+	progress := float64(at-p.start) / float64(p.duration)
+	var truth, values [4]float64
+	for i := range values {
+		truth[i] = p.from[i] + (p.to[i]-p.from[i])*progress
+		values[i] = truth[i] + p.noise[i].sample(s.randSource)
+	}
+	return sample{
+		payload: TelemetryPayload{
+			Temperature: float32(values[0]),
+			Battery:     float32(values[1]),
+			Altitude:    float32(values[2]),
+			Signal:      float32(values[3]),
+		},
+		description: p.name,
+		//noise can carry a metric over its limit and back, but only the profile makes it anomalous
+		anomalies: labelsFor(truth, fmt.Sprintf("%s phase %s", p.kind, p.name)),
+	}, true
+}
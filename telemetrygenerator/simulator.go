@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// acks go down on their own APID; these mirror telemetryingestion/pkg/commanding, which this module can't import
+const (
+	ACK_APID             = 0x7F0
+	TC_PACKET_TYPE       = 0x1
+	tcHeaderSize         = 16 // primary header plus the timestamp and opcode secondary header
+	ackStageAccepted     = 1
+	ackStageStarted      = 2
+	ackStageCompleted    = 3
+	ackOK                = 0
+	ackUnknownCommand    = 1
+	ackInvalidArguments  = 2
+	ackExecutionFailed   = 3
+	maxTelecommandLength = 1024
+)
+
+// commandArg is an argument as the spacecraft decodes it, with the range it accepts
+type commandArg struct {
+	name     string
+	kind     string
+	min, max float64
+}
+
+type commandSpec struct {
+	name string
+	args []commandArg
+}
+
+// onboardCommands is what the spacecraft understands, by APID and opcode. It matches the ground's command dictionary
+var onboardCommands = map[[2]uint16]commandSpec{
+	{0x10, 1}:   {name: "NOOP"},
+	{0x11, 16}:  {name: "HEATER_ON", args: []commandArg{{"heater_id", "uint8", 0, 3}}},
+	{0x11, 17}:  {name: "HEATER_OFF", args: []commandArg{{"heater_id", "uint8", 0, 3}}},
+	{0x11, 18}:  {name: "SET_HEATER_SETPOINT", args: []commandArg{{"setpoint", "float32", -20, 40}}},
+	{0x12, 32}:  {name: "PAYLOAD_POWER", args: []commandArg{{"on", "bool", 0, 1}}},
+	{0x13, 48}:  {name: "SET_TX_POWER", args: []commandArg{{"power", "float32", 0, 10}}},
+	{0x14, 64}:  {name: "ORBIT_RAISE", args: []commandArg{{"delta_altitude", "float32", 0.1, 50}, {"burn_seconds", "uint16", 1, 600}}},
+	{0x10, 240}: {name: "REBOOT_SUBSYSTEM", args: []commandArg{{"subsystem_id", "uint16", 1, 8}, {"delay", "uint16", 0, 3600}}},
+}
+
+var argSizes = map[string]int{"uint8": 1, "bool": 1, "uint16": 2, "float32": 4}
+
+// telecommand is a decoded TC packet
+type telecommand struct {
+	apid     uint16
+	seqCount uint16
+	opcode   uint16
+	args     []byte
+}
+
+// simulator receives telecommands on the uplink, applies them to the vehicle and reports each verification stage
+// with an ack on the downlink
+type simulator struct {
+	vehicle     *vehicle
+	downlink    downlink
+	uplinkAddr  string
+	ackSeqCount uint16
+	ackMu       sync.Mutex
+	wg          sync.WaitGroup
+}
+
+func newSimulator(v *vehicle, downlink downlink, uplinkAddr string) *simulator {
+	return &simulator{vehicle: v, downlink: downlink, uplinkAddr: uplinkAddr}
+}
+
+// run listens for telecommands until ctx is done, then waits for commands still executing to finish reporting
+func (s *simulator) run(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", s.uplinkAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	log.Printf("simulator listening for telecommands on %s", s.uplinkAddr)
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, maxTelecommandLength)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				s.wg.Wait()
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			log.Printf("error reading telecommand: %v", err)
+			continue
+		}
+		tc, err := decodeTelecommand(buf[:n])
+		if err != nil {
+			//nothing to ack, we can't tell which command it was
+			log.Printf("dropping bad telecommand: %v", err)
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(ctx, tc)
+		}()
+	}
+}
+
+// handle verifies a telecommand, executes it and acks each stage
+func (s *simulator) handle(ctx context.Context, tc telecommand) {
+	spec, ok := onboardCommands[[2]uint16{tc.apid, tc.opcode}]
+	if !ok {
+		log.Printf("rejecting unknown command APID %d opcode %d", tc.apid, tc.opcode)
+		s.ack(tc, ackStageAccepted, ackUnknownCommand)
+		emitEvent(eventCommandRejected, severityWarning, fmt.Sprintf("unknown command %d/%d", tc.apid, tc.opcode))
+		return
+	}
+	args, err := spec.decodeArgs(tc.args)
+	if err != nil {
+		log.Printf("rejecting %s: %v", spec.name, err)
+		s.ack(tc, ackStageAccepted, ackInvalidArguments)
+		emitEvent(eventCommandRejected, severityWarning, fmt.Sprintf("%s: %v", spec.name, err))
+		return
+	}
+
+	log.Printf("executing %s %v (seq %d)", spec.name, args, tc.seqCount)
+	s.ack(tc, ackStageAccepted, ackOK)
+	s.ack(tc, ackStageStarted, ackOK)
+	completesIn := s.vehicle.execute(spec.name, args)
+	if completesIn > 0 {
+		select {
+		case <-ctx.Done():
+			s.ack(tc, ackStageCompleted, ackExecutionFailed)
+			return
+		case <-time.After(completesIn):
+		}
+	}
+	s.ack(tc, ackStageCompleted, ackOK)
+	emitEvent(eventCommandExecuted, severityInfo, spec.name)
+}
+
+// ack sends an acknowledgement for a stage of the telecommand down with the telemetry
+func (s *simulator) ack(tc telecommand, stage uint8, code uint8) {
+	s.ackMu.Lock()
+	seqCount := s.ackSeqCount
+	s.ackSeqCount = (s.ackSeqCount + 1) & 0x3FFF
+	s.ackMu.Unlock()
+
+	packet := make([]byte, 0, 22)
+	packet = binary.BigEndian.AppendUint16(packet, uint16(PACKET_VERSION)<<13|uint16(PACKET_TYPE)<<12|uint16(SEC_HDR_FLAG)<<11|ACK_APID)
+	packet = binary.BigEndian.AppendUint16(packet, uint16(SEQ_FLAGS)<<14|seqCount)
+	packet = binary.BigEndian.AppendUint16(packet, 15)
+	packet = binary.BigEndian.AppendUint64(packet, uint64(time.Now().Unix()))
+	packet = binary.BigEndian.AppendUint16(packet, 0)
+	packet = binary.BigEndian.AppendUint16(packet, tc.apid)
+	packet = binary.BigEndian.AppendUint16(packet, tc.seqCount)
+	packet = append(packet, stage, code)
+
+	if err := s.downlink.send(packet); err != nil {
+		log.Printf("error sending ack: %v", err)
+	}
+}
+
+func decodeTelecommand(packet []byte) (telecommand, error) {
+	if len(packet) < tcHeaderSize {
+		return telecommand{}, fmt.Errorf("telecommand is %d bytes, too short for its headers", len(packet))
+	}
+	packetID := binary.BigEndian.Uint16(packet[0:2])
+	if (packetID>>12)&0x1 != TC_PACKET_TYPE {
+		return telecommand{}, errors.New("packet isn't a telecommand")
+	}
+	if length := int(binary.BigEndian.Uint16(packet[4:6])) + 7; length != len(packet) {
+		return telecommand{}, fmt.Errorf("telecommand length says %d bytes but has %d", length, len(packet))
+	}
+	return telecommand{
+		apid:     packetID & 0x7FF,
+		seqCount: binary.BigEndian.Uint16(packet[2:4]) & 0x3FFF,
+		opcode:   binary.BigEndian.Uint16(packet[14:16]),
+		//the read buffer is reused for the next telecommand
+		args: append([]byte(nil), packet[tcHeaderSize:]...),
+	}, nil
+}
+
+// decodeArgs reads the command's arguments and checks them against the ranges the spacecraft accepts
+func (c commandSpec) decodeArgs(encoded []byte) (map[string]float64, error) {
+	size := 0
+	for _, arg := range c.args {
+		size += argSizes[arg.kind]
+	}
+	if len(encoded) != size {
+		return nil, fmt.Errorf("arguments are %d bytes, expected %d", len(encoded), size)
+	}
+
+	args := make(map[string]float64, len(c.args))
+	for _, arg := range c.args {
+		var value float64
+		switch arg.kind {
+		case "uint8", "bool":
+			value = float64(encoded[0])
+		case "uint16":
+			value = float64(binary.BigEndian.Uint16(encoded))
+		case "float32":
+			value = float64(math.Float32frombits(binary.BigEndian.Uint32(encoded)))
+		}
+		if math.IsNaN(value) || value < arg.min || value > arg.max {
+			return nil, fmt.Errorf("%s is %v, outside %v to %v", arg.name, value, arg.min, arg.max)
+		}
+		args[arg.name] = value
+		encoded = encoded[argSizes[arg.kind]:]
+	}
+	return args, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+// testTelecommand builds a telecommand the way the ground sends one
+func testTelecommand(apid uint16, seqCount uint16, opcode uint16, args []byte) []byte {
+	packet := binary.BigEndian.AppendUint16(nil, uint16(TC_PACKET_TYPE)<<12|uint16(SEC_HDR_FLAG)<<11|apid)
+	packet = binary.BigEndian.AppendUint16(packet, uint16(SEQ_FLAGS)<<14|seqCount)
+	packet = binary.BigEndian.AppendUint16(packet, uint16(tcHeaderSize+len(args)-7))
+	packet = binary.BigEndian.AppendUint64(packet, uint64(testStart.Unix()))
+	packet = binary.BigEndian.AppendUint16(packet, opcode)
+	return append(packet, args...)
+}
+
+// testAck is an ack as the ground decodes it
+type testAck struct {
+	seqCount   uint16
+	tcAPID     uint16
+	tcSeqCount uint16
+	stage      uint8
+	code       uint8
+}
+
+func decodeTestAck(t *testing.T, packet []byte) testAck {
+	t.Helper()
+	if len(packet) != 22 || binary.BigEndian.Uint16(packet[0:2])&0x7FF != ACK_APID ||
+		int(binary.BigEndian.Uint16(packet[4:6]))+7 != len(packet) {
+		t.Fatalf("% x isn't an ack", packet)
+	}
+	return testAck{
+		seqCount:   binary.BigEndian.Uint16(packet[2:4]) & 0x3FFF,
+		tcAPID:     binary.BigEndian.Uint16(packet[16:18]),
+		tcSeqCount: binary.BigEndian.Uint16(packet[18:20]),
+		stage:      packet[20],
+		code:       packet[21],
+	}
+}
+
+func float32Arg(value float32) []byte {
+	return binary.BigEndian.AppendUint32(nil, math.Float32bits(value))
+}
+
+func TestDecodeTelecommand(t *testing.T) {
+	tc, err := decodeTelecommand(testTelecommand(0x11, 9, 18, float32Arg(30)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.apid != 0x11 || tc.seqCount != 9 || tc.opcode != 18 || len(tc.args) != 4 {
+		t.Errorf("decoded %+v", tc)
+	}
+
+	telemetry := testTelecommand(0x11, 9, 18, nil)
+	telemetry[0] &^= TC_PACKET_TYPE << 4
+	invalid := map[string][]byte{
+		"too short":     testTelecommand(0x11, 9, 18, nil)[:tcHeaderSize-1],
+		"telemetry":     telemetry,
+		"wrong length":  append(testTelecommand(0x11, 9, 18, nil), 0),
+		"short payload": testTelecommand(0x11, 9, 18, float32Arg(30))[:tcHeaderSize+2],
+	}
+	for name, packet := range invalid {
+		if _, err := decodeTelecommand(packet); err == nil {
+			t.Errorf("decoded a telecommand from %s", name)
+		}
+	}
+}
+
+func TestSimulatorAcks(t *testing.T) {
+	tests := []struct {
+		name  string
+		tc    []byte
+		acks  [][2]uint8
+		check func(v *vehicle) bool
+	}{
+		{
+			name:  "heater on",
+			tc:    testTelecommand(0x11, 1, 16, []byte{2}),
+			acks:  [][2]uint8{{ackStageAccepted, ackOK}, {ackStageStarted, ackOK}, {ackStageCompleted, ackOK}},
+			check: func(v *vehicle) bool { return v.heaters[2] && !v.heaters[0] },
+		},
+		{
+			name:  "setpoint",
+			tc:    testTelecommand(0x11, 2, 18, float32Arg(-5)),
+			acks:  [][2]uint8{{ackStageAccepted, ackOK}, {ackStageStarted, ackOK}, {ackStageCompleted, ackOK}},
+			check: func(v *vehicle) bool { return v.setpoint == -5 },
+		},
+		{
+			name:  "unknown opcode",
+			tc:    testTelecommand(0x11, 3, 99, nil),
+			acks:  [][2]uint8{{ackStageAccepted, ackUnknownCommand}},
+			check: func(v *vehicle) bool { return v.setpoint == defaultSetpoint },
+		},
+		{
+			name:  "heater out of range",
+			tc:    testTelecommand(0x11, 4, 16, []byte{4}),
+			acks:  [][2]uint8{{ackStageAccepted, ackInvalidArguments}},
+			check: func(v *vehicle) bool { return v.heaters == [numberOfHeaters]bool{} },
+		},
+		{
+			name:  "not a number",
+			tc:    testTelecommand(0x13, 5, 48, float32Arg(float32(math.NaN()))),
+			acks:  [][2]uint8{{ackStageAccepted, ackInvalidArguments}},
+			check: func(v *vehicle) bool { return v.txPower == nominalTxPower },
+		},
+		{
+			name:  "missing argument",
+			tc:    testTelecommand(0x12, 6, 32, nil),
+			acks:  [][2]uint8{{ackStageAccepted, ackInvalidArguments}},
+			check: func(v *vehicle) bool { return !v.payloadOn },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := &captureDownlink{}
+			v := newVehicle(testStart, 1, 1, time.Second)
+			s := newSimulator(v, out, "")
+			tc, err := decodeTelecommand(test.tc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.handle(context.Background(), tc)
+
+			if len(out.packets) != len(test.acks) {
+				t.Fatalf("sent %d acks, expected %d", len(out.packets), len(test.acks))
+			}
+			for i, packet := range out.packets {
+				ack := decodeTestAck(t, packet)
+				if ack.seqCount != uint16(i) || ack.tcAPID != tc.apid || ack.tcSeqCount != tc.seqCount {
+					t.Errorf("ack %d is %+v, for %+v", i, ack, tc)
+				}
+				if [2]uint8{ack.stage, ack.code} != test.acks[i] {
+					t.Errorf("ack %d is stage %d code %d, expected %v", i, ack.stage, ack.code, test.acks[i])
+				}
+			}
+			if !test.check(v) {
+				t.Errorf("vehicle state isn't what %s should have left it", test.name)
+			}
+		})
+	}
+}
+
+func TestSimulatorFailsUnfinishedCommands(t *testing.T) {
+	out := &captureDownlink{}
+	v := newVehicle(testStart, 1, 1, time.Second)
+	s := newSimulator(v, out, "")
+	//a 600 second burn, cut short by shutting down
+	tc, err := decodeTelecommand(testTelecommand(0x14, 7, 64, append(float32Arg(10), 0x02, 0x58)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.handle(ctx, tc)
+
+	expected := [][2]uint8{{ackStageAccepted, ackOK}, {ackStageStarted, ackOK}, {ackStageCompleted, ackExecutionFailed}}
+	if len(out.packets) != len(expected) {
+		t.Fatalf("sent %d acks, expected %d", len(out.packets), len(expected))
+	}
+	for i, packet := range out.packets {
+		if ack := decodeTestAck(t, packet); [2]uint8{ack.stage, ack.code} != expected[i] {
+			t.Errorf("ack %d is stage %d code %d, expected %v", i, ack.stage, ack.code, expected[i])
+		}
+	}
+	if v.burnRemaining != 600 || v.burnRate != 10.0/600 {
+		t.Errorf("burning %v km/s for %vs, expected the burn to have started", v.burnRate, v.burnRemaining)
+	}
+}
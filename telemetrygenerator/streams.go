@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// packet types. Housekeeping is the one the ingestion service decodes
+const (
+	ATTITUDE_APID         = 0x02
+	ATTITUDE_SUBSYSTEM_ID = 0x0002 // attitude determination and control
+	EVENT_APID            = 0x03
+	EVENT_SUBSYSTEM_ID    = 0x0003 // flight software
+	maxEventMessage       = 64
+)
+
+// event codes and severities
+const (
+	eventEclipseEntry    = 1
+	eventEclipseExit     = 2
+	eventScenarioPhase   = 3
+	eventCommandExecuted = 4
+	eventCommandRejected = 5
+
+	severityInfo    = 0
+	severityWarning = 1
+)
+
+// packetStream is one packet type: its own APID, subsystem and sequence count
+type packetStream struct {
+	name        string
+	apid        uint16
+	subsystemID uint16
+	seqCount    uint32
+	sent        uint64
+	out         downlink
+	//what packets are timestamped with
+	now func() time.Time
+}
+
+func newPacketStream(name string, apid uint16, subsystemID uint16, out downlink) *packetStream {
+	return &packetStream{name: name, apid: apid, subsystemID: subsystemID, out: out, now: time.Now}
+}
+
+// nextSeqCount takes the stream's next sequence count, which wraps at 14 bits
+func (p *packetStream) nextSeqCount() uint16 {
+	return uint16(atomic.AddUint32(&p.seqCount, 1)-1) & 0x3FFF
+}
+
+// send sends a packet, giving back the timestamp it went with
+func (p *packetStream) send(seqCount uint16, payload []byte) (time.Time, error) {
+	atomic.AddUint64(&p.sent, 1)
+	atomic.AddUint64(&packetCount, 1)
+	now := p.now()
+	return now, p.out.send(createPacket(p.apid, p.subsystemID, seqCount, now, payload))
+}
+
+// AttitudePayload is the attitude packet: where the vehicle is pointing and how fast it's turning
+type AttitudePayload struct {
+	Quaternion [4]float32 // body to inertial, scalar first
+	Rates      [3]float32 // body rates, deg/s
+}
+
+// attitude points the vehicle at the earth, so it pitches once an orbit, with a little control jitter
+type attitude struct {
+	start     time.Time
+	timeScale float64
+}
+
+const (
+	orbitPeriod  = 5700.0 // s
+	attitudeRate = 0.002  // jitter in each body rate, deg/s
+)
+
+func (a attitude) sample(now time.Time, randSource *rand.Rand) AttitudePayload {
+	elapsed := now.Sub(a.start).Seconds() * a.timeScale
+	pitchRate := 360 / orbitPeriod
+	pitch := math.Mod(elapsed*pitchRate, 360) * math.Pi / 180
+	return AttitudePayload{
+		Quaternion: [4]float32{float32(math.Cos(pitch / 2)), 0, float32(math.Sin(pitch / 2)), 0},
+		Rates: [3]float32{
+			float32(randSource.NormFloat64() * attitudeRate),
+			float32(pitchRate + randSource.NormFloat64()*attitudeRate),
+			float32(randSource.NormFloat64() * attitudeRate),
+		},
+	}
+}
+
+// sendAttitude sends an attitude packet rate times a second until ctx is done. Its jitter is drawn from seed, or a
+// random seed if it's zero
+func sendAttitude(ctx context.Context, stream *packetStream, rate float64, a attitude, seed int64) {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	randSource := rand.New(rand.NewSource(seed))
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			payload := a.sample(now, randSource)
+			buf := make([]byte, 0, binary.Size(payload))
+			for _, value := range payload.Quaternion {
+				buf = binary.BigEndian.AppendUint32(buf, math.Float32bits(value))
+			}
+			for _, value := range payload.Rates {
+				buf = binary.BigEndian.AppendUint32(buf, math.Float32bits(value))
+			}
+			if _, err := stream.send(stream.nextSeqCount(), buf); err != nil {
+				log.Printf("Error sending attitude: %v", err)
+			}
+		}
+	}
+}
+
+// events is where anything in the generator reports something happening on board. It's nil unless events are on
+var events *packetStream
+
+// emitEvent sends an event packet: code, severity, message length and the message itself. It does nothing unless
+// events are on
+func emitEvent(code uint16, severity uint8, message string) {
+	if events == nil {
+		return
+	}
+	if len(message) > maxEventMessage {
+		message = message[:maxEventMessage]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	payload = append(payload, severity, uint8(len(message)))
+	payload = append(payload, message...)
+	if _, err := events.send(events.nextSeqCount(), payload); err != nil {
+		log.Printf("Error sending event: %v", err)
+	}
+}
+
+// createPacket wraps a payload in the primary and secondary headers
+func createPacket(apid uint16, subsystemID uint16, seqCount uint16, timestamp time.Time, payload []byte) []byte {
+	packet := make([]byte, 0, primaryHeaderSize+binary.Size(CCSDSSecondaryHeader{})+len(payload))
+	// PacketID: Version(3) | Type(1) | SecHdrFlag(1) | APID(11)
+	packet = binary.BigEndian.AppendUint16(packet, uint16(PACKET_VERSION)<<13|uint16(PACKET_TYPE)<<12|uint16(SEC_HDR_FLAG)<<11|apid&0x07FF)
+	// PacketSeqCtrl: SeqFlags(2) | SeqCount(14)
+	packet = binary.BigEndian.AppendUint16(packet, uint16(SEQ_FLAGS)<<14|seqCount&0x3FFF)
+	// packet length, excluding the primary header, minus one
+	packet = binary.BigEndian.AppendUint16(packet, uint16(binary.Size(CCSDSSecondaryHeader{})+len(payload)-1))
+	packet = binary.BigEndian.AppendUint64(packet, uint64(timestamp.Unix()))
+	packet = binary.BigEndian.AppendUint16(packet, subsystemID)
+	return append(packet, payload...)
+}
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
+	"flag"
 	"github.com/sirupsen/logrus"
+	"math"
 	"math/rand"
-	"net"
 	"os"
 	"os/signal"
 	"sync"
@@ -45,19 +47,89 @@ const (
 	SUBSYSTEM_ID   = 0x0001 // Main bus telemetry
 )
 
-// atomic counter
+// sample is what goes in a packet, a description of it for the log, and which of its metrics are meant to be
+// anomalous
+type sample struct {
+	payload     TelemetryPayload
+	description string
+	anomalies   []anomalyLabel
+}
+
+// telemetrySource decides what each packet carries. next is called by every worker, and false means there's nothing
+// more to send
+type telemetrySource interface {
+	next(seqCount uint16, randSource *rand.Rand) (sample, bool)
+}
+
+// randomSource is the default: random telemetry with the pacer's anomaly rate spread evenly through it, so 0.2 is
+// every fifth packet
+type randomSource struct {
+	pacer *pacer
+}
+
+func (r randomSource) next(seqCount uint16, randSource *rand.Rand) (sample, bool) {
+	rate := r.pacer.getAnomalyRate()
+	if math.Floor(float64(seqCount)*rate) > math.Floor((float64(seqCount)-1)*rate) {
+		payload, metric := generateTelemetryPayload(true, randSource)
+		return sample{
+			payload:     payload,
+			description: "anomalous",
+			anomalies:   []anomalyLabel{{Type: metric, Reason: "random " + metric + " anomaly"}},
+		}, true
+	}
+	payload, _ := generateTelemetryPayload(false, randSource)
+	return sample{payload: payload, description: "normal"}, true
+}
+
+// atomic counts of every packet sent, of any type, and every one that failed
 var packetCount uint64
+var sendErrors uint64
 var log *logrus.Logger
-var packetDelay time.Duration
 
 func main() {
-	conn, err := net.Dial("udp", "telemetryingestion:8089")
+	//set logger
+	log = logrus.New()
+	log.SetFormatter(&logrus.JSONFormatter{})
+	log.SetOutput(os.Stdout)
+
+	cfg := configFromEnv()
+	if err := cfg.parseFlags(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		log.Fatal(err)
+	}
+	level, err := logrus.ParseLevel(cfg.logLevel)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer conn.Close()
+	log.SetLevel(level)
+
+	//packets go to the targets, or to a file instead
+	var base downlink
+	if cfg.outputPath != "" {
+		file, err := createFileDownlink(cfg.outputPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.close()
+		base = file
+	} else {
+		udp, err := dialTargets(cfg.targets)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer udp.close()
+		base = udp
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if cfg.duration > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, cfg.duration)
+		defer cancelTimeout()
+	}
 
 	//signal channel stuff
 	sigChan := make(chan os.Signal, 1)
@@ -70,32 +142,124 @@ func main() {
 		cancel()
 	}()
 
-	//set logger
-	log = logrus.New()
-	log.SetFormatter(&logrus.JSONFormatter{})
-	log.SetOutput(os.Stdout)
+	wg := &sync.WaitGroup{}
+
+	if cfg.model != modelRandom && cfg.model != modelPhysics {
+		log.Fatalf("unknown TELEMETRY_MODEL %q", cfg.model)
+	}
+	//the simulator's commands act on the physics model
+	if cfg.simulator {
+		cfg.model = modelPhysics
+	}
+	if cfg.scenarioPath != "" && cfg.model == modelPhysics {
+		log.Fatal("SCENARIO can't be used with SIMULATOR or the physics model")
+	}
 
-	//setup packet delay
-	delay := os.Getenv("PACKET_DELAY")
-	packetDelay = 500 * time.Millisecond
-	if delay != "" {
-		if d, err := time.ParseDuration(delay); err == nil {
-			packetDelay = d
+	p := newPacer(cfg.rate, cfg.burst, cfg.count, cfg.anomalyRate)
+	var source telemetrySource = randomSource{pacer: p}
+	if cfg.scenarioPath != "" {
+		s, err := loadScenario(cfg.scenarioPath, cfg.seed, cfg.scenarioStep)
+		if err != nil {
+			log.Fatal(err)
 		}
+		source = s
 	}
 
-	//spin up workers
-	wg := &sync.WaitGroup{}
-	startTime := time.Now()
-	for i := 0; i < 10; i++ {
+	if cfg.model == modelPhysics {
+		//a seeded vehicle runs on its own time, a scenario step a packet, so the run can be repeated
+		var step time.Duration
+		if cfg.seed != 0 {
+			if cfg.scenarioStep <= 0 {
+				log.Fatal("a seeded physics model needs a rate or SCENARIO_STEP")
+			}
+			step = cfg.scenarioStep
+		}
+		v := newVehicle(time.Now(), cfg.timeScale, cfg.seed, step)
+		source = v
+
+		//in simulator mode the vehicle also reacts to the commands we're sent
+		if cfg.simulator {
+			//acks aren't telemetry, so they don't go through the fault injector
+			sim := newSimulator(v, base, cfg.uplinkAddr)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := sim.run(ctx); err != nil {
+					log.Fatalf("simulator failed: %v", err)
+				}
+			}()
+		}
+	}
+
+	out := base
+	if cfg.faults.enabled() {
+		injector, err := newFaultInjector(cfg.faults, out, cfg.seed)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer injector.close()
+		out = injector
+	}
+
+	//every packet type has its own APID and sequence count
+	if cfg.attitudeRate > 0 || cfg.events {
+		log.Printf("sending attitude or event packets, which the ingestion service counts in unsupported_packets and drops")
+	}
+	streams := []*packetStream{newPacketStream("housekeeping", APID, SUBSYSTEM_ID, out)}
+	housekeeping := streams[0]
+	if cfg.attitudeRate > 0 {
+		attitudeStream := newPacketStream("attitude", ATTITUDE_APID, ATTITUDE_SUBSYSTEM_ID, out)
+		streams = append(streams, attitudeStream)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sendAttitude(ctx, attitudeStream, cfg.attitudeRate, attitude{start: time.Now(), timeScale: cfg.timeScale}, cfg.seed)
+		}()
+	}
+	if cfg.events {
+		events = newPacketStream("events", EVENT_APID, EVENT_SUBSYSTEM_ID, out)
+		streams = append(streams, events)
+	}
+
+	//ground truth for evaluating anomaly detection
+	var labels *labelLog
+	if cfg.labelsPath != "" {
+		var err error
+		labels, err = createLabelLog(cfg.labelsPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer labels.close()
+	}
+
+	if cfg.controlAddr != "" {
+		control := newController(p, streams)
 		wg.Add(1)
-		go func(conn net.Conn, ctx context.Context) {
+		go func() {
 			defer wg.Done()
-			sendPackets(conn, ctx)
-		}(conn, ctx)
+			control.run(ctx, cfg.controlAddr)
+		}()
 	}
 
-	//block here
+	//spin up workers
+	startTime := time.Now()
+	workers := &sync.WaitGroup{}
+	for i := 0; i < cfg.workers; i++ {
+		seed := time.Now().UnixNano()
+		if cfg.seed != 0 {
+			seed = cfg.seed + int64(i)
+		}
+		workers.Add(1)
+		go func(ctx context.Context, randSource *rand.Rand) {
+			defer workers.Done()
+			sendPackets(ctx, housekeeping, p, source, randSource, labels)
+		}(ctx, rand.New(rand.NewSource(seed)))
+	}
+
+	//block here. Housekeeping stops early when it's sent its count or a scenario ends, and everything else stops
+	//with it
+	workers.Wait()
+	cancel()
 	wg.Wait()
 
 	//generator run stats
@@ -103,69 +267,39 @@ func main() {
 	runLatency := endTime.Sub(startTime)
 	log.Println("run time:", runLatency)
 	log.Println("total packet count:", packetCount)
+	for _, stream := range streams {
+		log.Printf("%s packet count: %d", stream.name, stream.sent)
+	}
 }
 
-func sendPackets(conn net.Conn, ctx context.Context) {
-	randSource := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-	for {
-		select {
-		case <-ctx.Done():
+// sendPackets sends housekeeping telemetry from source at the pacer's pace until ctx is done, the pacer says that's
+// enough or source runs out
+func sendPackets(ctx context.Context, housekeeping *packetStream, p *pacer, source telemetrySource, randSource *rand.Rand, labels *labelLog) {
+	for p.wait(ctx) {
+		seqCount := housekeeping.nextSeqCount()
+		s, ok := source.next(seqCount, randSource)
+		if !ok {
 			return
-		default:
-			count := atomic.AddUint64(&packetCount, 1)
-			seqCount := uint16(count)
-			data := createTelemetryPacket(&seqCount, randSource)
-			_, err := conn.Write(data)
-			if err != nil {
-				log.Printf("Error sending telemetry: %v", err)
-				continue
-			}
-			if packetCount%5 == 0 {
-				log.Printf("Sent anomalous telemetry packet #%d\n", packetCount)
-			} else {
-				log.Printf("Sent normal telemetry packet #%d\n", packetCount)
-			}
-			time.Sleep(packetDelay)
 		}
+		sentAt, err := housekeeping.send(seqCount, encodeTelemetryPayload(s.payload))
+		if err != nil {
+			atomic.AddUint64(&sendErrors, 1)
+			log.Printf("Error sending telemetry: %v", err)
+			continue
+		}
+		labels.record(packetLabel{SentAt: sentAt, Timestamp: sentAt.Unix(), APID: housekeeping.apid, SeqCount: seqCount, Anomalies: s.anomalies})
+		log.Debugf("Sent %s telemetry packet #%d", s.description, seqCount)
 	}
 }
 
-func createTelemetryPacket(seqCount *uint16, randSource *rand.Rand) []byte {
+func encodeTelemetryPayload(payload TelemetryPayload) []byte {
 	buf := new(bytes.Buffer)
-	// Create primary header
-	// PacketID: Version(3) | Type(1) | SecHdrFlag(1) | APID(11)
-	packetID := uint16(PACKET_VERSION)<<13 |
-		uint16(PACKET_TYPE)<<12 |
-		uint16(SEC_HDR_FLAG)<<11 |
-		uint16(APID)
-
-	// PacketSeqCtrl: SeqFlags(2) | SeqCount(14)
-	packetSeqCtrl := uint16(SEQ_FLAGS)<<14 | (*seqCount & 0x3FFF)
-	// Generate telemetry data
-	payload := generateTelemetryPayload(*seqCount%5 == 0, randSource)
-	// Calculate total packet length (excluding primary header first 6 bytes)
-	packetDataLength := uint16(binary.Size(CCSDSSecondaryHeader{}) +
-		binary.Size(TelemetryPayload{}) - 1)
-
-	primaryHeader := CCSDSPrimaryHeader{
-		PacketID:      packetID,
-		PacketSeqCtrl: packetSeqCtrl,
-		PacketLength:  packetDataLength,
-	}
-	// Create secondary header
-	secondaryHeader := CCSDSSecondaryHeader{
-		Timestamp:   uint64(time.Now().Unix()),
-		SubsystemID: SUBSYSTEM_ID,
-	}
-	// Write headers and payload
-	binary.Write(buf, binary.BigEndian, primaryHeader) // CCSDS uses big-endian
-	binary.Write(buf, binary.BigEndian, secondaryHeader)
-	binary.Write(buf, binary.BigEndian, payload)
+	binary.Write(buf, binary.BigEndian, payload) // CCSDS uses big-endian
 	return buf.Bytes()
 }
 
-func generateTelemetryPayload(generateAnomaly bool, randSource *rand.Rand) TelemetryPayload {
+// generateTelemetryPayload gives back random telemetry, and which metric it made anomalous if it was asked to
+func generateTelemetryPayload(generateAnomaly bool, randSource *rand.Rand) (TelemetryPayload, string) {
 	if generateAnomaly {
 		// Randomly choose one parameter to be anomalous
 		anomalyType := randSource.Intn(4)
@@ -176,28 +310,28 @@ func generateTelemetryPayload(generateAnomaly bool, randSource *rand.Rand) Telem
 				Battery:     randomFloat(70.0, 100.0, randSource),
 				Altitude:    randomFloat(500.0, 550.0, randSource),
 				Signal:      randomFloat(-60.0, -40.0, randSource),
-			}
+			}, metricTemperature
 		case 1:
 			return TelemetryPayload{
 				Temperature: randomFloat(20.0, 30.0, randSource),
 				Battery:     randomFloat(20.0, 40.0, randSource), // Low battery anomaly
 				Altitude:    randomFloat(500.0, 550.0, randSource),
 				Signal:      randomFloat(-60.0, -40.0, randSource),
-			}
+			}, metricBattery
 		case 2:
 			return TelemetryPayload{
 				Temperature: randomFloat(20.0, 30.0, randSource),
 				Battery:     randomFloat(70.0, 100.0, randSource),
 				Altitude:    randomFloat(300.0, 400.0, randSource), // Low altitude anomaly
 				Signal:      randomFloat(-60.0, -40.0, randSource),
-			}
+			}, metricAltitude
 		default:
 			return TelemetryPayload{
 				Temperature: randomFloat(20.0, 30.0, randSource),
 				Battery:     randomFloat(70.0, 100.0, randSource),
 				Altitude:    randomFloat(500.0, 550.0, randSource),
 				Signal:      randomFloat(-90.0, -80.0, randSource), // Weak signal anomaly
-			}
+			}, metricSignal
 		}
 	}
 	return TelemetryPayload{
@@ -205,7 +339,7 @@ func generateTelemetryPayload(generateAnomaly bool, randSource *rand.Rand) Telem
 		Battery:     randomFloat(70.0, 100.0, randSource),  // Battery percentage
 		Altitude:    randomFloat(500.0, 550.0, randSource), // Orbit altitude
 		Signal:      randomFloat(-60.0, -40.0, randSource), // Signal strength
-	}
+	}, ""
 }
 func randomFloat(min, max float32, randSource *rand.Rand) float32 {
 	return min + randSource.Float32()*(max-min)
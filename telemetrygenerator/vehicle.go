@@ -0,0 +1,238 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// vehicle model constants
+const (
+	sunTemperature     = 28.0  // where the bus settles in sunlight with the heaters off, C
+	eclipseTemperature = 10.0  // and in eclipse, C
+	thermalTimeConst   = 1200  // how slowly the bus follows, s
+	heaterRate         = 0.05  // how fast each heater warms the bus, C/s
+	payloadHeatRate    = 0.005 // the payload warms the bus a little too, C/s
+	initialTemperature = 22.0
+	batteryCapacity    = 80.0 // Wh
+	solarPower         = 50.0 // W
+	baseLoad           = 18.0 // W
+	heaterLoad         = 6.0  // per heater, W
+	payloadLoad        = 20.0 // W
+	txEfficiency       = 0.4  // RF watts out per DC watt in
+	initialBattery     = 90.0
+	nominalAltitude    = 525.0   // km
+	altitudeDecay      = 0.00002 // km/s
+	nominalTxPower     = 5.0     // W
+	nominalSignal      = -50.0   // dB, at nominal transmitter power from nominalAltitude away
+	txOffSignal        = -120.0
+	defaultSetpoint    = 25.0
+	numberOfHeaters    = 4
+	rebootDuration     = 5 * time.Second
+	maxPhysicsStep     = 1.0 // s
+	temperatureNoise   = 0.05
+	batteryNoise       = 0.02
+	altitudeNoise      = 0.01
+	signalNoise        = 0.5
+)
+
+// vehicle is the simulated spacecraft's state. It's advanced by wall clock time, sped up by timeScale, whenever it's
+// sampled or commanded, so however many workers sample it they see one continuous vehicle. A vehicle with a
+// sampleStep runs on its own time instead, moving on that much for every sample, so a seeded run sends the same
+// telemetry every time
+type vehicle struct {
+	mu         sync.Mutex
+	updated    time.Time
+	timeScale  float64
+	sampleStep float64 // s, zero to follow the clock
+	//sensor noise, and where in its orbit the vehicle starts
+	randSource *rand.Rand
+	//seconds of vehicle time, which is how far the earth has turned under the orbit
+	elapsed     float64
+	orbit       orbit
+	sunlit      bool
+	temperature float64
+	battery     float64
+	heaters     [numberOfHeaters]bool
+	setpoint    float64
+	payloadOn   bool
+	txPower     float64
+	//an orbit raise burn in progress, in km/s for however many seconds are left of it
+	burnRate      float64
+	burnRemaining float64
+}
+
+// newVehicle starts a vehicle at a point in its orbit, and under the ground stations, drawn from seed, or a random
+// seed if it's zero. step is how much vehicle time passes between samples before timeScale speeds it up, or zero for
+// the vehicle to follow the clock
+func newVehicle(now time.Time, timeScale float64, seed int64, step time.Duration) *vehicle {
+	if timeScale <= 0 {
+		timeScale = 1
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	v := &vehicle{
+		updated:     now,
+		timeScale:   timeScale,
+		sampleStep:  step.Seconds() * timeScale,
+		randSource:  rand.New(rand.NewSource(seed)),
+		orbit:       newOrbit(nominalAltitude),
+		temperature: initialTemperature,
+		battery:     initialBattery,
+		setpoint:    defaultSetpoint,
+		txPower:     nominalTxPower,
+	}
+	//up to a day in, so the earth has turned under the orbit too
+	v.elapsed = v.randSource.Float64() * 86400
+	v.orbit.propagate(v.elapsed)
+	v.sunlit = !inEclipse(v.orbit.position())
+	return v
+}
+
+// advance moves the vehicle's state on to now. A vehicle on its own time only moves when it's sampled. The caller
+// holds the lock
+func (v *vehicle) advance(now time.Time) {
+	if v.sampleStep > 0 {
+		return
+	}
+	dt := now.Sub(v.updated).Seconds() * v.timeScale
+	if dt <= 0 {
+		return
+	}
+	v.updated = now
+	v.run(dt)
+}
+
+// run moves the vehicle on dt seconds of vehicle time, a step at a time so long gaps between samples don't skip over
+// an eclipse
+func (v *vehicle) run(dt float64) {
+	for dt > 0 {
+		step := math.Min(dt, maxPhysicsStep)
+		v.step(step)
+		dt -= step
+	}
+}
+
+func (v *vehicle) step(dt float64) {
+	v.elapsed += dt
+	v.orbit.propagate(dt)
+	if sunlit := !inEclipse(v.orbit.position()); sunlit != v.sunlit {
+		v.sunlit = sunlit
+		if sunlit {
+			emitEvent(eventEclipseExit, severityInfo, "eclipse exit")
+		} else {
+			emitEvent(eventEclipseEntry, severityInfo, "eclipse entry")
+		}
+	}
+
+	heatersOn := 0
+	for _, on := range v.heaters {
+		if on {
+			heatersOn++
+		}
+	}
+
+	//thermal: the bus drifts towards the sunlit or eclipse temperature, lagging behind the eclipse. Heaters act as a
+	//thermostat, only heating below the setpoint
+	equilibrium := eclipseTemperature
+	if v.sunlit {
+		equilibrium = sunTemperature
+	}
+	heating := 0.0
+	if v.temperature < v.setpoint {
+		heating += float64(heatersOn) * heaterRate
+	}
+	if v.payloadOn {
+		heating += payloadHeatRate
+	}
+	v.temperature += (heating + (equilibrium-v.temperature)/thermalTimeConst) * dt
+
+	//power: the arrays charge the battery in sunlight, everything switched on drains it
+	load := baseLoad + float64(heatersOn)*heaterLoad + v.txPower/txEfficiency
+	if v.payloadOn {
+		load += payloadLoad
+	}
+	generated := 0.0
+	if v.sunlit {
+		generated = solarPower
+	}
+	v.battery = clamp(v.battery+(generated-load)*dt/3600/batteryCapacity*100, 0, 100)
+
+	//orbit: drag slowly pulls us down, a burn pushes us up
+	v.orbit.semiMajorAxis -= altitudeDecay * dt
+	if v.burnRemaining > 0 {
+		burning := math.Min(dt, v.burnRemaining)
+		v.orbit.semiMajorAxis += v.burnRate * burning
+		v.burnRemaining -= burning
+	}
+}
+
+// signal is the received signal strength in dB, from the transmitter power and free space loss over the slant range
+// to the nearest ground station
+func (v *vehicle) signal(position vec3) float64 {
+	if v.txPower <= 0 {
+		return txOffSignal
+	}
+	distance := slantRange(position, v.elapsed)
+	return nominalSignal + 10*math.Log10(v.txPower/nominalTxPower) - 20*math.Log10(distance/nominalAltitude)
+}
+
+// next gives back the vehicle's telemetry as of now, or a step on from the last sample, with sensor noise. It makes
+// the vehicle a telemetrySource
+func (v *vehicle) next(_ uint16, _ *rand.Rand) (sample, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.advance(time.Now())
+	v.run(v.sampleStep)
+
+	description := "eclipse"
+	if v.sunlit {
+		description = "sunlit"
+	}
+	position := v.orbit.position()
+	truth := [4]float64{v.temperature, v.battery, position.norm() - earthRadius, v.signal(position)}
+	return sample{
+		payload: TelemetryPayload{
+			Temperature: float32(truth[0] + v.randSource.NormFloat64()*temperatureNoise),
+			Battery:     float32(clamp(truth[1]+v.randSource.NormFloat64()*batteryNoise, 0, 100)),
+			Altitude:    float32(truth[2] + v.randSource.NormFloat64()*altitudeNoise),
+			Signal:      float32(truth[3] + v.randSource.NormFloat64()*signalNoise),
+		},
+		description: description,
+		anomalies:   labelsFor(truth, description),
+	}, true
+}
+
+// execute applies a command to the vehicle, giving back how long the command takes to complete
+func (v *vehicle) execute(command string, args map[string]float64) time.Duration {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.advance(time.Now())
+
+	switch command {
+	case "HEATER_ON":
+		v.heaters[int(args["heater_id"])] = true
+	case "HEATER_OFF":
+		v.heaters[int(args["heater_id"])] = false
+	case "SET_HEATER_SETPOINT":
+		v.setpoint = args["setpoint"]
+	case "PAYLOAD_POWER":
+		v.payloadOn = args["on"] != 0
+	case "SET_TX_POWER":
+		v.txPower = args["power"]
+	case "ORBIT_RAISE":
+		//the burn is in vehicle time, the ground waits for it in wall clock time
+		v.burnRemaining = args["burn_seconds"]
+		v.burnRate = args["delta_altitude"] / v.burnRemaining
+		return time.Duration(v.burnRemaining / v.timeScale * float64(time.Second))
+	case "REBOOT_SUBSYSTEM":
+		return time.Duration(args["delay"])*time.Second + rebootDuration
+	}
+	return 0
+}
+
+func clamp(value float64, low float64, high float64) float64 {
+	return math.Max(low, math.Min(high, value))
+}
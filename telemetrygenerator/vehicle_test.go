@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// testVehicle is a vehicle on its own time at meanAnomaly in its orbit. A quarter orbit before perigee it's
+// sunlit, with the sun straight overhead, and a quarter orbit after it's straight behind the earth
+func testVehicle(meanAnomaly float64) *vehicle {
+	v := newVehicle(testStart, 1, 1, time.Second)
+	v.elapsed = 0
+	v.orbit = newOrbit(nominalAltitude)
+	v.orbit.meanAnomaly = meanAnomaly
+	v.sunlit = !inEclipse(v.orbit.position())
+	return v
+}
+
+func TestVehicleSunlight(t *testing.T) {
+	v := testVehicle(3 * math.Pi / 2)
+	if !v.sunlit {
+		t.Fatal("in eclipse with the sun overhead")
+	}
+	v.run(60)
+	//the arrays make more than the bus uses
+	drift := (solarPower - baseLoad - nominalTxPower/txEfficiency) * 60 / 3600 / batteryCapacity * 100
+	if math.Abs(v.battery-(initialBattery+drift)) > 1e-9 {
+		t.Errorf("battery is %v after a minute in the sun, expected %v", v.battery, initialBattery+drift)
+	}
+	if v.temperature <= initialTemperature {
+		t.Errorf("temperature is %v after a minute in the sun, expected it warmer than %v", v.temperature, initialTemperature)
+	}
+
+	//with everything on, the arrays can't keep up
+	v.payloadOn = true
+	v.heaters = [numberOfHeaters]bool{true, true, true, true}
+	before := v.battery
+	v.run(60)
+	if v.battery >= before {
+		t.Errorf("battery went from %v to %v with everything on, expected it to drain", before, v.battery)
+	}
+
+	//and it never charges past full
+	v.payloadOn = false
+	v.heaters = [numberOfHeaters]bool{}
+	v.battery = 99.9
+	v.run(600)
+	if v.battery != 100 {
+		t.Errorf("battery is %v, expected it full", v.battery)
+	}
+}
+
+func TestVehicleEclipse(t *testing.T) {
+	v := testVehicle(math.Pi / 2)
+	if v.sunlit {
+		t.Fatal("sunlit behind the earth")
+	}
+	v.run(60)
+	drift := (baseLoad + nominalTxPower/txEfficiency) * 60 / 3600 / batteryCapacity * 100
+	if math.Abs(v.battery-(initialBattery-drift)) > 1e-9 {
+		t.Errorf("battery is %v after a minute in eclipse, expected %v", v.battery, initialBattery-drift)
+	}
+	if v.temperature >= initialTemperature {
+		t.Errorf("temperature is %v after a minute in eclipse, expected it colder than %v", v.temperature, initialTemperature)
+	}
+
+	//heaters hold the bus at the setpoint, and don't heat it past
+	v.heaters[0] = true
+	v.temperature = 20
+	v.run(600)
+	if v.temperature < 20 || v.temperature > defaultSetpoint+heaterRate {
+		t.Errorf("temperature is %v with a heater on, expected it between 20 and the setpoint", v.temperature)
+	}
+}
+
+// TestVehicleEclipseTransitions runs the vehicle over an orbit, checking it goes into eclipse and out again, and
+// that the battery turns from charging to draining and back as it does
+func TestVehicleEclipseTransitions(t *testing.T) {
+	v := testVehicle(0)
+	if !v.sunlit {
+		t.Fatal("in eclipse over the pole")
+	}
+
+	var transitions []bool
+	var batteryAt []float64
+	previous := v.battery
+	for elapsed := 0; elapsed < 6000; elapsed++ {
+		sunlit := v.sunlit
+		v.run(1)
+		if v.sunlit != sunlit {
+			transitions = append(transitions, v.sunlit)
+			batteryAt = append(batteryAt, v.battery)
+			previous = v.battery
+			continue
+		}
+		charging := v.battery > previous || v.battery == 100
+		if charging != v.sunlit {
+			t.Fatalf("%ds in, battery went from %v to %v with the vehicle sunlit %v", elapsed, previous, v.battery, v.sunlit)
+		}
+		previous = v.battery
+	}
+	if len(transitions) != 2 || transitions[0] || !transitions[1] {
+		t.Fatalf("went sunlit %v, expected into eclipse and out again", transitions)
+	}
+	if batteryAt[1] >= batteryAt[0] {
+		t.Errorf("battery was %v going into eclipse and %v coming out", batteryAt[0], batteryAt[1])
+	}
+}
+
+func TestVehicleTime(t *testing.T) {
+	//following the clock, sped up
+	v := newVehicle(testStart, 60, 1, 0)
+	start := v.elapsed
+	v.advance(testStart.Add(time.Second))
+	if elapsed := v.elapsed - start; math.Abs(elapsed-60) > 1e-6 {
+		t.Errorf("advanced %vs in a second at 60 times, expected 60s", elapsed)
+	}
+
+	//on its own time, it only moves when it's sampled
+	v = newVehicle(testStart, 60, 1, time.Second)
+	start = v.elapsed
+	v.advance(time.Now())
+	if v.elapsed != start {
+		t.Errorf("a vehicle on its own time followed the clock %vs", v.elapsed-start)
+	}
+	v.next(0, nil)
+	v.next(1, nil)
+	if elapsed := v.elapsed - start; math.Abs(elapsed-120) > 1e-6 {
+		t.Errorf("advanced %vs in two samples a second apart at 60 times, expected 120s", elapsed)
+	}
+
+	//the same seed starts in the same place
+	if a, b := newVehicle(testStart, 1, 5, 0), newVehicle(testStart, 1, 5, 0); a.elapsed != b.elapsed || a.orbit != b.orbit {
+		t.Error("the same seed started two vehicles in different places")
+	}
+}
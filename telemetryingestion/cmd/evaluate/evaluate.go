@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"turiontakehome/telemetryingestion/internal/telemetryingestion"
+	"turiontakehome/telemetryingestion/pkg/evaluation"
+)
+
+const usage = `usage: evaluate -labels <file> [-packets <file>] [-faults <file>] [-json]
+
+compares telemetrygenerator's ground truth labels with what the anomaly detector flagged, and reports precision,
+recall and detection latency for each anomaly type.
+
+with -packets, the packets telemetrygenerator wrote with -out are run through the detector in process. Otherwise the
+flags are read from the telemetry table in the database at DATABASE_URL, and latency runs to when each row was stored.
+
+with -faults, packets telemetrygenerator's FAULT_LOG has corrupted on the way down are left out of the scores, since
+the ground can accept them with values that aren't the ones labelled`
+
+func main() {
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+
+	flags := flag.NewFlagSet("evaluate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, usage)
+	}
+	labelsPath := flags.String("labels", "", "")
+	packetsPath := flags.String("packets", "", "")
+	faultsPath := flags.String("faults", "", "")
+	asJSON := flags.Bool("json", false, "")
+	flags.Parse(os.Args[1:])
+	if *labelsPath == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	labels, err := readLabels(*labelsPath)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if len(labels) == 0 {
+		logger.Fatal("no labels to evaluate against")
+	}
+
+	corrupted := make(map[evaluation.Key]bool)
+	if *faultsPath != "" {
+		corrupted, err = readFaults(*faultsPath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	var detections map[evaluation.Key]evaluation.Detection
+	if *packetsPath != "" {
+		detections, err = detectInProcess(*packetsPath, labels)
+	} else {
+		detections, err = detectionsFromDatabase(ctx, labels)
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	report := evaluation.Evaluate(labels, detections, corrupted)
+	if *asJSON {
+		err = json.NewEncoder(os.Stdout).Encode(report)
+	} else {
+		err = report.Write(os.Stdout)
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+}
+
+func readLabels(path string) ([]evaluation.Label, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return evaluation.ReadLabels(file)
+}
+
+func readFaults(path string) (map[evaluation.Key]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return evaluation.ReadFaults(file)
+}
+
+// detectInProcess runs every packet in the file through the detector. A packet is detected on as soon as it's sent,
+// so latency is how long after an anomaly started the first flagged packet went
+func detectInProcess(path string, labels []evaluation.Label) (map[evaluation.Key]evaluation.Detection, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sentAt := make(map[evaluation.Key]time.Time, len(labels))
+	for _, label := range labels {
+		sentAt[label.Key()] = label.SentAt
+	}
+
+	detections := make(map[evaluation.Key]evaluation.Detection)
+	reader := bufio.NewReader(file)
+	var length [4]byte
+	for {
+		if _, err := io.ReadFull(reader, length[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return detections, nil
+			}
+			return nil, fmt.Errorf("invalid packets file: %w", err)
+		}
+		packet := make([]byte, binary.BigEndian.Uint32(length[:]))
+		if _, err := io.ReadFull(reader, packet); err != nil {
+			return nil, fmt.Errorf("invalid packets file: %w", err)
+		}
+
+		//anything the pipeline would reject never gets as far as the detector
+		data, err := telemetryingestion.DetectAnomalies(packet)
+		if err != nil {
+			continue
+		}
+		key := evaluation.Key{
+			APID:      data.PrimaryHeader.APID(),
+			Timestamp: int64(data.SecondaryHeader.Timestamp),
+			SeqCount:  data.PrimaryHeader.PacketSeqCtrl & 0x3FFF,
+		}
+		if _, seen := detections[key]; !seen {
+			detections[key] = evaluation.Detection{Flags: data.AnomalyFlags, DetectedAt: sentAt[key]}
+		}
+	}
+}
+
+// detectionsFromDatabase reads the flags the pipeline stored for the labelled packets. Duplicates of a packet are
+// judged by the first one stored
+func detectionsFromDatabase(ctx context.Context, labels []evaluation.Label) (map[evaluation.Key]evaluation.Detection, error) {
+	dbURL := os.Getenv("DATABASE_URL")
+	if dbURL == "" {
+		return nil, errors.New("DATABASE_URL environment variable is not set, and there's no -packets file")
+	}
+	pool, err := pgxpool.Connect(ctx, dbURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the database: %w", err)
+	}
+	defer pool.Close()
+
+	start, end := labels[0].Timestamp, labels[0].Timestamp
+	for _, label := range labels {
+		if label.Timestamp < start {
+			start = label.Timestamp
+		}
+		if label.Timestamp > end {
+			end = label.Timestamp
+		}
+	}
+	rows, err := pool.Query(ctx,
+		`SELECT timestamp, packet_id, seq_count, anomaly_flags, created_at FROM telemetry
+		WHERE timestamp BETWEEN $1 AND $2 ORDER BY created_at`,
+		time.Unix(start, 0), time.Unix(end, 0))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query telemetry: %w", err)
+	}
+	defer rows.Close()
+
+	detections := make(map[evaluation.Key]evaluation.Detection)
+	for rows.Next() {
+		var timestamp, createdAt time.Time
+		var packetID, seqCount, flags int32
+		if err := rows.Scan(&timestamp, &packetID, &seqCount, &flags, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan telemetry: %w", err)
+		}
+		key := evaluation.Key{APID: uint16(packetID) & 0x07FF, Timestamp: timestamp.Unix(), SeqCount: uint16(seqCount)}
+		if _, seen := detections[key]; !seen {
+			detections[key] = evaluation.Detection{Flags: uint32(flags), DetectedAt: createdAt}
+		}
+	}
+	return detections, rows.Err()
+}
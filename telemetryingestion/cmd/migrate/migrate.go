@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"turiontakehome/telemetryingestion/pkg/migrations"
+)
+
+const usage = `usage: migrate <command> [argument]
+
+commands:
+  up [version]        apply migrations up to version, or all of them
+  down [steps]        roll back the last steps migrations, 1 by default
+  status              show every migration and whether it's been applied
+  baseline <version>  record migrations up to version as applied without running them. A database
+                      created from init.sql before migrations is baselined at 1, then brought up
+
+the database is taken from DATABASE_URL`
+
+func main() {
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	command := os.Args[1]
+	argument := 0
+	if len(os.Args) > 2 {
+		n, err := strconv.Atoi(os.Args[2])
+		if err != nil || n < 0 {
+			fmt.Fprintf(os.Stderr, "invalid argument %q\n\n%s\n", os.Args[2], usage)
+			os.Exit(2)
+		}
+		argument = n
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	dbURL := os.Getenv("DATABASE_URL")
+	if dbURL == "" {
+		logger.Fatal("DATABASE_URL environment variable is not set")
+	}
+	pool, err := pgxpool.Connect(ctx, dbURL)
+	if err != nil {
+		logger.Fatalf("failed to connect to the database: %v", err)
+	}
+	defer pool.Close()
+
+	migrator, err := migrations.New(pool, logger)
+	if err != nil {
+		logger.Fatalf("failed to load migrations: %v", err)
+	}
+
+	switch command {
+	case "up":
+		err = migrator.Up(ctx, argument)
+	case "down":
+		if argument == 0 {
+			argument = 1
+		}
+		err = migrator.Down(ctx, argument)
+	case "baseline":
+		err = migrator.Baseline(ctx, argument)
+	case "status":
+		err = printStatus(ctx, migrator)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", command, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		logger.Fatalf("%s failed: %v", command, err)
+	}
+}
+
+func printStatus(ctx context.Context, migrator *migrations.Migrator) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, status := range statuses {
+		state, appliedAt := "pending", ""
+		if status.Applied {
+			state, appliedAt = "applied", status.AppliedAt.Format(time.RFC3339)
+		}
+		if status.Modified {
+			state = "modified since applied"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+	}
+	return w.Flush()
+}
@@ -1,7 +1,6 @@
 package telemetryingestion
 
 import (
-	"context"
 	"github.com/sirupsen/logrus"
 )
 
@@ -14,19 +13,15 @@ func newAlerter(alerterChannel chan TIData, logger *logrus.Logger) *telemetryAle
 	return &telemetryAlerter{alerterChannel, logger}
 }
 
-func (a *telemetryAlerter) run(ctx context.Context) {
+// run handles alerts until the validator closes the alert channel on shutdown
+func (a *telemetryAlerter) run() {
 	logrus.Info("starting alerter")
 
 	//in the future, this is the place where  we'll send alerts to some sort of paging or alerting system. We can do
 	//this in many forms that shouldn't be established in a vacuum; so for this test, we'll just simply log
 	//and know we'll come back to this later
-	for {
-		select {
-		case alert := <-a.alerterChannel:
-			a.log.Infof("alert received: %v", alert)
-		case <-ctx.Done():
-			a.log.Info("alerter canceled")
-			return
-		}
+	for alert := range a.alerterChannel {
+		a.log.Infof("alert received: %v", alert)
 	}
+	a.log.Info("alerter finished")
 }
@@ -0,0 +1,197 @@
+package telemetryingestion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sirupsen/logrus"
+	"time"
+	"turiontakehome/telemetryingestion/pkg/commanding"
+)
+
+// ackPacket is a command acknowledgement as it came off the wire
+type ackPacket struct {
+	ack        commanding.Ack
+	receivedAt time.Time
+	station    groundStation
+}
+
+// command states, as recorded in command_history, in the order a command moves through them
+var commandStateRank = map[string]int{
+	"queued":       0,
+	"sent":         1,
+	"acknowledged": 2,
+	"executing":    3,
+	"completed":    4,
+}
+
+// the state each verification stage moves a command to, and the column its time is recorded in
+var stageStates = map[uint8]struct{ state, column string }{
+	commanding.StageAccepted:  {"acknowledged", "acknowledged_at"},
+	commanding.StageStarted:   {"executing", "started_at"},
+	commanding.StageCompleted: {"completed", "completed_at"},
+}
+
+// commandVerifier matches acks to the commands in command_history they acknowledge, moving each command through its
+// verification stages, and fails commands that miss a stage's deadline. Every instance sweeps for timeouts; the
+// sweep only touches commands that are still pending, so running it more than once is harmless
+type commandVerifier struct {
+	ackChannel      chan ackPacket
+	dbPool          *pgxpool.Pool
+	ackTimeout      time.Duration
+	completeTimeout time.Duration
+	sweepInterval   time.Duration
+	errorChannel    chan error
+	log             *logrus.Logger
+}
+
+func newCommandVerifier(ackChan chan ackPacket, dbPool *pgxpool.Pool, ackTimeout time.Duration, completeTimeout time.Duration, errChan chan error, logger *logrus.Logger) *commandVerifier {
+	return &commandVerifier{ackChannel: ackChan, dbPool: dbPool, ackTimeout: ackTimeout, completeTimeout: completeTimeout, sweepInterval: time.Second, errorChannel: errChan, log: logger}
+}
+
+// run verifies acks until the decoder closes the channel on shutdown
+func (v *commandVerifier) run() {
+	v.log.Info("starting command verifier")
+
+	ticker := time.NewTicker(v.sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ack, ok := <-v.ackChannel:
+			if !ok {
+				v.log.Info("command verifier finished")
+				return
+			}
+			if err := v.verify(ack); err != nil {
+				v.errorChannel <- fmt.Errorf("failed to verify ack for APID %d seq %d: %w", ack.ack.CommandAPID, ack.ack.CommandSeqCount, err)
+			}
+		case <-ticker.C:
+			if err := v.expire(); err != nil {
+				v.errorChannel <- fmt.Errorf("failed to expire commands: %w", err)
+			}
+		}
+	}
+}
+
+// verify records an ack against the latest pending command with its APID and sequence count. Sequence counts wrap,
+// but a command can't still be pending by the time its count comes round again. Stages only move forward, so an ack
+// that arrives after a later one still records its time without moving the state back
+func (v *commandVerifier) verify(packet ackPacket) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := v.dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	var state string
+	err = tx.QueryRow(ctx,
+		`SELECT id, state FROM command_history
+		WHERE apid = $1 AND seq_count = $2 AND state NOT IN ('completed', 'failed')
+		ORDER BY id DESC LIMIT 1
+		FOR UPDATE`,
+		packet.ack.CommandAPID, packet.ack.CommandSeqCount).Scan(&id, &state)
+	if errors.Is(err, pgx.ErrNoRows) {
+		unmatchedAcks.Add(1)
+		v.log.Warnf("ack from %s for APID %d seq %d matches no pending command", packet.station.name, packet.ack.CommandAPID, packet.ack.CommandSeqCount)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	t := transition(state, packet.ack)
+	if t.failure != "" {
+		_, err = tx.Exec(ctx,
+			`UPDATE command_history SET state = 'failed', failed_at = $2, ack_code = $3, error = $4,
+			sent_at = COALESCE(sent_at, $2)
+			WHERE id = $1`,
+			id, packet.receivedAt, int(packet.ack.Code), t.failure)
+	} else {
+		//the ack can beat the backend recording that it sent the command
+		_, err = tx.Exec(ctx,
+			`UPDATE command_history SET state = $2, `+t.column+` = COALESCE(`+t.column+`, $3), ack_code = $4,
+			sent_at = COALESCE(sent_at, $3)
+			WHERE id = $1`,
+			id, t.state, packet.receivedAt, int(packet.ack.Code))
+	}
+	if err != nil {
+		return err
+	}
+	acksVerified.Add(1)
+	return tx.Commit(ctx)
+}
+
+// commandTransition is what an ack does to a pending command: moves it to state, recording the time in column, or
+// fails it with failure
+type commandTransition struct {
+	state   string
+	column  string
+	failure string
+}
+
+// transition works out what an ack does to a command in state
+func transition(state string, ack commanding.Ack) commandTransition {
+	stage := stageStates[ack.Stage]
+	if ack.Code != commanding.AckOK {
+		return commandTransition{
+			state:   "failed",
+			column:  "failed_at",
+			failure: fmt.Sprintf("failed %s: %s", commanding.StageName(ack.Stage), commanding.AckCodeName(ack.Code)),
+		}
+	}
+	next := state
+	if commandStateRank[stage.state] > commandStateRank[state] {
+		next = stage.state
+	}
+	return commandTransition{state: next, column: stage.column}
+}
+
+// expire fails commands that were queued but never sent within the ack timeout, sent commands that haven't been
+// acknowledged within it, and any that haven't completed within the completion timeout plus however long the command
+// runs for
+func (v *commandVerifier) expire() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	//the backend fails commands it can't send, so these are ones it went away before sending
+	tag, err := v.dbPool.Exec(ctx,
+		`UPDATE command_history SET state = 'failed', failed_at = now(), error = $2
+		WHERE state = 'queued' AND created_at < now() - make_interval(secs => $1)`,
+		v.ackTimeout.Seconds(), fmt.Sprintf("not sent within %v", v.ackTimeout))
+	if err != nil {
+		return err
+	}
+	expired := tag.RowsAffected()
+
+	tag, err = v.dbPool.Exec(ctx,
+		`UPDATE command_history SET state = 'failed', failed_at = now(), error = $2
+		WHERE state = 'sent' AND sent_at < now() - make_interval(secs => $1)`,
+		v.ackTimeout.Seconds(), fmt.Sprintf("no acknowledgement within %v", v.ackTimeout))
+	if err != nil {
+		return err
+	}
+	expired += tag.RowsAffected()
+
+	tag, err = v.dbPool.Exec(ctx,
+		`UPDATE command_history SET state = 'failed', failed_at = now(),
+		error = format('not completed within %s', make_interval(secs => $1 + execution_seconds))
+		WHERE state IN ('sent', 'acknowledged', 'executing') AND sent_at < now() - make_interval(secs => $1 + execution_seconds)`,
+		v.completeTimeout.Seconds())
+	if err != nil {
+		return err
+	}
+	expired += tag.RowsAffected()
+
+	if expired > 0 {
+		commandsTimedOut.Add(expired)
+		v.log.Warnf("%d commands timed out waiting on verification", expired)
+	}
+	return nil
+}
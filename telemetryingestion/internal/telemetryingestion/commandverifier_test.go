@@ -0,0 +1,120 @@
+package telemetryingestion
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"turiontakehome/telemetryingestion/pkg/commanding"
+)
+
+func rawTestPacket(packet []byte, station groundStation, receivedAt time.Time) rawPacket {
+	buffer := getPacketBuffer()
+	buffer.n = copy(buffer.buf[:], packet)
+	return rawPacket{buffer: buffer, receivedAt: receivedAt, station: station}
+}
+
+// TestDecoderRoutesAcks sends an ack and a telemetry packet through the decoder, and makes sure the ack goes to the
+// command verifier with where and when it was received, and the telemetry carries on to validation
+func TestDecoderRoutesAcks(t *testing.T) {
+	decoderChans := makePartitions[rawPacket](1, 10)
+	validatorChans := makePartitions[TIData](1, 10)
+	rejectedChan := make(chan rejectedPacket, 10)
+	ackChan := make(chan ackPacket, 10)
+	errCh := make(chan error, 10)
+	monitor := newLinkMonitor(nil, "test", time.Hour, time.Hour, newTestLogger())
+
+	station := groundStation{name: "svalbard", spacecraft: "sc1"}
+	receivedAt := time.Now()
+	ack := commanding.Ack{
+		SeqCount:        3,
+		Timestamp:       time.Unix(receivedAt.Unix(), 0),
+		CommandAPID:     20,
+		CommandSeqCount: 1234,
+		Stage:           commanding.StageCompleted,
+		Code:            commanding.AckOK,
+	}
+	decoderChans[0] <- rawTestPacket(ack.Encode(), station, receivedAt)
+	decoderChans[0] <- rawTestPacket(testPacket(1, 7, nominalPayload), station, receivedAt)
+	closePartitions(decoderChans)
+
+	newDecoder(decoderChans, make(chan TelemetryPayload), validatorChans, rejectedChan, ackChan, errCh, telemetryPacketLength, "test", monitor, newTestLogger()).run()
+	close(ackChan)
+
+	var acks []ackPacket
+	for packet := range ackChan {
+		acks = append(acks, packet)
+	}
+	if len(acks) != 1 {
+		t.Fatalf("got %d acks, expected 1", len(acks))
+	}
+	if acks[0].ack != ack || acks[0].station != station || !acks[0].receivedAt.Equal(receivedAt) {
+		t.Errorf("got ack %+v, expected %+v from %v", acks[0], ack, station)
+	}
+	if len(validatorChans[0]) != 1 {
+		t.Errorf("%d packets went on to validation, expected the telemetry packet", len(validatorChans[0]))
+	}
+	if len(errCh) != 0 || len(rejectedChan) != 0 {
+		t.Errorf("unexpected error or reject: %d errors %d rejects", len(errCh), len(rejectedChan))
+	}
+}
+
+func TestDecoderRejectsMalformedAcks(t *testing.T) {
+	decoderChans := makePartitions[rawPacket](1, 10)
+	rejectedChan := make(chan rejectedPacket, 10)
+	ackChan := make(chan ackPacket, 10)
+	errCh := make(chan error, 10)
+	monitor := newLinkMonitor(nil, "test", time.Hour, time.Hour, newTestLogger())
+
+	badStage := commanding.Ack{CommandAPID: 20, Stage: 9}.Encode()
+	decoderChans[0] <- rawTestPacket(badStage, groundStation{name: "svalbard"}, time.Now())
+	decoderChans[0] <- rawTestPacket(badStage[:len(badStage)-1], groundStation{name: "svalbard"}, time.Now())
+	closePartitions(decoderChans)
+
+	newDecoder(decoderChans, make(chan TelemetryPayload), makePartitions[TIData](1, 10), rejectedChan, ackChan, errCh, telemetryPacketLength, "test", monitor, newTestLogger()).run()
+
+	if len(ackChan) != 0 {
+		t.Errorf("%d malformed acks were sent to be verified", len(ackChan))
+	}
+	if len(rejectedChan) != 2 || len(errCh) != 2 {
+		t.Errorf("got %d rejects and %d errors, expected 2 of each", len(rejectedChan), len(errCh))
+	}
+}
+
+func TestCommandTransition(t *testing.T) {
+	tests := []struct {
+		state  string
+		stage  uint8
+		code   uint8
+		next   string
+		column string
+	}{
+		{"sent", commanding.StageAccepted, commanding.AckOK, "acknowledged", "acknowledged_at"},
+		{"acknowledged", commanding.StageStarted, commanding.AckOK, "executing", "started_at"},
+		{"executing", commanding.StageCompleted, commanding.AckOK, "completed", "completed_at"},
+		//the ack beat the backend recording that it sent the command
+		{"queued", commanding.StageAccepted, commanding.AckOK, "acknowledged", "acknowledged_at"},
+		//stages can be skipped when an ack is lost
+		{"sent", commanding.StageCompleted, commanding.AckOK, "completed", "completed_at"},
+		//an ack arriving after a later stage's records its time but doesn't move the command back
+		{"executing", commanding.StageAccepted, commanding.AckOK, "executing", "acknowledged_at"},
+		{"completed", commanding.StageStarted, commanding.AckOK, "completed", "started_at"},
+	}
+	for _, test := range tests {
+		got := transition(test.state, commanding.Ack{Stage: test.stage, Code: test.code})
+		if got.state != test.next || got.column != test.column || got.failure != "" {
+			t.Errorf("%s ack on a %s command: got %+v, expected %s in %s", commanding.StageName(test.stage), test.state, got, test.next, test.column)
+		}
+	}
+}
+
+func TestCommandTransitionFails(t *testing.T) {
+	for _, state := range []string{"sent", "acknowledged", "executing"} {
+		got := transition(state, commanding.Ack{Stage: commanding.StageStarted, Code: commanding.AckExecutionFailed})
+		if got.state != "failed" || got.column != "failed_at" {
+			t.Errorf("failed ack on a %s command: got %+v", state, got)
+		}
+		if !strings.Contains(got.failure, "execution start") || !strings.Contains(got.failure, "execution failed") {
+			t.Errorf("failure %q doesn't say which stage failed or why", got.failure)
+		}
+	}
+}
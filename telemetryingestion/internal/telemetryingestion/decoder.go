@@ -1,94 +1,166 @@
 package telemetryingestion
 
 import (
-	"bytes"
-	"context"
 	"encoding/binary"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"math"
 	"sync"
+	"turiontakehome/telemetryingestion/pkg/commanding"
 )
 
-//TODO packet expected size here
+// wire sizes of the packet sections
+const (
+	primaryHeaderSize    = 6
+	secondaryHeaderSize  = 10
+	telemetryPayloadSize = 16
+	//the PacketLength of a telemetry packet: everything after the primary header, less one
+	telemetryPacketLength = secondaryHeaderSize + telemetryPayloadSize - 1
+)
+
+// APIDs the spacecraft downlinks that aren't ingested yet. Their packets are counted in unsupported_packets and
+// dropped, rather than rejected, so they don't fill rejected_packets at the rate they're sent
+const (
+	attitudeAPID = 0x02
+	eventAPID    = 0x03
+)
 
 type decoder struct {
-	decodeChannel           chan []byte
+	decodeChannels          []chan rawPacket
 	telemetryPayloadChannel chan TelemetryPayload
-	validatorChannel        chan TIData
+	validatorChannels       []chan TIData
+	rejectedChannel         chan rejectedPacket
+	ackChannel              chan ackPacket
 	errChan                 chan error
 	numberOfWorkers         int
 	expectedPacketLength    uint16
+	instance                string
+	linkMonitor             *linkMonitor
 	log                     *logrus.Logger
 }
 
-func newDecoder(decoderChan chan []byte, telemetryPayloadChan chan TelemetryPayload, validatorChan chan TIData, numberOfWorkers int, expPktLen uint16, logger *logrus.Logger) *decoder {
-	return &decoder{decodeChannel: decoderChan, telemetryPayloadChannel: telemetryPayloadChan, validatorChannel: validatorChan, numberOfWorkers: numberOfWorkers, expectedPacketLength: expPktLen, log: logger}
+// newDecoder starts a worker per decode channel; each worker owns the APIDs partitioned onto its channel
+func newDecoder(decoderChans []chan rawPacket, telemetryPayloadChan chan TelemetryPayload, validatorChans []chan TIData, rejectedChan chan rejectedPacket, ackChan chan ackPacket, errChan chan error, expPktLen uint16, instance string, monitor *linkMonitor, logger *logrus.Logger) *decoder {
+	return &decoder{decodeChannels: decoderChans, telemetryPayloadChannel: telemetryPayloadChan, validatorChannels: validatorChans, rejectedChannel: rejectedChan, ackChannel: ackChan, errChan: errChan, numberOfWorkers: len(decoderChans), expectedPacketLength: expPktLen, instance: instance, linkMonitor: monitor, log: logger}
 }
 
-func (d *decoder) run(ctx context.Context) {
+// run starts the decoding workers and returns once they have drained the decode channels, which happens after the
+// listener closes them on shutdown
+func (d *decoder) run() {
 	d.log.Infof("starting %d decoding workers", d.numberOfWorkers)
 	wg := &sync.WaitGroup{}
 	for i := 0; i < d.numberOfWorkers; i++ {
 		wg.Add(1)
 		go func(workerNum int) {
 			defer wg.Done()
-			d.decodingWorker(ctx, workerNum)
+			d.decodingWorker(workerNum)
 		}(i)
 	}
 
-	<-ctx.Done()
-	d.log.Info("decoding canceled")
 	wg.Wait()
+	d.log.Info("decoding finished")
 }
 
-func (d *decoder) decodingWorker(ctx context.Context, workerNum int) {
+func (d *decoder) decodingWorker(workerNum int) {
 	d.log.Infof("Starting decoding worker #%d", workerNum)
-	for {
-		select {
-		case packet := <-d.decodeChannel:
-			data, err := decodePacket(packet, d.expectedPacketLength, d.log)
-			if err != nil {
-				d.errChan <- err
-				continue
-			}
-			d.validatorChannel <- data
-		case <-ctx.Done():
-			d.log.Infof("decoding canceled for worker #%d", workerNum)
-			return
+	for packet := range d.decodeChannels[workerNum] {
+		switch peekAPID(packet.buffer.bytes()) {
+		//command acks share the downlink with telemetry but go to the command verifier
+		case commanding.AckAPID:
+			d.decodeAck(packet)
+			continue
+		case attitudeAPID, eventAPID:
+			unsupportedPackets.Add(1)
+			packet.buffer.release()
+			continue
+		}
+
+		data, err := decodePacket(packet.buffer.bytes(), d.expectedPacketLength)
+		if err != nil {
+			decodeErrors.Add(1)
+			d.reject(packet, err)
+			packet.buffer.release()
+			d.errChan <- err
+			continue
 		}
+		//everything we need has been copied out, the buffer can go back to the listener
+		packet.buffer.release()
+		data.GroundReceiveTime = packet.receivedAt
+		data.GroundStation = packet.station.name
+		data.Spacecraft = packet.station.spacecraft
+		data.IngestInstance = d.instance
+		d.linkMonitor.observe(data.Spacecraft, data.PrimaryHeader.APID(), data.GroundReceiveTime)
+		d.validatorChannels[partitionFor(data.PrimaryHeader.APID(), len(d.validatorChannels))] <- data
 	}
+	d.log.Infof("decode channel drained for worker #%d", workerNum)
+}
 
+// decodeAck hands an ack to the command verifier. Losing one would fail its command, so unlike rejects, acks wait for
+// the verifier; there are few enough of them that it's never far behind
+func (d *decoder) decodeAck(packet rawPacket) {
+	ack, err := commanding.DecodeAck(packet.buffer.bytes())
+	if err != nil {
+		decodeErrors.Add(1)
+		d.reject(packet, err)
+		packet.buffer.release()
+		d.errChan <- err
+		return
+	}
+	packet.buffer.release()
+	d.ackChannel <- ackPacket{ack: ack, receivedAt: packet.receivedAt, station: packet.station}
 }
 
-func decodePacket(packet []byte, expectedPacketLength uint16, log *logrus.Logger) (TIData, error) {
-	log.Info("decoding packet")
-	buf := bytes.NewReader(packet)
+// reject copies the packet out of its buffer and hands it to the rejected writer, dropping it if the writer is behind
+func (d *decoder) reject(packet rawPacket, err error) {
+	rejected := rejectedPacket{
+		receivedAt: packet.receivedAt,
+		station:    packet.station,
+		instance:   d.instance,
+		reason:     err.Error(),
+		packet:     append([]byte(nil), packet.buffer.bytes()...),
+	}
+	select {
+	case d.rejectedChannel <- rejected:
+	default:
+		rejectsDropped.Add(1)
+	}
+}
+
+// decodePacket decodes straight out of the packet bytes; it's on the hot path, so it doesn't allocate unless the
+// packet is bad
+func decodePacket(packet []byte, expectedPacketLength uint16) (TIData, error) {
+	var data TIData
 
 	// primary header decoding
-	var primaryHeader CCSDSPrimaryHeader
-	err := binary.Read(buf, binary.BigEndian, &primaryHeader)
-	if err != nil {
-		return TIData{}, fmt.Errorf("error decoding primary header: %v", err)
+	if len(packet) < primaryHeaderSize {
+		return TIData{}, fmt.Errorf("error decoding primary header: packet is %d bytes", len(packet))
 	}
+	data.PrimaryHeader.PacketID = binary.BigEndian.Uint16(packet[0:2])
+	data.PrimaryHeader.PacketSeqCtrl = binary.BigEndian.Uint16(packet[2:4])
+	data.PrimaryHeader.PacketLength = binary.BigEndian.Uint16(packet[4:6])
 
 	//we know the packet size that's being sent to us, so we can throw out anything that does meet the requirement
-	if primaryHeader.PacketLength != expectedPacketLength {
-		return TIData{}, fmt.Errorf("unexpected packet length. got: %d expected: %d", primaryHeader.PacketLength, expectedPacketLength)
+	if data.PrimaryHeader.PacketLength != expectedPacketLength {
+		return TIData{}, fmt.Errorf("unexpected packet length. got: %d expected: %d", data.PrimaryHeader.PacketLength, expectedPacketLength)
 	}
 
 	// secondary header decoding
-	var secondaryHeader CCSDSSecondaryHeader
-	err = binary.Read(buf, binary.BigEndian, &secondaryHeader)
-	if err != nil {
-		return TIData{}, fmt.Errorf("error decoding secondary header: %v", err)
+	if len(packet) < primaryHeaderSize+secondaryHeaderSize {
+		return TIData{}, fmt.Errorf("error decoding secondary header: packet is %d bytes", len(packet))
 	}
+	body := packet[primaryHeaderSize:]
+	data.SecondaryHeader.Timestamp = binary.BigEndian.Uint64(body[0:8])
+	data.SecondaryHeader.SubsystemID = binary.BigEndian.Uint16(body[8:10])
 
 	// telemetry payload decoding
-	var payload TelemetryPayload
-	err = binary.Read(buf, binary.BigEndian, &payload)
-	if err != nil {
-		return TIData{}, fmt.Errorf("error decoding telemetry payload: %v", err)
+	if len(packet) < primaryHeaderSize+secondaryHeaderSize+telemetryPayloadSize {
+		return TIData{}, fmt.Errorf("error decoding telemetry payload: packet is %d bytes", len(packet))
 	}
+	payload := body[secondaryHeaderSize:]
+	data.TelemetryPayload.Temperature = math.Float32frombits(binary.BigEndian.Uint32(payload[0:4]))
+	data.TelemetryPayload.Battery = math.Float32frombits(binary.BigEndian.Uint32(payload[4:8]))
+	data.TelemetryPayload.Altitude = math.Float32frombits(binary.BigEndian.Uint32(payload[8:12]))
+	data.TelemetryPayload.Signal = math.Float32frombits(binary.BigEndian.Uint32(payload[12:16]))
 
-	return TIData{PrimaryHeader: primaryHeader, SecondaryHeader: secondaryHeader, TelemetryPayload: payload}, nil
+	return data, nil
 }
@@ -0,0 +1,119 @@
+package telemetryingestion
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDecodePacket(t *testing.T) {
+	packet := testPacket(1, 42, nominalPayload)
+
+	data, err := decodePacket(packet, telemetryPacketLength)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if data.PrimaryHeader.APID() != 1 || data.PrimaryHeader.PacketSeqCtrl&0x3FFF != 42 {
+		t.Errorf("decoded apid %d seq %d", data.PrimaryHeader.APID(), data.PrimaryHeader.PacketSeqCtrl&0x3FFF)
+	}
+	if data.PrimaryHeader.PacketLength != telemetryPacketLength {
+		t.Errorf("decoded packet length %d", data.PrimaryHeader.PacketLength)
+	}
+	if data.SecondaryHeader.SubsystemID != 1 {
+		t.Errorf("decoded subsystem %d", data.SecondaryHeader.SubsystemID)
+	}
+	if data.TelemetryPayload != nominalPayload {
+		t.Errorf("decoded payload %+v, expected %+v", data.TelemetryPayload, nominalPayload)
+	}
+}
+
+func TestDecodePacketRejects(t *testing.T) {
+	packet := testPacket(1, 42, nominalPayload)
+	wrongLength := append([]byte(nil), packet...)
+	binary.BigEndian.PutUint16(wrongLength[4:6], telemetryPacketLength+1)
+
+	tests := map[string][]byte{
+		"empty":                    nil,
+		"short primary header":     packet[:primaryHeaderSize-1],
+		"short secondary header":   packet[:primaryHeaderSize+secondaryHeaderSize-1],
+		"short payload":            packet[:len(packet)-1],
+		"unexpected packet length": wrongLength,
+	}
+	for name, packet := range tests {
+		if _, err := decodePacket(packet, telemetryPacketLength); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// TestDecodePacketConcurrent decodes the same bytes from several workers at once, as the decoders do. Run it with
+// -race
+func TestDecodePacketConcurrent(t *testing.T) {
+	packet := testPacket(1, 42, nominalPayload)
+
+	wg := &sync.WaitGroup{}
+	for w := 0; w < decoderWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				data, err := decodePacket(packet, telemetryPacketLength)
+				if err != nil || data.TelemetryPayload != nominalPayload {
+					t.Errorf("decoded %+v: %v", data.TelemetryPayload, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkDecodePacket(b *testing.B) {
+	packet := testPacket(1, 42, nominalPayload)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet)))
+	for i := 0; i < b.N; i++ {
+		if _, err := decodePacket(packet, telemetryPacketLength); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestDecoderDropsUnsupportedPackets sends attitude and event packets, which aren't ingested, alongside telemetry, and
+// makes sure they're counted and dropped without being rejected or reported as errors
+func TestDecoderDropsUnsupportedPackets(t *testing.T) {
+	decoderChans := makePartitions[rawPacket](1, 10)
+	validatorChans := makePartitions[TIData](1, 10)
+	rejectedChan := make(chan rejectedPacket, 10)
+	errCh := make(chan error, 10)
+	monitor := newLinkMonitor(nil, "test", time.Hour, time.Hour, newTestLogger())
+
+	//attitude is a quaternion and body rates, seven floats, and an event a code, severity and message
+	attitude := make([]byte, primaryHeaderSize+secondaryHeaderSize+28)
+	binary.BigEndian.PutUint16(attitude[0:2], 0x0800|attitudeAPID)
+	binary.BigEndian.PutUint16(attitude[4:6], uint16(secondaryHeaderSize+28-1))
+	event := make([]byte, primaryHeaderSize+secondaryHeaderSize+4+len("safe mode"))
+	binary.BigEndian.PutUint16(event[0:2], 0x0800|eventAPID)
+	binary.BigEndian.PutUint16(event[4:6], uint16(len(event)-primaryHeaderSize-1))
+
+	station := groundStation{name: "svalbard", spacecraft: "sc1"}
+	unsupported := unsupportedPackets.Value()
+	decoderChans[0] <- rawTestPacket(attitude, station, time.Now())
+	decoderChans[0] <- rawTestPacket(event, station, time.Now())
+	decoderChans[0] <- rawTestPacket(testPacket(1, 7, nominalPayload), station, time.Now())
+	closePartitions(decoderChans)
+
+	newDecoder(decoderChans, make(chan TelemetryPayload), validatorChans, rejectedChan, make(chan ackPacket, 10), errCh, telemetryPacketLength, "test", monitor, newTestLogger()).run()
+
+	if dropped := unsupportedPackets.Value() - unsupported; dropped != 2 {
+		t.Errorf("counted %d unsupported packets, expected 2", dropped)
+	}
+	if len(rejectedChan) != 0 || len(errCh) != 0 {
+		t.Errorf("got %d rejects and %d errors, expected none", len(rejectedChan), len(errCh))
+	}
+	if len(validatorChans[0]) != 1 {
+		t.Errorf("%d packets went on to validation, expected the telemetry packet", len(validatorChans[0]))
+	}
+}
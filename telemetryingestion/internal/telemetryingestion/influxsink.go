@@ -0,0 +1,141 @@
+package telemetryingestion
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// influxSink writes telemetry to InfluxDB in line protocol through the v2 write api. Each packet is one point,
+// tagged with where it came from and timestamped with the onboard time
+type influxSink struct {
+	client      *http.Client
+	writeURL    string
+	token       string
+	measurement string
+}
+
+func newInfluxSink(baseURL string, org string, bucket string, token string, measurement string) (*influxSink, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/") + "/api/v2/write")
+	if err != nil {
+		return nil, fmt.Errorf("invalid influx url %s: %w", baseURL, err)
+	}
+	query := u.Query()
+	query.Set("org", org)
+	query.Set("bucket", bucket)
+	query.Set("precision", "s") //the onboard clock only has second resolution
+	u.RawQuery = query.Encode()
+
+	return &influxSink{client: &http.Client{}, writeURL: u.String(), token: token, measurement: measurement}, nil
+}
+
+func (i *influxSink) Name() string {
+	return "influx"
+}
+
+func (i *influxSink) Write(ctx context.Context, packets []TIData) error {
+	body := &bytes.Buffer{}
+	for _, packet := range packets {
+		writeLineProtocol(body, i.measurement, packet)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.writeURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.token != "" {
+		req.Header.Set("Authorization", "Token "+i.token)
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := fmt.Errorf("influx write failed with %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		//influx rejected the batch itself, e.g. bad line protocol or a missing bucket, and sending it again won't
+		//change that. Timeouts and rate limiting are worth another try
+		if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			return permanentError{err}
+		}
+		return err
+	}
+	return nil
+}
+
+func (i *influxSink) Close(ctx context.Context) error {
+	i.client.CloseIdleConnections()
+	return nil
+}
+
+// writeLineProtocol writes a packet as a single point, e.g.
+// telemetry,spacecraft=sc1,apid=1,subsystem_id=1,ground_station=svalbard anomaly_flags=0i,seq_count=7i,temperature=25.1,... 1733360400
+func writeLineProtocol(buf *bytes.Buffer, measurement string, packet TIData) {
+	buf.WriteString(lineProtocolEscaper(measurement, false))
+
+	writeTag(buf, "spacecraft", packet.Spacecraft)
+	writeTag(buf, "apid", strconv.Itoa(int(packet.PrimaryHeader.APID())))
+	writeTag(buf, "subsystem_id", strconv.Itoa(int(packet.SecondaryHeader.SubsystemID)))
+	writeTag(buf, "ground_station", packet.GroundStation)
+
+	//the integer fields are always there, so a point has fields even when every float is skipped
+	buf.WriteByte(' ')
+	fmt.Fprintf(buf, "anomaly_flags=%di,seq_count=%di", packet.AnomalyFlags, packet.PrimaryHeader.PacketSeqCtrl&0x3FFF)
+	writeFloatField(buf, "temperature", packet.TelemetryPayload.Temperature)
+	writeFloatField(buf, "battery", packet.TelemetryPayload.Battery)
+	writeFloatField(buf, "altitude", packet.TelemetryPayload.Altitude)
+	writeFloatField(buf, "signal", packet.TelemetryPayload.Signal)
+	if !packet.GroundReceiveTime.IsZero() {
+		fmt.Fprintf(buf, ",latency_ms=%di", packet.GroundReceiveTime.Sub(time.Unix(int64(packet.SecondaryHeader.Timestamp), 0)).Milliseconds())
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatUint(packet.SecondaryHeader.Timestamp, 10))
+	buf.WriteByte('\n')
+}
+
+// writeTag skips empty values, line protocol doesn't allow them
+func writeTag(buf *bytes.Buffer, key string, value string) {
+	if value == "" {
+		return
+	}
+	buf.WriteByte(',')
+	buf.WriteString(lineProtocolEscaper(key, true))
+	buf.WriteByte('=')
+	buf.WriteString(lineProtocolEscaper(value, true))
+}
+
+// writeFloatField skips NaN and infinite values; line protocol can't represent them and influx rejects the whole batch
+func writeFloatField(buf *bytes.Buffer, key string, value float32) {
+	if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+		return
+	}
+	buf.WriteByte(',')
+	buf.WriteString(lineProtocolEscaper(key, true))
+	buf.WriteByte('=')
+	buf.WriteString(strconv.FormatFloat(float64(value), 'f', -1, 32))
+}
+
+var (
+	measurementReplacer = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagReplacer         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+// lineProtocolEscaper escapes measurements, or tag keys, tag values and field keys
+func lineProtocolEscaper(value string, tag bool) string {
+	if tag {
+		return tagReplacer.Replace(value)
+	}
+	return measurementReplacer.Replace(value)
+}
@@ -0,0 +1,207 @@
+package telemetryingestion
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// influxStandIn answers writes with the given status codes in turn, repeating the last, and keeps what it was sent
+type influxStandIn struct {
+	server   *httptest.Server
+	statuses []int
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func newInfluxStandIn(t *testing.T, statuses ...int) *influxStandIn {
+	standIn := &influxStandIn{statuses: statuses}
+	standIn.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		standIn.mu.Lock()
+		standIn.requests = append(standIn.requests, r)
+		standIn.bodies = append(standIn.bodies, string(body))
+		status := standIn.statuses[min(len(standIn.requests), len(standIn.statuses))-1]
+		standIn.mu.Unlock()
+
+		if status/100 != 2 {
+			http.Error(w, `{"code":"invalid","message":"rejected"}`, status)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(standIn.server.Close)
+	return standIn
+}
+
+func (s *influxStandIn) received() ([]*http.Request, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests, s.bodies
+}
+
+func testInfluxPacket() TIData {
+	return TIData{
+		PrimaryHeader:     CCSDSPrimaryHeader{PacketID: 0x0801, PacketSeqCtrl: 0xC02A},
+		SecondaryHeader:   CCSDSSecondaryHeader{Timestamp: 1733360400, SubsystemID: 1},
+		TelemetryPayload:  TelemetryPayload{Temperature: 25.5, Battery: 80, Altitude: 550, Signal: -60},
+		GroundReceiveTime: time.Unix(1733360400, 120*int64(time.Millisecond)),
+		GroundStation:     "svalbard",
+		Spacecraft:        "sc1",
+	}
+}
+
+func TestInfluxSinkWrite(t *testing.T) {
+	standIn := newInfluxStandIn(t, http.StatusNoContent)
+	sink, err := newInfluxSink(standIn.server.URL+"/", "turion", "telemetry", "secret", "telemetry")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Write(context.Background(), []TIData{testInfluxPacket(), testInfluxPacket()}); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	requests, bodies := standIn.received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, expected 1", len(requests))
+	}
+	req := requests[0]
+	if req.URL.Path != "/api/v2/write" {
+		t.Errorf("wrote to %s", req.URL.Path)
+	}
+	query := req.URL.Query()
+	if query.Get("org") != "turion" || query.Get("bucket") != "telemetry" || query.Get("precision") != "s" {
+		t.Errorf("wrote with query %v", query)
+	}
+	if req.Header.Get("Authorization") != "Token secret" {
+		t.Errorf("wrote with authorization %q", req.Header.Get("Authorization"))
+	}
+
+	line := "telemetry,spacecraft=sc1,apid=1,subsystem_id=1,ground_station=svalbard " +
+		"anomaly_flags=0i,seq_count=42i,temperature=25.5,battery=80,altitude=550,signal=-60,latency_ms=120i 1733360400\n"
+	if bodies[0] != line+line {
+		t.Errorf("wrote\n%s\nexpected\n%s", bodies[0], line+line)
+	}
+}
+
+func TestWriteLineProtocolSkipsNonFiniteFields(t *testing.T) {
+	packet := testInfluxPacket()
+	packet.TelemetryPayload = TelemetryPayload{
+		Temperature: float32(math.NaN()),
+		Battery:     80,
+		Altitude:    float32(math.Inf(1)),
+		Signal:      float32(math.Inf(-1)),
+	}
+	packet.GroundReceiveTime = time.Time{}
+
+	buf := &bytes.Buffer{}
+	writeLineProtocol(buf, "telemetry", packet)
+
+	expected := "telemetry,spacecraft=sc1,apid=1,subsystem_id=1,ground_station=svalbard anomaly_flags=0i,seq_count=42i,battery=80 1733360400\n"
+	if buf.String() != expected {
+		t.Errorf("wrote %q, expected %q", buf.String(), expected)
+	}
+
+	packet.TelemetryPayload.Battery = float32(math.NaN())
+	buf.Reset()
+	writeLineProtocol(buf, "telemetry", packet)
+	expected = "telemetry,spacecraft=sc1,apid=1,subsystem_id=1,ground_station=svalbard anomaly_flags=0i,seq_count=42i 1733360400\n"
+	if buf.String() != expected {
+		t.Errorf("wrote %q, expected %q", buf.String(), expected)
+	}
+}
+
+func TestWriteLineProtocolEscapes(t *testing.T) {
+	packet := testInfluxPacket()
+	packet.GroundStation = "mc murdo,=1"
+
+	buf := &bytes.Buffer{}
+	writeLineProtocol(buf, "my telemetry", packet)
+	if !strings.HasPrefix(buf.String(), `my\ telemetry,spacecraft=sc1,apid=1,subsystem_id=1,ground_station=mc\ murdo\,\=1 `) {
+		t.Errorf("wrote %q", buf.String())
+	}
+}
+
+func TestInfluxSinkRetries(t *testing.T) {
+	tests := []struct {
+		status   int
+		attempts int
+	}{
+		//influx rejected the batch, retrying won't help
+		{http.StatusBadRequest, 1},
+		{http.StatusUnauthorized, 1},
+		{http.StatusNotFound, 1},
+		{http.StatusRequestEntityTooLarge, 1},
+		//worth another try
+		{http.StatusRequestTimeout, 3},
+		{http.StatusTooManyRequests, 3},
+		{http.StatusInternalServerError, 3},
+		{http.StatusServiceUnavailable, 3},
+	}
+	for _, test := range tests {
+		standIn := newInfluxStandIn(t, test.status)
+		sink, err := newInfluxSink(standIn.server.URL, "turion", "telemetry", "", "telemetry")
+		if err != nil {
+			t.Fatal(err)
+		}
+		errCh := make(chan error, 1)
+		runner := newSinkRunner(sink, sinkConfig{writeTimeout: time.Second, maxRetries: 2, retryBackoff: time.Millisecond}, errCh, newTestLogger())
+
+		runner.flush([]TIData{testInfluxPacket()})
+
+		if requests, _ := standIn.received(); len(requests) != test.attempts {
+			t.Errorf("%d: made %d attempts, expected %d", test.status, len(requests), test.attempts)
+		}
+		if err := <-errCh; !strings.Contains(err.Error(), "rejected") {
+			t.Errorf("%d: reported %v", test.status, err)
+		}
+	}
+}
+
+func TestInfluxSinkRecoversOnRetry(t *testing.T) {
+	standIn := newInfluxStandIn(t, http.StatusServiceUnavailable, http.StatusNoContent)
+	sink, err := newInfluxSink(standIn.server.URL, "turion", "telemetry", "", "telemetry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 1)
+	runner := newSinkRunner(sink, sinkConfig{writeTimeout: time.Second, maxRetries: 2, retryBackoff: time.Millisecond}, errCh, newTestLogger())
+
+	runner.flush([]TIData{testInfluxPacket()})
+
+	if requests, _ := standIn.received(); len(requests) != 2 {
+		t.Errorf("made %d attempts, expected 2", len(requests))
+	}
+	if written := sinkMetric(runner, "written"); written != 1 {
+		t.Errorf("counted %d written, expected 1", written)
+	}
+	if len(errCh) != 0 {
+		t.Errorf("unexpected error: %v", <-errCh)
+	}
+}
+
+func TestInfluxSinkPermanentError(t *testing.T) {
+	standIn := newInfluxStandIn(t, http.StatusBadRequest)
+	sink, err := newInfluxSink(standIn.server.URL, "turion", "telemetry", "", "telemetry")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sink.Write(context.Background(), []TIData{testInfluxPacket()})
+	var permanent permanentError
+	if !errors.As(err, &permanent) {
+		t.Errorf("got %v, expected a permanent error", err)
+	}
+}
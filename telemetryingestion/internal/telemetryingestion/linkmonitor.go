@@ -0,0 +1,201 @@
+package telemetryingestion
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	linkStateAOS = "AOS" // acquisition of signal -- telemetry is flowing
+	linkStateLOS = "LOS" // loss of signal -- telemetry stopped arriving
+)
+
+// streamKey identifies a telemetry stream; each APID downlinked by a spacecraft is tracked on its own
+type streamKey struct {
+	spacecraft string
+	apid       uint16
+}
+
+type streamState struct {
+	state      string
+	lastPacket time.Time
+	changedAt  time.Time
+}
+
+type linkEvent struct {
+	key        streamKey
+	state      string
+	lastPacket time.Time
+	changedAt  time.Time
+}
+
+// linkMonitor tracks when we last heard from every stream. A stream that stays silent longer than the los timeout
+// raises a loss of signal, and the next packet we get from it raises an acquisition of signal. Each ingest instance
+// only hears the senders the kernel hashes to it, so the state it keeps is its own, keyed by instance in link_state
+type linkMonitor struct {
+	mu            sync.Mutex
+	streams       map[streamKey]*streamState
+	events        chan linkEvent
+	instance      string
+	losTimeout    time.Duration
+	checkInterval time.Duration
+	dbPool        *pgxpool.Pool
+	log           *logrus.Logger
+}
+
+func newLinkMonitor(dbPool *pgxpool.Pool, instance string, losTimeout time.Duration, checkInterval time.Duration, logger *logrus.Logger) *linkMonitor {
+	return &linkMonitor{
+		streams:       make(map[streamKey]*streamState),
+		events:        make(chan linkEvent, 100),
+		instance:      instance,
+		losTimeout:    losTimeout,
+		checkInterval: checkInterval,
+		dbPool:        dbPool,
+		log:           logger,
+	}
+}
+
+// observe records a packet for the stream, raising an AOS if the stream is new or was in LOS
+func (l *linkMonitor) observe(spacecraft string, apid uint16, receivedAt time.Time) {
+	key := streamKey{spacecraft: spacecraft, apid: apid}
+
+	l.mu.Lock()
+	stream, known := l.streams[key]
+	if !known {
+		stream = &streamState{}
+		l.streams[key] = stream
+	}
+	if receivedAt.After(stream.lastPacket) {
+		stream.lastPacket = receivedAt
+	}
+	acquired := !known || stream.state == linkStateLOS
+	if acquired {
+		stream.state = linkStateAOS
+		stream.changedAt = receivedAt
+	}
+	event := linkEvent{key: key, state: stream.state, lastPacket: stream.lastPacket, changedAt: stream.changedAt}
+	l.mu.Unlock()
+
+	if acquired {
+		l.emit(event)
+	}
+}
+
+func (l *linkMonitor) run(ctx context.Context) {
+	l.log.Infof("starting link monitor, loss of signal after %v of silence", l.losTimeout)
+	l.loadStreams(ctx)
+
+	ticker := time.NewTicker(l.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			l.log.Info("link monitor canceled")
+			return
+		case now := <-ticker.C:
+			l.checkForLossOfSignal(now)
+		case event := <-l.events:
+			l.record(ctx, event)
+		}
+	}
+}
+
+// loadStreams picks up the streams this instance knew about before a restart, so a stream that never comes back still
+// raises LOS. Streams other instances are receiving are left to them
+func (l *linkMonitor) loadStreams(ctx context.Context) {
+	rows, err := l.dbPool.Query(ctx,
+		`SELECT spacecraft, apid, state, last_packet_at, changed_at FROM link_state WHERE ingest_instance = $1`, l.instance)
+	if err != nil {
+		l.log.Errorf("failed to load link state: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for rows.Next() {
+		var key streamKey
+		var stream streamState
+		var apid int32
+		if err := rows.Scan(&key.spacecraft, &apid, &stream.state, &stream.lastPacket, &stream.changedAt); err != nil {
+			l.log.Errorf("failed to scan link state: %v", err)
+			return
+		}
+		key.apid = uint16(apid)
+		if _, ok := l.streams[key]; !ok {
+			l.streams[key] = &stream
+		}
+	}
+}
+
+func (l *linkMonitor) checkForLossOfSignal(now time.Time) {
+	var lost []linkEvent
+
+	l.mu.Lock()
+	for key, stream := range l.streams {
+		if stream.state == linkStateAOS && now.Sub(stream.lastPacket) > l.losTimeout {
+			stream.state = linkStateLOS
+			stream.changedAt = now.UTC()
+			lost = append(lost, linkEvent{key: key, state: stream.state, lastPacket: stream.lastPacket, changedAt: stream.changedAt})
+		}
+	}
+	l.mu.Unlock()
+
+	for _, event := range lost {
+		l.emit(event)
+	}
+}
+
+func (l *linkMonitor) emit(event linkEvent) {
+	if event.state == linkStateLOS {
+		l.log.Warnf("LOSS OF SIGNAL: spacecraft %s apid %d silent since %v", event.key.spacecraft, event.key.apid, event.lastPacket)
+	} else {
+		l.log.Infof("acquisition of signal: spacecraft %s apid %d", event.key.spacecraft, event.key.apid)
+	}
+
+	//never block the decoders on the link monitor
+	select {
+	case l.events <- event:
+	default:
+		l.log.Errorf("link event queue full, dropping %s event for spacecraft %s apid %d", event.state, event.key.spacecraft, event.key.apid)
+	}
+}
+
+// record persists the current state of the stream and appends the transition to the event history, which is what
+// the backend listens on to push link state to its clients
+func (l *linkMonitor) record(ctx context.Context, event linkEvent) {
+	tx, err := l.dbPool.Begin(ctx)
+	if err != nil {
+		l.log.Errorf("failed to record link event: %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO link_state (spacecraft, apid, ingest_instance, state, last_packet_at, changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (spacecraft, apid, ingest_instance) DO UPDATE
+		SET state = EXCLUDED.state, last_packet_at = EXCLUDED.last_packet_at, changed_at = EXCLUDED.changed_at`,
+		event.key.spacecraft, int32(event.key.apid), l.instance, event.state, event.lastPacket, event.changedAt)
+	if err != nil {
+		l.log.Errorf("failed to update link state: %v", err)
+		return
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO link_events (spacecraft, apid, ingest_instance, state, last_packet_at, changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		event.key.spacecraft, int32(event.key.apid), l.instance, event.state, event.lastPacket, event.changedAt)
+	if err != nil {
+		l.log.Errorf("failed to insert link event: %v", err)
+		return
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		l.log.Errorf("failed to commit link event: %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package telemetryingestion
+
+import (
+	"expvar"
+)
+
+// ingestion metrics are published with expvar, so they're served at /debug/vars alongside pprof. Every instance
+// reports its own identity with them, since several can be sharing the port
+var (
+	ingestMetrics = expvar.NewMap("telemetryingestion")
+
+	instanceID      = new(expvar.String)
+	packetsReceived = new(expvar.Int)
+	packetsDropped  = new(expvar.Int)
+	decodeErrors    = new(expvar.Int)
+	rejectsDropped  = new(expvar.Int)
+	//attitude and event packets, which aren't ingested
+	unsupportedPackets = new(expvar.Int)
+	//command verification
+	acksVerified     = new(expvar.Int)
+	unmatchedAcks    = new(expvar.Int)
+	commandsTimedOut = new(expvar.Int)
+	//written, retried, failed and dropped counts for each sink
+	sinkMetrics = new(expvar.Map).Init()
+)
+
+func init() {
+	ingestMetrics.Set("instance", instanceID)
+	ingestMetrics.Set("packets_received", packetsReceived)
+	ingestMetrics.Set("packets_dropped", packetsDropped)
+	ingestMetrics.Set("decode_errors", decodeErrors)
+	ingestMetrics.Set("rejects_dropped", rejectsDropped)
+	ingestMetrics.Set("unsupported_packets", unsupportedPackets)
+	ingestMetrics.Set("acks_verified", acksVerified)
+	ingestMetrics.Set("unmatched_acks", unmatchedAcks)
+	ingestMetrics.Set("commands_timed_out", commandsTimedOut)
+	ingestMetrics.Set("sinks", sinkMetrics)
+}
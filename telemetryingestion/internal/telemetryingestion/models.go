@@ -1,5 +1,7 @@
 package telemetryingestion
 
+import "time"
+
 // CCSDS Primary Header (6 bytes)
 type CCSDSPrimaryHeader struct {
 	PacketID      uint16 // Version(3 bits), Type(1 bit), SecHdrFlag(1 bit), APID(11 bits)
@@ -21,9 +23,26 @@ type TelemetryPayload struct {
 	Signal      float32 // Signal strength in dB
 }
 
+// rawPacket is a datagram as it came off the wire, stamped with when and from where we received it. The buffer is
+// owned by whoever holds the rawPacket and must be released once it has been decoded
+type rawPacket struct {
+	buffer     *packetBuffer
+	receivedAt time.Time
+	station    groundStation
+}
+
 type TIData struct {
-	PrimaryHeader    CCSDSPrimaryHeader
-	SecondaryHeader  CCSDSSecondaryHeader
-	TelemetryPayload TelemetryPayload
-	AnomalyFlags     uint32
+	PrimaryHeader     CCSDSPrimaryHeader
+	SecondaryHeader   CCSDSSecondaryHeader
+	TelemetryPayload  TelemetryPayload
+	AnomalyFlags      uint32
+	GroundReceiveTime time.Time // when the ground received the packet, as opposed to the onboard timestamp
+	GroundStation     string    // the station (or peer address) the packet was downlinked through
+	Spacecraft        string    // the spacecraft the ground station was tracking
+	IngestInstance    string    // the ingestion instance that received the packet
+}
+
+// APID gives back the 11 bit application process id out of the packet id
+func (p CCSDSPrimaryHeader) APID() uint16 {
+	return p.PacketID & 0x7FF
 }
@@ -0,0 +1,137 @@
+package telemetryingestion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"strings"
+	"time"
+	"turiontakehome/telemetryingestion/pkg/anomaly"
+)
+
+// parameterMessage is a single telemetry parameter, published on <prefix>.<spacecraft>.<apid>.<parameter>
+type parameterMessage struct {
+	Timestamp     time.Time `json:"timestamp"`
+	ReceivedAt    time.Time `json:"received_at"`
+	Spacecraft    string    `json:"spacecraft"`
+	APID          uint16    `json:"apid"`
+	SeqCount      uint16    `json:"seq_count"`
+	SubsystemID   uint16    `json:"subsystem_id"`
+	GroundStation string    `json:"ground_station"`
+	Parameter     string    `json:"parameter"`
+	Value         float32   `json:"value"`
+	Anomalous     bool      `json:"anomalous"`
+}
+
+// natsSink publishes every parameter of every packet to NATS for downstream consumers, with packets that tripped
+// an anomaly also published whole on <anomalyPrefix>.<spacecraft>.<apid>. Consumers can pick exactly what they want
+// with subject wildcards, e.g. telemetry.sc1.*.battery
+type natsSink struct {
+	conn          *nats.Conn
+	prefix        string
+	anomalyPrefix string
+}
+
+// newNATSSink connects in the background; if NATS isn't up yet the sink's writes fail and are retried until it is
+func newNATSSink(url string, prefix string, anomalyPrefix string, instance string) (*natsSink, error) {
+	conn, err := nats.Connect(url,
+		nats.Name("telemetryingestion-"+instance),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(time.Second),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats at %s: %w", url, err)
+	}
+	return &natsSink{conn: conn, prefix: prefix, anomalyPrefix: anomalyPrefix}, nil
+}
+
+func (n *natsSink) Name() string {
+	return "nats"
+}
+
+// Write marshals the whole batch before publishing any of it, so nothing in it can fail part way and have a retry
+// publish what already went out again. A parameter that's NaN or infinite, which a corrupted packet can decode to,
+// isn't published on its own, and is null in the anomaly record
+func (n *natsSink) Write(ctx context.Context, packets []TIData) error {
+	if !n.conn.IsConnected() {
+		return fmt.Errorf("not connected to nats, status %v", n.conn.Status())
+	}
+
+	var messages []*nats.Msg
+	for _, packet := range packets {
+		record := newTelemetryRecord(packet)
+		stream := fmt.Sprintf("%s.%d", subjectToken(record.Spacecraft), record.APID)
+
+		parameters := []struct {
+			name  string
+			value *float32
+			flag  uint32
+		}{
+			{"temperature", record.Temperature, anomaly.TemperatureAnomalyFlag},
+			{"battery", record.Battery, anomaly.BatteryAnomalyFlag},
+			{"altitude", record.Altitude, anomaly.AltitudeAnomalyFlag},
+			{"signal", record.Signal, anomaly.SignalAnomalyFlag},
+		}
+		for _, parameter := range parameters {
+			if parameter.value == nil {
+				continue
+			}
+			msg, err := json.Marshal(parameterMessage{
+				Timestamp:     record.Timestamp,
+				ReceivedAt:    record.ReceivedAt,
+				Spacecraft:    record.Spacecraft,
+				APID:          record.APID,
+				SeqCount:      record.SeqCount,
+				SubsystemID:   record.SubsystemID,
+				GroundStation: record.GroundStation,
+				Parameter:     parameter.name,
+				Value:         *parameter.value,
+				Anomalous:     record.AnomalyFlags&parameter.flag != 0,
+			})
+			if err != nil {
+				return err
+			}
+			messages = append(messages, &nats.Msg{Subject: n.prefix + "." + stream + "." + parameter.name, Data: msg})
+		}
+
+		if record.AnomalyFlags != 0 {
+			msg, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			messages = append(messages, &nats.Msg{Subject: n.anomalyPrefix + "." + stream, Data: msg})
+		}
+	}
+
+	for _, msg := range messages {
+		if err := n.conn.PublishMsg(msg); err != nil {
+			return err
+		}
+	}
+	//a batch only counts as written once the server has it
+	return n.conn.FlushWithContext(ctx)
+}
+
+func (n *natsSink) Close(ctx context.Context) error {
+	defer n.conn.Close()
+	if !n.conn.IsConnected() {
+		return nil
+	}
+	return n.conn.FlushWithContext(ctx)
+}
+
+// subjectToken makes a value safe to use as a single token of a NATS subject
+func subjectToken(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, value)
+}
@@ -0,0 +1,244 @@
+package telemetryingestion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"math"
+	"testing"
+	"time"
+	"turiontakehome/telemetryingestion/pkg/anomaly"
+)
+
+// startNATSServer runs an embedded NATS server on a free port for the length of the test
+func startNATSServer(t *testing.T) *server.Server {
+	t.Helper()
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: server.RANDOM_PORT, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("failed to create nats server: %v", err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server didn't start")
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func TestNATSSinkPublishesBatch(t *testing.T) {
+	s := startNATSServer(t)
+
+	subscriber, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect subscriber: %v", err)
+	}
+	defer subscriber.Close()
+	sub, err := subscriber.SubscribeSync(">")
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	if err := subscriber.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err := newNATSSink(s.ClientURL(), "telemetry", "anomaly", "test")
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	waitFor(t, "the sink to connect", sink.conn.IsConnected)
+
+	receivedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	nominal := TIData{
+		PrimaryHeader:     CCSDSPrimaryHeader{PacketID: 0x0801, PacketSeqCtrl: 0xC007},
+		SecondaryHeader:   CCSDSSecondaryHeader{Timestamp: uint64(receivedAt.Unix()), SubsystemID: 1},
+		TelemetryPayload:  nominalPayload,
+		GroundReceiveTime: receivedAt,
+		GroundStation:     "svalbard",
+		Spacecraft:        "sc1",
+	}
+	anomalous := nominal
+	anomalous.PrimaryHeader.PacketSeqCtrl = 0xC008
+	anomalous.TelemetryPayload.Battery = 10
+	anomalous.Spacecraft = "sc.2"
+	checkForAnomaliesAndSet(&anomalous)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sink.Write(ctx, []TIData{nominal, anomalous}); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	messages := make(map[string][]byte)
+	for len(messages) < 9 {
+		msg, err := sub.NextMsg(5 * time.Second)
+		if err != nil {
+			t.Fatalf("got %d of 9 messages: %v", len(messages), err)
+		}
+		messages[msg.Subject] = msg.Data
+	}
+	if msg, err := sub.NextMsg(100 * time.Millisecond); err == nil {
+		t.Errorf("unexpected message on %s", msg.Subject)
+	}
+
+	parameters := []struct {
+		subject   string
+		value     float32
+		seq       uint16
+		anomalous bool
+	}{
+		{"telemetry.sc1.1.temperature", nominalPayload.Temperature, 7, false},
+		{"telemetry.sc1.1.battery", nominalPayload.Battery, 7, false},
+		{"telemetry.sc1.1.altitude", nominalPayload.Altitude, 7, false},
+		{"telemetry.sc1.1.signal", nominalPayload.Signal, 7, false},
+		//the spacecraft's dot can't end up splitting the subject
+		{"telemetry.sc_2.1.temperature", nominalPayload.Temperature, 8, false},
+		{"telemetry.sc_2.1.battery", 10, 8, true},
+		{"telemetry.sc_2.1.altitude", nominalPayload.Altitude, 8, false},
+		{"telemetry.sc_2.1.signal", nominalPayload.Signal, 8, false},
+	}
+	for _, expected := range parameters {
+		data, ok := messages[expected.subject]
+		if !ok {
+			t.Errorf("nothing published on %s", expected.subject)
+			continue
+		}
+		var msg parameterMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Errorf("%s: %v", expected.subject, err)
+			continue
+		}
+		if msg.Value != expected.value || msg.SeqCount != expected.seq || msg.Anomalous != expected.anomalous {
+			t.Errorf("%s: got value %v seq %d anomalous %v, expected %v %d %v", expected.subject, msg.Value, msg.SeqCount, msg.Anomalous, expected.value, expected.seq, expected.anomalous)
+		}
+		if msg.APID != 1 || msg.GroundStation != "svalbard" || !msg.ReceivedAt.Equal(receivedAt) {
+			t.Errorf("%s: got apid %d station %q received %v", expected.subject, msg.APID, msg.GroundStation, msg.ReceivedAt)
+		}
+	}
+
+	data, ok := messages["anomaly.sc_2.1"]
+	if !ok {
+		t.Fatal("anomalous packet wasn't published on anomaly.sc_2.1")
+	}
+	var record telemetryRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.SeqCount != 8 || record.Battery == nil || *record.Battery != 10 || record.AnomalyFlags != anomaly.BatteryAnomalyFlag || len(record.Anomalies) != 1 {
+		t.Errorf("anomaly record %+v", record)
+	}
+
+	if err := sink.Close(ctx); err != nil {
+		t.Errorf("failed to close: %v", err)
+	}
+}
+
+// TestNATSSinkSkipsNonFiniteParameters sends a batch with a corrupted packet in it, and makes sure everything that
+// can be published is, once, and the rest isn't
+func TestNATSSinkSkipsNonFiniteParameters(t *testing.T) {
+	s := startNATSServer(t)
+
+	subscriber, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect subscriber: %v", err)
+	}
+	defer subscriber.Close()
+	sub, err := subscriber.SubscribeSync(">")
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	if err := subscriber.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err := newNATSSink(s.ClientURL(), "telemetry", "anomaly", "test")
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	defer sink.Close(context.Background())
+	waitFor(t, "the sink to connect", sink.conn.IsConnected)
+
+	corrupted := TIData{PrimaryHeader: CCSDSPrimaryHeader{PacketID: 0x0801, PacketSeqCtrl: 0xC001}, TelemetryPayload: nominalPayload, Spacecraft: "sc1"}
+	corrupted.TelemetryPayload.Temperature = float32(math.NaN())
+	corrupted.TelemetryPayload.Battery = 10
+	corrupted.TelemetryPayload.Signal = float32(math.Inf(1))
+	checkForAnomaliesAndSet(&corrupted)
+	nominal := TIData{PrimaryHeader: CCSDSPrimaryHeader{PacketID: 0x0801, PacketSeqCtrl: 0xC002}, TelemetryPayload: nominalPayload, Spacecraft: "sc1"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sink.Write(ctx, []TIData{corrupted, nominal}); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	counts := make(map[string]int)
+	var anomalyData []byte
+	for {
+		msg, err := sub.NextMsg(200 * time.Millisecond)
+		if err != nil {
+			break
+		}
+		var parameter parameterMessage
+		json.Unmarshal(msg.Data, &parameter)
+		counts[fmt.Sprintf("%s#%d", msg.Subject, parameter.SeqCount)]++
+		if msg.Subject == "anomaly.sc1.1" {
+			anomalyData = msg.Data
+		}
+	}
+	expected := []string{
+		"telemetry.sc1.1.battery#1", "telemetry.sc1.1.altitude#1",
+		"telemetry.sc1.1.temperature#2", "telemetry.sc1.1.battery#2", "telemetry.sc1.1.altitude#2", "telemetry.sc1.1.signal#2",
+		"anomaly.sc1.1#1",
+	}
+	for _, key := range expected {
+		if counts[key] != 1 {
+			t.Errorf("%s published %d times, expected once", key, counts[key])
+		}
+	}
+	if len(counts) != len(expected) {
+		t.Errorf("published %v", counts)
+	}
+
+	var record telemetryRecord
+	if err := json.Unmarshal(anomalyData, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Temperature != nil || record.Signal != nil || record.Battery == nil || *record.Battery != 10 {
+		t.Errorf("anomaly record %+v", record)
+	}
+}
+
+func TestNATSSinkWriteFailsWhenDisconnected(t *testing.T) {
+	s := startNATSServer(t)
+	sink, err := newNATSSink(s.ClientURL(), "telemetry", "anomaly", "test")
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	defer sink.Close(context.Background())
+	waitFor(t, "the sink to connect", sink.conn.IsConnected)
+
+	s.Shutdown()
+	waitFor(t, "the sink to disconnect", func() bool { return !sink.conn.IsConnected() })
+
+	//failing the write is what gets the batch retried
+	if err := sink.Write(context.Background(), []TIData{{TelemetryPayload: nominalPayload}}); err == nil {
+		t.Error("expected the write to fail while disconnected")
+	}
+}
+
+func TestSubjectToken(t *testing.T) {
+	tests := map[string]string{
+		"sc1":       "sc1",
+		"":          "unknown",
+		"sc.1":      "sc_1",
+		"sc *>":     "sc___",
+		"10.0.0.5":  "10_0_0_5",
+		"line\nend": "line_end",
+	}
+	for value, expected := range tests {
+		if got := subjectToken(value); got != expected {
+			t.Errorf("subjectToken(%q) = %q, expected %q", value, got, expected)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package telemetryingestion
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ndjsonSink writes packets as newline delimited JSON to rolling files, for offline analysis. A file is rolled over
+// once it passes maxBytes or has been open longer than maxAge, whichever comes first
+type ndjsonSink struct {
+	dir      string
+	prefix   string
+	maxBytes int64
+	maxAge   time.Duration
+
+	file *os.File
+	//bytes in the file, all of them whole batches
+	written  int64
+	openedAt time.Time
+}
+
+func newNDJSONSink(dir string, prefix string, maxBytes int64, maxAge time.Duration) (*ndjsonSink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create ndjson directory %s: %w", dir, err)
+	}
+	return &ndjsonSink{dir: dir, prefix: prefix, maxBytes: maxBytes, maxAge: maxAge}, nil
+}
+
+func (n *ndjsonSink) Name() string {
+	return "ndjson"
+}
+
+// Write is only ever called from the sink's runner, so the file doesn't need locking. A batch is encoded whole before
+// any of it's written, and if writing it fails the file is cut back to before it and a new one started, so a retry
+// neither writes any of it twice nor fails on the same file
+func (n *ndjsonSink) Write(ctx context.Context, packets []TIData) error {
+	if err := n.rollIfNeeded(); err != nil {
+		return err
+	}
+
+	batch := &bytes.Buffer{}
+	encoder := json.NewEncoder(batch)
+	for _, packet := range packets {
+		if err := encoder.Encode(newTelemetryRecord(packet)); err != nil {
+			return err
+		}
+	}
+
+	_, err := n.file.Write(batch.Bytes())
+	//a batch is only written once it's on disk, so a retry doesn't lose it to a crash
+	if err == nil {
+		err = n.file.Sync()
+	}
+	if err != nil {
+		n.abandonFile()
+		return err
+	}
+	n.written += int64(batch.Len())
+	return nil
+}
+
+func (n *ndjsonSink) Close(ctx context.Context) error {
+	return n.closeFile()
+}
+
+func (n *ndjsonSink) rollIfNeeded() error {
+	if n.file != nil && n.written < n.maxBytes && time.Since(n.openedAt) < n.maxAge {
+		return nil
+	}
+	if err := n.closeFile(); err != nil {
+		return err
+	}
+
+	n.openedAt = time.Now().UTC()
+	name := filepath.Join(n.dir, fmt.Sprintf("%s-%s.ndjson", n.prefix, n.openedAt.Format("20060102T150405.000000000Z")))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open ndjson file %s: %w", name, err)
+	}
+	n.file = file
+	n.written = 0
+	return nil
+}
+
+func (n *ndjsonSink) closeFile() error {
+	if n.file == nil {
+		return nil
+	}
+	err := n.file.Close()
+	n.file = nil
+	return err
+}
+
+// abandonFile cuts the file back to the last whole batch and closes it, so the next write starts a new one. It's
+// best effort, the write has already failed
+func (n *ndjsonSink) abandonFile() {
+	n.file.Truncate(n.written)
+	n.closeFile()
+}
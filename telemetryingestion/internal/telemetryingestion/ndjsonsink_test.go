@@ -0,0 +1,138 @@
+package telemetryingestion
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// ndjsonLines reads back every line the sink wrote, across all its files, in the order they were written
+func ndjsonLines(t *testing.T, dir string) []telemetryRecord {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	var records []telemetryRecord
+	for _, name := range names {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var record telemetryRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Fatalf("%s: invalid line %q: %v", name, scanner.Text(), err)
+			}
+			records = append(records, record)
+		}
+		file.Close()
+	}
+	return records
+}
+
+func ndjsonTestPackets(seqCounts ...uint16) []TIData {
+	packets := make([]TIData, len(seqCounts))
+	for i, seqCount := range seqCounts {
+		packets[i] = TIData{PrimaryHeader: CCSDSPrimaryHeader{PacketID: 0x0801, PacketSeqCtrl: 0xC000 | seqCount}, TelemetryPayload: nominalPayload}
+	}
+	return packets
+}
+
+func TestNDJSONSinkWritesNonFiniteAsNull(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := newNDJSONSink(dir, "telemetry", 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//a corrupted packet in the middle of the batch doesn't cost the rest of it
+	packets := ndjsonTestPackets(1, 2, 3)
+	packets[1].TelemetryPayload.Temperature = float32(math.NaN())
+	packets[1].TelemetryPayload.Signal = float32(math.Inf(-1))
+	if err := sink.Write(context.Background(), packets); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err := sink.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	records := ndjsonLines(t, dir)
+	if len(records) != 3 {
+		t.Fatalf("wrote %d records, expected 3", len(records))
+	}
+	corrupted := records[1]
+	if corrupted.SeqCount != 2 || corrupted.Temperature != nil || corrupted.Signal != nil ||
+		corrupted.Battery == nil || *corrupted.Battery != nominalPayload.Battery {
+		t.Errorf("wrote the corrupted packet as %+v", corrupted)
+	}
+	if records[2].Temperature == nil || *records[2].Temperature != nominalPayload.Temperature {
+		t.Errorf("wrote the packet after it as %+v", records[2])
+	}
+}
+
+func TestNDJSONSinkRecoversFromWriteError(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := newNDJSONSink(dir, "telemetry", 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := sink.Write(ctx, ndjsonTestPackets(1, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	//the file going bad under the sink fails the batch, and the retry goes to a new file
+	sink.file.Close()
+	batch := ndjsonTestPackets(3, 4)
+	if err := sink.Write(ctx, batch); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	if err := sink.Write(ctx, batch); err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+	if err := sink.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	records := ndjsonLines(t, dir)
+	if len(records) != 4 {
+		t.Fatalf("wrote %d records, expected 4", len(records))
+	}
+	for i, record := range records {
+		if record.SeqCount != uint16(i+1) {
+			t.Errorf("record %d is packet %d", i, record.SeqCount)
+		}
+	}
+}
+
+func TestNDJSONSinkRollsOver(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := newNDJSONSink(dir, "telemetry", 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for seqCount := uint16(1); seqCount <= 3; seqCount++ {
+		if err := sink.Write(ctx, ndjsonTestPackets(seqCount)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Close(ctx)
+
+	names, _ := filepath.Glob(filepath.Join(dir, "telemetry-*.ndjson"))
+	if len(names) != 3 {
+		t.Errorf("wrote %d files, expected one a batch", len(names))
+	}
+	if records := ndjsonLines(t, dir); len(records) != 3 {
+		t.Errorf("wrote %d records, expected 3", len(records))
+	}
+}
@@ -0,0 +1,46 @@
+package telemetryingestion
+
+import (
+	"net/netip"
+	"sync"
+)
+
+// maxDatagramSize is the largest datagram we'll read; anything longer is truncated and fails decoding
+const maxDatagramSize = 1024
+
+// packetBuffer owns the bytes of a single datagram. The listener takes one from the pool for every read and hands
+// it off with the packet; whoever finishes with the packet (the decoder, or the listener when it drops one) must
+// release it. Nothing may hold on to bytes() after release
+type packetBuffer struct {
+	buf  [maxDatagramSize]byte
+	n    int
+	peer netip.Addr
+}
+
+var packetBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(packetBuffer)
+	},
+}
+
+func getPacketBuffer() *packetBuffer {
+	return packetBufferPool.Get().(*packetBuffer)
+}
+
+// bytes gives back the datagram read into the buffer
+func (p *packetBuffer) bytes() []byte {
+	return p.buf[:p.n]
+}
+
+// release hands the buffer back to the pool
+func (p *packetBuffer) release() {
+	p.n = 0
+	p.peer = netip.Addr{}
+	packetBufferPool.Put(p)
+}
+
+// batchReader reads up to len(packets) datagrams into the given buffers, giving back how many were filled. On Linux
+// this is a single recvmmsg call
+type batchReader interface {
+	readBatch(packets []*packetBuffer) (int, error)
+}
@@ -0,0 +1,71 @@
+package telemetryingestion
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+// TestPacketBufferConcurrentUse has workers fill, hand off and release buffers the way the listener and decoders do,
+// checking no buffer is ever shared while it's owned. Run it with -race
+func TestPacketBufferConcurrentUse(t *testing.T) {
+	const workers, rounds = 8, 1000
+
+	handoff := make(chan *packetBuffer, 64)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(worker byte) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				packet := getPacketBuffer()
+				if packet.n != 0 {
+					t.Errorf("got a buffer still holding %d bytes", packet.n)
+				}
+				packet.n = copy(packet.buf[:], bytes.Repeat([]byte{worker}, 32))
+				handoff <- packet
+			}
+		}(byte(w))
+	}
+
+	consumers := &sync.WaitGroup{}
+	for c := 0; c < workers; c++ {
+		consumers.Add(1)
+		go func() {
+			defer consumers.Done()
+			for packet := range handoff {
+				data := packet.bytes()
+				if len(data) != 32 || !bytes.Equal(data, bytes.Repeat(data[:1], 32)) {
+					t.Errorf("buffer was written to while it was owned: %v", data)
+				}
+				packet.release()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(handoff)
+	consumers.Wait()
+}
+
+func TestPacketBufferRelease(t *testing.T) {
+	packet := getPacketBuffer()
+	packet.n = copy(packet.buf[:], testPacket(1, 1, nominalPayload))
+	packet.release()
+	if len(packet.bytes()) != 0 || packet.peer.IsValid() {
+		t.Errorf("released buffer still holds %d bytes from %v", len(packet.bytes()), packet.peer)
+	}
+}
+
+func BenchmarkPacketBuffer(b *testing.B) {
+	packet := testPacket(1, 1, nominalPayload)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			buffer := getPacketBuffer()
+			buffer.n = copy(buffer.buf[:], packet)
+			buffer.release()
+		}
+	})
+}
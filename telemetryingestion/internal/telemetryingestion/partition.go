@@ -0,0 +1,35 @@
+package telemetryingestion
+
+import "encoding/binary"
+
+// Packets are partitioned by APID all the way through the pipeline: the listener, decoder and validator always
+// route an APID to the same worker, and each worker handles its partition in order, so packets of an APID reach the
+// data writer in the order we received them
+
+// partitionFor gives back the partition an APID is routed to
+func partitionFor(apid uint16, partitions int) int {
+	return int(apid) % partitions
+}
+
+// peekAPID reads the APID out of a raw datagram without decoding it. Anything too short to have one goes to
+// partition 0 and fails decoding there
+func peekAPID(packet []byte) uint16 {
+	if len(packet) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(packet[0:2]) & 0x7FF
+}
+
+func makePartitions[T any](partitions int, capacity int) []chan T {
+	chans := make([]chan T, partitions)
+	for i := range chans {
+		chans[i] = make(chan T, capacity)
+	}
+	return chans
+}
+
+func closePartitions[T any](chans []chan T) {
+	for _, ch := range chans {
+		close(ch)
+	}
+}
@@ -0,0 +1,235 @@
+package telemetryingestion
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/sirupsen/logrus"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// testPacket lays out a telemetry packet the way the generator does
+func testPacket(apid uint16, seq uint16, payload TelemetryPayload) []byte {
+	packet := make([]byte, primaryHeaderSize+secondaryHeaderSize+telemetryPayloadSize)
+	binary.BigEndian.PutUint16(packet[0:2], 0x0800|apid)
+	binary.BigEndian.PutUint16(packet[2:4], 0xC000|seq&0x3FFF)
+	binary.BigEndian.PutUint16(packet[4:6], telemetryPacketLength)
+	binary.BigEndian.PutUint64(packet[6:14], uint64(time.Now().Unix()))
+	binary.BigEndian.PutUint16(packet[14:16], 1)
+	binary.BigEndian.PutUint32(packet[16:20], math.Float32bits(payload.Temperature))
+	binary.BigEndian.PutUint32(packet[20:24], math.Float32bits(payload.Battery))
+	binary.BigEndian.PutUint32(packet[24:28], math.Float32bits(payload.Altitude))
+	binary.BigEndian.PutUint32(packet[28:32], math.Float32bits(payload.Signal))
+	return packet
+}
+
+var nominalPayload = TelemetryPayload{Temperature: 20, Battery: 80, Altitude: 500, Signal: -60}
+
+// fakeSink keeps every packet written to it. Writes wait on gate, when it's set, so a test can hold packets in the
+// pipeline, and the first failures writes fail
+type fakeSink struct {
+	name     string
+	gate     chan struct{}
+	failures int
+
+	mu      sync.Mutex
+	writes  int
+	batches [][]TIData
+	closed  bool
+}
+
+func (f *fakeSink) Name() string {
+	return f.name
+}
+
+func (f *fakeSink) Write(ctx context.Context, packets []TIData) error {
+	if f.gate != nil {
+		select {
+		case <-f.gate:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes++
+	if f.writes <= f.failures {
+		return io.ErrUnexpectedEOF
+	}
+	f.batches = append(f.batches, append([]TIData(nil), packets...))
+	return nil
+}
+
+func (f *fakeSink) Close(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeSink) packets() []TIData {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var packets []TIData
+	for _, batch := range f.batches {
+		packets = append(packets, batch...)
+	}
+	return packets
+}
+
+// TestShutdownWritesEveryPacketRead pushes packets through the pipeline the way Run wires it, with the sink holding
+// everything back until the context has been canceled, and makes sure every packet read off the socket is written
+func TestShutdownWritesEveryPacketRead(t *testing.T) {
+	const packets = 2000
+	logger := newTestLogger()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer conn.Close()
+	stations, err := newStationRegistry("", "sc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg := &sync.WaitGroup{}
+	errCh := make(chan error, packets)
+	//the decoder queues can hold everything, so the listener never has to drop and every packet sent is read
+	decoderChans := makePartitions[rawPacket](decoderWorkers, packets)
+	validatorChans := makePartitions[TIData](validatorWorkers, 128)
+	alertChan := make(chan TIData, 100)
+	packetChan := make(chan TIData, 2000)
+	rejectedChan := make(chan rejectedPacket, 1000)
+	ackChan := make(chan ackPacket, 1000)
+
+	sink := &fakeSink{name: "shutdown_test", gate: make(chan struct{})}
+	runner := newSinkRunner(sink, sinkConfig{
+		blockWhenFull: true,
+		maxBlock:      time.Minute,
+		queueSize:     100,
+		batchSize:     50,
+		batchTimeout:  10 * time.Millisecond,
+		writeTimeout:  10 * time.Second,
+	}, errCh, logger)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		newAlerter(alertChan, logger).run()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		newDataWriter(packetChan, []*sinkRunner{runner}, logger).run()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		newValidator(validatorChans, alertChan, packetChan, logger).run()
+		close(alertChan)
+		close(packetChan)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range rejectedChan {
+		}
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range ackChan {
+		}
+	}()
+	monitor := newLinkMonitor(nil, "test", time.Hour, time.Hour, logger)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		newDecoder(decoderChans, make(chan TelemetryPayload), validatorChans, rejectedChan, ackChan, errCh, telemetryPacketLength, "test", monitor, logger).run()
+		closePartitions(validatorChans)
+		close(rejectedChan)
+		close(ackChan)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		listenUDP(ctx, conn, decoderChans, stations, logger)
+		closePartitions(decoderChans)
+	}()
+
+	sender, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer sender.Close()
+
+	//send in chunks, waiting for each to be read, so nothing overflows the socket's receive buffer
+	received := packetsReceived.Value()
+	const chunk = 100
+	for sent := 0; sent < packets; sent += chunk {
+		for i := sent; i < sent+chunk; i++ {
+			//housekeeping spread over eight APIDs, clear of attitude and events
+			if _, err := sender.Write(testPacket(uint16(4+i%8), uint16(i), nominalPayload)); err != nil {
+				t.Fatalf("failed to send: %v", err)
+			}
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for packetsReceived.Value()-received < int64(sent+chunk) {
+			if time.Now().After(deadline) {
+				t.Fatalf("listener read %d of %d packets", packetsReceived.Value()-received, sent+chunk)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	//everything is read but nothing has been written yet; shut down, then let the sink go
+	cancel()
+	if written := len(sink.packets()); written != 0 {
+		t.Fatalf("sink wrote %d packets before it was released", written)
+	}
+	close(sink.gate)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("pipeline did not drain")
+	}
+	close(errCh)
+	for err := range errCh {
+		t.Errorf("pipeline error: %v", err)
+	}
+
+	written := sink.packets()
+	if len(written) != packets {
+		t.Fatalf("wrote %d packets, expected %d", len(written), packets)
+	}
+	seen := make(map[uint16]bool, packets)
+	for _, packet := range written {
+		seen[packet.PrimaryHeader.PacketSeqCtrl&0x3FFF] = true
+	}
+	if len(seen) != packets {
+		t.Errorf("wrote %d distinct packets, expected %d", len(seen), packets)
+	}
+	if !sink.closed {
+		t.Error("sink was not closed")
+	}
+}
@@ -0,0 +1,71 @@
+package telemetryingestion
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// postgresSink writes telemetry to the telemetry table, which is what the backend serves from
+type postgresSink struct {
+	dbPool *pgxpool.Pool
+	log    *logrus.Logger
+}
+
+func newPostgresSink(dbPool *pgxpool.Pool, logger *logrus.Logger) *postgresSink {
+	return &postgresSink{dbPool: dbPool, log: logger}
+}
+
+func (p *postgresSink) Name() string {
+	return "postgres"
+}
+
+func (p *postgresSink) Write(ctx context.Context, packets []TIData) error {
+	return insertPackets(ctx, p.dbPool, packets, p.log)
+}
+
+// Close leaves the pool alone, it's shared with the rest of ingestion
+func (p *postgresSink) Close(ctx context.Context) error {
+	return nil
+}
+
+// insertPackets copies the whole batch in as a single statement, so the change feed sees it as one change
+func insertPackets(ctx context.Context, dbPool *pgxpool.Pool, packets []TIData, log *logrus.Logger) error {
+	if len(packets) == 0 {
+		log.Info("no packets to insert")
+		return nil
+	}
+
+	rows := make([][]interface{}, len(packets))
+	for i, packet := range packets {
+		rows[i] = []interface{}{
+			time.Unix(int64(packet.SecondaryHeader.Timestamp), 0),
+			int32(packet.PrimaryHeader.PacketID),
+			int32((packet.PrimaryHeader.PacketSeqCtrl >> 14) & 0x3), // Extract seq_flags (2 bits)
+			int32(packet.PrimaryHeader.PacketSeqCtrl & 0x3FFF),      // Extract seq_count (14 bits)
+			int32(packet.SecondaryHeader.SubsystemID),
+			packet.TelemetryPayload.Temperature,
+			packet.TelemetryPayload.Battery,
+			packet.TelemetryPayload.Altitude,
+			packet.TelemetryPayload.Signal,
+			int32(packet.AnomalyFlags),
+			packet.GroundReceiveTime,
+			packet.GroundStation,
+			packet.IngestInstance,
+		}
+	}
+
+	copied, err := dbPool.CopyFrom(ctx,
+		pgx.Identifier{"telemetry"},
+		[]string{"timestamp", "packet_id", "seq_flags", "seq_count", "subsystem_id", "temperature", "battery", "altitude",
+			"signal", "anomaly_flags", "received_at", "ground_station", "ingest_instance"},
+		pgx.CopyFromRows(rows))
+	if err != nil {
+		return err
+	}
+
+	log.Infof("successfully inserted %d packets", copied)
+	return nil
+}
@@ -0,0 +1,38 @@
+//go:build linux
+
+package telemetryingestion
+
+import (
+	"golang.org/x/net/ipv4"
+	"net"
+)
+
+// mmsgReader reads a batch of datagrams per syscall with recvmmsg
+type mmsgReader struct {
+	conn *ipv4.PacketConn
+	msgs []ipv4.Message
+}
+
+func newBatchReader(conn *net.UDPConn, batchSize int) batchReader {
+	msgs := make([]ipv4.Message, batchSize)
+	for i := range msgs {
+		msgs[i].Buffers = make([][]byte, 1)
+	}
+	return &mmsgReader{conn: ipv4.NewPacketConn(conn), msgs: msgs}
+}
+
+func (r *mmsgReader) readBatch(packets []*packetBuffer) (int, error) {
+	msgs := r.msgs[:len(packets)]
+	for i, packet := range packets {
+		msgs[i].Buffers[0] = packet.buf[:]
+	}
+
+	n, err := r.conn.ReadBatch(msgs, 0)
+	for i := 0; i < n; i++ {
+		packets[i].n = msgs[i].N
+		if addr, ok := msgs[i].Addr.(*net.UDPAddr); ok {
+			packets[i].peer = addr.AddrPort().Addr()
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package telemetryingestion
+
+import (
+	"net"
+)
+
+// singleReader reads one datagram per syscall where recvmmsg isn't available
+type singleReader struct {
+	conn *net.UDPConn
+}
+
+func newBatchReader(conn *net.UDPConn, batchSize int) batchReader {
+	return &singleReader{conn: conn}
+}
+
+func (r *singleReader) readBatch(packets []*packetBuffer) (int, error) {
+	n, addr, err := r.conn.ReadFromUDPAddrPort(packets[0].buf[:])
+	if err != nil {
+		return 0, err
+	}
+	packets[0].n = n
+	packets[0].peer = addr.Addr()
+	return 1, nil
+}
@@ -0,0 +1,147 @@
+package telemetryingestion
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func newReaderTestConns(t testing.TB) (*net.UDPConn, *net.UDPConn) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	sender, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		conn.Close()
+		t.Fatalf("failed to dial: %v", err)
+	}
+	return conn, sender
+}
+
+func TestBatchReaderReadsDatagrams(t *testing.T) {
+	conn, sender := newReaderTestConns(t)
+	defer conn.Close()
+	defer sender.Close()
+
+	const datagrams = 20
+	for i := 0; i < datagrams; i++ {
+		if _, err := sender.Write(testPacket(1, uint16(i), nominalPayload)); err != nil {
+			t.Fatalf("failed to send: %v", err)
+		}
+	}
+
+	reader := newBatchReader(conn, readBatchSize)
+	packets := make([]*packetBuffer, readBatchSize)
+	for i := range packets {
+		packets[i] = getPacketBuffer()
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for read := 0; read < datagrams; {
+		n, err := reader.readBatch(packets)
+		if err != nil {
+			t.Fatalf("read %d of %d datagrams: %v", read, datagrams, err)
+		}
+		for i := 0; i < n; i++ {
+			if !bytes.Equal(packets[i].bytes(), testPacket(1, uint16(read+i), nominalPayload)) {
+				t.Errorf("datagram %d came back as %v", read+i, packets[i].bytes())
+			}
+			if packets[i].peer != sender.LocalAddr().(*net.UDPAddr).AddrPort().Addr() {
+				t.Errorf("datagram %d came from %v, expected %v", read+i, packets[i].peer, sender.LocalAddr())
+			}
+		}
+		read += n
+	}
+}
+
+// TestBatchReaderConcurrentHandoff reads on one goroutine while another decodes and releases what was read, the way
+// the listener and decoders share buffers. Run it with -race
+func TestBatchReaderConcurrentHandoff(t *testing.T) {
+	conn, sender := newReaderTestConns(t)
+	defer conn.Close()
+	defer sender.Close()
+
+	const datagrams = 500
+	handoff := make(chan *packetBuffer, datagrams)
+	done := make(chan int)
+	go func() {
+		decoded := 0
+		for packet := range handoff {
+			if _, err := decodePacket(packet.bytes(), telemetryPacketLength); err != nil {
+				t.Errorf("failed to decode: %v", err)
+			}
+			packet.release()
+			decoded++
+		}
+		done <- decoded
+	}()
+
+	reader := newBatchReader(conn, readBatchSize)
+	packets := make([]*packetBuffer, readBatchSize)
+	for i := range packets {
+		packets[i] = getPacketBuffer()
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for read := 0; read < datagrams; {
+		//send a few at a time so none overflow the socket's receive buffer
+		for i := 0; i < 10; i++ {
+			if _, err := sender.Write(testPacket(1, uint16(read+i), nominalPayload)); err != nil {
+				t.Fatalf("failed to send: %v", err)
+			}
+		}
+		for batch := 0; batch < 10; {
+			n, err := reader.readBatch(packets)
+			if err != nil {
+				t.Fatalf("read %d of %d datagrams: %v", read, datagrams, err)
+			}
+			for i := 0; i < n; i++ {
+				handoff <- packets[i]
+				packets[i] = getPacketBuffer()
+			}
+			batch += n
+		}
+		read += 10
+	}
+	close(handoff)
+
+	if decoded := <-done; decoded != datagrams {
+		t.Errorf("decoded %d of %d datagrams", decoded, datagrams)
+	}
+}
+
+func BenchmarkBatchReader(b *testing.B) {
+	conn, sender := newReaderTestConns(b)
+	defer conn.Close()
+	defer sender.Close()
+	packet := testPacket(1, 1, nominalPayload)
+
+	reader := newBatchReader(conn, readBatchSize)
+	packets := make([]*packetBuffer, readBatchSize)
+	for i := range packets {
+		packets[i] = getPacketBuffer()
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet)))
+	conn.SetReadDeadline(time.Now().Add(time.Minute))
+	for read := 0; read < b.N; {
+		//a batch at a time, so the receive buffer never overflows
+		batch := min(readBatchSize, b.N-read)
+		for i := 0; i < batch; i++ {
+			if _, err := sender.Write(packet); err != nil {
+				b.Fatalf("failed to send: %v", err)
+			}
+		}
+		for got := 0; got < batch; {
+			n, err := reader.readBatch(packets)
+			if err != nil {
+				b.Fatalf("failed to read: %v", err)
+			}
+			got += n
+		}
+		read += batch
+	}
+}
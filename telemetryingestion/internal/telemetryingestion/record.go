@@ -0,0 +1,58 @@
+package telemetryingestion
+
+import (
+	"math"
+	"time"
+	"turiontakehome/telemetryingestion/pkg/anomaly"
+)
+
+// telemetryRecord is the self contained JSON form of a packet written by the sinks that don't have a schema of their
+// own, with the headers already unpacked. Packets have no checksum, so a corrupted one can decode to NaN or an
+// infinity, which JSON can't represent. Those values are written as null rather than failing the whole batch
+type telemetryRecord struct {
+	Timestamp      time.Time `json:"timestamp"`
+	ReceivedAt     time.Time `json:"received_at"`
+	GroundStation  string    `json:"ground_station"`
+	Spacecraft     string    `json:"spacecraft"`
+	IngestInstance string    `json:"ingest_instance"`
+	APID           uint16    `json:"apid"`
+	PacketID       uint16    `json:"packet_id"`
+	SeqFlags       uint16    `json:"seq_flags"`
+	SeqCount       uint16    `json:"seq_count"`
+	SubsystemID    uint16    `json:"subsystem_id"`
+	Temperature    *float32  `json:"temperature"`
+	Battery        *float32  `json:"battery"`
+	Altitude       *float32  `json:"altitude"`
+	Signal         *float32  `json:"signal"`
+	AnomalyFlags   uint32    `json:"anomaly_flags"`
+	Anomalies      []string  `json:"anomalies"`
+}
+
+func newTelemetryRecord(packet TIData) telemetryRecord {
+	return telemetryRecord{
+		Timestamp:      time.Unix(int64(packet.SecondaryHeader.Timestamp), 0).UTC(),
+		ReceivedAt:     packet.GroundReceiveTime,
+		GroundStation:  packet.GroundStation,
+		Spacecraft:     packet.Spacecraft,
+		IngestInstance: packet.IngestInstance,
+		APID:           packet.PrimaryHeader.APID(),
+		PacketID:       packet.PrimaryHeader.PacketID,
+		SeqFlags:       (packet.PrimaryHeader.PacketSeqCtrl >> 14) & 0x3,
+		SeqCount:       packet.PrimaryHeader.PacketSeqCtrl & 0x3FFF,
+		SubsystemID:    packet.SecondaryHeader.SubsystemID,
+		Temperature:    finite(packet.TelemetryPayload.Temperature),
+		Battery:        finite(packet.TelemetryPayload.Battery),
+		Altitude:       finite(packet.TelemetryPayload.Altitude),
+		Signal:         finite(packet.TelemetryPayload.Signal),
+		AnomalyFlags:   packet.AnomalyFlags,
+		Anomalies:      anomaly.DecodeAnomalies(packet.AnomalyFlags),
+	}
+}
+
+// finite is a pointer to value, or nil if it's NaN or infinite
+func finite(value float32) *float32 {
+	if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+		return nil
+	}
+	return &value
+}
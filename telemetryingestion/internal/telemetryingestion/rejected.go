@@ -0,0 +1,81 @@
+package telemetryingestion
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// rejectedPacket is a datagram the decoder couldn't make sense of, kept so it can be looked at later
+type rejectedPacket struct {
+	receivedAt time.Time
+	station    groundStation
+	instance   string
+	reason     string
+	packet     []byte
+}
+
+// rejectedWriter records rejected packets in the rejected_packets table. It's best effort; when it falls behind the
+// decoder drops rejects rather than slowing down good telemetry
+type rejectedWriter struct {
+	rejectedChannel chan rejectedPacket
+	dbPool          *pgxpool.Pool
+	batchSize       int
+	batchTimeout    time.Duration
+	errorChannel    chan error
+	log             *logrus.Logger
+}
+
+func newRejectedWriter(rejectedChan chan rejectedPacket, dbPool *pgxpool.Pool, errChan chan error, logger *logrus.Logger) *rejectedWriter {
+	return &rejectedWriter{rejectedChannel: rejectedChan, dbPool: dbPool, batchSize: 100, batchTimeout: time.Second, errorChannel: errChan, log: logger}
+}
+
+// run batches rejects until the decoder closes the channel on shutdown, then writes whatever is left
+func (r *rejectedWriter) run() {
+	r.log.Info("starting rejected packet writer")
+
+	batch := make([]rejectedPacket, 0, r.batchSize)
+	ticker := time.NewTicker(r.batchTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case packet, ok := <-r.rejectedChannel:
+			if !ok {
+				r.flush(batch)
+				r.log.Info("rejected packet writer finished")
+				return
+			}
+			batch = append(batch, packet)
+			if len(batch) >= r.batchSize {
+				r.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			r.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (r *rejectedWriter) flush(packets []rejectedPacket) {
+	if len(packets) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	batch := &pgx.Batch{}
+	for _, packet := range packets {
+		batch.Queue(
+			`INSERT INTO rejected_packets (received_at, ground_station, spacecraft, ingest_instance, reason, packet)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			packet.receivedAt, packet.station.name, packet.station.spacecraft, packet.instance, packet.reason, packet.packet)
+	}
+	if err := r.dbPool.SendBatch(ctx, batch).Close(); err != nil {
+		r.errorChannel <- err
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package telemetryingestion
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on this platform")
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package telemetryingestion
+
+import (
+	"golang.org/x/sys/unix"
+	"syscall"
+)
+
+// reusePort lets every ingestion instance on the host bind the same UDP port; the kernel then spreads datagrams
+// across the sockets by hashing the sender's address, so a sender always lands on the same instance
+func reusePort(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
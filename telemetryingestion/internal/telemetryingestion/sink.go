@@ -0,0 +1,262 @@
+package telemetryingestion
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"time"
+	"turiontakehome/telemetryingestion/pkg/env"
+)
+
+// Sink is somewhere validated telemetry gets written. Sinks don't batch, retry or queue for themselves; each one
+// runs behind its own sinkRunner that does that for it, so a slow or failing sink never holds up the others
+type Sink interface {
+	Name() string
+	// Write writes a batch, giving back an error if any of it didn't make it. A failed batch is retried as a whole,
+	// unless the error is a permanentError
+	Write(ctx context.Context, packets []TIData) error
+	// Close flushes and releases whatever the sink holds once the last batch has been written
+	Close(ctx context.Context) error
+}
+
+// permanentError is a failed write that retrying won't fix, e.g. a batch the sink's server rejected as invalid
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// sinkConfig is how a sink is batched and retried
+type sinkConfig struct {
+	//blockWhenFull applies backpressure to the pipeline instead of dropping when the sink falls behind. Only the
+	//system of record should do this, everything else is best effort. A sink that stays full for longer than maxBlock
+	//is stalled, and drops until it has room again, so it can't hold up every other sink with it
+	blockWhenFull bool
+	maxBlock      time.Duration
+	queueSize     int
+	batchSize     int
+	batchTimeout  time.Duration
+	writeTimeout  time.Duration
+	maxRetries    int
+	retryBackoff  time.Duration
+}
+
+type sinkRunner struct {
+	sink         Sink
+	queue        chan TIData
+	config       sinkConfig
+	errorChannel chan error
+	metrics      *expvar.Map
+	log          *logrus.Logger
+	//only touched by the data writer
+	stalled bool
+}
+
+func newSinkRunner(sink Sink, config sinkConfig, errChan chan error, logger *logrus.Logger) *sinkRunner {
+	metrics := new(expvar.Map).Init()
+	sinkMetrics.Set(sink.Name(), metrics)
+	return &sinkRunner{sink: sink, queue: make(chan TIData, config.queueSize), config: config, errorChannel: errChan, metrics: metrics, log: logger}
+}
+
+// enqueue hands a packet to the sink. Unless the sink blocks when full, this never blocks; a sink that can't keep up
+// drops packets and the others carry on. A sink that blocks waits at most maxBlock for room before it's stalled
+func (r *sinkRunner) enqueue(packet TIData) {
+	select {
+	case r.queue <- packet:
+		if r.stalled {
+			r.stalled = false
+			r.log.Infof("%s sink caught up, applying backpressure again", r.sink.Name())
+		}
+		return
+	default:
+	}
+
+	if r.config.blockWhenFull && !r.stalled {
+		timer := time.NewTimer(r.config.maxBlock)
+		select {
+		case r.queue <- packet:
+			timer.Stop()
+			return
+		case <-timer.C:
+			r.stalled = true
+			r.errorChannel <- fmt.Errorf("%s sink has been full for %v, dropping until it catches up", r.sink.Name(), r.config.maxBlock)
+		}
+	}
+	r.metrics.Add("dropped", 1)
+}
+
+// run batches packets until the data writer closes the queue on shutdown, then flushes what's left and closes the
+// sink. Writes aren't tied to the pipeline's ctx; by the time we're draining it has long been canceled, so each write
+// gets its own deadline instead
+func (r *sinkRunner) run() {
+	r.log.Infof("starting %s sink", r.sink.Name())
+
+	var batch []TIData
+	ticker := time.NewTicker(r.config.batchTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case packet, ok := <-r.queue:
+			if !ok {
+				//write remaining packets now that the queue is closed and drained
+				r.log.Infof("%s sink queue closed, flushing %d packets", r.sink.Name(), len(batch))
+				r.flush(batch)
+				r.close()
+				return
+			}
+			//append to batch
+			batch = append(batch, packet)
+			//write if we are at size
+			if len(batch) >= r.config.batchSize {
+				r.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			//write the batch if the timer expired
+			if len(batch) > 0 {
+				r.flush(batch)
+				batch = nil
+			}
+		}
+	}
+}
+
+// flush writes the batch, retrying with exponential backoff before giving up on it. A permanent error gives up on it
+// straight away
+func (r *sinkRunner) flush(batch []TIData) {
+	if len(batch) == 0 {
+		return
+	}
+
+	backoff := r.config.retryBackoff
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), r.config.writeTimeout)
+		err := r.sink.Write(ctx, batch)
+		cancel()
+		if err == nil {
+			r.metrics.Add("written", int64(len(batch)))
+			return
+		}
+
+		var permanent permanentError
+		if attempt > r.config.maxRetries || errors.As(err, &permanent) {
+			r.metrics.Add("failed", int64(len(batch)))
+			r.errorChannel <- fmt.Errorf("%s sink gave up on %d packets after %d attempts: %w", r.sink.Name(), len(batch), attempt, err)
+			return
+		}
+		r.metrics.Add("retries", 1)
+		r.log.Warnf("%s sink write failed, retrying in %v: %v", r.sink.Name(), backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (r *sinkRunner) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.writeTimeout)
+	defer cancel()
+	if err := r.sink.Close(ctx); err != nil {
+		r.errorChannel <- fmt.Errorf("failed to close %s sink: %w", r.sink.Name(), err)
+	}
+}
+
+// newSinks builds a runner for each named sink; the names come from SINKS, e.g. "postgres,ndjson,stdout"
+func newSinks(names []string, dbPool *pgxpool.Pool, instance string, errChan chan error, logger *logrus.Logger) ([]*sinkRunner, error) {
+	var runners []*sinkRunner
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case "postgres":
+			runners = append(runners, newSinkRunner(newPostgresSink(dbPool, logger), sinkConfig{
+				blockWhenFull: true,
+				maxBlock:      env.Duration("POSTGRES_MAX_BLOCK", 10*time.Second),
+				queueSize:     2000,
+				batchSize:     500,
+				batchTimeout:  5 * time.Second,
+				writeTimeout:  env.Duration("WRITE_TIMEOUT", 10*time.Second),
+				maxRetries:    3,
+				retryBackoff:  500 * time.Millisecond,
+			}, errChan, logger))
+		case "ndjson":
+			sink, err := newNDJSONSink(
+				env.String("NDJSON_DIR", "telemetry-ndjson"),
+				"telemetry-"+instance,
+				int64(env.Int("NDJSON_MAX_BYTES", 64<<20)),
+				env.Duration("NDJSON_MAX_AGE", time.Hour))
+			if err != nil {
+				return nil, err
+			}
+			runners = append(runners, newSinkRunner(sink, sinkConfig{
+				queueSize:    10000,
+				batchSize:    200,
+				batchTimeout: time.Second,
+				writeTimeout: 5 * time.Second,
+				maxRetries:   3,
+				retryBackoff: 200 * time.Millisecond,
+			}, errChan, logger))
+		case "stdout":
+			runners = append(runners, newSinkRunner(newStdoutSink(), sinkConfig{
+				queueSize:    1000,
+				batchSize:    50,
+				batchTimeout: time.Second,
+				writeTimeout: 5 * time.Second,
+				maxRetries:   0,
+			}, errChan, logger))
+		case "nats":
+			sink, err := newNATSSink(
+				env.String("NATS_URL", nats.DefaultURL),
+				env.String("NATS_SUBJECT_PREFIX", "telemetry"),
+				env.String("NATS_ANOMALY_SUBJECT_PREFIX", "anomaly"),
+				instance)
+			if err != nil {
+				return nil, err
+			}
+			runners = append(runners, newSinkRunner(sink, sinkConfig{
+				queueSize:    10000,
+				batchSize:    100,
+				batchTimeout: 250 * time.Millisecond,
+				writeTimeout: 5 * time.Second,
+				maxRetries:   3,
+				retryBackoff: 500 * time.Millisecond,
+			}, errChan, logger))
+		case "influx":
+			sink, err := newInfluxSink(
+				env.String("INFLUX_URL", "http://influxdb:8086"),
+				os.Getenv("INFLUX_ORG"),
+				env.String("INFLUX_BUCKET", "telemetry"),
+				os.Getenv("INFLUX_TOKEN"),
+				env.String("INFLUX_MEASUREMENT", "telemetry"))
+			if err != nil {
+				return nil, err
+			}
+			runners = append(runners, newSinkRunner(sink, sinkConfig{
+				queueSize:    20000,
+				batchSize:    1000,
+				batchTimeout: time.Second,
+				writeTimeout: 10 * time.Second,
+				maxRetries:   5,
+				retryBackoff: time.Second,
+			}, errChan, logger))
+		case "":
+			continue
+		default:
+			return nil, fmt.Errorf("unknown sink %q", name)
+		}
+	}
+
+	if len(runners) == 0 {
+		return nil, errors.New("no sinks configured")
+	}
+	return runners, nil
+}
@@ -0,0 +1,209 @@
+package telemetryingestion
+
+import (
+	"expvar"
+	"testing"
+	"time"
+)
+
+func testSinkPackets(n int) []TIData {
+	packets := make([]TIData, n)
+	for i := range packets {
+		packets[i].PrimaryHeader.PacketSeqCtrl = uint16(i)
+	}
+	return packets
+}
+
+func sinkMetric(r *sinkRunner, name string) int64 {
+	if v, ok := r.metrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDataWriterFansOutToEverySink(t *testing.T) {
+	logger := newTestLogger()
+	errCh := make(chan error, 10)
+	first, second := &fakeSink{name: "fanout_first"}, &fakeSink{name: "fanout_second"}
+	config := sinkConfig{queueSize: 100, batchSize: 10, batchTimeout: time.Hour, writeTimeout: time.Second}
+	runners := []*sinkRunner{newSinkRunner(first, config, errCh, logger), newSinkRunner(second, config, errCh, logger)}
+
+	packetChan := make(chan TIData, 100)
+	for _, packet := range testSinkPackets(55) {
+		packetChan <- packet
+	}
+	close(packetChan)
+	newDataWriter(packetChan, runners, logger).run()
+
+	for _, sink := range []*fakeSink{first, second} {
+		packets := sink.packets()
+		if len(packets) != 55 {
+			t.Errorf("%s sink wrote %d packets, expected 55", sink.name, len(packets))
+		}
+		for i, packet := range packets {
+			if packet.PrimaryHeader.PacketSeqCtrl != uint16(i) {
+				t.Errorf("%s sink wrote packet %d out of order at %d", sink.name, packet.PrimaryHeader.PacketSeqCtrl, i)
+				break
+			}
+		}
+		if !sink.closed {
+			t.Errorf("%s sink was not closed", sink.name)
+		}
+	}
+	if len(errCh) != 0 {
+		t.Errorf("unexpected error: %v", <-errCh)
+	}
+}
+
+// TestDataWriterStalledSinkDoesNotBlockOthers has a sink that applies backpressure stop writing, and makes sure the
+// other sinks still get every packet once it's been full for longer than maxBlock
+func TestDataWriterStalledSinkDoesNotBlockOthers(t *testing.T) {
+	logger := newTestLogger()
+	errCh := make(chan error, 10)
+	stalled, other := &fakeSink{name: "stalled_blocking", gate: make(chan struct{})}, &fakeSink{name: "stalled_other"}
+	stalledRunner := newSinkRunner(stalled, sinkConfig{
+		blockWhenFull: true,
+		maxBlock:      20 * time.Millisecond,
+		queueSize:     5,
+		batchSize:     5,
+		batchTimeout:  time.Hour,
+		writeTimeout:  time.Minute,
+	}, errCh, logger)
+	otherRunner := newSinkRunner(other, sinkConfig{queueSize: 1000, batchSize: 10, batchTimeout: time.Millisecond, writeTimeout: time.Second}, errCh, logger)
+
+	packetChan := make(chan TIData)
+	done := make(chan struct{})
+	go func() {
+		newDataWriter(packetChan, []*sinkRunner{stalledRunner, otherRunner}, logger).run()
+		close(done)
+	}()
+
+	for _, packet := range testSinkPackets(200) {
+		packetChan <- packet
+	}
+	waitFor(t, "the other sink to write every packet", func() bool { return len(other.packets()) == 200 })
+	if dropped := sinkMetric(stalledRunner, "dropped"); dropped == 0 {
+		t.Error("stalled sink didn't drop anything")
+	}
+	select {
+	case err := <-errCh:
+		t.Logf("stalled: %v", err)
+	default:
+		t.Error("stalling wasn't reported")
+	}
+
+	close(stalled.gate)
+	close(packetChan)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("data writer did not finish")
+	}
+}
+
+func TestSinkRunnerBatchesBySize(t *testing.T) {
+	errCh := make(chan error, 10)
+	sink := &fakeSink{name: "batch_size"}
+	runner := newSinkRunner(sink, sinkConfig{queueSize: 100, batchSize: 10, batchTimeout: time.Hour, writeTimeout: time.Second}, errCh, newTestLogger())
+
+	for _, packet := range testSinkPackets(25) {
+		runner.enqueue(packet)
+	}
+	close(runner.queue)
+	runner.run()
+
+	var sizes []int
+	for _, batch := range sink.batches {
+		sizes = append(sizes, len(batch))
+	}
+	if len(sizes) != 3 || sizes[0] != 10 || sizes[1] != 10 || sizes[2] != 5 {
+		t.Errorf("wrote batches of %v, expected [10 10 5]", sizes)
+	}
+	if written := sinkMetric(runner, "written"); written != 25 {
+		t.Errorf("counted %d written, expected 25", written)
+	}
+}
+
+func TestSinkRunnerFlushesOnTimeout(t *testing.T) {
+	errCh := make(chan error, 10)
+	sink := &fakeSink{name: "batch_timeout"}
+	runner := newSinkRunner(sink, sinkConfig{queueSize: 100, batchSize: 100, batchTimeout: 10 * time.Millisecond, writeTimeout: time.Second}, errCh, newTestLogger())
+
+	done := make(chan struct{})
+	go func() {
+		runner.run()
+		close(done)
+	}()
+	for _, packet := range testSinkPackets(5) {
+		runner.enqueue(packet)
+	}
+	waitFor(t, "the partial batch to be written", func() bool { return len(sink.packets()) == 5 })
+
+	close(runner.queue)
+	<-done
+}
+
+func TestSinkRunnerRetries(t *testing.T) {
+	errCh := make(chan error, 10)
+	sink := &fakeSink{name: "retry", failures: 2}
+	runner := newSinkRunner(sink, sinkConfig{queueSize: 100, batchSize: 10, batchTimeout: time.Hour, writeTimeout: time.Second, maxRetries: 3, retryBackoff: time.Millisecond}, errCh, newTestLogger())
+
+	runner.flush(testSinkPackets(10))
+
+	if len(sink.packets()) != 10 {
+		t.Errorf("wrote %d packets, expected the batch to make it on retry", len(sink.packets()))
+	}
+	if sink.writes != 3 {
+		t.Errorf("took %d attempts, expected 3", sink.writes)
+	}
+	if retries := sinkMetric(runner, "retries"); retries != 2 {
+		t.Errorf("counted %d retries, expected 2", retries)
+	}
+	if len(errCh) != 0 {
+		t.Errorf("unexpected error: %v", <-errCh)
+	}
+}
+
+func TestSinkRunnerGivesUp(t *testing.T) {
+	errCh := make(chan error, 10)
+	sink := &fakeSink{name: "give_up", failures: 10}
+	runner := newSinkRunner(sink, sinkConfig{queueSize: 100, batchSize: 10, batchTimeout: time.Hour, writeTimeout: time.Second, maxRetries: 2, retryBackoff: time.Millisecond}, errCh, newTestLogger())
+
+	runner.flush(testSinkPackets(10))
+
+	if sink.writes != 3 {
+		t.Errorf("took %d attempts, expected 3", sink.writes)
+	}
+	if failed := sinkMetric(runner, "failed"); failed != 10 {
+		t.Errorf("counted %d failed, expected 10", failed)
+	}
+	select {
+	case err := <-errCh:
+		t.Logf("gave up: %v", err)
+	default:
+		t.Error("giving up wasn't reported")
+	}
+}
+
+func TestSinkRunnerDropsWhenFull(t *testing.T) {
+	errCh := make(chan error, 10)
+	runner := newSinkRunner(&fakeSink{name: "drop"}, sinkConfig{queueSize: 5, batchSize: 10, batchTimeout: time.Hour, writeTimeout: time.Second}, errCh, newTestLogger())
+
+	for _, packet := range testSinkPackets(8) {
+		runner.enqueue(packet)
+	}
+	if dropped := sinkMetric(runner, "dropped"); dropped != 3 {
+		t.Errorf("counted %d dropped, expected 3", dropped)
+	}
+}
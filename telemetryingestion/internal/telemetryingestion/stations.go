@@ -0,0 +1,77 @@
+package telemetryingestion
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maxUnregisteredPeers bounds how many unregistered peers the registry remembers, so a flood of spoofed or scanning
+// senders can't grow it forever. Peers past the cap are still resolved, just not remembered
+const maxUnregisteredPeers = 1024
+
+// groundStation is who downlinked a packet and which spacecraft that station is tracking
+type groundStation struct {
+	name       string
+	spacecraft string
+}
+
+// stationRegistry maps the UDP peer a packet came from to the ground station that downlinked it. Peers that
+// aren't registered are identified by their address so we never lose track of where a packet came from
+type stationRegistry struct {
+	stations          map[netip.Addr]groundStation
+	defaultSpacecraft string
+	//peers that aren't registered, remembered so resolving them stays allocation free on the hot path
+	unregistered      sync.Map
+	unregisteredPeers atomic.Int64
+}
+
+// newStationRegistry parses a registry spec of the form "10.0.0.5=svalbard,10.0.0.6=mcmurdo:sc2". A station
+// without a spacecraft is assumed to be tracking the default spacecraft
+func newStationRegistry(spec string, defaultSpacecraft string) (*stationRegistry, error) {
+	registry := &stationRegistry{stations: make(map[netip.Addr]groundStation), defaultSpacecraft: defaultSpacecraft}
+	if strings.TrimSpace(spec) == "" {
+		return registry, nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		ip, station, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || ip == "" || station == "" {
+			return nil, fmt.Errorf("invalid ground station entry %q, expected <ip>=<name>[:<spacecraft>]", entry)
+		}
+		parsedIP, err := netip.ParseAddr(ip)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ground station ip %q: %w", ip, err)
+		}
+		name, spacecraft, _ := strings.Cut(station, ":")
+		if spacecraft == "" {
+			spacecraft = defaultSpacecraft
+		}
+		registry.stations[parsedIP.Unmap()] = groundStation{name: name, spacecraft: spacecraft}
+	}
+	return registry, nil
+}
+
+// resolve gives back the station for the peer, falling back to the peer's ip and the default spacecraft
+func (s *stationRegistry) resolve(addr netip.Addr) groundStation {
+	if !addr.IsValid() {
+		return groundStation{spacecraft: s.defaultSpacecraft}
+	}
+	//dual stack sockets hand us ipv4 peers as ipv4 mapped ipv6
+	addr = addr.Unmap()
+	if station, ok := s.stations[addr]; ok {
+		return station
+	}
+	if station, ok := s.unregistered.Load(addr); ok {
+		return station.(groundStation)
+	}
+	station := groundStation{name: addr.String(), spacecraft: s.defaultSpacecraft}
+	if s.unregisteredPeers.Load() < maxUnregisteredPeers {
+		if _, loaded := s.unregistered.LoadOrStore(addr, station); !loaded {
+			s.unregisteredPeers.Add(1)
+		}
+	}
+	return station
+}
@@ -0,0 +1,79 @@
+package telemetryingestion
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestStationRegistryResolve(t *testing.T) {
+	stations, err := newStationRegistry("10.0.0.5=svalbard, 10.0.0.6=mcmurdo:sc2", "sc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr netip.Addr
+		want groundStation
+	}{
+		{netip.MustParseAddr("10.0.0.5"), groundStation{name: "svalbard", spacecraft: "sc1"}},
+		{netip.MustParseAddr("10.0.0.6"), groundStation{name: "mcmurdo", spacecraft: "sc2"}},
+		{netip.MustParseAddr("::ffff:10.0.0.6"), groundStation{name: "mcmurdo", spacecraft: "sc2"}},
+		{netip.MustParseAddr("10.0.0.7"), groundStation{name: "10.0.0.7", spacecraft: "sc1"}},
+		{netip.Addr{}, groundStation{spacecraft: "sc1"}},
+	}
+	for _, test := range tests {
+		if got := stations.resolve(test.addr); got != test.want {
+			t.Errorf("resolve(%v) = %+v, expected %+v", test.addr, got, test.want)
+		}
+	}
+}
+
+func TestStationRegistryInvalidSpec(t *testing.T) {
+	for _, spec := range []string{"10.0.0.5", "=svalbard", "not-an-ip=svalbard"} {
+		if _, err := newStationRegistry(spec, "sc1"); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
+
+func TestStationRegistryCapsUnregisteredPeers(t *testing.T) {
+	stations, err := newStationRegistry("", "sc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < maxUnregisteredPeers*2; i++ {
+		addr := netip.AddrFrom4([4]byte{10, 1, byte(i >> 8), byte(i)})
+		if got := stations.resolve(addr); got.name != addr.String() {
+			t.Fatalf("resolve(%v) named the station %q", addr, got.name)
+		}
+	}
+
+	remembered := 0
+	stations.unregistered.Range(func(_, _ any) bool {
+		remembered++
+		return true
+	})
+	if remembered != maxUnregisteredPeers {
+		t.Errorf("remembered %d unregistered peers, expected %d", remembered, maxUnregisteredPeers)
+	}
+}
+
+func BenchmarkStationRegistryResolve(b *testing.B) {
+	stations, err := newStationRegistry("10.0.0.5=svalbard", "sc1")
+	if err != nil {
+		b.Fatal(err)
+	}
+	registered, unregistered := netip.MustParseAddr("10.0.0.5"), netip.MustParseAddr("10.0.0.9")
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if i%2 == 0 {
+				stations.resolve(registered)
+			} else {
+				stations.resolve(unregistered)
+			}
+		}
+	})
+}
@@ -0,0 +1,39 @@
+package telemetryingestion
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// stdoutSink writes every packet as a line of JSON to stdout, for debugging without touching the database
+type stdoutSink struct {
+	out io.Writer
+}
+
+func newStdoutSink() *stdoutSink {
+	return &stdoutSink{out: os.Stdout}
+}
+
+func (s *stdoutSink) Name() string {
+	return "stdout"
+}
+
+// Write encodes the whole batch before writing any of it, so a retry doesn't repeat lines
+func (s *stdoutSink) Write(ctx context.Context, packets []TIData) error {
+	batch := &bytes.Buffer{}
+	encoder := json.NewEncoder(batch)
+	for _, packet := range packets {
+		if err := encoder.Encode(newTelemetryRecord(packet)); err != nil {
+			return err
+		}
+	}
+	_, err := s.out.Write(batch.Bytes())
+	return err
+}
+
+func (s *stdoutSink) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,28 @@
+package telemetryingestion
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestStdoutSinkWritesNonFiniteAsNull(t *testing.T) {
+	out := &bytes.Buffer{}
+	sink := &stdoutSink{out: out}
+
+	packets := []TIData{{TelemetryPayload: nominalPayload}, {TelemetryPayload: nominalPayload}}
+	packets[0].TelemetryPayload.Altitude = float32(math.Inf(1))
+	if err := sink.Write(context.Background(), packets); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("wrote %d lines, expected 2", len(lines))
+	}
+	if !strings.Contains(lines[0], `"altitude":null`) || !strings.Contains(lines[1], `"altitude":500`) {
+		t.Errorf("wrote %q", lines)
+	}
+}
@@ -8,12 +8,38 @@ import (
 	"github.com/sirupsen/logrus"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"turiontakehome/telemetryingestion/pkg/env"
+	"turiontakehome/telemetryingestion/pkg/health"
+	"turiontakehome/telemetryingestion/pkg/migrations"
 )
 
-var droppedPackets uint64
+// readBatchSize is how many datagrams the listener asks for per read
+const readBatchSize = 64
+
+// worker counts, which are also the number of APID partitions at each stage
+const (
+	decoderWorkers   = 10
+	validatorWorkers = 5
+)
+
+// queueHighWaterMark is how full, as a fraction of capacity, a pipeline queue can get before we report not ready
+const queueHighWaterMark = 0.8
+
+var registerHealthOnce sync.Once
+
+// registerHealthEndpoints serves /healthz and /readyz on the default mux, which is what main serves pprof on
+func registerHealthEndpoints(checker *health.Checker) {
+	registerHealthOnce.Do(func() {
+		http.Handle("/healthz", checker.LivenessHandler())
+		http.Handle("/readyz", checker.ReadinessHandler())
+	})
+}
 
 func Run(ctx context.Context, logger *logrus.Logger) error {
 	logger.Info("running telemetry ingestion...\n")
@@ -25,105 +51,197 @@ func Run(ctx context.Context, logger *logrus.Logger) error {
 	}
 	defer dbPool.Close()
 
+	//refuse to run against a schema we weren't built for
+	migrator, err := migrations.New(dbPool, logger)
+	if err != nil {
+		return err
+	}
+	if err := migrator.Check(ctx); err != nil {
+		return err
+	}
+
+	//ground station registry, used to stamp every packet with the station that downlinked it
+	stations, err := newStationRegistry(os.Getenv("GROUND_STATIONS"), env.String("SPACECRAFT_ID", "sc1"))
+	if err != nil {
+		return err
+	}
+
 	//waitgroup setup
 	wg := &sync.WaitGroup{}
 
-	//Listen on port
-	//TODO config
-	addr := net.UDPAddr{
-		Port: 8089,
-		IP:   net.ParseIP("0.0.0.0"),
+	//identify this instance in the rows we write and the metrics we publish
+	hostname, _ := os.Hostname()
+	instance := env.String("INSTANCE_ID", hostname)
+	instanceID.Set(instance)
+	logger.Infof("ingestion instance %s", instance)
+
+	//Listen on port -- with SO_REUSEPORT several instances can share it, and the kernel keeps each sender on one
+	//instance so its APIDs stay in order
+	listenConfig := net.ListenConfig{}
+	if env.Bool("REUSE_PORT", true) {
+		listenConfig.Control = reusePort
 	}
-	conn, err := net.ListenUDP("udp", &addr)
+	packetConn, err := listenConfig.ListenPacket(ctx, "udp", env.String("UDP_LISTEN_ADDR", "0.0.0.0:8089"))
 	if err != nil {
 		log.Fatalf("Error listening on UDP port: %v", err)
 	}
+	conn := packetConn.(*net.UDPConn)
 	defer conn.Close()
 
-	//channel creations -- TI will be responsible for closing them up later
+	//channel creations -- each pipeline stage closes the channel it feeds once its own input is drained. Every queue the
+	//readiness checks watch is buffered, so how full it is reflects the backlog behind that stage
 	errCh := make(chan error, 5)
-	decoderChan := make(chan []byte)
+	decoderChans := makePartitions[rawPacket](decoderWorkers, 128)
 	telemetryPayloadChan := make(chan TelemetryPayload)
-	validatorChan := make(chan TIData)
-	alertChan := make(chan TIData)
+	validatorChans := makePartitions[TIData](validatorWorkers, 128)
+	alertChan := make(chan TIData, 100)
 	packetChan := make(chan TIData, 2000)
+	rejectedChan := make(chan rejectedPacket, 1000)
+	ackChan := make(chan ackPacket, 1000)
+
+	//sinks are built before any stage starts, so failing to build one leaves nothing running
+	sinks, err := newSinks(strings.Split(env.String("SINKS", "postgres"), ","), dbPool, instance, errCh, logger)
+	if err != nil {
+		return err
+	}
+
+	//on cancel the pipeline shuts down in order: the listener stops reading UDP and closes the decoder channel, then
+	//the decoder, validator and data writer each drain what's left before handing off. Nothing we've already read
+	//off the socket gets lost
 
 	//alerter
 	alerter := newAlerter(alertChan, logger)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		alerter.run(ctx)
+		alerter.run()
+	}()
+
+	//data writer -- fans out to every configured sink, each batching and retrying on its own
+	telemetryDataWriter := newDataWriter(packetChan, sinks, logger)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		telemetryDataWriter.run()
 	}()
 
 	//validator
-	validator := newValidator(validatorChan, alertChan, packetChan, 5, logger)
+	validator := newValidator(validatorChans, alertChan, packetChan, logger)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		validator.run(ctx)
+		validator.run()
+		close(alertChan)
+		close(packetChan)
 	}()
 
-	//decoder
-	telemetryDecoder := newDecoder(decoderChan, telemetryPayloadChan, validatorChan, 10, 25, logger)
+	//link monitor -- raises LOS when a stream goes quiet and AOS when it comes back
+	monitor := newLinkMonitor(dbPool, instance, env.Duration("LOS_TIMEOUT", 10*time.Second), time.Second, logger)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		telemetryDecoder.run(ctx)
+		monitor.run(ctx)
 	}()
 
-	//data writer
-	telemetryDataWriter := newDataWriter(packetChan, errCh, dbPool, 500, time.Second*5, logger)
+	//rejected packet writer -- keeps what the decoder couldn't decode for later inspection
+	rejects := newRejectedWriter(rejectedChan, dbPool, errCh, logger)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		telemetryDataWriter.run(ctx)
+		rejects.run()
 	}()
 
-	//udp listener
+	//command verifier -- matches acks to the commands they verify, and fails commands that miss a deadline
+	verifier := newCommandVerifier(ackChan, dbPool, env.Duration("COMMAND_ACK_TIMEOUT", 10*time.Second), env.Duration("COMMAND_COMPLETE_TIMEOUT", time.Minute), errCh, logger)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		listenUDP(ctx, conn, decoderChan, logger)
+		verifier.run()
 	}()
 
-	// watch the error channel
+	//decoder
+	telemetryDecoder := newDecoder(decoderChans, telemetryPayloadChan, validatorChans, rejectedChan, ackChan, errCh, telemetryPacketLength, instance, monitor, logger)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		for {
-			select {
-			case <-ctx.Done():
-				logger.Info("error monitoring canceled")
-				return
-			case err := <-errCh:
-				logger.Errorf("error: %v", err)
-			}
+		telemetryDecoder.run()
+		closePartitions(validatorChans)
+		close(rejectedChan)
+		close(ackChan)
+	}()
+
+	//udp listener
+	var listening atomic.Bool
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		listening.Store(true)
+		defer listening.Store(false)
+		listenUDP(ctx, conn, decoderChans, stations, logger)
+		closePartitions(decoderChans)
+	}()
+
+	//health and readiness -- served alongside pprof
+	checker := health.NewChecker(2 * time.Second)
+	checker.Register("postgres", health.PostgresCheck(dbPool))
+	checker.Register("udp_listener", func(ctx context.Context) (health.Details, error) {
+		details := health.Details{"local_addr": conn.LocalAddr().String()}
+		if !listening.Load() {
+			return details, errors.New("udp listener is not running")
+		}
+		return details, nil
+	})
+	checker.Register("decoder_queues", health.QueuesCheck(decoderChans, queueHighWaterMark))
+	checker.Register("validator_queues", health.QueuesCheck(validatorChans, queueHighWaterMark))
+	checker.Register("alert_queue", health.QueueCheck(alertChan, queueHighWaterMark))
+	checker.Register("packet_queue", health.QueueCheck(packetChan, queueHighWaterMark))
+	checker.Register("ack_queue", health.QueueCheck(ackChan, queueHighWaterMark))
+	for _, sink := range sinks {
+		checker.Register(sink.sink.Name()+"_sink_queue", health.QueueCheck(sink.queue, queueHighWaterMark))
+	}
+	registerHealthEndpoints(checker)
+
+	// watch the error channel -- stays up until every stage that can report an error has finished
+	errWG := &sync.WaitGroup{}
+	errWG.Add(1)
+	go func() {
+		defer errWG.Done()
+		for err := range errCh {
+			logger.Errorf("error: %v", err)
 		}
+		logger.Info("error monitoring finished")
 	}()
 
 	<-ctx.Done()
-	logger.Info("cancel received, waiting for all goroutintes to finish")
+	logger.Info("cancel received, draining the pipeline")
 
-	//wait for routines to finish up and close out the errCh
+	//wait for every stage to drain and the writer to flush, then close out what's left
 	wg.Wait()
-	//cut the channels
-	close(decoderChan)
-	close(telemetryPayloadChan)
-	close(validatorChan)
-	close(alertChan)
-	close(packetChan)
 	close(errCh)
+	errWG.Wait()
+	close(telemetryPayloadChan)
 	logger.Info("all telemetry ingestion go routines finished")
-	logger.Infof("dropped packets %d", droppedPackets)
+	logger.Infof("dropped packets %d", packetsDropped.Value())
 	return nil
 }
 
-func listenUDP(ctx context.Context, conn *net.UDPConn, decoderChan chan []byte, logger *logrus.Logger) {
-	buf := make([]byte, 1024)
+func listenUDP(ctx context.Context, conn *net.UDPConn, decoderChans []chan rawPacket, stations *stationRegistry, logger *logrus.Logger) {
+	reader := newBatchReader(conn, readBatchSize)
+
+	//every slot always holds a buffer we own; one that gets handed off to the decoders is replaced from the pool
+	packets := make([]*packetBuffer, readBatchSize)
+	for i := range packets {
+		packets[i] = getPacketBuffer()
+	}
+	defer func() {
+		for _, packet := range packets {
+			packet.release()
+		}
+	}()
+
 	for {
 
-		//for periodic checking; the context could be done and we'd be blocking on ReadFromUDP
+		//for periodic checking; the context could be done and we'd be blocking on the read
 		conn.SetReadDeadline(time.Now().Add(1 * time.Second))
 
 		select {
@@ -131,8 +249,10 @@ func listenUDP(ctx context.Context, conn *net.UDPConn, decoderChan chan []byte,
 			logger.Info("UDP listener canceled")
 			return
 		default:
-			n, _, err := conn.ReadFromUDP(buf)
-			if err != nil {
+			n, err := reader.readBatch(packets)
+			//stamp as close to the socket as we can so the ground receive time isn't skewed by our own queueing
+			receivedAt := time.Now().UTC()
+			if err != nil && n == 0 {
 				//handle timeout and continue if it is so we can complete the ctx.Done() case
 				var netErr net.Error
 				if errors.As(err, &netErr) && netErr.Timeout() {
@@ -144,17 +264,21 @@ func listenUDP(ctx context.Context, conn *net.UDPConn, decoderChan chan []byte,
 				logger.Errorf("Error reading from UDP: %v", err)
 				continue
 			}
-			if n > 0 {
-				//here we'll provide a way to send data to the channel while listening for cancel signals
-				//this ensures we aren't blocking and have a graceful shutdown
+			packetsReceived.Add(int64(n))
+			for i := 0; i < n; i++ {
+				if packets[i].n == 0 {
+					continue
+				}
+				partition := partitionFor(peekAPID(packets[i].bytes()), len(decoderChans))
+				//never block the socket on the decoders; if they're all busy we drop rather than stall reads. Once a
+				//packet is handed off it's guaranteed to be drained through the pipeline, even on shutdown
+				packet := rawPacket{buffer: packets[i], receivedAt: receivedAt, station: stations.resolve(packets[i].peer)}
 				select {
-				case decoderChan <- buf[:n]:
-					logger.Info("sent packet to be decoded")
+				case decoderChans[partition] <- packet:
+					packets[i] = getPacketBuffer()
 				default:
-					droppedPackets++
-					logger.Info("DROPPING PACKET")
-				case <-ctx.Done():
-					return
+					//the slot keeps its buffer and the next read overwrites it
+					packetsDropped.Add(1)
 				}
 			}
 		}
@@ -1,60 +1,65 @@
 package telemetryingestion
 
 import (
-	"context"
 	"github.com/sirupsen/logrus"
 	"sync"
 	"turiontakehome/telemetryingestion/pkg/anomaly"
 )
 
 type telemetryValidator struct {
-	validatorChannel chan TIData
-	alertChannel     chan TIData
-	packetChannel    chan TIData
-	numberOfWorkers  int
-	log              *logrus.Logger
+	validatorChannels []chan TIData
+	alertChannel      chan TIData
+	packetChannel     chan TIData
+	numberOfWorkers   int
+	log               *logrus.Logger
 }
 
-func newValidator(validatorChannel chan TIData, alertChan chan TIData, packetChan chan TIData, workerNum int, logger *logrus.Logger) *telemetryValidator {
-	return &telemetryValidator{validatorChannel: validatorChannel, alertChannel: alertChan, numberOfWorkers: workerNum, packetChannel: packetChan, log: logger}
+// newValidator starts a worker per validator channel; each worker owns the APIDs partitioned onto its channel
+func newValidator(validatorChannels []chan TIData, alertChan chan TIData, packetChan chan TIData, logger *logrus.Logger) *telemetryValidator {
+	return &telemetryValidator{validatorChannels: validatorChannels, alertChannel: alertChan, numberOfWorkers: len(validatorChannels), packetChannel: packetChan, log: logger}
 }
 
-func (t *telemetryValidator) run(ctx context.Context) {
+// run starts the validator workers and returns once they have drained the validator channels, which happens after
+// the decoder closes them on shutdown
+func (t *telemetryValidator) run() {
 	t.log.Infof("starting %d validator workers", t.numberOfWorkers)
 	wg := &sync.WaitGroup{}
 	for i := 0; i < t.numberOfWorkers; i++ {
 		wg.Add(1)
 		go func(workerNum int) {
 			defer wg.Done()
-			t.validatorWorker(ctx, workerNum)
+			t.validatorWorker(workerNum)
 		}(i)
 	}
 
-	<-ctx.Done()
-	t.log.Infof("stopping %d validator workers", t.numberOfWorkers)
 	wg.Wait()
+	t.log.Infof("stopped %d validator workers", t.numberOfWorkers)
 }
 
-func (t *telemetryValidator) validatorWorker(ctx context.Context, workerNum int) {
+func (t *telemetryValidator) validatorWorker(workerNum int) {
 	t.log.Infof("starting validator worker #%v", workerNum)
-	for {
-		select {
-		case payload := <-t.validatorChannel:
-			t.log.Infof("validating payload: %v", payload.TelemetryPayload)
-
-			//For now, given the anomaly requirements, we'll just check to see if the packet has anomalous Payload data
-			//in the future, we can just expand on other validations for things like out of Normal (warnings), etc
-			checkForAnomaliesAndSet(&payload)
-			if payload.AnomalyFlags != 0 {
-				t.alertChannel <- payload
-			}
-			//send to packet channel to be written to database
-			t.packetChannel <- payload
-		case <-ctx.Done():
-			t.log.Infof("validator worker #%v cancelled", workerNum)
-			return
+	for payload := range t.validatorChannels[workerNum] {
+		//For now, given the anomaly requirements, we'll just check to see if the packet has anomalous Payload data
+		//in the future, we can just expand on other validations for things like out of Normal (warnings), etc
+		checkForAnomaliesAndSet(&payload)
+		if payload.AnomalyFlags != 0 {
+			t.alertChannel <- payload
 		}
+		//send to packet channel to be written to database
+		t.packetChannel <- payload
+	}
+	t.log.Infof("validator channel drained for worker #%v", workerNum)
+}
+
+// DetectAnomalies decodes a telemetry packet and flags its anomalies exactly as the pipeline would, so the detector
+// can be evaluated without running the service
+func DetectAnomalies(packet []byte) (TIData, error) {
+	data, err := decodePacket(packet, telemetryPacketLength)
+	if err != nil {
+		return TIData{}, err
 	}
+	checkForAnomaliesAndSet(&data)
+	return data, nil
 }
 
 func setAnomaly(anomaly *uint32, flag uint32) {
@@ -0,0 +1,115 @@
+package commanding
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// AckAPID is the telemetry APID the spacecraft reports command verification on
+const AckAPID uint16 = 0x7F0
+
+// the verification stages a command is acknowledged at, in the order they happen
+const (
+	StageAccepted  uint8 = 1
+	StageStarted   uint8 = 2
+	StageCompleted uint8 = 3
+)
+
+// ack codes. Anything other than AckOK means the command failed at that stage
+const (
+	AckOK               uint8 = 0
+	AckUnknownCommand   uint8 = 1
+	AckInvalidArguments uint8 = 2
+	AckExecutionFailed  uint8 = 3
+	AckRejected         uint8 = 4
+)
+
+var stageNames = map[uint8]string{
+	StageAccepted:  "acceptance",
+	StageStarted:   "execution start",
+	StageCompleted: "completion",
+}
+
+var ackCodeNames = map[uint8]string{
+	AckOK:               "ok",
+	AckUnknownCommand:   "unknown command",
+	AckInvalidArguments: "invalid arguments",
+	AckExecutionFailed:  "execution failed",
+	AckRejected:         "rejected",
+}
+
+// ackPayloadSize is the command's APID and sequence count, the stage and the code
+const ackPayloadSize = 6
+
+// Ack is the spacecraft reporting a command, identified by its APID and sequence count, reaching a verification stage
+type Ack struct {
+	SeqCount        uint16 // the ack packet's own sequence count
+	Timestamp       time.Time
+	CommandAPID     uint16
+	CommandSeqCount uint16
+	Stage           uint8
+	Code            uint8
+}
+
+func StageName(stage uint8) string {
+	if name, ok := stageNames[stage]; ok {
+		return name
+	}
+	return fmt.Sprintf("stage %d", stage)
+}
+
+func AckCodeName(code uint8) string {
+	if name, ok := ackCodeNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("code %d", code)
+}
+
+// Encode lays the ack out as a CCSDS telemetry packet on AckAPID
+func (a Ack) Encode() []byte {
+	packet := make([]byte, 0, primaryHeaderSize+secondaryHeaderSize+ackPayloadSize)
+
+	packetID := uint16(secondaryHeaderFlag)<<11 | AckAPID
+	packetSeqCtrl := uint16(seqFlagsStandalone)<<14 | a.SeqCount&MaxSeqCount
+
+	packet = binary.BigEndian.AppendUint16(packet, packetID)
+	packet = binary.BigEndian.AppendUint16(packet, packetSeqCtrl)
+	packet = binary.BigEndian.AppendUint16(packet, secondaryHeaderSize+ackPayloadSize-1)
+	packet = binary.BigEndian.AppendUint64(packet, uint64(a.Timestamp.Unix()))
+	//acks don't come from a subsystem
+	packet = binary.BigEndian.AppendUint16(packet, 0)
+	packet = binary.BigEndian.AppendUint16(packet, a.CommandAPID)
+	packet = binary.BigEndian.AppendUint16(packet, a.CommandSeqCount)
+	return append(packet, a.Stage, a.Code)
+}
+
+// DecodeAck reads an ack packet
+func DecodeAck(packet []byte) (Ack, error) {
+	if len(packet) != primaryHeaderSize+secondaryHeaderSize+ackPayloadSize {
+		return Ack{}, fmt.Errorf("ack is %d bytes, expected %d", len(packet), primaryHeaderSize+secondaryHeaderSize+ackPayloadSize)
+	}
+
+	packetID := binary.BigEndian.Uint16(packet[0:2])
+	if packetID&0x7FF != AckAPID {
+		return Ack{}, fmt.Errorf("packet is on APID %d, not the ack APID", packetID&0x7FF)
+	}
+	if packetLength := binary.BigEndian.Uint16(packet[4:6]); packetLength != secondaryHeaderSize+ackPayloadSize-1 {
+		return Ack{}, fmt.Errorf("unexpected ack length. got: %d expected: %d", packetLength, secondaryHeaderSize+ackPayloadSize-1)
+	}
+
+	body := packet[primaryHeaderSize:]
+	payload := body[secondaryHeaderSize:]
+	ack := Ack{
+		SeqCount:        binary.BigEndian.Uint16(packet[2:4]) & MaxSeqCount,
+		Timestamp:       time.Unix(int64(binary.BigEndian.Uint64(body[0:8])), 0),
+		CommandAPID:     binary.BigEndian.Uint16(payload[0:2]) & 0x7FF,
+		CommandSeqCount: binary.BigEndian.Uint16(payload[2:4]) & MaxSeqCount,
+		Stage:           payload[4],
+		Code:            payload[5],
+	}
+	if _, ok := stageNames[ack.Stage]; !ok {
+		return Ack{}, fmt.Errorf("ack has unknown verification stage %d", ack.Stage)
+	}
+	return ack, nil
+}
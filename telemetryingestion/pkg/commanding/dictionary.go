@@ -0,0 +1,280 @@
+package commanding
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// argument types and how many bytes each takes on the wire
+const (
+	TypeUint8   = "uint8"
+	TypeUint16  = "uint16"
+	TypeUint32  = "uint32"
+	TypeInt16   = "int16"
+	TypeInt32   = "int32"
+	TypeFloat32 = "float32"
+	TypeBool    = "bool"
+)
+
+var argumentSizes = map[string]int{
+	TypeUint8: 1, TypeUint16: 2, TypeUint32: 4, TypeInt16: 2, TypeInt32: 4, TypeFloat32: 4, TypeBool: 1,
+}
+
+// the dictionary the system ships with
+//
+//go:embed dictionary.json
+var defaultDictionary []byte
+
+// ArgumentDef describes an argument. An ExecutionTime argument is seconds the command runs for once it starts, such
+// as a burn's duration or a delay before it acts
+type ArgumentDef struct {
+	Name          string   `json:"name"`
+	Type          string   `json:"type"`
+	Min           *float64 `json:"min,omitempty"`
+	Max           *float64 `json:"max,omitempty"`
+	Unit          string   `json:"unit,omitempty"`
+	ExecutionTime bool     `json:"execution_time,omitempty"`
+	Description   string   `json:"description,omitempty"`
+}
+
+// CommandDef describes a command: which APID it goes to, its opcode, and its arguments in the order they're encoded
+type CommandDef struct {
+	Name        string        `json:"name"`
+	Opcode      uint16        `json:"opcode"`
+	APID        uint16        `json:"apid"`
+	Description string        `json:"description,omitempty"`
+	Arguments   []ArgumentDef `json:"arguments,omitempty"`
+}
+
+type Dictionary struct {
+	commands map[string]CommandDef
+}
+
+// DefaultDictionary is the built in command dictionary
+func DefaultDictionary() (*Dictionary, error) {
+	return parseDictionary(defaultDictionary)
+}
+
+// LoadDictionary reads a dictionary from a JSON file laid out like dictionary.json, or gives back the default one if
+// path is empty
+func LoadDictionary(path string) (*Dictionary, error) {
+	if path == "" {
+		return DefaultDictionary()
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseDictionary(contents)
+}
+
+func parseDictionary(contents []byte) (*Dictionary, error) {
+	var file struct {
+		Commands []CommandDef `json:"commands"`
+	}
+	if err := json.Unmarshal(contents, &file); err != nil {
+		return nil, fmt.Errorf("invalid command dictionary: %w", err)
+	}
+
+	d := &Dictionary{commands: make(map[string]CommandDef, len(file.Commands))}
+	opcodes := make(map[[2]uint16]string)
+	for _, command := range file.Commands {
+		if _, ok := d.commands[command.Name]; ok {
+			return nil, fmt.Errorf("command %s is defined twice", command.Name)
+		}
+		if command.APID > 0x7FF {
+			return nil, fmt.Errorf("command %s has APID %d, which doesn't fit in 11 bits", command.Name, command.APID)
+		}
+		key := [2]uint16{command.APID, command.Opcode}
+		if other, ok := opcodes[key]; ok {
+			return nil, fmt.Errorf("commands %s and %s share APID %d opcode %d", other, command.Name, command.APID, command.Opcode)
+		}
+		opcodes[key] = command.Name
+		for _, argument := range command.Arguments {
+			if _, ok := argumentSizes[argument.Type]; !ok {
+				return nil, fmt.Errorf("command %s argument %s has unknown type %s", command.Name, argument.Name, argument.Type)
+			}
+			if argument.ExecutionTime && (argument.Type == TypeBool || argument.Unit != "s") {
+				return nil, fmt.Errorf("command %s argument %s is an execution time, so it must be a number of seconds", command.Name, argument.Name)
+			}
+		}
+		d.commands[command.Name] = command
+	}
+	return d, nil
+}
+
+func (d *Dictionary) Lookup(name string) (CommandDef, bool) {
+	command, ok := d.commands[name]
+	return command, ok
+}
+
+// LookupOpcode finds the command an APID and opcode belong to
+func (d *Dictionary) LookupOpcode(apid uint16, opcode uint16) (CommandDef, bool) {
+	for _, command := range d.commands {
+		if command.APID == apid && command.Opcode == opcode {
+			return command, true
+		}
+	}
+	return CommandDef{}, false
+}
+
+// Commands gives back every command, sorted by name
+func (d *Dictionary) Commands() []CommandDef {
+	commands := make([]CommandDef, 0, len(d.commands))
+	for _, command := range d.commands {
+		commands = append(commands, command)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
+// EncodeArguments validates arguments, as decoded from JSON, against the command's definition and encodes them
+// big-endian in definition order. Every argument is required and nothing else is allowed
+func (c CommandDef) EncodeArguments(arguments map[string]interface{}) ([]byte, error) {
+	for name := range arguments {
+		if !c.hasArgument(name) {
+			return nil, fmt.Errorf("%s has no argument %s", c.Name, name)
+		}
+	}
+
+	encoded := make([]byte, 0, c.ArgumentsSize())
+	for _, argument := range c.Arguments {
+		value, ok := arguments[argument.Name]
+		if !ok {
+			return nil, fmt.Errorf("%s is missing argument %s", c.Name, argument.Name)
+		}
+		var err error
+		encoded, err = argument.encode(encoded, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s argument %s: %w", c.Name, argument.Name, err)
+		}
+	}
+	return encoded, nil
+}
+
+// DecodeArguments is the reverse of EncodeArguments
+func (c CommandDef) DecodeArguments(encoded []byte) (map[string]interface{}, error) {
+	if len(encoded) != c.ArgumentsSize() {
+		return nil, fmt.Errorf("%s arguments are %d bytes, expected %d", c.Name, len(encoded), c.ArgumentsSize())
+	}
+
+	arguments := make(map[string]interface{}, len(c.Arguments))
+	for _, argument := range c.Arguments {
+		var value interface{}
+		switch argument.Type {
+		case TypeUint8:
+			value = encoded[0]
+		case TypeUint16:
+			value = binary.BigEndian.Uint16(encoded)
+		case TypeUint32:
+			value = binary.BigEndian.Uint32(encoded)
+		case TypeInt16:
+			value = int16(binary.BigEndian.Uint16(encoded))
+		case TypeInt32:
+			value = int32(binary.BigEndian.Uint32(encoded))
+		case TypeFloat32:
+			value = math.Float32frombits(binary.BigEndian.Uint32(encoded))
+		case TypeBool:
+			value = encoded[0] != 0
+		}
+		arguments[argument.Name] = value
+		encoded = encoded[argumentSizes[argument.Type]:]
+	}
+	return arguments, nil
+}
+
+// ExecutionTime is how long the command runs for once it starts, from its execution time arguments. arguments are as
+// decoded from JSON, and should have already been through EncodeArguments
+func (c CommandDef) ExecutionTime(arguments map[string]interface{}) time.Duration {
+	var seconds float64
+	for _, argument := range c.Arguments {
+		if number, ok := arguments[argument.Name].(float64); ok && argument.ExecutionTime {
+			seconds += number
+		}
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// ArgumentsSize is how many bytes the command's arguments take on the wire
+func (c CommandDef) ArgumentsSize() int {
+	size := 0
+	for _, argument := range c.Arguments {
+		size += argumentSizes[argument.Type]
+	}
+	return size
+}
+
+func (c CommandDef) hasArgument(name string) bool {
+	for _, argument := range c.Arguments {
+		if argument.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (a ArgumentDef) encode(encoded []byte, value interface{}) ([]byte, error) {
+	if a.Type == TypeBool {
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected a bool, got %v", value)
+		}
+		if b {
+			return append(encoded, 1), nil
+		}
+		return append(encoded, 0), nil
+	}
+
+	number, ok := value.(float64)
+	if !ok {
+		return nil, fmt.Errorf("expected a number, got %v", value)
+	}
+	if a.Min != nil && number < *a.Min {
+		return nil, fmt.Errorf("%v is below the minimum of %v", number, *a.Min)
+	}
+	if a.Max != nil && number > *a.Max {
+		return nil, fmt.Errorf("%v is above the maximum of %v", number, *a.Max)
+	}
+	if a.Type != TypeFloat32 && number != math.Trunc(number) {
+		return nil, fmt.Errorf("%v isn't a whole number", number)
+	}
+
+	switch a.Type {
+	case TypeUint8:
+		if number < 0 || number > math.MaxUint8 {
+			return nil, fmt.Errorf("%v doesn't fit in a uint8", number)
+		}
+		return append(encoded, uint8(number)), nil
+	case TypeUint16:
+		if number < 0 || number > math.MaxUint16 {
+			return nil, fmt.Errorf("%v doesn't fit in a uint16", number)
+		}
+		return binary.BigEndian.AppendUint16(encoded, uint16(number)), nil
+	case TypeUint32:
+		if number < 0 || number > math.MaxUint32 {
+			return nil, fmt.Errorf("%v doesn't fit in a uint32", number)
+		}
+		return binary.BigEndian.AppendUint32(encoded, uint32(number)), nil
+	case TypeInt16:
+		if number < math.MinInt16 || number > math.MaxInt16 {
+			return nil, fmt.Errorf("%v doesn't fit in an int16", number)
+		}
+		return binary.BigEndian.AppendUint16(encoded, uint16(int16(number))), nil
+	case TypeInt32:
+		if number < math.MinInt32 || number > math.MaxInt32 {
+			return nil, fmt.Errorf("%v doesn't fit in an int32", number)
+		}
+		return binary.BigEndian.AppendUint32(encoded, uint32(int32(number))), nil
+	default:
+		if math.Abs(number) > math.MaxFloat32 {
+			return nil, fmt.Errorf("%v doesn't fit in a float32", number)
+		}
+		return binary.BigEndian.AppendUint32(encoded, math.Float32bits(float32(number))), nil
+	}
+}
@@ -0,0 +1,51 @@
+package commanding
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecutionTime(t *testing.T) {
+	d, err := DefaultDictionary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		command   string
+		arguments map[string]interface{}
+		expected  time.Duration
+	}{
+		{"NOOP", map[string]interface{}{}, 0},
+		{"HEATER_ON", map[string]interface{}{"heater_id": float64(1)}, 0},
+		{"ORBIT_RAISE", map[string]interface{}{"delta_altitude": float64(10), "burn_seconds": float64(600)}, 10 * time.Minute},
+		{"REBOOT_SUBSYSTEM", map[string]interface{}{"subsystem_id": float64(2), "delay": float64(3600)}, time.Hour},
+	}
+	for _, test := range tests {
+		command, ok := d.Lookup(test.command)
+		if !ok {
+			t.Fatalf("%s isn't in the dictionary", test.command)
+		}
+		if _, err := command.EncodeArguments(test.arguments); err != nil {
+			t.Fatalf("%s: %v", test.command, err)
+		}
+		if got := command.ExecutionTime(test.arguments); got != test.expected {
+			t.Errorf("%s runs for %v, expected %v", test.command, got, test.expected)
+		}
+	}
+}
+
+func TestParseDictionaryRejectsBadExecutionTimes(t *testing.T) {
+	dictionaries := map[string]string{
+		"not seconds": `{"commands": [{"name": "BURN", "opcode": 1, "apid": 1,
+			"arguments": [{"name": "duration", "type": "uint16", "unit": "ms", "execution_time": true}]}]}`,
+		"not a number": `{"commands": [{"name": "BURN", "opcode": 1, "apid": 1,
+			"arguments": [{"name": "duration", "type": "bool", "unit": "s", "execution_time": true}]}]}`,
+	}
+	for name, dictionary := range dictionaries {
+		_, err := parseDictionary([]byte(dictionary))
+		if err == nil || !strings.Contains(err.Error(), "execution time") {
+			t.Errorf("%s: got %v, expected the execution time to be rejected", name, err)
+		}
+	}
+}
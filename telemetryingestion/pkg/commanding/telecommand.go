@@ -0,0 +1,71 @@
+package commanding
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// A telecommand is a CCSDS space packet with the type bit set. Its secondary header mirrors telemetry's: the time it
+// was sent, then the opcode where telemetry has its subsystem id. The arguments follow
+const (
+	primaryHeaderSize   = 6
+	secondaryHeaderSize = 10
+
+	packetTypeTelecommand = 1
+	secondaryHeaderFlag   = 1
+	seqFlagsStandalone    = 0x3
+
+	// MaxSeqCount is the largest sequence count the 14 bit field holds
+	MaxSeqCount = 0x3FFF
+)
+
+type Telecommand struct {
+	APID      uint16
+	SeqCount  uint16
+	Timestamp time.Time
+	Opcode    uint16
+	Arguments []byte
+}
+
+// Encode lays the telecommand out as a CCSDS TC packet
+func (tc Telecommand) Encode() []byte {
+	packet := make([]byte, 0, primaryHeaderSize+secondaryHeaderSize+len(tc.Arguments))
+
+	packetID := uint16(packetTypeTelecommand)<<12 | uint16(secondaryHeaderFlag)<<11 | tc.APID&0x7FF
+	packetSeqCtrl := uint16(seqFlagsStandalone)<<14 | tc.SeqCount&MaxSeqCount
+	//packet length is everything after the primary header, minus one
+	packetLength := uint16(secondaryHeaderSize + len(tc.Arguments) - 1)
+
+	packet = binary.BigEndian.AppendUint16(packet, packetID)
+	packet = binary.BigEndian.AppendUint16(packet, packetSeqCtrl)
+	packet = binary.BigEndian.AppendUint16(packet, packetLength)
+	packet = binary.BigEndian.AppendUint64(packet, uint64(tc.Timestamp.Unix()))
+	packet = binary.BigEndian.AppendUint16(packet, tc.Opcode)
+	return append(packet, tc.Arguments...)
+}
+
+// DecodeTelecommand reads a CCSDS TC packet back into a Telecommand
+func DecodeTelecommand(packet []byte) (Telecommand, error) {
+	if len(packet) < primaryHeaderSize+secondaryHeaderSize {
+		return Telecommand{}, fmt.Errorf("telecommand is %d bytes, too short for its headers", len(packet))
+	}
+
+	packetID := binary.BigEndian.Uint16(packet[0:2])
+	if (packetID>>12)&0x1 != packetTypeTelecommand {
+		return Telecommand{}, fmt.Errorf("packet isn't a telecommand")
+	}
+	packetLength := binary.BigEndian.Uint16(packet[4:6])
+	if int(packetLength)+1 != len(packet)-primaryHeaderSize {
+		return Telecommand{}, fmt.Errorf("telecommand length says %d bytes but has %d", int(packetLength)+1, len(packet)-primaryHeaderSize)
+	}
+
+	body := packet[primaryHeaderSize:]
+	return Telecommand{
+		APID:      packetID & 0x7FF,
+		SeqCount:  binary.BigEndian.Uint16(packet[2:4]) & MaxSeqCount,
+		Timestamp: time.Unix(int64(binary.BigEndian.Uint64(body[0:8])), 0),
+		Opcode:    binary.BigEndian.Uint16(body[8:10]),
+		Arguments: append([]byte(nil), body[secondaryHeaderSize:]...),
+	}, nil
+}
@@ -0,0 +1,48 @@
+// Package env reads service configuration out of environment variables, falling back to a default when a variable
+// isn't set or can't be parsed
+package env
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// String gives back the environment variable, or the default if it isn't set
+func String(name string, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// Duration gives back the environment variable parsed as a time.Duration, or the default if it isn't set or can't be
+// parsed
+func Duration(name string, def time.Duration) time.Duration {
+	if value := os.Getenv(name); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+// Int gives back the environment variable parsed as an int, or the default if it isn't set or can't be parsed
+func Int(name string, def int) int {
+	if value := os.Getenv(name); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+// Bool gives back the environment variable parsed as a bool, or the default if it isn't set or can't be parsed
+func Bool(name string, def bool) bool {
+	if value := os.Getenv(name); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return def
+}
@@ -0,0 +1,262 @@
+package evaluation
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+	"turiontakehome/telemetryingestion/pkg/anomaly"
+)
+
+// Types are the anomaly types as telemetrygenerator labels them, with the flag the ground sets for each
+var Types = []struct {
+	Name string
+	Flag uint32
+}{
+	{"temperature", anomaly.TemperatureAnomalyFlag},
+	{"battery", anomaly.BatteryAnomalyFlag},
+	{"altitude", anomaly.AltitudeAnomalyFlag},
+	{"signal", anomaly.SignalAnomalyFlag},
+}
+
+// Label is telemetrygenerator's ground truth for a packet it sent: which of its metrics it meant to be anomalous
+type Label struct {
+	SentAt    time.Time `json:"sent_at"`
+	Timestamp int64     `json:"timestamp"`
+	APID      uint16    `json:"apid"`
+	SeqCount  uint16    `json:"seq_count"`
+	Anomalies []Anomaly `json:"anomalies"`
+}
+
+// Anomaly is one metric a packet was meant to have anomalous, and why
+type Anomaly struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+func (l Label) has(anomalyType string) bool {
+	for _, a := range l.Anomalies {
+		if a.Type == anomalyType {
+			return true
+		}
+	}
+	return false
+}
+
+// Key identifies a packet on the ground. The sequence count wraps every 16384 packets, so above that rate two
+// packets sent in the same second can share a key, and nothing on the ground tells them apart
+type Key struct {
+	APID      uint16
+	Timestamp int64
+	SeqCount  uint16
+}
+
+func (l Label) Key() Key {
+	return Key{APID: l.APID, Timestamp: l.Timestamp, SeqCount: l.SeqCount}
+}
+
+// Detection is what the detector made of a packet, and when it was done with it
+type Detection struct {
+	Flags      uint32
+	DetectedAt time.Time
+}
+
+// ReadLabels reads a labels file, one label a line, giving them back in the order they were sent
+func ReadLabels(r io.Reader) ([]Label, error) {
+	var labels []Label
+	err := readLines(r, func(line int, data []byte) error {
+		var label Label
+		if err := json.Unmarshal(data, &label); err != nil {
+			return fmt.Errorf("invalid label on line %d: %w", line, err)
+		}
+		labels = append(labels, label)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(labels, func(i, j int) bool {
+		return labels[i].SentAt.Before(labels[j].SentAt)
+	})
+	return labels, nil
+}
+
+// Fault is a line of telemetrygenerator's fault log, as much of it as an evaluation needs
+type Fault struct {
+	Fault     string `json:"fault"`
+	APID      uint16 `json:"apid"`
+	Timestamp int64  `json:"timestamp"`
+	SeqCount  uint16 `json:"seq_count"`
+}
+
+// corrupting are the faults that change what's in a packet. The ground can accept a corrupted packet, and whatever
+// the detector makes of it isn't about the values that were labelled
+var corrupting = map[string]bool{"bit_flip": true, "truncate": true, "bad_length": true}
+
+// ReadFaults reads a fault log, one fault a line, giving back the packets that were corrupted on the way down
+func ReadFaults(r io.Reader) (map[Key]bool, error) {
+	corrupted := make(map[Key]bool)
+	err := readLines(r, func(line int, data []byte) error {
+		var fault Fault
+		if err := json.Unmarshal(data, &fault); err != nil {
+			return fmt.Errorf("invalid fault on line %d: %w", line, err)
+		}
+		if corrupting[fault.Fault] {
+			corrupted[Key{APID: fault.APID, Timestamp: fault.Timestamp, SeqCount: fault.SeqCount}] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return corrupted, nil
+}
+
+// readLines calls decode with every line of r that isn't empty, numbering them from 1
+func readLines(r io.Reader, decode func(line int, data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := decode(line, scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// TypeReport is how the detector did on one anomaly type. Precision and recall are per packet. An episode is a run of
+// packets labelled with the type, and its latency is from the first of them being sent to the first being flagged
+type TypeReport struct {
+	Type             string        `json:"type"`
+	Labelled         int           `json:"labelled"`
+	TruePositives    int           `json:"true_positives"`
+	FalsePositives   int           `json:"false_positives"`
+	FalseNegatives   int           `json:"false_negatives"`
+	Precision        float64       `json:"precision"`
+	Recall           float64       `json:"recall"`
+	Episodes         int           `json:"episodes"`
+	EpisodesDetected int           `json:"episodes_detected"`
+	MeanLatency      time.Duration `json:"mean_latency"`
+	MaxLatency       time.Duration `json:"max_latency"`
+}
+
+// Report is the evaluation of a run. Packets that can't be scored are counted and left out of the scores, though they
+// still make up the episodes they're part of:
+//   - ambiguous: another labelled packet has the same key, so there's no knowing which of them was detected on
+//   - faulted: the fault log has the packet corrupted on the way down, so it wasn't what was labelled
+//   - missing: never detected on, lost or rejected on the way
+type Report struct {
+	Packets   int          `json:"packets"`
+	Ambiguous int          `json:"ambiguous"`
+	Faulted   int          `json:"faulted"`
+	Missing   int          `json:"missing"`
+	Types     []TypeReport `json:"types"`
+}
+
+// Evaluate scores the detections against the labels, leaving out the packets in corrupted
+func Evaluate(labels []Label, detections map[Key]Detection, corrupted map[Key]bool) Report {
+	report := Report{Packets: len(labels)}
+	labelled := make(map[Key]int, len(labels))
+	for _, label := range labels {
+		labelled[label.Key()]++
+	}
+	//what each label is scored against, if it can be
+	scored := make([]*Detection, len(labels))
+	for i, label := range labels {
+		key := label.Key()
+		detection, ok := detections[key]
+		switch {
+		case labelled[key] > 1:
+			report.Ambiguous++
+		case corrupted[key]:
+			report.Faulted++
+		case !ok:
+			report.Missing++
+		default:
+			scored[i] = &detection
+		}
+	}
+
+	for _, t := range Types {
+		typeReport := TypeReport{Type: t.Name}
+		var latencies []time.Duration
+		//the episode in progress, and whether it's been flagged yet
+		inEpisode, episodeDetected := false, false
+		var episodeStart time.Time
+
+		for i, label := range labels {
+			labelled := label.has(t.Name)
+			if labelled {
+				typeReport.Labelled++
+				if !inEpisode {
+					inEpisode, episodeDetected, episodeStart = true, false, label.SentAt
+					typeReport.Episodes++
+				}
+			} else {
+				inEpisode = false
+			}
+
+			detection := scored[i]
+			if detection == nil {
+				continue
+			}
+			flagged := detection.Flags&t.Flag != 0
+			switch {
+			case labelled && flagged:
+				typeReport.TruePositives++
+				if !episodeDetected {
+					episodeDetected = true
+					typeReport.EpisodesDetected++
+					latencies = append(latencies, detection.DetectedAt.Sub(episodeStart))
+				}
+			case labelled:
+				typeReport.FalseNegatives++
+			case flagged:
+				typeReport.FalsePositives++
+			}
+		}
+
+		typeReport.Precision = ratio(typeReport.TruePositives, typeReport.TruePositives+typeReport.FalsePositives)
+		typeReport.Recall = ratio(typeReport.TruePositives, typeReport.TruePositives+typeReport.FalseNegatives)
+		var total time.Duration
+		for _, latency := range latencies {
+			total += latency
+			if latency > typeReport.MaxLatency {
+				typeReport.MaxLatency = latency
+			}
+		}
+		if len(latencies) > 0 {
+			typeReport.MeanLatency = total / time.Duration(len(latencies))
+		}
+		report.Types = append(report.Types, typeReport)
+	}
+	return report
+}
+
+// ratio is n/d, or 1 when there's nothing to be wrong about
+func ratio(n int, d int) float64 {
+	if d == 0 {
+		return 1
+	}
+	return float64(n) / float64(d)
+}
+
+// Write writes the report as a table
+func (r Report) Write(w io.Writer) error {
+	fmt.Fprintf(w, "%d packets labelled, %d ambiguous, %d faulted, %d missing\n\n", r.Packets, r.Ambiguous, r.Faulted, r.Missing)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tLABELLED\tTP\tFP\tFN\tPRECISION\tRECALL\tEPISODES\tDETECTED\tMEAN LATENCY\tMAX LATENCY")
+	for _, t := range r.Types {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%.3f\t%.3f\t%d\t%d\t%v\t%v\n", t.Type, t.Labelled, t.TruePositives,
+			t.FalsePositives, t.FalseNegatives, t.Precision, t.Recall, t.Episodes, t.EpisodesDetected,
+			t.MeanLatency.Round(time.Millisecond), t.MaxLatency.Round(time.Millisecond))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,202 @@
+package evaluation
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"turiontakehome/telemetryingestion/pkg/anomaly"
+)
+
+var start = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// testLabel labels the housekeeping packet with seqCount, sent that many seconds into the run, as anomalous in types
+func testLabel(seqCount uint16, types ...string) Label {
+	sentAt := start.Add(time.Duration(seqCount) * time.Second)
+	label := Label{SentAt: sentAt, Timestamp: sentAt.Unix(), APID: 1, SeqCount: seqCount}
+	for _, t := range types {
+		label.Anomalies = append(label.Anomalies, Anomaly{Type: t})
+	}
+	return label
+}
+
+// detected is the detector flagging the label's packet with flags, after
+func detected(detections map[Key]Detection, label Label, flags uint32, after time.Duration) {
+	detections[label.Key()] = Detection{Flags: flags, DetectedAt: label.SentAt.Add(after)}
+}
+
+func typeReport(t *testing.T, report Report, name string) TypeReport {
+	t.Helper()
+	for _, typeReport := range report.Types {
+		if typeReport.Type == name {
+			return typeReport
+		}
+	}
+	t.Fatalf("no report for %s", name)
+	return TypeReport{}
+}
+
+func TestRatio(t *testing.T) {
+	tests := []struct {
+		n, d     int
+		expected float64
+	}{
+		{0, 0, 1},
+		{0, 4, 0},
+		{1, 4, 0.25},
+		{4, 4, 1},
+	}
+	for _, test := range tests {
+		if got := ratio(test.n, test.d); got != test.expected {
+			t.Errorf("ratio(%d, %d) = %v, expected %v", test.n, test.d, got, test.expected)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	labels := []Label{
+		testLabel(0),
+		//an episode flagged on its second packet
+		testLabel(1, "temperature"),
+		testLabel(2, "temperature"),
+		testLabel(3, "temperature"),
+		testLabel(4),
+		//an episode that's never flagged
+		testLabel(5, "temperature"),
+		testLabel(6),
+		//an episode whose first packet never arrives, flagged on its second
+		testLabel(7, "temperature", "battery"),
+		testLabel(8, "temperature"),
+	}
+	detections := make(map[Key]Detection)
+	detected(detections, labels[0], 0, 0)
+	detected(detections, labels[1], 0, 0)
+	detected(detections, labels[2], anomaly.TemperatureAnomalyFlag, 100*time.Millisecond)
+	detected(detections, labels[3], anomaly.TemperatureAnomalyFlag, 0)
+	//a false alarm
+	detected(detections, labels[4], anomaly.TemperatureAnomalyFlag|anomaly.SignalAnomalyFlag, 0)
+	detected(detections, labels[5], 0, 0)
+	detected(detections, labels[6], 0, 0)
+	detected(detections, labels[8], anomaly.TemperatureAnomalyFlag, 0)
+
+	report := Evaluate(labels, detections, nil)
+	if report.Packets != 9 || report.Missing != 1 || report.Faulted != 0 || report.Ambiguous != 0 {
+		t.Errorf("counted %d packets, %d missing, %d faulted, %d ambiguous", report.Packets, report.Missing,
+			report.Faulted, report.Ambiguous)
+	}
+
+	temperature := typeReport(t, report, "temperature")
+	if temperature.Labelled != 6 || temperature.TruePositives != 3 || temperature.FalsePositives != 1 ||
+		temperature.FalseNegatives != 2 {
+		t.Errorf("scored temperature %+v", temperature)
+	}
+	if temperature.Precision != 0.75 || temperature.Recall != 0.6 {
+		t.Errorf("temperature precision %v recall %v, expected 0.75 0.6", temperature.Precision, temperature.Recall)
+	}
+	if temperature.Episodes != 3 || temperature.EpisodesDetected != 2 {
+		t.Errorf("temperature detected %d of %d episodes, expected 2 of 3", temperature.EpisodesDetected, temperature.Episodes)
+	}
+	//latency runs from the first packet of the episode being sent, whether it arrived or not
+	if temperature.MeanLatency != 1050*time.Millisecond || temperature.MaxLatency != 1100*time.Millisecond {
+		t.Errorf("temperature latency mean %v max %v, expected 1.05s 1.1s", temperature.MeanLatency, temperature.MaxLatency)
+	}
+
+	//only labelled on a packet that never arrived, so there's nothing to score
+	battery := typeReport(t, report, "battery")
+	if battery.Labelled != 1 || battery.Episodes != 1 || battery.EpisodesDetected != 0 ||
+		battery.Precision != 1 || battery.Recall != 1 {
+		t.Errorf("scored battery %+v", battery)
+	}
+
+	signal := typeReport(t, report, "signal")
+	if signal.Labelled != 0 || signal.FalsePositives != 1 || signal.Precision != 0 || signal.Recall != 1 {
+		t.Errorf("scored signal %+v", signal)
+	}
+}
+
+func TestEvaluateLeavesOutFaulted(t *testing.T) {
+	labels := []Label{testLabel(0), testLabel(1, "battery"), testLabel(2)}
+	detections := make(map[Key]Detection)
+	//a bit flip took the first packet's battery under its limit, and the second's back over it
+	detected(detections, labels[0], anomaly.BatteryAnomalyFlag, 0)
+	detected(detections, labels[1], 0, 0)
+	detected(detections, labels[2], 0, 0)
+
+	report := Evaluate(labels, detections, map[Key]bool{labels[0].Key(): true, labels[1].Key(): true})
+	if report.Faulted != 2 || report.Missing != 0 {
+		t.Errorf("counted %d faulted, %d missing, expected 2 faulted", report.Faulted, report.Missing)
+	}
+	battery := typeReport(t, report, "battery")
+	if battery.TruePositives != 0 || battery.FalsePositives != 0 || battery.FalseNegatives != 0 || battery.Labelled != 1 {
+		t.Errorf("scored battery %+v, expected the faulted packets left out", battery)
+	}
+}
+
+func TestEvaluateLeavesOutAmbiguous(t *testing.T) {
+	//above 16384 packets a second the sequence count wraps within the second
+	first, second := testLabel(3, "signal"), testLabel(3)
+	second.SentAt = first.SentAt.Add(time.Millisecond)
+	other := testLabel(4)
+	detections := make(map[Key]Detection)
+	detected(detections, first, anomaly.SignalAnomalyFlag, 0)
+	detected(detections, other, 0, 0)
+
+	report := Evaluate([]Label{first, second, other}, detections, nil)
+	if report.Ambiguous != 2 || report.Missing != 0 {
+		t.Errorf("counted %d ambiguous, %d missing, expected 2 ambiguous", report.Ambiguous, report.Missing)
+	}
+	signal := typeReport(t, report, "signal")
+	if signal.TruePositives != 0 || signal.FalsePositives != 0 || signal.Episodes != 1 {
+		t.Errorf("scored signal %+v, expected the ambiguous packets left out", signal)
+	}
+}
+
+func TestReadLabels(t *testing.T) {
+	file := `{"sent_at":"2026-01-02T03:04:07Z","timestamp":1767323047,"apid":1,"seq_count":2,"anomalies":[]}
+
+{"sent_at":"2026-01-02T03:04:06Z","timestamp":1767323046,"apid":1,"seq_count":1,"anomalies":[{"type":"battery","reason":"battery below 40% in eclipse"}]}
+`
+	labels, err := ReadLabels(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 2 {
+		t.Fatalf("read %d labels, expected 2", len(labels))
+	}
+	//in the order they were sent, not the order they were written
+	if labels[0].SeqCount != 1 || !labels[0].has("battery") || labels[1].SeqCount != 2 || len(labels[1].Anomalies) != 0 {
+		t.Errorf("read %+v", labels)
+	}
+
+	if _, err := ReadLabels(strings.NewReader("{}\nnot json\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("got %v, expected an invalid label on line 2", err)
+	}
+}
+
+func TestReadFaults(t *testing.T) {
+	file := `{"fault":"bit_flip","apid":1,"timestamp":100,"seq_count":1,"expect":"accepted"}
+{"fault":"truncate","apid":1,"timestamp":100,"seq_count":2,"expect":"rejected"}
+{"fault":"bad_length","apid":1,"timestamp":101,"seq_count":3,"expect":"rejected"}
+{"fault":"duplicate","apid":1,"timestamp":101,"seq_count":4,"expect":"accepted"}
+{"fault":"reorder","apid":1,"timestamp":101,"seq_count":5,"expect":"accepted"}
+{"fault":"loss","apid":1,"timestamp":102,"seq_count":6,"expect":"lost"}
+{"fault":"garbage","apid":1,"timestamp":102,"seq_count":7,"expect":"rejected"}
+`
+	corrupted, err := ReadFaults(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//duplicated, reordered and lost packets, and those garbage went ahead of, arrive as they were sent or not at all
+	expected := map[Key]bool{
+		{APID: 1, Timestamp: 100, SeqCount: 1}: true,
+		{APID: 1, Timestamp: 100, SeqCount: 2}: true,
+		{APID: 1, Timestamp: 101, SeqCount: 3}: true,
+	}
+	if len(corrupted) != len(expected) {
+		t.Errorf("read %d corrupted packets, expected %d", len(corrupted), len(expected))
+	}
+	for key := range expected {
+		if !corrupted[key] {
+			t.Errorf("%+v isn't corrupted", key)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PostgresCheck pings the database through the pool and reports how the pool's connections are being used
+func PostgresCheck(pool *pgxpool.Pool) Check {
+	return func(ctx context.Context) (Details, error) {
+		stat := pool.Stat()
+		details := Details{
+			"total_conns":    stat.TotalConns(),
+			"acquired_conns": stat.AcquiredConns(),
+			"idle_conns":     stat.IdleConns(),
+			"max_conns":      stat.MaxConns(),
+		}
+
+		conn, err := pool.Acquire(ctx)
+		if err != nil {
+			return details, fmt.Errorf("failed to acquire connection: %w", err)
+		}
+		defer conn.Release()
+
+		if err := conn.Conn().Ping(ctx); err != nil {
+			return details, fmt.Errorf("failed to ping database: %w", err)
+		}
+		return details, nil
+	}
+}
+
+// QueueCheck fails once the queue is filled past its high water mark, given as a fraction of its capacity.
+// Unbuffered queues hand off directly and have nothing to fill, so they always pass
+func QueueCheck[T any](queue chan T, highWaterMark float64) Check {
+	return func(ctx context.Context) (Details, error) {
+		length, capacity := len(queue), cap(queue)
+		highWater := int(float64(capacity) * highWaterMark)
+		details := Details{"len": length, "cap": capacity, "high_water": highWater}
+
+		if capacity > 0 && length >= highWater {
+			return details, fmt.Errorf("queue above high water mark: %d/%d", length, capacity)
+		}
+		return details, nil
+	}
+}
+
+// QueuesCheck is QueueCheck across a set of partitioned queues, failing if any one of them is past its high water mark
+func QueuesCheck[T any](queues []chan T, highWaterMark float64) Check {
+	return func(ctx context.Context) (Details, error) {
+		lengths := make([]int, len(queues))
+		var failed error
+		for i, queue := range queues {
+			lengths[i] = len(queue)
+			if _, err := QueueCheck(queue, highWaterMark)(ctx); err != nil && failed == nil {
+				failed = fmt.Errorf("partition %d: %w", i, err)
+			}
+		}
+		details := Details{"partitions": len(queues), "lens": lengths}
+		if len(queues) > 0 {
+			details["cap"] = cap(queues[0])
+		}
+		return details, failed
+	}
+}
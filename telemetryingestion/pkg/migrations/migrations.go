@@ -0,0 +1,381 @@
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sirupsen/logrus"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// every migration is a pair of files, NNNN_name.up.sql and NNNN_name.down.sql, run in order of NNNN
+//
+//go:embed sql/*.sql
+var migrationFiles embed.FS
+
+// advisoryLockID keeps two migrators, say two services starting at once, from running against the same database
+const advisoryLockID = 7_265_331_042
+
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Status is a migration and whether, and when, it's been applied. Modified means the migration has changed since
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	Modified  bool
+}
+
+// ErrIncompatibleSchema is what Check reports when the database isn't at the schema version this build expects
+var ErrIncompatibleSchema = errors.New("incompatible schema version")
+
+// Load gives back every embedded migration, in order
+func Load() ([]Migration, error) {
+	return load(migrationFiles)
+}
+
+// load reads the migrations out of the sql directory of files
+func load(files fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(files, "sql/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, name := range names {
+		base := path.Base(name)
+		direction := ""
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction, base = "up", strings.TrimSuffix(base, ".up.sql")
+		case strings.HasSuffix(base, ".down.sql"):
+			direction, base = "down", strings.TrimSuffix(base, ".down.sql")
+		default:
+			return nil, fmt.Errorf("migration %s is neither up nor down", name)
+		}
+
+		versionStr, migrationName, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionStr)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s isn't named NNNN_name", name)
+		}
+
+		contents, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: migrationName}
+			byVersion[version] = migration
+		}
+		if migration.Name != migrationName {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, migration.Name, migrationName)
+		}
+		if direction == "up" {
+			migration.Up = string(contents)
+			sum := sha256.Sum256(contents)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d needs both an up and a down", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migrations skip from %d to %d", i, migration.Version)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrator applies and rolls back the embedded migrations, recording what's been applied in schema_version
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+	log        *logrus.Logger
+}
+
+func New(pool *pgxpool.Pool, logger *logrus.Logger) (*Migrator, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{pool: pool, migrations: migrations, log: logger}, nil
+}
+
+// Latest is the schema version this build was written against
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Up applies every migration after the current version, up to and including target. A target of 0 means the latest
+func (m *Migrator) Up(ctx context.Context, target int) error {
+	if target == 0 {
+		target = m.Latest()
+	}
+	if target < 0 || target > m.Latest() {
+		return fmt.Errorf("no migration %d, the latest is %d", target, m.Latest())
+	}
+
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := verify(statuses); err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			if status.Applied || status.Version > target {
+				continue
+			}
+			m.log.Infof("applying migration %d %s", status.Version, status.Name)
+			err := conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, status.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, `INSERT INTO schema_version (version, name, checksum) VALUES ($1, $2, $3)`,
+					status.Version, status.Name, status.Checksum)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d %s failed: %w", status.Version, status.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Down rolls back the given number of the most recently applied migrations
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := verify(statuses); err != nil {
+			return err
+		}
+
+		for i := len(statuses) - 1; i >= 0 && steps > 0; i-- {
+			status := statuses[i]
+			if !status.Applied {
+				continue
+			}
+			m.log.Infof("rolling back migration %d %s", status.Version, status.Name)
+			err := conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, status.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, `DELETE FROM schema_version WHERE version = $1`, status.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("rolling back migration %d %s failed: %w", status.Version, status.Name, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// Baseline records every migration up to and including version as applied without running them, for a database
+// whose schema was created before migrations existed
+func (m *Migrator) Baseline(ctx context.Context, version int) error {
+	if version <= 0 || version > m.Latest() {
+		return fmt.Errorf("no migration %d, the latest is %d", version, m.Latest())
+	}
+
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			if status.Applied {
+				return fmt.Errorf("migration %d has already been applied, there's nothing to baseline", status.Version)
+			}
+		}
+
+		return conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+			for _, migration := range m.migrations[:version] {
+				m.log.Infof("baselining migration %d %s", migration.Version, migration.Name)
+				_, err := tx.Exec(ctx, `INSERT INTO schema_version (version, name, checksum) VALUES ($1, $2, $3)`,
+					migration.Version, migration.Name, migration.Checksum)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// Status describes every migration, applied or not
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		var err error
+		statuses, err = m.status(ctx, conn)
+		return err
+	})
+	return statuses, err
+}
+
+// Check makes sure the database is at exactly the schema version this build expects, with nothing applied that's
+// since been changed. Services call it on start up and refuse to run if it fails. It only reads: it neither takes the
+// migration lock nor creates schema_version, so a service can run it with a role that can't change the schema
+func (m *Migrator) Check(ctx context.Context) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	var exists bool
+	if err := conn.QueryRow(ctx, `SELECT to_regclass('schema_version') IS NOT NULL`).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to look for schema_version: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: database has no schema_version, expected %d", ErrIncompatibleSchema, m.Latest())
+	}
+
+	statuses, err := m.status(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return check(statuses, m.Latest())
+}
+
+// check makes sure the statuses are every migration up to latest applied, in order and unchanged
+func check(statuses []Status, latest int) error {
+	if err := verify(statuses); err != nil {
+		return fmt.Errorf("%w: %v", ErrIncompatibleSchema, err)
+	}
+
+	current := 0
+	for _, status := range statuses {
+		if status.Applied {
+			current = status.Version
+		}
+	}
+	if current != latest {
+		return fmt.Errorf("%w: database is at %d, expected %d", ErrIncompatibleSchema, current, latest)
+	}
+	return nil
+}
+
+// verify checks applied migrations are a prefix of ours, in order and unchanged
+func verify(statuses []Status) error {
+	pending := false
+	for _, status := range statuses {
+		if status.Modified {
+			return fmt.Errorf("migration %d %s has changed since it was applied", status.Version, status.Name)
+		}
+		if status.Applied && pending {
+			return fmt.Errorf("migration %d %s was applied out of order", status.Version, status.Name)
+		}
+		pending = pending || !status.Applied
+	}
+	return nil
+}
+
+// applied is a migration as recorded in schema_version
+type applied struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+func (m *Migrator) status(ctx context.Context, conn *pgxpool.Conn) ([]Status, error) {
+	rows, err := conn.Query(ctx, `SELECT version, checksum, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appliedVersions := make(map[int]applied)
+	for rows.Next() {
+		var version int
+		var a applied
+		if err := rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		appliedVersions[version] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return statusesFor(m.migrations, appliedVersions)
+}
+
+// statusesFor matches the migrations up with the ones recorded as applied, taking ownership of appliedVersions
+func statusesFor(migrations []Migration, appliedVersions map[int]applied) ([]Status, error) {
+	statuses := make([]Status, len(migrations))
+	for i, migration := range migrations {
+		statuses[i] = Status{Migration: migration}
+		if a, ok := appliedVersions[migration.Version]; ok {
+			statuses[i].Applied = true
+			statuses[i].AppliedAt = a.appliedAt
+			statuses[i].Modified = a.checksum != migration.Checksum
+			delete(appliedVersions, migration.Version)
+		}
+	}
+	for version := range appliedVersions {
+		return nil, fmt.Errorf("%w: database has migration %d, which this build doesn't know about", ErrIncompatibleSchema, version)
+	}
+	return statuses, nil
+}
+
+// withLock runs fn holding the migration advisory lock, making sure schema_version exists first
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, advisoryLockID); err != nil {
+		return fmt.Errorf("failed to take the migration lock: %w", err)
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, advisoryLockID)
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_version: %w", err)
+	}
+
+	return fn(conn)
+}
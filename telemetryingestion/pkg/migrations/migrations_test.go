@@ -0,0 +1,224 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoadEmbedded(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatalf("failed to load the embedded migrations: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %d is at position %d", migration.Version, i)
+		}
+		if migration.Name == "" || strings.TrimSpace(migration.Up) == "" || strings.TrimSpace(migration.Down) == "" {
+			t.Errorf("migration %d is missing its name, up or down", migration.Version)
+		}
+		sum := sha256.Sum256([]byte(migration.Up))
+		if migration.Checksum != hex.EncodeToString(sum[:]) {
+			t.Errorf("migration %d's checksum isn't of its up file", migration.Version)
+		}
+	}
+}
+
+func TestLoadOrdersByVersion(t *testing.T) {
+	files := fstest.MapFS{
+		"sql/0010_tenth.up.sql":    {Data: []byte("10 up")},
+		"sql/0010_tenth.down.sql":  {Data: []byte("10 down")},
+		"sql/0002_second.up.sql":   {Data: []byte("2 up")},
+		"sql/0002_second.down.sql": {Data: []byte("2 down")},
+		"sql/0001_first.up.sql":    {Data: []byte("1 up")},
+		"sql/0001_first.down.sql":  {Data: []byte("1 down")},
+	}
+	for version := 3; version <= 9; version++ {
+		name := fmt.Sprintf("sql/%04d_filler", version)
+		files[name+".up.sql"] = &fstest.MapFile{Data: []byte("up")}
+		files[name+".down.sql"] = &fstest.MapFile{Data: []byte("down")}
+	}
+
+	migrations, err := load(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 10 {
+		t.Fatalf("loaded %d migrations, expected 10", len(migrations))
+	}
+	//ordered by number, not by name, so 0010 comes after 0009
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %d is at position %d", migration.Version, i)
+		}
+	}
+	if migrations[0].Name != "first" || migrations[0].Up != "1 up" || migrations[0].Down != "1 down" {
+		t.Errorf("loaded migration 1 as %+v", migrations[0])
+	}
+	if migrations[9].Name != "tenth" {
+		t.Errorf("loaded migration 10 as %s", migrations[9].Name)
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"skipped version": {
+			"sql/0001_first.up.sql":   {Data: []byte("up")},
+			"sql/0001_first.down.sql": {Data: []byte("down")},
+			"sql/0003_third.up.sql":   {Data: []byte("up")},
+			"sql/0003_third.down.sql": {Data: []byte("down")},
+		},
+		"missing down": {
+			"sql/0001_first.up.sql": {Data: []byte("up")},
+		},
+		"missing up": {
+			"sql/0001_first.down.sql": {Data: []byte("down")},
+		},
+		"mismatched names": {
+			"sql/0001_first.up.sql":   {Data: []byte("up")},
+			"sql/0001_other.down.sql": {Data: []byte("down")},
+		},
+		"not numbered": {
+			"sql/first.up.sql":   {Data: []byte("up")},
+			"sql/first.down.sql": {Data: []byte("down")},
+		},
+		"version zero": {
+			"sql/0000_zero.up.sql":   {Data: []byte("up")},
+			"sql/0000_zero.down.sql": {Data: []byte("down")},
+		},
+		"neither up nor down": {
+			"sql/0001_first.sql": {Data: []byte("up")},
+		},
+	}
+	for name, files := range tests {
+		if _, err := load(files); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func testMigrations(n int) []Migration {
+	migrations := make([]Migration, n)
+	for i := range migrations {
+		migrations[i] = Migration{Version: i + 1, Name: "m", Up: "up", Down: "down", Checksum: "sum"}
+	}
+	return migrations
+}
+
+func TestStatusesFor(t *testing.T) {
+	appliedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	statuses, err := statusesFor(testMigrations(3), map[int]applied{
+		1: {checksum: "sum", appliedAt: appliedAt},
+		2: {checksum: "changed", appliedAt: appliedAt},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !statuses[0].Applied || statuses[0].Modified || !statuses[0].AppliedAt.Equal(appliedAt) {
+		t.Errorf("migration 1 is %+v", statuses[0])
+	}
+	if !statuses[1].Applied || !statuses[1].Modified {
+		t.Errorf("migration 2 should be applied and modified, is %+v", statuses[1])
+	}
+	if statuses[2].Applied {
+		t.Errorf("migration 3 shouldn't be applied, is %+v", statuses[2])
+	}
+}
+
+func TestStatusesForUnknownVersion(t *testing.T) {
+	_, err := statusesFor(testMigrations(2), map[int]applied{1: {checksum: "sum"}, 2: {checksum: "sum"}, 3: {checksum: "sum"}})
+	if !errors.Is(err, ErrIncompatibleSchema) {
+		t.Errorf("got %v, expected an incompatible schema", err)
+	}
+}
+
+// statusesOf builds a status for each migration: pending, applied, or modified, applied with a different checksum
+func statusesOf(applied ...string) []Status {
+	statuses := make([]Status, len(applied))
+	for i, state := range applied {
+		statuses[i] = Status{Migration: testMigrations(len(applied))[i], Applied: state != "pending", Modified: state == "modified"}
+	}
+	return statuses
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []Status
+		err      string
+	}{
+		{"nothing applied", statusesOf("pending", "pending"), ""},
+		{"prefix applied", statusesOf("applied", "applied", "pending"), ""},
+		{"all applied", statusesOf("applied", "applied"), ""},
+		{"applied out of order", statusesOf("applied", "pending", "applied"), "migration 3 m was applied out of order"},
+		{"first pending", statusesOf("pending", "applied"), "migration 2 m was applied out of order"},
+		{"modified", statusesOf("applied", "modified"), "migration 2 m has changed since it was applied"},
+		//a modified migration is reported ahead of anything applied out of order after it
+		{"modified then out of order", statusesOf("modified", "pending", "applied"), "migration 1 m has changed since it was applied"},
+	}
+	for _, test := range tests {
+		err := verify(test.statuses)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("%s: got %v, expected %s", test.name, err, test.err)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []Status
+		latest     int
+		compatible bool
+	}{
+		{"up to date", statusesOf("applied", "applied", "applied"), 3, true},
+		{"behind", statusesOf("applied", "applied", "pending"), 3, false},
+		{"empty", statusesOf("pending", "pending", "pending"), 3, false},
+		{"modified", statusesOf("applied", "modified", "applied"), 3, false},
+		{"out of order", statusesOf("applied", "pending", "applied"), 3, false},
+	}
+	for _, test := range tests {
+		err := check(test.statuses, test.latest)
+		if test.compatible && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if !test.compatible && !errors.Is(err, ErrIncompatibleSchema) {
+			t.Errorf("%s: got %v, expected an incompatible schema", test.name, err)
+		}
+	}
+}
+
+// TestUpgradeMigrationSkipsExisting makes sure migration 2, which brings a database created from any version of
+// init.sql up to date, doesn't create anything a later init.sql could already have
+func TestUpgradeMigrationSkipsExisting(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(migrations[1].Up, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "CREATE TABLE") && !strings.HasPrefix(line, "CREATE TABLE IF NOT EXISTS"),
+			strings.HasPrefix(line, "CREATE VIEW"),
+			strings.HasPrefix(line, "CREATE FUNCTION"),
+			strings.HasPrefix(line, "ADD COLUMN") && !strings.HasPrefix(line, "ADD COLUMN IF NOT EXISTS"):
+			t.Errorf("migration 2 fails on a database that already has it: %s", line)
+		}
+	}
+	if !strings.Contains(migrations[1].Up, "DROP TRIGGER IF EXISTS link_state_update_trigger") {
+		t.Error("migration 2 creates link_state_update_trigger without dropping it first")
+	}
+}
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS telemetry_update_trigger ON telemetry;
+DROP FUNCTION IF EXISTS notify_telemetry_update();
+DROP TABLE IF EXISTS telemetry;
@@ -0,0 +1,16 @@
+DROP VIEW IF EXISTS telemetry_history;
+DROP TABLE IF EXISTS telemetry_restored;
+DROP TABLE IF EXISTS rejected_packets;
+DROP TABLE IF EXISTS telemetry_rollup_watermarks;
+DROP TABLE IF EXISTS telemetry_rollup_1d;
+DROP TABLE IF EXISTS telemetry_rollup_1h;
+DROP TABLE IF EXISTS telemetry_rollup_1m;
+DROP TRIGGER IF EXISTS link_state_update_trigger ON link_events;
+DROP FUNCTION IF EXISTS notify_link_state_update();
+DROP TABLE IF EXISTS link_events;
+DROP TABLE IF EXISTS link_state;
+ALTER TABLE telemetry
+    DROP COLUMN IF EXISTS received_at,
+    DROP COLUMN IF EXISTS ground_station,
+    DROP COLUMN IF EXISTS ingest_instance,
+    DROP COLUMN IF EXISTS created_at;
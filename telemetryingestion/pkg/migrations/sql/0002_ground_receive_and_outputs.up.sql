@@ -0,0 +1,111 @@
+-- What the services came to need on top of the original init.sql before the schema was migrated: ground receive time,
+-- station and instance on telemetry, link state, rollups, rejected packets and restored telemetry. A database created
+-- from a later init.sql already has some or all of it, so nothing is added that's already there, and any database
+-- created from init.sql can be baselined at 1 and brought up to date from here
+
+ALTER TABLE telemetry
+    ADD COLUMN IF NOT EXISTS received_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS ground_station TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS ingest_instance TEXT NOT NULL DEFAULT '', -- which telemetryingestion instance received it
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ;
+
+-- rows from before these were recorded take their onboard time
+UPDATE telemetry SET received_at = COALESCE(received_at, timestamp), created_at = COALESCE(created_at, timestamp)
+WHERE received_at IS NULL OR created_at IS NULL;
+
+ALTER TABLE telemetry
+    ALTER COLUMN received_at SET DEFAULT now(),
+    ALTER COLUMN received_at SET NOT NULL,
+    ALTER COLUMN created_at SET DEFAULT now(),
+    ALTER COLUMN created_at SET NOT NULL;
+
+-- Link state per telemetry stream (spacecraft + APID), maintained by telemetryingestion
+CREATE TABLE IF NOT EXISTS link_state (
+                                          spacecraft TEXT NOT NULL,
+                                          apid INTEGER NOT NULL,
+                                          state TEXT NOT NULL, -- AOS or LOS
+                                          last_packet_at TIMESTAMPTZ NOT NULL,
+                                          changed_at TIMESTAMPTZ NOT NULL,
+                                          PRIMARY KEY (spacecraft, apid)
+);
+
+-- History of every AOS/LOS transition
+CREATE TABLE IF NOT EXISTS link_events (
+                                           id SERIAL PRIMARY KEY,
+                                           spacecraft TEXT NOT NULL,
+                                           apid INTEGER NOT NULL,
+                                           state TEXT NOT NULL,
+                                           last_packet_at TIMESTAMPTZ NOT NULL,
+                                           changed_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE OR REPLACE FUNCTION notify_link_state_update()
+    RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('link_state_update', row_to_json(NEW)::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS link_state_update_trigger ON link_events;
+CREATE TRIGGER link_state_update_trigger
+    AFTER INSERT ON link_events
+    FOR EACH ROW
+EXECUTE FUNCTION notify_link_state_update();
+
+-- Time-bucketed rollups of telemetry, maintained by turionbackend. We keep the sum rather than the average so coarser
+-- rollups can be built from finer ones; avg is sum / count
+CREATE TABLE IF NOT EXISTS telemetry_rollup_1m (
+                                                   bucket TIMESTAMPTZ PRIMARY KEY,
+                                                   count BIGINT NOT NULL,
+                                                   anomaly_count BIGINT NOT NULL,
+                                                   temperature_min REAL NOT NULL,
+                                                   temperature_max REAL NOT NULL,
+                                                   temperature_sum DOUBLE PRECISION NOT NULL,
+                                                   battery_min REAL NOT NULL,
+                                                   battery_max REAL NOT NULL,
+                                                   battery_sum DOUBLE PRECISION NOT NULL,
+                                                   altitude_min REAL NOT NULL,
+                                                   altitude_max REAL NOT NULL,
+                                                   altitude_sum DOUBLE PRECISION NOT NULL,
+                                                   signal_min REAL NOT NULL,
+                                                   signal_max REAL NOT NULL,
+                                                   signal_sum DOUBLE PRECISION NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS telemetry_rollup_1h (LIKE telemetry_rollup_1m INCLUDING ALL);
+CREATE TABLE IF NOT EXISTS telemetry_rollup_1d (LIKE telemetry_rollup_1m INCLUDING ALL);
+
+-- How far each rollup has been computed up to; everything from here, less the allowed lateness, is recomputed each pass
+CREATE TABLE IF NOT EXISTS telemetry_rollup_watermarks (
+                                                           resolution TEXT PRIMARY KEY,
+                                                           rolled_up_to TIMESTAMPTZ NOT NULL
+);
+
+-- Datagrams telemetryingestion couldn't decode, kept for inspection
+CREATE TABLE IF NOT EXISTS rejected_packets (
+                                                id SERIAL PRIMARY KEY,
+                                                received_at TIMESTAMPTZ NOT NULL,
+                                                ground_station TEXT NOT NULL,
+                                                spacecraft TEXT NOT NULL,
+                                                ingest_instance TEXT NOT NULL,
+                                                reason TEXT NOT NULL,
+                                                packet BYTEA NOT NULL
+);
+
+-- Telemetry restored from the cold archive. It's kept apart from telemetry so restores don't notify live clients or
+-- get archived again, and it's cleared out on its own retention
+CREATE TABLE IF NOT EXISTS telemetry_restored (
+                                                  LIKE telemetry INCLUDING ALL,
+                                                  restored_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Everything we can currently serve history from, live and restored
+CREATE OR REPLACE VIEW telemetry_history AS
+SELECT id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal, anomaly_flags,
+       received_at, ground_station, ingest_instance, created_at
+FROM telemetry
+UNION ALL
+SELECT id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal, anomaly_flags,
+       received_at, ground_station, ingest_instance, created_at
+FROM telemetry_restored;
@@ -0,0 +1,55 @@
+-- Puts telemetry back into a single unpartitioned table
+
+ALTER TABLE telemetry RENAME TO telemetry_partitioned;
+ALTER SEQUENCE telemetry_id_seq RENAME TO telemetry_partitioned_id_seq;
+DROP TRIGGER telemetry_update_trigger ON telemetry_partitioned;
+DROP VIEW telemetry_history;
+
+CREATE TABLE telemetry (
+                           id SERIAL PRIMARY KEY,
+                           timestamp TIMESTAMPTZ NOT NULL,
+                           packet_id INTEGER NOT NULL,
+                           seq_flags INTEGER NOT NULL,
+                           seq_count INTEGER NOT NULL,
+                           subsystem_id INTEGER NOT NULL,
+                           temperature REAL NOT NULL,
+                           battery REAL NOT NULL,
+                           altitude REAL NOT NULL,
+                           signal REAL NOT NULL,
+                           anomaly_flags INTEGER NOT NULL,
+                           received_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                           ground_station TEXT NOT NULL DEFAULT '',
+                           ingest_instance TEXT NOT NULL DEFAULT '',
+                           created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO telemetry (id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal,
+                       anomaly_flags, received_at, ground_station, ingest_instance, created_at)
+SELECT id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal,
+       anomaly_flags, received_at, ground_station, ingest_instance, created_at
+FROM telemetry_partitioned;
+
+SELECT setval('telemetry_id_seq', coalesce(max(id), 0) + 1, false) FROM telemetry;
+
+CREATE TRIGGER telemetry_update_trigger
+    AFTER INSERT ON telemetry
+    FOR EACH STATEMENT
+EXECUTE FUNCTION notify_telemetry_update();
+
+-- restored rows can't share an id with a live one any more, so restored duplicates of those are dropped
+DELETE FROM telemetry_restored a USING telemetry_restored b WHERE a.id = b.id AND a.timestamp > b.timestamp;
+ALTER TABLE telemetry_restored ALTER COLUMN id SET DEFAULT nextval('telemetry_id_seq');
+ALTER TABLE telemetry_restored DROP CONSTRAINT telemetry_restored_pkey;
+ALTER TABLE telemetry_restored ADD PRIMARY KEY (id);
+DROP INDEX IF EXISTS telemetry_restored_timestamp_idx;
+
+CREATE VIEW telemetry_history AS
+SELECT id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal, anomaly_flags,
+       received_at, ground_station, ingest_instance, created_at
+FROM telemetry
+UNION ALL
+SELECT id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal, anomaly_flags,
+       received_at, ground_station, ingest_instance, created_at
+FROM telemetry_restored;
+
+DROP TABLE telemetry_partitioned;
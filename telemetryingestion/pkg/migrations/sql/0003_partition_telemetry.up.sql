@@ -0,0 +1,63 @@
+-- Range partitions telemetry on timestamp, a partition per day or week. turionbackend creates partitions ahead of
+-- time and detaches them once they've been archived; anything that falls outside them lands in telemetry_default until
+-- its partition is created. Existing rows are copied into the default partition, and partition maintenance moves each
+-- day or week into a partition of its own on its next pass.
+
+ALTER TABLE telemetry RENAME TO telemetry_unpartitioned;
+ALTER SEQUENCE telemetry_id_seq RENAME TO telemetry_unpartitioned_id_seq;
+DROP TRIGGER telemetry_update_trigger ON telemetry_unpartitioned;
+DROP VIEW IF EXISTS telemetry_history;
+
+CREATE TABLE telemetry (
+                           id SERIAL,
+                           timestamp TIMESTAMPTZ NOT NULL,
+                           packet_id INTEGER NOT NULL,
+                           seq_flags INTEGER NOT NULL,
+                           seq_count INTEGER NOT NULL,
+                           subsystem_id INTEGER NOT NULL,
+                           temperature REAL NOT NULL,
+                           battery REAL NOT NULL,
+                           altitude REAL NOT NULL,
+                           signal REAL NOT NULL,
+                           anomaly_flags INTEGER NOT NULL,
+                           received_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                           ground_station TEXT NOT NULL DEFAULT '',
+                           ingest_instance TEXT NOT NULL DEFAULT '',
+                           created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                           PRIMARY KEY (id, timestamp)
+) PARTITION BY RANGE (timestamp);
+
+CREATE TABLE telemetry_default PARTITION OF telemetry DEFAULT;
+
+CREATE INDEX telemetry_timestamp_idx ON telemetry (timestamp);
+CREATE INDEX telemetry_anomalies_idx ON telemetry (timestamp) WHERE anomaly_flags > 0;
+
+INSERT INTO telemetry (id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal,
+                       anomaly_flags, received_at, ground_station, ingest_instance, created_at)
+SELECT id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal,
+       anomaly_flags, received_at, ground_station, ingest_instance, created_at
+FROM telemetry_unpartitioned;
+
+SELECT setval('telemetry_id_seq', coalesce(max(id), 0) + 1, false) FROM telemetry;
+
+CREATE TRIGGER telemetry_update_trigger
+    AFTER INSERT ON telemetry
+    FOR EACH STATEMENT
+EXECUTE FUNCTION notify_telemetry_update();
+
+-- restored rows are keyed the same way as live ones now
+ALTER TABLE telemetry_restored ALTER COLUMN id SET DEFAULT nextval('telemetry_id_seq');
+ALTER TABLE telemetry_restored DROP CONSTRAINT telemetry_restored_pkey;
+ALTER TABLE telemetry_restored ADD PRIMARY KEY (id, timestamp);
+CREATE INDEX IF NOT EXISTS telemetry_restored_timestamp_idx ON telemetry_restored (timestamp);
+
+CREATE VIEW telemetry_history AS
+SELECT id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal, anomaly_flags,
+       received_at, ground_station, ingest_instance, created_at
+FROM telemetry
+UNION ALL
+SELECT id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal, anomaly_flags,
+       received_at, ground_station, ingest_instance, created_at
+FROM telemetry_restored;
+
+DROP TABLE telemetry_unpartitioned;
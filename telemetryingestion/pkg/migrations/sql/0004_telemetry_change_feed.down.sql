@@ -0,0 +1,28 @@
+DROP TRIGGER telemetry_update_trigger ON telemetry;
+
+CREATE OR REPLACE FUNCTION notify_telemetry_update()
+    RETURNS TRIGGER AS $$
+DECLARE
+    inserted_data JSON;
+BEGIN
+    -- Aggregate all rows inserted in this statement into a JSON array
+    SELECT json_agg(row_to_json(t))
+    INTO inserted_data
+    FROM telemetry AS t
+    WHERE t.id IN (SELECT id FROM telemetry WHERE id >= CURRVAL('telemetry_id_seq') - TG_NARGS);
+
+    -- Send the aggregated JSON array as a notification
+    IF inserted_data IS NOT NULL THEN
+        PERFORM pg_notify('telemetry_update', inserted_data::text);
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER telemetry_update_trigger
+    AFTER INSERT ON telemetry
+    FOR EACH STATEMENT
+EXECUTE FUNCTION notify_telemetry_update();
+
+DROP TABLE telemetry_changes;
@@ -0,0 +1,43 @@
+-- Replaces the telemetry notification, which carried every inserted row and so broke on pg_notify's 8000 byte limit,
+-- with an outbox. Each insert statement records the ids it inserted in telemetry_changes, and the notification only
+-- carries the id of that change. Listeners read changes after the last one they delivered and fetch the rows from
+-- telemetry themselves
+
+CREATE TABLE telemetry_changes (
+                                   id BIGSERIAL PRIMARY KEY,
+                                   ids BIGINT[] NOT NULL,
+                                   -- bounds the rows' timestamps so fetching them only touches their partitions
+                                   min_timestamp TIMESTAMPTZ NOT NULL,
+                                   max_timestamp TIMESTAMPTZ NOT NULL,
+                                   created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX telemetry_changes_created_at_idx ON telemetry_changes (created_at);
+
+DROP TRIGGER telemetry_update_trigger ON telemetry;
+
+CREATE OR REPLACE FUNCTION notify_telemetry_update()
+    RETURNS TRIGGER AS $$
+DECLARE
+    change_id BIGINT;
+BEGIN
+    -- the transition table holds exactly the rows this statement inserted
+    INSERT INTO telemetry_changes (ids, min_timestamp, max_timestamp)
+    SELECT array_agg(id ORDER BY id), min(timestamp), max(timestamp)
+    FROM inserted
+    HAVING count(*) > 0
+    RETURNING id INTO change_id;
+
+    IF change_id IS NOT NULL THEN
+        PERFORM pg_notify('telemetry_update', change_id::text);
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER telemetry_update_trigger
+    AFTER INSERT ON telemetry
+    REFERENCING NEW TABLE AS inserted
+    FOR EACH STATEMENT
+EXECUTE FUNCTION notify_telemetry_update();
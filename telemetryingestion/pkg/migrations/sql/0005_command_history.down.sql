@@ -0,0 +1,3 @@
+DROP SEQUENCE command_seq_count;
+
+DROP TABLE command_history;
@@ -0,0 +1,24 @@
+-- Every telecommand sent up, and how far it got. A command is queued when it's accepted, sent once it's on the
+-- uplink, then either acknowledged by the spacecraft or failed
+
+CREATE TABLE command_history (
+                                 id BIGSERIAL PRIMARY KEY,
+                                 command VARCHAR(64) NOT NULL,
+                                 opcode INTEGER NOT NULL,
+                                 apid INTEGER NOT NULL,
+                                 seq_count INTEGER NOT NULL,
+                                 arguments JSONB NOT NULL DEFAULT '{}',
+                                 state VARCHAR(16) NOT NULL DEFAULT 'queued'
+                                     CHECK (state IN ('queued', 'sent', 'acknowledged', 'failed')),
+                                 error TEXT,
+                                 created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                                 sent_at TIMESTAMPTZ,
+                                 acknowledged_at TIMESTAMPTZ,
+                                 failed_at TIMESTAMPTZ
+);
+
+CREATE INDEX command_history_created_at_idx ON command_history (created_at);
+CREATE INDEX command_history_seq_count_idx ON command_history (apid, seq_count);
+
+-- telecommand sequence counts are 14 bits, so the sequence wraps where the packet field does
+CREATE SEQUENCE command_seq_count MINVALUE 0 MAXVALUE 16383 START 0 CYCLE;
@@ -0,0 +1,16 @@
+DROP TRIGGER command_update_trigger ON command_history;
+
+DROP FUNCTION notify_command_update();
+
+DROP INDEX command_history_pending_idx;
+
+UPDATE command_history SET state = 'acknowledged' WHERE state IN ('executing', 'completed');
+
+ALTER TABLE command_history DROP CONSTRAINT command_history_state_check;
+ALTER TABLE command_history ADD CONSTRAINT command_history_state_check
+    CHECK (state IN ('queued', 'sent', 'acknowledged', 'failed'));
+
+ALTER TABLE command_history
+    DROP COLUMN ack_code,
+    DROP COLUMN completed_at,
+    DROP COLUMN started_at;
@@ -0,0 +1,28 @@
+-- Command verification. Beyond being acknowledged (accepted), the spacecraft reports when a command starts executing
+-- and when it completes. Commands that miss a stage's deadline, or report failing one, end up failed
+
+ALTER TABLE command_history
+    ADD COLUMN started_at TIMESTAMPTZ,
+    ADD COLUMN completed_at TIMESTAMPTZ,
+    ADD COLUMN ack_code INTEGER;
+
+ALTER TABLE command_history DROP CONSTRAINT command_history_state_check;
+ALTER TABLE command_history ADD CONSTRAINT command_history_state_check
+    CHECK (state IN ('queued', 'sent', 'acknowledged', 'executing', 'completed', 'failed'));
+
+-- the commands still waiting on a verification stage, which is what the timeout sweep scans
+CREATE INDEX command_history_pending_idx ON command_history (sent_at)
+    WHERE state IN ('sent', 'acknowledged', 'executing');
+
+CREATE OR REPLACE FUNCTION notify_command_update()
+    RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('command_update', row_to_json(NEW)::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER command_update_trigger
+    AFTER INSERT OR UPDATE ON command_history
+    FOR EACH ROW
+EXECUTE FUNCTION notify_command_update();
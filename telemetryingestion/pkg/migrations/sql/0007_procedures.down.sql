@@ -0,0 +1,5 @@
+DROP TABLE procedure_run_log;
+
+DROP TABLE procedure_runs;
+
+DROP TABLE procedures;
@@ -0,0 +1,37 @@
+-- Procedures are declarative, telemetry gated command sequences that turionbackend runs. A run keeps its own copy of
+-- the steps, so changing a procedure doesn't change runs already under way
+
+CREATE TABLE procedures (
+                            name VARCHAR(64) PRIMARY KEY,
+                            description TEXT NOT NULL DEFAULT '',
+                            steps JSONB NOT NULL,
+                            created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                            updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE procedure_runs (
+                                id BIGSERIAL PRIMARY KEY,
+                                procedure VARCHAR(64) NOT NULL,
+                                steps JSONB NOT NULL,
+                                state VARCHAR(16) NOT NULL DEFAULT 'running'
+                                    CHECK (state IN ('running', 'paused', 'completed', 'failed', 'aborted')),
+                                current_step TEXT,
+                                error TEXT,
+                                started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+                                finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX procedure_runs_started_at_idx ON procedure_runs (started_at);
+
+-- everything that happened during a run, in order
+CREATE TABLE procedure_run_log (
+                                   id BIGSERIAL PRIMARY KEY,
+                                   run_id BIGINT NOT NULL REFERENCES procedure_runs (id) ON DELETE CASCADE,
+                                   step TEXT,
+                                   event VARCHAR(32) NOT NULL,
+                                   message TEXT NOT NULL DEFAULT '',
+                                   command_id BIGINT,
+                                   created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX procedure_run_log_run_id_idx ON procedure_run_log (run_id, id);
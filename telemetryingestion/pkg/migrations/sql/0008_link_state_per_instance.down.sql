@@ -0,0 +1,12 @@
+ALTER TABLE link_events DROP COLUMN ingest_instance;
+
+-- keep the most recently heard instance's row for each stream
+DELETE FROM link_state a
+    USING link_state b
+WHERE a.spacecraft = b.spacecraft
+  AND a.apid = b.apid
+  AND (a.last_packet_at, a.ingest_instance) < (b.last_packet_at, b.ingest_instance);
+
+ALTER TABLE link_state DROP CONSTRAINT link_state_pkey;
+ALTER TABLE link_state DROP COLUMN ingest_instance;
+ALTER TABLE link_state ADD PRIMARY KEY (spacecraft, apid);
@@ -0,0 +1,8 @@
+-- Link state per ingest instance. Each instance only hears the senders the kernel hashes to it, so it tracks the
+-- streams it receives on its own instead of timing out streams another instance is still receiving
+
+ALTER TABLE link_state ADD COLUMN ingest_instance TEXT NOT NULL DEFAULT '';
+ALTER TABLE link_state DROP CONSTRAINT link_state_pkey;
+ALTER TABLE link_state ADD PRIMARY KEY (spacecraft, apid, ingest_instance);
+
+ALTER TABLE link_events ADD COLUMN ingest_instance TEXT NOT NULL DEFAULT '';
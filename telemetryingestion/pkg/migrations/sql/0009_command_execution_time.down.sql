@@ -0,0 +1,3 @@
+DROP INDEX command_history_queued_idx;
+
+ALTER TABLE command_history DROP COLUMN execution_seconds;
@@ -0,0 +1,8 @@
+-- How long a command runs for once it starts, from its dictionary arguments, so a long burn or a delayed reboot gets
+-- that long on top of the completion timeout
+
+ALTER TABLE command_history ADD COLUMN execution_seconds DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- commands that were queued but never made it onto the uplink, which the timeout sweep also fails
+CREATE INDEX command_history_queued_idx ON command_history (created_at)
+    WHERE state = 'queued';
@@ -0,0 +1 @@
+DROP INDEX telemetry_vehicle_received_at_idx;
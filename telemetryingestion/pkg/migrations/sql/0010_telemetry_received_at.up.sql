@@ -0,0 +1,4 @@
+-- Procedures check conditions against the vehicle telemetry (APID 1) the ground received last
+
+CREATE INDEX telemetry_vehicle_received_at_idx ON telemetry (received_at)
+    WHERE (packet_id & 2047) = 1;
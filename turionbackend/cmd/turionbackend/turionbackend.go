@@ -10,10 +10,20 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+	"turiontakehome/telemetryingestion/pkg/commanding"
+	"turiontakehome/telemetryingestion/pkg/env"
+	"turiontakehome/telemetryingestion/pkg/health"
+	"turiontakehome/telemetryingestion/pkg/migrations"
 	"turiontakehome/turionbackend/internal/turionbackendv1/api"
+	"turiontakehome/turionbackend/internal/turionbackendv1/command/commandmodels"
+	"turiontakehome/turionbackend/internal/turionbackendv1/command/commandstorage/persistentcommand"
+	"turiontakehome/turionbackend/internal/turionbackendv1/procedure/proceduremodels"
+	"turiontakehome/turionbackend/internal/turionbackendv1/procedure/procedurestorage/persistentprocedure"
 	"turiontakehome/turionbackend/internal/turionbackendv1/requesthandlers"
 	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/broadcaster"
 	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/telemetrymodels"
+	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/telemetrystorage"
 	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/telemetrystorage/persistenttelemetry"
 	"turiontakehome/turionbackend/service"
 	"turiontakehome/turionbackend/utils/datasource"
@@ -61,6 +71,15 @@ func main() {
 		telemetryEnvelope.Logger.Fatalf("failed to connect to postgres: %v", err)
 	}
 
+	//refuse to run against a schema we weren't built for
+	migrator, err := migrations.New(turionBackendPostgres, telemetryEnvelope.Logger)
+	if err != nil {
+		telemetryEnvelope.Logger.Fatalf("failed to load migrations: %v", err)
+	}
+	if err := migrator.Check(mainCtx); err != nil {
+		telemetryEnvelope.Logger.Fatalf("%v, run migrate up", err)
+	}
+
 	//create the backend service
 	turionBackendService := createService(mainCtx, telemetryEnvelope, "turion backend", turionBackendPostgres)
 
@@ -77,18 +96,66 @@ func main() {
 func createService(mainCtx context.Context, telemetryEnvelope *envelope.ServiceEnvelope, serviceName string, postgres *pgxpool.Pool) *service.TurionBackendService {
 	//events channel
 	eventsCh := make(chan telemetrymodels.Telemetry, 100)
+	linkEventsCh := make(chan telemetrymodels.LinkState, 100)
+	commandEventsCh := make(chan commandmodels.Command, 100)
+	procedureEventsCh := make(chan proceduremodels.ProcedureRunEvent, 100)
 
 	//create and run the broadcaster
-	broadCaster := broadcaster.NewTelemetryBroadcaster(eventsCh, telemetryEnvelope)
+	broadCaster := broadcaster.NewTelemetryBroadcaster(eventsCh, linkEventsCh, commandEventsCh, procedureEventsCh, telemetryEnvelope)
 	go broadCaster.Run(mainCtx)
 
 	//create persistent telemetrystorage for Telemetry
-	storage := persistenttelemetry.New(postgres, "telemetry", telemetryEnvelope, eventsCh)
+	storage := persistenttelemetry.New(postgres, "telemetry", telemetryEnvelope, eventsCh, linkEventsCh, commandEventsCh, env.String("ARCHIVE_DIR", "telemetry-archive"))
+
+	//readiness checks
+	checker := health.NewChecker(2 * time.Second)
+	checker.Register("postgres", health.PostgresCheck(postgres))
+	checker.Register("postgres_listener", storage.CheckListener)
+	checker.Register("telemetry_events_queue", health.QueueCheck(eventsCh, 0.8))
+
+	//commands go up to the spacecraft over UDP, checked against the command dictionary first
+	dictionary, err := commanding.LoadDictionary(os.Getenv("COMMAND_DICTIONARY"))
+	if err != nil {
+		telemetryEnvelope.Logger.Fatalf("failed to load command dictionary: %v", err)
+	}
+	commands := persistentcommand.New(postgres, telemetryEnvelope, dictionary, env.String("UPLINK_ADDR", "telemetrygenerator:8090"))
+
+	//procedures send commands, gated on telemetry
+	procedures := persistentprocedure.New(postgres, telemetryEnvelope, commands, dictionary, procedureEventsCh)
+	go procedures.RunProcedures(mainCtx)
 
 	//make request handlers
-	handlers := requesthandlers.MakeRequestHandlers(storage, telemetryEnvelope, broadCaster)
+	handlers := requesthandlers.MakeRequestHandlers(storage, telemetryEnvelope, broadCaster, checker, commands, procedures)
 
 	//start listener
 	go storage.RunPostgresListener(mainCtx)
+
+	//keep the rollups the series endpoint serves long ranges from up to date
+	go storage.RunRollups(mainCtx, env.Duration("ROLLUP_INTERVAL", 30*time.Second), env.Duration("ROLLUP_LATENESS", 2*time.Minute))
+
+	//age old rows out, archiving raw telemetry first
+	rawRetention := env.Duration("RETENTION_RAW", 30*24*time.Hour)
+	go storage.RunRetention(mainCtx, telemetrystorage.RetentionPolicy{
+		Interval: env.Duration("RETENTION_INTERVAL", time.Hour),
+		Raw:      rawRetention,
+		Rollup1m: env.Duration("RETENTION_ROLLUP_1M", 90*24*time.Hour),
+		Rollup1h: env.Duration("RETENTION_ROLLUP_1H", 2*365*24*time.Hour),
+		Rollup1d: env.Duration("RETENTION_ROLLUP_1D", 0),
+		Rejected: env.Duration("RETENTION_REJECTED", 7*24*time.Hour),
+		Restored: env.Duration("RETENTION_RESTORED", 24*time.Hour),
+		Changes:  env.Duration("RETENTION_CHANGES", time.Hour),
+	})
+
+	//keep telemetry partitions made ahead of time, and detach them once they've been archived
+	partitionPeriod := 24 * time.Hour
+	if os.Getenv("PARTITION_PERIOD") == "week" {
+		partitionPeriod = 7 * 24 * time.Hour
+	}
+	go storage.RunPartitionMaintenance(mainCtx, telemetrystorage.PartitionPolicy{
+		Interval:  env.Duration("PARTITION_MAINTENANCE_INTERVAL", time.Hour),
+		Period:    partitionPeriod,
+		Ahead:     env.Duration("PARTITION_AHEAD", 7*24*time.Hour),
+		Retention: rawRetention,
+	})
 	return service.New(telemetryEnvelope, serviceName, handlers)
 }
@@ -9,6 +9,9 @@ type OffsetLimitParams struct {
 
 type RequestHandlers interface {
 	TelemetryRequestHandlers
+	HealthRequestHandlers
+	CommandRequestHandlers
+	ProcedureRequestHandlers
 	//add other handlers here as the backend grows to handle other requests...
 }
 
@@ -23,4 +26,7 @@ func RegisterRoutes(basePath string, serviceName string, handlers RequestHandler
 	v1 := fiberApp.Group("api/v1")
 
 	addTelemetryRoutes(handlers, v1)
+	addCommandRoutes(handlers, v1)
+	addProcedureRoutes(handlers, v1)
+	addHealthRoutes(handlers, fiberApp)
 }
@@ -0,0 +1,18 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+type CommandRequestHandlers interface {
+	HandleSendCommand() fiber.Handler
+	HandleGetCommands() fiber.Handler
+	HandleGetCommandDictionary() fiber.Handler
+}
+
+// looks like /api/v1/commands/...
+func addCommandRoutes(handlers RequestHandlers, router fiber.Router) {
+	router.Post("/commands", handlers.HandleSendCommand())
+	router.Get("/commands", handlers.HandleGetCommands())
+	router.Get("/commands/dictionary", handlers.HandleGetCommandDictionary())
+}
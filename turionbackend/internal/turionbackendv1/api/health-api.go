@@ -0,0 +1,16 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+type HealthRequestHandlers interface {
+	HandleLiveness() fiber.Handler
+	HandleReadiness() fiber.Handler
+}
+
+// looks like /healthz and /readyz, outside of the versioned api so probes never have to change
+func addHealthRoutes(handlers RequestHandlers, router fiber.Router) {
+	router.Get("/healthz", handlers.HandleLiveness())
+	router.Get("/readyz", handlers.HandleReadiness())
+}
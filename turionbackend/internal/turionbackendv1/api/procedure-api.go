@@ -0,0 +1,28 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+type ProcedureRequestHandlers interface {
+	HandleGetProcedures() fiber.Handler
+	HandleSaveProcedure() fiber.Handler
+	HandleStartProcedureRun() fiber.Handler
+	HandleGetProcedureRuns() fiber.Handler
+	HandleGetProcedureRun() fiber.Handler
+	HandlePauseProcedureRun() fiber.Handler
+	HandleResumeProcedureRun() fiber.Handler
+	HandleAbortProcedureRun() fiber.Handler
+}
+
+// looks like /api/v1/procedures/...
+func addProcedureRoutes(handlers RequestHandlers, router fiber.Router) {
+	router.Get("/procedures", handlers.HandleGetProcedures())
+	router.Post("/procedures", handlers.HandleSaveProcedure())
+	router.Get("/procedures/runs", handlers.HandleGetProcedureRuns())
+	router.Get("/procedures/runs/:id", handlers.HandleGetProcedureRun())
+	router.Post("/procedures/runs/:id/pause", handlers.HandlePauseProcedureRun())
+	router.Post("/procedures/runs/:id/resume", handlers.HandleResumeProcedureRun())
+	router.Post("/procedures/runs/:id/abort", handlers.HandleAbortProcedureRun())
+	router.Post("/procedures/:name/runs", handlers.HandleStartProcedureRun())
+}
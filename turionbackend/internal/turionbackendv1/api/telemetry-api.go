@@ -10,6 +10,12 @@ type TelemetryRequestHandlers interface {
 	HandleGetCurrent() fiber.Handler
 	HandleGetAnomalies() fiber.Handler
 	HandleGetAggregations() fiber.Handler
+	HandleGetLatency() fiber.Handler
+	HandleGetLinkState() fiber.Handler
+	HandleGetSeries() fiber.Handler
+	HandleGetArchives() fiber.Handler
+	HandleRestoreArchives() fiber.Handler
+	HandleGetListenerStatus() fiber.Handler
 	HandleWebsocket() fiber.Handler
 }
 
@@ -19,5 +25,11 @@ func addTelemetryRoutes(handlers RequestHandlers, router fiber.Router) {
 	router.Get("/telemetry/current", handlers.HandleGetCurrent())
 	router.Get("/telemetry/anomalies", handlers.HandleGetAnomalies())
 	router.Get("/telemetry/aggregations", handlers.HandleGetAggregations())
+	router.Get("/telemetry/latency", handlers.HandleGetLatency())
+	router.Get("/telemetry/link", handlers.HandleGetLinkState())
+	router.Get("/telemetry/series", handlers.HandleGetSeries())
+	router.Get("/telemetry/archives", handlers.HandleGetArchives())
+	router.Post("/telemetry/archives/restore", handlers.HandleRestoreArchives())
+	router.Get("/telemetry/listener", handlers.HandleGetListenerStatus())
 	router.Get("/telemetry/ws", handlers.HandleWebsocket())
 }
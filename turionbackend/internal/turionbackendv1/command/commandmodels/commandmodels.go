@@ -0,0 +1,66 @@
+package commandmodels
+
+import (
+	"time"
+	"turiontakehome/telemetryingestion/pkg/commanding"
+)
+
+// the states a command moves through. Queued and sent are on the way up, acknowledged and executing are the
+// spacecraft verifying it, and completed and failed are final
+const (
+	StateQueued       = "queued"
+	StateSent         = "sent"
+	StateAcknowledged = "acknowledged"
+	StateExecuting    = "executing"
+	StateCompleted    = "completed"
+	StateFailed       = "failed"
+)
+
+type Command struct {
+	ID               int64                  `db:"id" json:"id"`
+	Command          string                 `db:"command" json:"command"`
+	Opcode           int                    `db:"opcode" json:"opcode"`
+	APID             int                    `db:"apid" json:"apid"`
+	SeqCount         int                    `db:"seq_count" json:"seq_count"`
+	Arguments        map[string]interface{} `db:"arguments" json:"arguments"`
+	ExecutionSeconds float64                `db:"execution_seconds" json:"execution_seconds"` // runs this long once started, on top of the completion timeout
+	State            string                 `db:"state" json:"state"`
+	Error            *string                `db:"error" json:"error,omitempty"`
+	CreatedAt        time.Time              `db:"created_at" json:"created_at"`
+	SentAt           *time.Time             `db:"sent_at" json:"sent_at,omitempty"`
+	AcknowledgedAt   *time.Time             `db:"acknowledged_at" json:"acknowledged_at,omitempty"`
+	StartedAt        *time.Time             `db:"started_at" json:"started_at,omitempty"`
+	CompletedAt      *time.Time             `db:"completed_at" json:"completed_at,omitempty"`
+	FailedAt         *time.Time             `db:"failed_at" json:"failed_at,omitempty"`
+	AckCode          *int                   `db:"ack_code" json:"ack_code,omitempty"`
+}
+
+type CommandRequest struct {
+	Command   string                 `json:"command" validate:"required"`
+	Arguments map[string]interface{} `json:"arguments"`
+}
+
+type CommandResponse struct {
+	Status  int     `json:"status"`
+	Message string  `json:"message,omitempty"`
+	Data    Command `json:"data"`
+}
+
+type CommandHistoryRequest struct {
+	StartTime time.Time `query:"start_time" validate:"required"`
+	EndTime   time.Time `query:"end_time" validate:"required"`
+	State     string    `query:"state"`
+}
+
+type CommandHistoryResponse struct {
+	Status  int       `json:"status"`
+	Count   int       `json:"count"`
+	Message string    `json:"message"`
+	Data    []Command `json:"data"`
+}
+
+type CommandDictionaryResponse struct {
+	Status  int                     `json:"status"`
+	Message string                  `json:"message,omitempty"`
+	Data    []commanding.CommandDef `json:"data"`
+}
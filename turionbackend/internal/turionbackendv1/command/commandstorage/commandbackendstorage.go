@@ -0,0 +1,26 @@
+package commandstorage
+
+import (
+	"context"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"turiontakehome/turionbackend/internal/turionbackendv1/command/commandmodels"
+)
+
+// ErrInvalidCommand is a command the dictionary doesn't allow, and ErrUplink a command that couldn't be sent
+var (
+	ErrInvalidCommand = errors.New("invalid command")
+	ErrUplink         = errors.New("failed to send command")
+)
+
+type CommandBackendStorage interface {
+	commandStorage
+}
+
+type commandStorage interface {
+	SendCommand(c *fiber.Ctx) error
+	GetCommands(c *fiber.Ctx) error
+	GetDictionary(c *fiber.Ctx) error
+	Send(ctx context.Context, name string, arguments map[string]interface{}) (commandmodels.Command, error)
+	GetCommand(ctx context.Context, id int64) (commandmodels.Command, error)
+}
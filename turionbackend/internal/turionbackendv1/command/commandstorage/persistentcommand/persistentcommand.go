@@ -0,0 +1,292 @@
+package persistentcommand
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"net"
+	"time"
+	"turiontakehome/telemetryingestion/pkg/commanding"
+	"turiontakehome/turionbackend/internal/turionbackendv1/command/commandmodels"
+	"turiontakehome/turionbackend/internal/turionbackendv1/command/commandstorage"
+	"turiontakehome/turionbackend/utils/envelope"
+)
+
+// how long sending a telecommand on the uplink may take before the command is failed
+const uplinkTimeout = 2 * time.Second
+
+type commandStorage struct {
+	postgresClient *pgxpool.Pool
+	envelope       *envelope.ServiceEnvelope
+	dictionary     *commanding.Dictionary
+	uplinkAddr     string
+}
+
+func New(dbClient *pgxpool.Pool, commandEnvelope *envelope.ServiceEnvelope, dictionary *commanding.Dictionary, uplinkAddr string) commandstorage.CommandBackendStorage {
+	return &commandStorage{
+		postgresClient: dbClient,
+		envelope:       commandEnvelope,
+		dictionary:     dictionary,
+		uplinkAddr:     uplinkAddr,
+	}
+}
+
+// SendCommand validates a command against the dictionary, records it as queued, and sends it up as a CCSDS
+// telecommand. The command comes back sent, or failed if it never made it onto the uplink
+func (t commandStorage) SendCommand(c *fiber.Ctx) error {
+	//tracing
+	ctx, span := t.envelope.Tracer.Start(c.Context(), "SendCommand")
+	defer span.End()
+	t.envelope.LogWithContext(ctx, "SendCommand started")
+
+	var req commandmodels.CommandRequest
+	var res commandmodels.CommandResponse
+	if err := c.BodyParser(&req); err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid command body %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	command, err := t.Send(c.Context(), req.Command, req.Arguments)
+	switch {
+	case errors.Is(err, commandstorage.ErrInvalidCommand):
+		res.Status = fiber.StatusBadRequest
+		res.Message = err.Error()
+		span.RecordError(err)
+		return c.JSON(res)
+	case errors.Is(err, commandstorage.ErrUplink):
+		res.Status = fiber.StatusBadGateway
+		res.Message = err.Error()
+		res.Data = command
+		span.RecordError(err)
+		return c.JSON(res)
+	case err != nil:
+		res.Status = fiber.StatusInternalServerError
+		res.Message = err.Error()
+		span.RecordError(err)
+		return c.JSON(res)
+	}
+
+	res.Status = fiber.StatusOK
+	res.Data = command
+
+	return c.JSON(res)
+}
+
+// Send is SendCommand for callers other than the API, such as procedures
+func (t commandStorage) Send(ctx context.Context, name string, arguments map[string]interface{}) (commandmodels.Command, error) {
+	def, ok := t.dictionary.Lookup(name)
+	if !ok {
+		return commandmodels.Command{}, fmt.Errorf("%w: unknown command %q", commandstorage.ErrInvalidCommand, name)
+	}
+
+	if arguments == nil {
+		arguments = map[string]interface{}{}
+	}
+	encoded, err := def.EncodeArguments(arguments)
+	if err != nil {
+		return commandmodels.Command{}, fmt.Errorf("%w: %s", commandstorage.ErrInvalidCommand, err.Error())
+	}
+
+	//queue it first so there's a record of the attempt even if the uplink is down
+	command := commandmodels.Command{
+		Command:          def.Name,
+		Opcode:           int(def.Opcode),
+		APID:             int(def.APID),
+		Arguments:        arguments,
+		ExecutionSeconds: def.ExecutionTime(arguments).Seconds(),
+		State:            commandmodels.StateQueued,
+	}
+	err = t.postgresClient.QueryRow(ctx,
+		`INSERT INTO command_history (command, opcode, apid, seq_count, arguments, execution_seconds)
+		VALUES ($1, $2, $3, nextval('command_seq_count'), $4, $5)
+		RETURNING id, seq_count, created_at`,
+		command.Command, command.Opcode, command.APID, command.Arguments, command.ExecutionSeconds).Scan(&command.ID, &command.SeqCount, &command.CreatedAt)
+	if err != nil {
+		return commandmodels.Command{}, fmt.Errorf("failed to queue command %s", err.Error())
+	}
+
+	packet := commanding.Telecommand{
+		APID:      def.APID,
+		SeqCount:  uint16(command.SeqCount),
+		Timestamp: time.Now(),
+		Opcode:    def.Opcode,
+		Arguments: encoded,
+	}.Encode()
+
+	if sendErr := t.uplink(packet); sendErr != nil {
+		if err := t.markFailed(ctx, &command, sendErr); err != nil {
+			return command, fmt.Errorf("failed to record failed command %s", err.Error())
+		}
+		return command, fmt.Errorf("%w: %s", commandstorage.ErrUplink, sendErr.Error())
+	}
+
+	if err := t.markSent(ctx, &command); err != nil {
+		return command, fmt.Errorf("command was sent but couldn't be recorded %s", err.Error())
+	}
+	return command, nil
+}
+
+// GetCommand reads a single command back out of the history
+func (t commandStorage) GetCommand(ctx context.Context, id int64) (commandmodels.Command, error) {
+	var command commandmodels.Command
+	err := t.postgresClient.QueryRow(ctx,
+		`SELECT id, command, opcode, apid, seq_count, arguments, execution_seconds, state, error, created_at, sent_at,
+		acknowledged_at, started_at, completed_at, failed_at, ack_code
+		FROM command_history
+		WHERE id = $1`,
+		id).Scan(&command.ID, &command.Command, &command.Opcode, &command.APID, &command.SeqCount,
+		&command.Arguments, &command.ExecutionSeconds, &command.State, &command.Error, &command.CreatedAt, &command.SentAt,
+		&command.AcknowledgedAt, &command.StartedAt, &command.CompletedAt, &command.FailedAt, &command.AckCode)
+	return command, err
+}
+
+// uplink sends a telecommand to the spacecraft. The uplink is UDP, so getting it out is all we can know here; the
+// spacecraft acknowledging it is what says it arrived
+func (t commandStorage) uplink(packet []byte) error {
+	conn, err := net.DialTimeout("udp", t.uplinkAddr, uplinkTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(uplinkTimeout)); err != nil {
+		return err
+	}
+	_, err = conn.Write(packet)
+	return err
+}
+
+// markSent records the command as sent. The spacecraft's ack can get recorded first, in which case the command has
+// already moved on and keeps its state
+func (t commandStorage) markSent(ctx context.Context, command *commandmodels.Command) error {
+	var sentAt time.Time
+	err := t.postgresClient.QueryRow(ctx,
+		`UPDATE command_history SET sent_at = COALESCE(sent_at, now()),
+		state = CASE WHEN state = $2 THEN $3 ELSE state END
+		WHERE id = $1 RETURNING state, sent_at`,
+		command.ID, commandmodels.StateQueued, commandmodels.StateSent).Scan(&command.State, &sentAt)
+	if err != nil {
+		return err
+	}
+	command.SentAt = &sentAt
+	return nil
+}
+
+func (t commandStorage) markFailed(ctx context.Context, command *commandmodels.Command, cause error) error {
+	var failedAt time.Time
+	err := t.postgresClient.QueryRow(ctx,
+		`UPDATE command_history SET state = $2, failed_at = now(), error = $3 WHERE id = $1 RETURNING failed_at`,
+		command.ID, commandmodels.StateFailed, cause.Error()).Scan(&failedAt)
+	if err != nil {
+		return err
+	}
+	message := cause.Error()
+	command.State = commandmodels.StateFailed
+	command.FailedAt = &failedAt
+	command.Error = &message
+	return nil
+}
+
+func (t commandStorage) GetCommands(c *fiber.Ctx) error {
+	//tracing
+	ctx, span := t.envelope.Tracer.Start(c.Context(), "GetCommands")
+	defer span.End()
+	t.envelope.LogWithContext(ctx, "GetCommands started")
+
+	var req commandmodels.CommandHistoryRequest
+	var res commandmodels.CommandHistoryResponse
+	if err := c.QueryParser(&req); err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid query parameters %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	startTimeStr, _ := req.StartTime.MarshalText()
+	endTimeStr, _ := req.EndTime.MarshalText()
+
+	// Parse the times from the query parameters
+	startTime, err := time.Parse(time.RFC3339, string(startTimeStr))
+	if err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid start_time format. Use ISO8601 %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	endTime, err := time.Parse(time.RFC3339, string(endTimeStr))
+	if err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid end_time format. Use ISO8601 %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	switch req.State {
+	case "", commandmodels.StateQueued, commandmodels.StateSent, commandmodels.StateAcknowledged,
+		commandmodels.StateExecuting, commandmodels.StateCompleted, commandmodels.StateFailed:
+	default:
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid state %q", req.State)
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	rows, err := t.postgresClient.Query(c.Context(),
+		`SELECT id, command, opcode, apid, seq_count, arguments, execution_seconds, state, error, created_at, sent_at,
+		acknowledged_at, started_at, completed_at, failed_at, ack_code
+		FROM command_history
+		WHERE created_at >= $1 AND created_at <= $2 AND ($3 = '' OR state = $3)
+		ORDER BY created_at ASC`,
+		startTime, endTime, req.State)
+	if err != nil {
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to query command history %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+	defer rows.Close()
+
+	var commands []commandmodels.Command
+	for rows.Next() {
+		var command commandmodels.Command
+		err := rows.Scan(&command.ID, &command.Command, &command.Opcode, &command.APID, &command.SeqCount,
+			&command.Arguments, &command.ExecutionSeconds, &command.State, &command.Error, &command.CreatedAt, &command.SentAt,
+			&command.AcknowledgedAt, &command.StartedAt, &command.CompletedAt, &command.FailedAt, &command.AckCode)
+		if err != nil {
+			res.Status = fiber.StatusInternalServerError
+			res.Message = fmt.Sprintf("failed to scan command history %s", err.Error())
+			span.RecordError(errors.New(res.Message))
+			return c.JSON(res)
+		}
+		commands = append(commands, command)
+	}
+	if err := rows.Err(); err != nil {
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to read command history %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	res.Status = fiber.StatusOK
+	res.Count = len(commands)
+	res.Data = commands
+
+	return c.JSON(res)
+}
+
+func (t commandStorage) GetDictionary(c *fiber.Ctx) error {
+	//tracing
+	ctx, span := t.envelope.Tracer.Start(c.Context(), "GetDictionary")
+	defer span.End()
+	t.envelope.LogWithContext(ctx, "GetDictionary started")
+
+	return c.JSON(commandmodels.CommandDictionaryResponse{
+		Status: fiber.StatusOK,
+		Data:   t.dictionary.Commands(),
+	})
+}
@@ -0,0 +1,135 @@
+package proceduremodels
+
+import "time"
+
+// step types
+const (
+	StepCommand = "command"
+	StepWait    = "wait"
+	StepBranch  = "branch"
+)
+
+// StepEnd is a jump target that finishes the run successfully
+const StepEnd = "end"
+
+// run states. Completed, failed and aborted are final
+const (
+	RunRunning   = "running"
+	RunPaused    = "paused"
+	RunCompleted = "completed"
+	RunFailed    = "failed"
+	RunAborted   = "aborted"
+)
+
+// run log events
+const (
+	EventStarted      = "started"
+	EventStepStarted  = "step_started"
+	EventCommandSent  = "command_sent"
+	EventCommandState = "command_state"
+	EventConditionMet = "condition_met"
+	EventTimedOut     = "timed_out"
+	EventBranched     = "branched"
+	EventStepFailed   = "step_failed"
+	EventPaused       = "paused"
+	EventResumed      = "resumed"
+	EventAborted      = "aborted"
+	EventCompleted    = "completed"
+	EventFailed       = "failed"
+	EventInterrupted  = "interrupted"
+)
+
+type Procedure struct {
+	Name        string    `json:"name" validate:"required"`
+	Description string    `json:"description"`
+	Steps       []Step    `json:"steps" validate:"required"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Step is one step of a procedure. Steps run in order unless one jumps elsewhere by id.
+//   - command sends Command with Arguments. With Verify it waits for the command to complete, failing if the command
+//     fails or Timeout passes first
+//   - wait waits for telemetry received after the step started to meet Condition, failing if Timeout passes first
+//   - branch jumps to Then if the latest telemetry meets Condition, and to Else if it doesn't
+//
+// A step that fails jumps to OnFailure if it's set, and fails the run if it isn't. Jumping to "end" finishes the run
+type Step struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	Command   string                 `json:"command,omitempty"`
+	Arguments map[string]interface{} `json:"arguments,omitempty"`
+	Verify    bool                   `json:"verify,omitempty"`
+	Condition *Condition             `json:"condition,omitempty"`
+	Timeout   string                 `json:"timeout,omitempty"`
+	Then      string                 `json:"then,omitempty"`
+	Else      string                 `json:"else,omitempty"`
+	OnFailure string                 `json:"on_failure,omitempty"`
+}
+
+// Condition compares a telemetry metric against a value, e.g. temperature > 25
+type Condition struct {
+	Metric   string  `json:"metric"`
+	Operator string  `json:"operator"`
+	Value    float32 `json:"value"`
+}
+
+type ProcedureRun struct {
+	ID          int64      `json:"id"`
+	Procedure   string     `json:"procedure"`
+	Steps       []Step     `json:"steps"`
+	State       string     `json:"state"`
+	CurrentStep *string    `json:"current_step,omitempty"`
+	Error       *string    `json:"error,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Log         []LogEntry `json:"log,omitempty"`
+}
+
+type LogEntry struct {
+	ID        int64     `json:"id"`
+	Step      *string   `json:"step,omitempty"`
+	Event     string    `json:"event"`
+	Message   string    `json:"message"`
+	CommandID *int64    `json:"command_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ProcedureRunEvent is pushed to WebSocket clients for every entry in a run's log
+type ProcedureRunEvent struct {
+	RunID     int64    `json:"run_id"`
+	Procedure string   `json:"procedure"`
+	State     string   `json:"state"`
+	Entry     LogEntry `json:"entry"`
+}
+
+type ProcedureResponse struct {
+	Status  int       `json:"status"`
+	Message string    `json:"message,omitempty"`
+	Data    Procedure `json:"data"`
+}
+
+type ProceduresResponse struct {
+	Status  int         `json:"status"`
+	Message string      `json:"message,omitempty"`
+	Data    []Procedure `json:"data"`
+}
+
+type ProcedureRunRequest struct {
+	StartTime time.Time `query:"start_time" validate:"required"`
+	EndTime   time.Time `query:"end_time" validate:"required"`
+	State     string    `query:"state"`
+}
+
+type ProcedureRunResponse struct {
+	Status  int          `json:"status"`
+	Message string       `json:"message,omitempty"`
+	Data    ProcedureRun `json:"data"`
+}
+
+type ProcedureRunsResponse struct {
+	Status  int            `json:"status"`
+	Count   int            `json:"count"`
+	Message string         `json:"message"`
+	Data    []ProcedureRun `json:"data"`
+}
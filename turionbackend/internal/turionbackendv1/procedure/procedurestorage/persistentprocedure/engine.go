@@ -0,0 +1,572 @@
+package persistentprocedure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"sync"
+	"time"
+	"turiontakehome/turionbackend/internal/turionbackendv1/command/commandmodels"
+	"turiontakehome/turionbackend/internal/turionbackendv1/procedure/proceduremodels"
+	"turiontakehome/turionbackend/utils/telemetry"
+)
+
+const (
+	// how often waits check telemetry and verifying command steps check the command
+	pollInterval = time.Second
+	// how long any one query the engine makes may take
+	dbTimeout = 10 * time.Second
+	// a run that's gone through this many steps is assumed to be looping and is failed
+	maxStepsPerRun = 10000
+)
+
+var errNoTelemetry = errors.New("no telemetry received yet")
+
+// runRegistry holds the runs in progress so the API can pause, resume and abort them. Runs live in this process, so
+// ones that were in progress when the backend stopped are failed when it starts again
+type runRegistry struct {
+	mu     sync.Mutex
+	ctx    context.Context
+	closed bool
+	active map[int64]*activeRun
+	wg     sync.WaitGroup
+}
+
+// open lets runs start under ctx
+func (r *runRegistry) open(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ctx = ctx
+}
+
+// close stops new runs starting, then waits for the ones in progress to stop. Runs are only launched under mu while
+// the registry is open, so none can be added to the wait group once we're waiting on it
+func (r *runRegistry) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+// accepting is whether a new run can start. The caller holds mu
+func (r *runRegistry) accepting() bool {
+	return r.ctx != nil && r.ctx.Err() == nil && !r.closed
+}
+
+// launch registers a run and runs execute in the background. The caller holds mu and has checked the registry is
+// accepting runs
+func (r *runRegistry) launch(run *activeRun, execute func()) {
+	r.active[run.id] = run
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		execute()
+	}()
+}
+
+func (r *runRegistry) get(id int64) (*activeRun, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run, ok := r.active[id]
+	return run, ok
+}
+
+func (r *runRegistry) remove(id int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.active, id)
+}
+
+type activeRun struct {
+	id        int64
+	procedure string
+	cancel    context.CancelFunc
+
+	mu      sync.Mutex
+	step    string
+	paused  bool
+	resumed chan struct{}
+	aborted bool
+}
+
+func (r *activeRun) pause() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.paused || r.aborted {
+		return false
+	}
+	r.paused = true
+	r.resumed = make(chan struct{})
+	return true
+}
+
+func (r *activeRun) resume() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.paused || r.aborted {
+		return false
+	}
+	r.paused = false
+	close(r.resumed)
+	return true
+}
+
+func (r *activeRun) abort() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.aborted {
+		return false
+	}
+	r.aborted = true
+	r.cancel()
+	return true
+}
+
+func (r *activeRun) wasAborted() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.aborted
+}
+
+func (r *activeRun) state() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.paused {
+		return proceduremodels.RunPaused
+	}
+	return proceduremodels.RunRunning
+}
+
+func (r *activeRun) setStep(step string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.step = step
+}
+
+func (r *activeRun) currentStep() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.step
+}
+
+// checkpoint blocks while the run is paused, giving back how long it was held up. The run carries on only between
+// checkpoints, so that's where pausing takes effect
+func (r *activeRun) checkpoint(ctx context.Context) (time.Duration, error) {
+	r.mu.Lock()
+	if !r.paused {
+		r.mu.Unlock()
+		return 0, ctx.Err()
+	}
+	resumed := r.resumed
+	r.mu.Unlock()
+
+	pausedAt := time.Now()
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-resumed:
+		return time.Since(pausedAt), nil
+	}
+}
+
+// RunProcedures lets runs start until ctx is done, then waits for the ones in progress to stop
+func (t procedureStorage) RunProcedures(ctx context.Context) {
+	t.envelope.Logger.Info("starting procedure engine")
+	t.failInterrupted()
+
+	t.runs.open(ctx)
+
+	<-ctx.Done()
+	t.envelope.Logger.Info("stopping procedure engine")
+	t.runs.close()
+}
+
+// failInterrupted fails the runs that were in progress when the backend last stopped
+func (t procedureStorage) failInterrupted() {
+	ctx, cancel := dbContext()
+	defer cancel()
+
+	rows, err := t.postgresClient.Query(ctx,
+		`UPDATE procedure_runs SET state = $1, error = $2, finished_at = now()
+		WHERE state IN ($3, $4)
+		RETURNING id, procedure, COALESCE(current_step, '')`,
+		proceduremodels.RunFailed, "interrupted by a backend restart", proceduremodels.RunRunning, proceduremodels.RunPaused)
+	if err != nil {
+		t.envelope.Logger.Errorf("failed to fail interrupted procedure runs: %s", err.Error())
+		return
+	}
+	var interrupted []*activeRun
+	for rows.Next() {
+		var run activeRun
+		if err := rows.Scan(&run.id, &run.procedure, &run.step); err != nil {
+			t.envelope.Logger.Errorf("failed to scan interrupted procedure run: %s", err.Error())
+			continue
+		}
+		interrupted = append(interrupted, &run)
+	}
+	rows.Close()
+
+	for _, run := range interrupted {
+		t.log(run, proceduremodels.RunFailed, run.step, proceduremodels.EventInterrupted, "interrupted by a backend restart", nil)
+	}
+	if len(interrupted) > 0 {
+		t.envelope.Logger.Warnf("failed %d procedure runs interrupted by a restart", len(interrupted))
+	}
+}
+
+// start records a new run of the procedure and runs it in the background
+func (t procedureStorage) start(procedure proceduremodels.Procedure) (proceduremodels.ProcedureRun, error) {
+	t.runs.mu.Lock()
+	defer t.runs.mu.Unlock()
+	if !t.runs.accepting() {
+		return proceduremodels.ProcedureRun{}, errors.New("the procedure engine isn't running")
+	}
+
+	ctx, cancel := dbContext()
+	defer cancel()
+
+	run := proceduremodels.ProcedureRun{Procedure: procedure.Name, Steps: procedure.Steps, State: proceduremodels.RunRunning}
+	err := t.postgresClient.QueryRow(ctx,
+		`INSERT INTO procedure_runs (procedure, steps) VALUES ($1, $2) RETURNING id, started_at`,
+		run.Procedure, run.Steps).Scan(&run.ID, &run.StartedAt)
+	if err != nil {
+		return proceduremodels.ProcedureRun{}, err
+	}
+
+	runCtx, runCancel := context.WithCancel(t.runs.ctx)
+	active := &activeRun{id: run.ID, procedure: run.Procedure, cancel: runCancel}
+	t.runs.launch(active, func() {
+		t.execute(runCtx, active, run.Steps)
+	})
+
+	return run, nil
+}
+
+// execute runs the steps until one jumps to the end or the last one finishes
+func (t procedureStorage) execute(ctx context.Context, run *activeRun, steps []proceduremodels.Step) {
+	defer run.cancel()
+
+	t.log(run, proceduremodels.RunRunning, "", proceduremodels.EventStarted, fmt.Sprintf("started %s", run.procedure), nil)
+
+	index := make(map[string]int, len(steps))
+	for i, step := range steps {
+		index[step.ID] = i
+	}
+
+	for i, executed := 0, 0; i < len(steps); executed++ {
+		if executed >= maxStepsPerRun {
+			t.finish(run, proceduremodels.RunFailed, proceduremodels.EventFailed, fmt.Sprintf("gave up after %d steps, the procedure looks to be looping", maxStepsPerRun))
+			return
+		}
+		if _, err := run.checkpoint(ctx); err != nil {
+			t.stopped(run)
+			return
+		}
+
+		step := steps[i]
+		t.startStep(run, step)
+		next, err := t.runStep(ctx, run, step)
+		if ctx.Err() != nil {
+			t.stopped(run)
+			return
+		}
+		if err != nil {
+			t.log(run, run.state(), step.ID, proceduremodels.EventStepFailed, err.Error(), nil)
+			if step.OnFailure == "" {
+				t.finish(run, proceduremodels.RunFailed, proceduremodels.EventFailed, fmt.Sprintf("step %s failed: %s", step.ID, err.Error()))
+				return
+			}
+			next = step.OnFailure
+		}
+
+		if next == proceduremodels.StepEnd {
+			break
+		}
+		if next == "" {
+			i++
+		} else {
+			i = index[next]
+		}
+	}
+
+	t.finish(run, proceduremodels.RunCompleted, proceduremodels.EventCompleted, fmt.Sprintf("%s completed", run.procedure))
+}
+
+// runStep runs a single step, giving back the step to jump to, or "" for the next one
+func (t procedureStorage) runStep(ctx context.Context, run *activeRun, step proceduremodels.Step) (string, error) {
+	switch step.Type {
+	case proceduremodels.StepCommand:
+		return "", t.runCommand(ctx, run, step)
+	case proceduremodels.StepWait:
+		return "", t.runWait(ctx, run, step)
+	default:
+		return t.runBranch(ctx, run, step)
+	}
+}
+
+// runCommand sends the step's command and, if the step verifies, waits for the spacecraft to complete it
+func (t procedureStorage) runCommand(ctx context.Context, run *activeRun, step proceduremodels.Step) error {
+	command, err := t.commands.Send(ctx, step.Command, step.Arguments)
+	if command.ID != 0 {
+		commandID := command.ID
+		t.log(run, run.state(), step.ID, proceduremodels.EventCommandSent, fmt.Sprintf("%s is command %d, %s", step.Command, command.ID, command.State), &commandID)
+	}
+	if err != nil || !step.Verify {
+		return err
+	}
+
+	commandID := command.ID
+	timeout := stepTimeout(step)
+	deadline := time.Now().Add(timeout)
+	state := command.State
+	for {
+		paused, err := run.checkpoint(ctx)
+		if err != nil {
+			return err
+		}
+		deadline = deadline.Add(paused)
+
+		command, err = t.commands.GetCommand(ctx, commandID)
+		if err != nil {
+			return fmt.Errorf("failed to check on command %d: %w", commandID, err)
+		}
+		if command.State != state {
+			state = command.State
+			t.log(run, run.state(), step.ID, proceduremodels.EventCommandState, fmt.Sprintf("command %d is %s", commandID, state), &commandID)
+		}
+
+		switch command.State {
+		case commandmodels.StateCompleted:
+			return nil
+		case commandmodels.StateFailed:
+			reason := "no reason given"
+			if command.Error != nil {
+				reason = *command.Error
+			}
+			return fmt.Errorf("command %d failed: %s", commandID, reason)
+		}
+
+		if timeout > 0 && time.Now().After(deadline) {
+			t.log(run, run.state(), step.ID, proceduremodels.EventTimedOut, fmt.Sprintf("command %d didn't complete within %v", commandID, timeout), &commandID)
+			return fmt.Errorf("command %d didn't complete within %v", commandID, timeout)
+		}
+		if err := sleep(ctx, pollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// runWait waits for telemetry received after the step started to meet the step's condition
+func (t procedureStorage) runWait(ctx context.Context, run *activeRun, step proceduremodels.Step) error {
+	condition := *step.Condition
+	timeout := stepTimeout(step)
+	startedAt := time.Now()
+	deadline := startedAt.Add(timeout)
+	for {
+		paused, err := run.checkpoint(ctx)
+		if err != nil {
+			return err
+		}
+		deadline = deadline.Add(paused)
+
+		sample, err := t.latestTelemetry(ctx)
+		if err != nil && !errors.Is(err, errNoTelemetry) {
+			return err
+		}
+		if err == nil && !sample.receivedAt.Before(startedAt) {
+			value := sample.metric(condition.Metric)
+			if conditionOperators[condition.Operator](value, condition.Value) {
+				t.log(run, run.state(), step.ID, proceduremodels.EventConditionMet, fmt.Sprintf("%s is %v, met %s", condition.Metric, value, describeCondition(condition)), nil)
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			t.log(run, run.state(), step.ID, proceduremodels.EventTimedOut, fmt.Sprintf("%s wasn't met within %v", describeCondition(condition), timeout), nil)
+			return fmt.Errorf("%s wasn't met within %v", describeCondition(condition), timeout)
+		}
+		if err := sleep(ctx, pollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// runBranch checks the step's condition against the latest telemetry and picks where to go from there
+func (t procedureStorage) runBranch(ctx context.Context, run *activeRun, step proceduremodels.Step) (string, error) {
+	condition := *step.Condition
+	sample, err := t.latestTelemetry(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	value := sample.metric(condition.Metric)
+	met := conditionOperators[condition.Operator](value, condition.Value)
+	next := step.Else
+	if met {
+		next = step.Then
+	}
+	destination := next
+	if destination == "" {
+		destination = "the next step"
+	}
+	t.log(run, run.state(), step.ID, proceduremodels.EventBranched, fmt.Sprintf("%s is %v, %s is %t, going to %s", condition.Metric, value, describeCondition(condition), met, destination), nil)
+	return next, nil
+}
+
+func (t procedureStorage) startStep(run *activeRun, step proceduremodels.Step) {
+	run.setStep(step.ID)
+
+	ctx, cancel := dbContext()
+	defer cancel()
+	if _, err := t.postgresClient.Exec(ctx, `UPDATE procedure_runs SET current_step = $2 WHERE id = $1`, run.id, step.ID); err != nil {
+		t.envelope.Logger.Errorf("failed to record procedure run %d at step %s: %s", run.id, step.ID, err.Error())
+	}
+	t.log(run, run.state(), step.ID, proceduremodels.EventStepStarted, fmt.Sprintf("running %s step", step.Type), nil)
+}
+
+// stopped finishes a run whose context was canceled, either by an operator aborting it or by the backend shutting down
+func (t procedureStorage) stopped(run *activeRun) {
+	if run.wasAborted() {
+		t.finish(run, proceduremodels.RunAborted, proceduremodels.EventAborted, "aborted by operator")
+		return
+	}
+	t.finish(run, proceduremodels.RunFailed, proceduremodels.EventInterrupted, "interrupted by the backend shutting down")
+}
+
+// finish records the run's final state. It's taken out of the registry first, so it can't be paused or resumed
+// from here on
+func (t procedureStorage) finish(run *activeRun, state string, event string, message string) {
+	t.runs.remove(run.id)
+
+	ctx, cancel := dbContext()
+	defer cancel()
+
+	var runError *string
+	if state != proceduremodels.RunCompleted {
+		runError = &message
+	}
+	_, err := t.postgresClient.Exec(ctx,
+		`UPDATE procedure_runs SET state = $2, error = $3, finished_at = now() WHERE id = $1`,
+		run.id, state, runError)
+	if err != nil {
+		t.envelope.Logger.Errorf("failed to record procedure run %d as %s: %s", run.id, state, err.Error())
+	}
+	t.log(run, state, run.currentStep(), event, message, nil)
+}
+
+func (t procedureStorage) pause(run *activeRun) (string, error) {
+	if !run.pause() {
+		return "", fmt.Errorf("procedure run %d is already paused", run.id)
+	}
+
+	ctx, cancel := dbContext()
+	defer cancel()
+	_, err := t.postgresClient.Exec(ctx, `UPDATE procedure_runs SET state = $2 WHERE id = $1 AND state = $3`,
+		run.id, proceduremodels.RunPaused, proceduremodels.RunRunning)
+	if err != nil {
+		t.envelope.Logger.Errorf("failed to record procedure run %d as paused: %s", run.id, err.Error())
+	}
+	t.log(run, proceduremodels.RunPaused, run.currentStep(), proceduremodels.EventPaused, "paused by operator", nil)
+	return proceduremodels.RunPaused, nil
+}
+
+func (t procedureStorage) resume(run *activeRun) (string, error) {
+	if !run.resume() {
+		return "", fmt.Errorf("procedure run %d isn't paused", run.id)
+	}
+
+	ctx, cancel := dbContext()
+	defer cancel()
+	_, err := t.postgresClient.Exec(ctx, `UPDATE procedure_runs SET state = $2 WHERE id = $1 AND state = $3`,
+		run.id, proceduremodels.RunRunning, proceduremodels.RunPaused)
+	if err != nil {
+		t.envelope.Logger.Errorf("failed to record procedure run %d as resumed: %s", run.id, err.Error())
+	}
+	t.log(run, proceduremodels.RunRunning, run.currentStep(), proceduremodels.EventResumed, "resumed by operator", nil)
+	return proceduremodels.RunRunning, nil
+}
+
+// abort cancels the run; the run records itself as aborted once it's stopped
+func (t procedureStorage) abort(run *activeRun) (string, error) {
+	if !run.abort() {
+		return "", fmt.Errorf("procedure run %d is already being aborted", run.id)
+	}
+	return proceduremodels.RunAborted, nil
+}
+
+// log appends an entry to the run's log and pushes it to WebSocket clients. Neither is allowed to hold the run up:
+// failures are only logged, and the entry isn't pushed if the broadcaster is behind
+func (t procedureStorage) log(run *activeRun, state string, step string, event string, message string, commandID *int64) {
+	ctx, cancel := dbContext()
+	defer cancel()
+
+	entry := proceduremodels.LogEntry{Event: event, Message: message, CommandID: commandID}
+	if step != "" {
+		entry.Step = &step
+	}
+	err := t.postgresClient.QueryRow(ctx,
+		`INSERT INTO procedure_run_log (run_id, step, event, message, command_id) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`,
+		run.id, entry.Step, entry.Event, entry.Message, entry.CommandID).Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		t.envelope.Logger.Errorf("failed to log %s for procedure run %d: %s", event, run.id, err.Error())
+		return
+	}
+
+	select {
+	case t.events <- proceduremodels.ProcedureRunEvent{RunID: run.id, Procedure: run.procedure, State: state, Entry: entry}:
+	default:
+		t.envelope.Logger.Warnf("procedure events are backed up, not pushing %s for run %d", event, run.id)
+	}
+}
+
+type telemetrySample struct {
+	temperature float32
+	battery     float32
+	altitude    float32
+	signal      float32
+	receivedAt  time.Time
+}
+
+func (s telemetrySample) metric(name string) float32 {
+	switch name {
+	case telemetry.TEMPERATURE:
+		return s.temperature
+	case telemetry.BATTERY:
+		return s.battery
+	case telemetry.ALTITUDE:
+		return s.altitude
+	default:
+		return s.signal
+	}
+}
+
+// latestTelemetry is the vehicle telemetry we received last. It goes by when the ground received it, the same clock
+// waits compare against, since the spacecraft's clock can be off and other APIDs and stations interleave
+func (t procedureStorage) latestTelemetry(ctx context.Context) (telemetrySample, error) {
+	var sample telemetrySample
+	//APID 1 is written out rather than passed in so the query matches telemetry_vehicle_received_at_idx
+	err := t.postgresClient.QueryRow(ctx,
+		`SELECT temperature, battery, altitude, signal, received_at FROM telemetry
+		WHERE (packet_id & 2047) = 1
+		ORDER BY received_at DESC LIMIT 1`).
+		Scan(&sample.temperature, &sample.battery, &sample.altitude, &sample.signal, &sample.receivedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return sample, errNoTelemetry
+	}
+	return sample, err
+}
+
+func dbContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), dbTimeout)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,187 @@
+package persistentprocedure
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"turiontakehome/turionbackend/internal/turionbackendv1/procedure/proceduremodels"
+	"turiontakehome/turionbackend/utils/telemetry"
+)
+
+func newTestRegistry() *runRegistry {
+	return &runRegistry{active: make(map[int64]*activeRun)}
+}
+
+// TestRunRegistryCloseWaitsForLaunchedRuns launches runs the way start does while the engine shuts down, and makes
+// sure close waits for every run that got in and nothing gets in after. Run it with -race
+func TestRunRegistryCloseWaitsForLaunchedRuns(t *testing.T) {
+	registry := newTestRegistry()
+	ctx, cancel := context.WithCancel(context.Background())
+	registry.open(ctx)
+
+	var launched, finished atomic.Int64
+	starters := &sync.WaitGroup{}
+	for s := 0; s < 8; s++ {
+		starters.Add(1)
+		go func(s int) {
+			defer starters.Done()
+			for i := 0; i < 1000; i++ {
+				registry.mu.Lock()
+				if !registry.accepting() {
+					registry.mu.Unlock()
+					return
+				}
+				launched.Add(1)
+				registry.launch(&activeRun{id: int64(s*1000 + i)}, func() {
+					time.Sleep(time.Millisecond)
+					finished.Add(1)
+				})
+				registry.mu.Unlock()
+			}
+		}(s)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	cancel()
+	registry.close()
+	if launched.Load() != finished.Load() {
+		t.Errorf("close returned with %d of %d runs finished", finished.Load(), launched.Load())
+	}
+	starters.Wait()
+	if launched.Load() != finished.Load() {
+		t.Errorf("%d runs launched after close", launched.Load()-finished.Load())
+	}
+}
+
+func TestRunRegistryAccepting(t *testing.T) {
+	registry := newTestRegistry()
+	if registry.accepting() {
+		t.Error("accepting runs before the engine has started")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	registry.open(ctx)
+	if !registry.accepting() {
+		t.Error("not accepting runs once the engine has started")
+	}
+
+	cancel()
+	if registry.accepting() {
+		t.Error("accepting runs once the engine's context is done")
+	}
+
+	registry.open(context.Background())
+	registry.close()
+	if registry.accepting() {
+		t.Error("accepting runs once the registry is closed")
+	}
+}
+
+func TestStartRefusedOnceClosed(t *testing.T) {
+	registry := newTestRegistry()
+	registry.open(context.Background())
+	registry.close()
+
+	storage := procedureStorage{runs: registry}
+	procedure := proceduremodels.Procedure{Name: "noop", Steps: []proceduremodels.Step{{ID: "step1", Type: proceduremodels.StepCommand, Command: "NOOP"}}}
+	if _, err := storage.start(procedure); err == nil {
+		t.Error("started a run after the engine stopped")
+	}
+}
+
+func TestActiveRunPauseResume(t *testing.T) {
+	run := &activeRun{id: 1, cancel: func() {}}
+	ctx := context.Background()
+
+	if paused, err := run.checkpoint(ctx); paused != 0 || err != nil {
+		t.Fatalf("checkpoint held up a running run: %v %v", paused, err)
+	}
+	if !run.pause() || run.pause() {
+		t.Fatal("expected only the first pause to take")
+	}
+	if state := run.state(); state != proceduremodels.RunPaused {
+		t.Errorf("state %s, expected paused", state)
+	}
+
+	done := make(chan time.Duration, 1)
+	go func() {
+		paused, _ := run.checkpoint(ctx)
+		done <- paused
+	}()
+	select {
+	case <-done:
+		t.Fatal("checkpoint didn't wait while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if !run.resume() || run.resume() {
+		t.Fatal("expected only the first resume to take")
+	}
+	select {
+	case paused := <-done:
+		if paused < 20*time.Millisecond {
+			t.Errorf("checkpoint reported being paused for %v, expected at least 20ms", paused)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("checkpoint didn't carry on once resumed")
+	}
+	if state := run.state(); state != proceduremodels.RunRunning {
+		t.Errorf("state %s, expected running", state)
+	}
+}
+
+func TestActiveRunAbortWhilePaused(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &activeRun{id: 1, cancel: cancel}
+	run.pause()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := run.checkpoint(ctx)
+		done <- err
+	}()
+
+	if !run.abort() || run.abort() {
+		t.Fatal("expected only the first abort to take")
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, expected the run's context to be canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("aborting didn't stop a paused run")
+	}
+	if !run.wasAborted() {
+		t.Error("run doesn't know it was aborted")
+	}
+	//an aborted run can't be paused or resumed
+	if run.pause() || run.resume() {
+		t.Error("paused or resumed an aborted run")
+	}
+}
+
+func TestConditions(t *testing.T) {
+	sample := telemetrySample{temperature: 25, battery: 80, altitude: 525, signal: -60}
+	tests := []struct {
+		condition proceduremodels.Condition
+		met       bool
+	}{
+		{proceduremodels.Condition{Metric: telemetry.TEMPERATURE, Operator: ">", Value: 20}, true},
+		{proceduremodels.Condition{Metric: telemetry.TEMPERATURE, Operator: ">", Value: 25}, false},
+		{proceduremodels.Condition{Metric: telemetry.TEMPERATURE, Operator: ">=", Value: 25}, true},
+		{proceduremodels.Condition{Metric: telemetry.BATTERY, Operator: "<", Value: 20}, false},
+		{proceduremodels.Condition{Metric: telemetry.BATTERY, Operator: "<=", Value: 80}, true},
+		{proceduremodels.Condition{Metric: telemetry.ALTITUDE, Operator: "==", Value: 525}, true},
+		{proceduremodels.Condition{Metric: telemetry.SIGNAL, Operator: "!=", Value: -60}, false},
+		{proceduremodels.Condition{Metric: telemetry.SIGNAL, Operator: "<", Value: -50}, true},
+	}
+	for _, test := range tests {
+		if met := conditionOperators[test.condition.Operator](sample.metric(test.condition.Metric), test.condition.Value); met != test.met {
+			t.Errorf("%s: got %t, expected %t", describeCondition(test.condition), met, test.met)
+		}
+	}
+}
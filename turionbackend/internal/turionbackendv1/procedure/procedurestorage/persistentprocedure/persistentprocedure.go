@@ -0,0 +1,353 @@
+package persistentprocedure
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"strconv"
+	"time"
+	"turiontakehome/telemetryingestion/pkg/commanding"
+	"turiontakehome/turionbackend/internal/turionbackendv1/command/commandstorage"
+	"turiontakehome/turionbackend/internal/turionbackendv1/procedure/proceduremodels"
+	"turiontakehome/turionbackend/internal/turionbackendv1/procedure/procedurestorage"
+	"turiontakehome/turionbackend/utils/envelope"
+	"turiontakehome/turionbackend/utils/telemetry"
+)
+
+type procedureStorage struct {
+	postgresClient *pgxpool.Pool
+	envelope       *envelope.ServiceEnvelope
+	commands       commandstorage.CommandBackendStorage
+	dictionary     *commanding.Dictionary
+	events         chan proceduremodels.ProcedureRunEvent
+	validMetrics   map[string]struct{}
+	runs           *runRegistry
+}
+
+func New(dbClient *pgxpool.Pool, procedureEnvelope *envelope.ServiceEnvelope, commands commandstorage.CommandBackendStorage, dictionary *commanding.Dictionary, eventsChan chan proceduremodels.ProcedureRunEvent) procedurestorage.ProcedureBackendStorage {
+	return &procedureStorage{
+		postgresClient: dbClient,
+		envelope:       procedureEnvelope,
+		commands:       commands,
+		dictionary:     dictionary,
+		events:         eventsChan,
+		validMetrics:   telemetry.ValidMetrics(),
+		runs:           &runRegistry{active: make(map[int64]*activeRun)},
+	}
+}
+
+func (t procedureStorage) GetProcedures(c *fiber.Ctx) error {
+	//tracing
+	ctx, span := t.envelope.Tracer.Start(c.Context(), "GetProcedures")
+	defer span.End()
+	t.envelope.LogWithContext(ctx, "GetProcedures started")
+
+	var res proceduremodels.ProceduresResponse
+	rows, err := t.postgresClient.Query(c.Context(),
+		`SELECT name, description, steps, created_at, updated_at FROM procedures ORDER BY name`)
+	if err != nil {
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to query procedures %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var procedure proceduremodels.Procedure
+		if err := rows.Scan(&procedure.Name, &procedure.Description, &procedure.Steps, &procedure.CreatedAt, &procedure.UpdatedAt); err != nil {
+			res.Status = fiber.StatusInternalServerError
+			res.Message = fmt.Sprintf("failed to scan procedures %s", err.Error())
+			span.RecordError(errors.New(res.Message))
+			return c.JSON(res)
+		}
+		res.Data = append(res.Data, procedure)
+	}
+	if err := rows.Err(); err != nil {
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to read procedures %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	res.Status = fiber.StatusOK
+
+	return c.JSON(res)
+}
+
+// SaveProcedure validates a procedure and creates it, or replaces the procedure with the same name. Runs already
+// under way keep the steps they started with
+func (t procedureStorage) SaveProcedure(c *fiber.Ctx) error {
+	//tracing
+	ctx, span := t.envelope.Tracer.Start(c.Context(), "SaveProcedure")
+	defer span.End()
+	t.envelope.LogWithContext(ctx, "SaveProcedure started")
+
+	var procedure proceduremodels.Procedure
+	var res proceduremodels.ProcedureResponse
+	if err := c.BodyParser(&procedure); err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid procedure body %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	if err := t.validate(&procedure); err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid procedure %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	err := t.postgresClient.QueryRow(c.Context(),
+		`INSERT INTO procedures (name, description, steps) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description, steps = EXCLUDED.steps, updated_at = now()
+		RETURNING created_at, updated_at`,
+		procedure.Name, procedure.Description, procedure.Steps).Scan(&procedure.CreatedAt, &procedure.UpdatedAt)
+	if err != nil {
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to save procedure %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	res.Status = fiber.StatusOK
+	res.Data = procedure
+
+	return c.JSON(res)
+}
+
+// StartRun starts a run of the named procedure. The run carries on in the background; its progress is in its log
+// and pushed over the WebSocket
+func (t procedureStorage) StartRun(c *fiber.Ctx) error {
+	//tracing
+	ctx, span := t.envelope.Tracer.Start(c.Context(), "StartRun")
+	defer span.End()
+	t.envelope.LogWithContext(ctx, "StartRun started")
+
+	var res proceduremodels.ProcedureRunResponse
+	var procedure proceduremodels.Procedure
+	err := t.postgresClient.QueryRow(c.Context(),
+		`SELECT name, description, steps, created_at, updated_at FROM procedures WHERE name = $1`,
+		c.Params("name")).Scan(&procedure.Name, &procedure.Description, &procedure.Steps, &procedure.CreatedAt, &procedure.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		res.Status = fiber.StatusNotFound
+		res.Message = fmt.Sprintf("no procedure %q", c.Params("name"))
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+	if err != nil {
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to load procedure %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	run, err := t.start(procedure)
+	if err != nil {
+		res.Status = fiber.StatusServiceUnavailable
+		res.Message = fmt.Sprintf("failed to start procedure %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	res.Status = fiber.StatusOK
+	res.Data = run
+
+	return c.JSON(res)
+}
+
+func (t procedureStorage) GetRuns(c *fiber.Ctx) error {
+	//tracing
+	ctx, span := t.envelope.Tracer.Start(c.Context(), "GetRuns")
+	defer span.End()
+	t.envelope.LogWithContext(ctx, "GetRuns started")
+
+	var req proceduremodels.ProcedureRunRequest
+	var res proceduremodels.ProcedureRunsResponse
+	if err := c.QueryParser(&req); err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid query parameters %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	startTimeStr, _ := req.StartTime.MarshalText()
+	endTimeStr, _ := req.EndTime.MarshalText()
+
+	// Parse the times from the query parameters
+	startTime, err := time.Parse(time.RFC3339, string(startTimeStr))
+	if err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid start_time format. Use ISO8601 %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	endTime, err := time.Parse(time.RFC3339, string(endTimeStr))
+	if err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid end_time format. Use ISO8601 %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	rows, err := t.postgresClient.Query(c.Context(),
+		`SELECT id, procedure, steps, state, current_step, error, started_at, finished_at
+		FROM procedure_runs
+		WHERE started_at >= $1 AND started_at <= $2 AND ($3 = '' OR state = $3)
+		ORDER BY started_at ASC`,
+		startTime, endTime, req.State)
+	if err != nil {
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to query procedure runs %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+	defer rows.Close()
+
+	var runs []proceduremodels.ProcedureRun
+	for rows.Next() {
+		var run proceduremodels.ProcedureRun
+		err := rows.Scan(&run.ID, &run.Procedure, &run.Steps, &run.State, &run.CurrentStep, &run.Error, &run.StartedAt, &run.FinishedAt)
+		if err != nil {
+			res.Status = fiber.StatusInternalServerError
+			res.Message = fmt.Sprintf("failed to scan procedure runs %s", err.Error())
+			span.RecordError(errors.New(res.Message))
+			return c.JSON(res)
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to read procedure runs %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	res.Status = fiber.StatusOK
+	res.Count = len(runs)
+	res.Data = runs
+
+	return c.JSON(res)
+}
+
+// GetRun gives back a run along with its log
+func (t procedureStorage) GetRun(c *fiber.Ctx) error {
+	//tracing
+	ctx, span := t.envelope.Tracer.Start(c.Context(), "GetRun")
+	defer span.End()
+	t.envelope.LogWithContext(ctx, "GetRun started")
+
+	var res proceduremodels.ProcedureRunResponse
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid run id %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	run := proceduremodels.ProcedureRun{ID: id}
+	err = t.postgresClient.QueryRow(c.Context(),
+		`SELECT procedure, steps, state, current_step, error, started_at, finished_at FROM procedure_runs WHERE id = $1`,
+		id).Scan(&run.Procedure, &run.Steps, &run.State, &run.CurrentStep, &run.Error, &run.StartedAt, &run.FinishedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		res.Status = fiber.StatusNotFound
+		res.Message = fmt.Sprintf("no procedure run %d", id)
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+	if err != nil {
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to query procedure run %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	rows, err := t.postgresClient.Query(c.Context(),
+		`SELECT id, step, event, message, command_id, created_at FROM procedure_run_log WHERE run_id = $1 ORDER BY id`,
+		id)
+	if err != nil {
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to query procedure run log %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry proceduremodels.LogEntry
+		if err := rows.Scan(&entry.ID, &entry.Step, &entry.Event, &entry.Message, &entry.CommandID, &entry.CreatedAt); err != nil {
+			res.Status = fiber.StatusInternalServerError
+			res.Message = fmt.Sprintf("failed to scan procedure run log %s", err.Error())
+			span.RecordError(errors.New(res.Message))
+			return c.JSON(res)
+		}
+		run.Log = append(run.Log, entry)
+	}
+	if err := rows.Err(); err != nil {
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to read procedure run log %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	res.Status = fiber.StatusOK
+	res.Data = run
+
+	return c.JSON(res)
+}
+
+// PauseRun holds a run where it is. A wait that's paused gets the time it spent paused added to its timeout
+func (t procedureStorage) PauseRun(c *fiber.Ctx) error {
+	return t.controlRun(c, "PauseRun", t.pause)
+}
+
+func (t procedureStorage) ResumeRun(c *fiber.Ctx) error {
+	return t.controlRun(c, "ResumeRun", t.resume)
+}
+
+// AbortRun stops a run for good. A command that's already been sent isn't recalled
+func (t procedureStorage) AbortRun(c *fiber.Ctx) error {
+	return t.controlRun(c, "AbortRun", t.abort)
+}
+
+func (t procedureStorage) controlRun(c *fiber.Ctx, name string, control func(run *activeRun) (string, error)) error {
+	//tracing
+	ctx, span := t.envelope.Tracer.Start(c.Context(), name)
+	defer span.End()
+	t.envelope.LogWithContext(ctx, name+" started")
+
+	var res proceduremodels.ProcedureRunResponse
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid run id %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	run, ok := t.runs.get(id)
+	if !ok {
+		res.Status = fiber.StatusConflict
+		res.Message = fmt.Sprintf("procedure run %d isn't in progress", id)
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	state, err := control(run)
+	if err != nil {
+		res.Status = fiber.StatusConflict
+		res.Message = err.Error()
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	res.Status = fiber.StatusOK
+	res.Data = proceduremodels.ProcedureRun{ID: id, Procedure: run.procedure, State: state}
+
+	return c.JSON(res)
+}
@@ -0,0 +1,124 @@
+package persistentprocedure
+
+import (
+	"fmt"
+	"time"
+	"turiontakehome/turionbackend/internal/turionbackendv1/procedure/proceduremodels"
+)
+
+// maxNameLength is as long as the procedures table allows a name to be
+const maxNameLength = 64
+
+// conditionOperators compare a telemetry value against a condition's value
+var conditionOperators = map[string]func(value float32, threshold float32) bool{
+	">":  func(value float32, threshold float32) bool { return value > threshold },
+	">=": func(value float32, threshold float32) bool { return value >= threshold },
+	"<":  func(value float32, threshold float32) bool { return value < threshold },
+	"<=": func(value float32, threshold float32) bool { return value <= threshold },
+	"==": func(value float32, threshold float32) bool { return value == threshold },
+	"!=": func(value float32, threshold float32) bool { return value != threshold },
+}
+
+// validate checks a procedure can run: every command and its arguments are in the dictionary, every condition is on
+// a known metric, every timeout parses and every jump goes somewhere. Steps without an id are given one from their
+// position, e.g. step3
+func (t procedureStorage) validate(procedure *proceduremodels.Procedure) error {
+	if procedure.Name == "" || len(procedure.Name) > maxNameLength {
+		return fmt.Errorf("name must be between 1 and %d characters", maxNameLength)
+	}
+	if len(procedure.Steps) == 0 {
+		return fmt.Errorf("procedure has no steps")
+	}
+
+	ids := make(map[string]struct{}, len(procedure.Steps))
+	for i := range procedure.Steps {
+		step := &procedure.Steps[i]
+		if step.ID == "" {
+			step.ID = fmt.Sprintf("step%d", i+1)
+		}
+		if step.ID == proceduremodels.StepEnd {
+			return fmt.Errorf("step %d can't be called %q, it's reserved for ending the run", i+1, proceduremodels.StepEnd)
+		}
+		if _, ok := ids[step.ID]; ok {
+			return fmt.Errorf("step id %q is used more than once", step.ID)
+		}
+		ids[step.ID] = struct{}{}
+	}
+
+	for _, step := range procedure.Steps {
+		if err := t.validateStep(step, ids); err != nil {
+			return fmt.Errorf("step %s: %w", step.ID, err)
+		}
+	}
+	return nil
+}
+
+func (t procedureStorage) validateStep(step proceduremodels.Step, ids map[string]struct{}) error {
+	switch step.Type {
+	case proceduremodels.StepCommand:
+		def, ok := t.dictionary.Lookup(step.Command)
+		if !ok {
+			return fmt.Errorf("unknown command %q", step.Command)
+		}
+		arguments := step.Arguments
+		if arguments == nil {
+			arguments = map[string]interface{}{}
+		}
+		if _, err := def.EncodeArguments(arguments); err != nil {
+			return err
+		}
+		if step.Timeout != "" && !step.Verify {
+			return fmt.Errorf("timeout only applies to a command step that verifies")
+		}
+	case proceduremodels.StepWait:
+		if step.Condition == nil {
+			return fmt.Errorf("wait step needs a condition")
+		}
+		if step.Timeout == "" {
+			return fmt.Errorf("wait step needs a timeout")
+		}
+	case proceduremodels.StepBranch:
+		if step.Condition == nil {
+			return fmt.Errorf("branch step needs a condition")
+		}
+	default:
+		return fmt.Errorf("unknown step type %q, expected %s, %s or %s", step.Type, proceduremodels.StepCommand, proceduremodels.StepWait, proceduremodels.StepBranch)
+	}
+
+	if step.Timeout != "" {
+		timeout, err := time.ParseDuration(step.Timeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout %q", step.Timeout)
+		}
+	}
+
+	if step.Condition != nil {
+		if _, ok := t.validMetrics[step.Condition.Metric]; !ok {
+			return fmt.Errorf("unknown metric %q", step.Condition.Metric)
+		}
+		if _, ok := conditionOperators[step.Condition.Operator]; !ok {
+			return fmt.Errorf("unknown operator %q", step.Condition.Operator)
+		}
+	}
+
+	for _, target := range []string{step.Then, step.Else, step.OnFailure} {
+		if target == "" || target == proceduremodels.StepEnd {
+			continue
+		}
+		if _, ok := ids[target]; !ok {
+			return fmt.Errorf("jumps to unknown step %q", target)
+		}
+	}
+	return nil
+}
+
+// stepTimeout is the step's timeout, or zero if it doesn't have one. Procedures are validated when they're saved, so
+// it always parses
+func stepTimeout(step proceduremodels.Step) time.Duration {
+	timeout, _ := time.ParseDuration(step.Timeout)
+	return timeout
+}
+
+func describeCondition(condition proceduremodels.Condition) string {
+	return fmt.Sprintf("%s %s %v", condition.Metric, condition.Operator, condition.Value)
+}
@@ -0,0 +1,22 @@
+package procedurestorage
+
+import (
+	"context"
+	"github.com/gofiber/fiber/v2"
+)
+
+type ProcedureBackendStorage interface {
+	procedureStorage
+}
+
+type procedureStorage interface {
+	GetProcedures(c *fiber.Ctx) error
+	SaveProcedure(c *fiber.Ctx) error
+	StartRun(c *fiber.Ctx) error
+	GetRuns(c *fiber.Ctx) error
+	GetRun(c *fiber.Ctx) error
+	PauseRun(c *fiber.Ctx) error
+	ResumeRun(c *fiber.Ctx) error
+	AbortRun(c *fiber.Ctx) error
+	RunProcedures(ctx context.Context)
+}
@@ -3,6 +3,9 @@ package requesthandlers
 import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
+	"turiontakehome/telemetryingestion/pkg/health"
+	"turiontakehome/turionbackend/internal/turionbackendv1/command/commandstorage"
+	"turiontakehome/turionbackend/internal/turionbackendv1/procedure/procedurestorage"
 	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/broadcaster"
 	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/telemetrystorage"
 	"turiontakehome/turionbackend/utils/envelope"
@@ -13,14 +16,20 @@ type TurionBackendServiceRequestHandlers struct {
 	envelope      *envelope.ServiceEnvelope
 	storage       telemetrystorage.TelemetryBackendStorage
 	tmBroadCaster *broadcaster.TelemetryBroadcaster
+	checker       *health.Checker
+	commands      commandstorage.CommandBackendStorage
+	procedures    procedurestorage.ProcedureBackendStorage
 	//we can add other storages here as the service grows
 }
 
-func MakeRequestHandlers(storage telemetrystorage.TelemetryBackendStorage, telemetryEnvelope *envelope.ServiceEnvelope, tmBroadCaster *broadcaster.TelemetryBroadcaster) *TurionBackendServiceRequestHandlers {
+func MakeRequestHandlers(storage telemetrystorage.TelemetryBackendStorage, telemetryEnvelope *envelope.ServiceEnvelope, tmBroadCaster *broadcaster.TelemetryBroadcaster, checker *health.Checker, commands commandstorage.CommandBackendStorage, procedures procedurestorage.ProcedureBackendStorage) *TurionBackendServiceRequestHandlers {
 	return &TurionBackendServiceRequestHandlers{
 		envelope:      telemetryEnvelope,
 		storage:       storage,
 		tmBroadCaster: tmBroadCaster,
+		checker:       checker,
+		commands:      commands,
+		procedures:    procedures,
 	}
 }
 
@@ -60,6 +69,159 @@ func (t TurionBackendServiceRequestHandlers) HandleGetAggregations() fiber.Handl
 	}
 }
 
+func (t TurionBackendServiceRequestHandlers) HandleGetLatency() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandleGetLatency called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.storage.GetLatency(c)
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandleGetLinkState() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandleGetLinkState called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.storage.GetLinkState(c)
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandleGetSeries() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandleGetSeries called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.storage.GetSeries(c)
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandleGetArchives() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandleGetArchives called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.storage.GetArchives(c)
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandleRestoreArchives() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandleRestoreArchives called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.storage.RestoreArchives(c)
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandleGetListenerStatus() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandleGetListenerStatus called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.storage.GetListenerStatus(c)
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandleSendCommand() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandleSendCommand called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.commands.SendCommand(c)
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandleGetCommands() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandleGetCommands called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.commands.GetCommands(c)
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandleGetCommandDictionary() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandleGetCommandDictionary called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.commands.GetDictionary(c)
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandleGetProcedures() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandleGetProcedures called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.procedures.GetProcedures(c)
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandleSaveProcedure() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandleSaveProcedure called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.procedures.SaveProcedure(c)
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandleStartProcedureRun() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandleStartProcedureRun called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.procedures.StartRun(c)
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandleGetProcedureRuns() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandleGetProcedureRuns called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.procedures.GetRuns(c)
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandleGetProcedureRun() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandleGetProcedureRun called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.procedures.GetRun(c)
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandlePauseProcedureRun() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandlePauseProcedureRun called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.procedures.PauseRun(c)
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandleResumeProcedureRun() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandleResumeProcedureRun called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.procedures.ResumeRun(c)
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandleAbortProcedureRun() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t.envelope.Logger.Info("HandleAbortProcedureRun called")
+
+		//add child traces here if we add more logic than just calling the function
+		return t.procedures.AbortRun(c)
+	}
+}
+
 func (t TurionBackendServiceRequestHandlers) HandleWebsocket() fiber.Handler {
 	return websocket.New(func(conn *websocket.Conn) {
 		t.envelope.Logger.Info("HandleWebsocket called")
@@ -74,3 +236,17 @@ func (t TurionBackendServiceRequestHandlers) HandleWebsocket() fiber.Handler {
 		}
 	})
 }
+
+// HandleLiveness and HandleReadiness are hit by probes every few seconds, so they don't log
+func (t TurionBackendServiceRequestHandlers) HandleLiveness() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(health.Report{Status: health.StatusOK})
+	}
+}
+
+func (t TurionBackendServiceRequestHandlers) HandleReadiness() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		report := t.checker.Run(c.UserContext())
+		return c.Status(report.StatusCode()).JSON(report)
+	}
+}
@@ -0,0 +1,226 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Format and Version identify our archives; readers refuse anything newer than they understand
+const (
+	Format  = "turion-telemetry-archive"
+	Version = 1
+)
+
+const extension = ".ndjson.gz"
+
+// Header is the first line of every archive, so a file can be understood on its own without the database
+type Header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	Table     string    `json:"table"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Count     int       `json:"count"`
+	Columns   []string  `json:"columns"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Row is a telemetry row exactly as it was stored, one per line after the header
+type Row struct {
+	ID             int64     `json:"id"`
+	Timestamp      time.Time `json:"timestamp"`
+	PacketID       int32     `json:"packet_id"`
+	SeqFlags       int32     `json:"seq_flags"`
+	SeqCount       int32     `json:"seq_count"`
+	SubsystemID    int32     `json:"subsystem_id"`
+	Temperature    float32   `json:"temperature"`
+	Battery        float32   `json:"battery"`
+	Altitude       float32   `json:"altitude"`
+	Signal         float32   `json:"signal"`
+	AnomalyFlags   int32     `json:"anomaly_flags"`
+	ReceivedAt     time.Time `json:"received_at"`
+	GroundStation  string    `json:"ground_station"`
+	IngestInstance string    `json:"ingest_instance"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Columns are the telemetry columns a Row holds, in order
+func Columns() []string {
+	return []string{"id", "timestamp", "packet_id", "seq_flags", "seq_count", "subsystem_id", "temperature", "battery",
+		"altitude", "signal", "anomaly_flags", "received_at", "ground_station", "ingest_instance", "created_at"}
+}
+
+// Info describes an archive on disk
+type Info struct {
+	File      string
+	SizeBytes int64
+	Header    Header
+}
+
+// Writer writes an archive to a temporary file, which only takes the archive's name once Close has made it durable.
+// A crash part way through never leaves behind something that looks like a complete archive
+type Writer struct {
+	file    *os.File
+	gz      *gzip.Writer
+	buf     *bufio.Writer
+	enc     *json.Encoder
+	path    string
+	written int
+	count   int
+}
+
+// Create starts a new archive in dir named for the table and the day it starts on. If that day has already been
+// archived, the new file gets the creation time added so the old one isn't overwritten
+func Create(dir string, header Header) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory %s: %w", dir, err)
+	}
+
+	header.Format = Format
+	header.Version = Version
+	header.Columns = Columns()
+	header.CreatedAt = time.Now().UTC()
+
+	name := fmt.Sprintf("%s-%s", header.Table, header.From.UTC().Format("2006-01-02"))
+	path := filepath.Join(dir, name+extension)
+	if _, err := os.Stat(path); err == nil {
+		path = filepath.Join(dir, fmt.Sprintf("%s-%d%s", name, header.CreatedAt.UnixNano(), extension))
+	}
+
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive %s: %w", path, err)
+	}
+
+	gz := gzip.NewWriter(file)
+	gz.Comment = fmt.Sprintf("%s v%d", Format, Version)
+	buf := bufio.NewWriter(gz)
+	w := &Writer{file: file, gz: gz, buf: buf, enc: json.NewEncoder(buf), path: path, count: header.Count}
+
+	if err := w.enc.Encode(header); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) Write(row Row) error {
+	w.written++
+	return w.enc.Encode(row)
+}
+
+// Close flushes and syncs the archive and gives it its final name, returning that path. It fails if the number of
+// rows written doesn't match the header
+func (w *Writer) Close() (string, error) {
+	if w.written != w.count {
+		w.Abort()
+		return "", fmt.Errorf("archive header says %d rows but %d were written", w.count, w.written)
+	}
+
+	err := errors.Join(w.buf.Flush(), w.gz.Close(), w.file.Sync(), w.file.Close())
+	if err != nil {
+		os.Remove(w.file.Name())
+		return "", fmt.Errorf("failed to write archive %s: %w", w.path, err)
+	}
+	if err := os.Rename(w.file.Name(), w.path); err != nil {
+		os.Remove(w.file.Name())
+		return "", err
+	}
+	return w.path, nil
+}
+
+// Abort throws away an archive that won't be completed
+func (w *Writer) Abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// Read calls fn with every row in the archive at path, stopping at the first error. Once every row has been read it
+// checks there were as many as the header says, and that the file's gzip checksum holds, so a truncated or corrupted
+// archive is an error rather than fewer rows
+func Read(path string, fn func(Row) error) (Header, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Header{}, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return Header{}, fmt.Errorf("failed to open archive %s: %w", path, err)
+	}
+	defer gz.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(gz))
+	var header Header
+	if err := dec.Decode(&header); err != nil {
+		return Header{}, fmt.Errorf("failed to read archive header %s: %w", path, err)
+	}
+	if header.Format != Format || header.Version > Version {
+		return header, fmt.Errorf("unsupported archive %s: %s v%d", path, header.Format, header.Version)
+	}
+	if fn == nil {
+		return header, nil
+	}
+
+	count := 0
+	for dec.More() {
+		var row Row
+		if err := dec.Decode(&row); err != nil {
+			return header, fmt.Errorf("failed to read archive row %s: %w", path, err)
+		}
+		count++
+		if err := fn(row); err != nil {
+			return header, err
+		}
+	}
+	//More stops at the end of the data without saying why, the gzip reader keeps the error
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return header, fmt.Errorf("failed to read archive %s: %w", path, err)
+	}
+	if count != header.Count {
+		return header, fmt.Errorf("archive %s header says %d rows but has %d", path, header.Count, count)
+	}
+	return header, nil
+}
+
+// List describes every archive in dir, oldest first. Files that aren't readable archives are skipped
+func List(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []Info
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), extension) {
+			continue
+		}
+		fileInfo, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		header, err := Read(filepath.Join(dir, entry.Name()), nil)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, Info{File: entry.Name(), SizeBytes: fileInfo.Size(), Header: header})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Header.From.Before(infos[j].Header.From)
+	})
+	return infos, nil
+}
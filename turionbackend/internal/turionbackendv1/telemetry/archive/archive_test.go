@@ -0,0 +1,186 @@
+package archive
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var day = time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+
+func testRows(n int) []Row {
+	rows := make([]Row, n)
+	for i := range rows {
+		at := day.Add(time.Duration(i) * time.Hour)
+		rows[i] = Row{ID: int64(i + 1), Timestamp: at, PacketID: 0x0801, SeqFlags: 3, SeqCount: int32(i), SubsystemID: 1,
+			Temperature: 25.5, Battery: 80, Altitude: 525, Signal: -60, AnomalyFlags: int32(i % 2),
+			ReceivedAt: at.Add(120 * time.Millisecond), GroundStation: "svalbard", IngestInstance: "ingest-1", CreatedAt: at.Add(time.Second)}
+	}
+	return rows
+}
+
+func writeArchive(t *testing.T, dir string, from time.Time, rows []Row) string {
+	t.Helper()
+	writer, err := Create(dir, Header{Table: "telemetry", From: from, To: from.Add(24 * time.Hour), Count: len(rows)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := writer.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path, err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	rows := testRows(5)
+	path := writeArchive(t, dir, day, rows)
+	if filepath.Base(path) != "telemetry-2026-01-02.ndjson.gz" {
+		t.Errorf("archived to %s", path)
+	}
+
+	var read []Row
+	header, err := Read(path, func(row Row) error {
+		read = append(read, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Format != Format || header.Version != Version || header.Table != "telemetry" || header.Count != 5 ||
+		!header.From.Equal(day) || len(header.Columns) != len(Columns()) {
+		t.Errorf("read header %+v", header)
+	}
+	if len(read) != len(rows) {
+		t.Fatalf("read %d rows, expected %d", len(read), len(rows))
+	}
+	for i, row := range rows {
+		got := read[i]
+		if !got.Timestamp.Equal(row.Timestamp) || !got.ReceivedAt.Equal(row.ReceivedAt) || !got.CreatedAt.Equal(row.CreatedAt) {
+			t.Errorf("row %d read back with times %v %v %v", i, got.Timestamp, got.ReceivedAt, got.CreatedAt)
+		}
+		got.Timestamp, got.ReceivedAt, got.CreatedAt = row.Timestamp, row.ReceivedAt, row.CreatedAt
+		if got != row {
+			t.Errorf("row %d read back as %+v, expected %+v", i, read[i], row)
+		}
+	}
+}
+
+func TestCreateDoesNotOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	first := writeArchive(t, dir, day, testRows(1))
+	second := writeArchive(t, dir, day, testRows(2))
+	if first == second {
+		t.Fatalf("archived the same day twice to %s", first)
+	}
+	infos, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Errorf("listed %d archives, expected 2", len(infos))
+	}
+}
+
+func TestCloseChecksCount(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := Create(dir, Header{Table: "telemetry", From: day, To: day.Add(24 * time.Hour), Count: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(testRows(1)[0])
+	if _, err := writer.Close(); err == nil {
+		t.Error("closed an archive with fewer rows than its header says")
+	}
+	//nothing's left behind that looks like an archive, or a temporary file
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("left %d files behind", len(entries))
+	}
+}
+
+func TestReadRejectsCorruption(t *testing.T) {
+	dir := t.TempDir()
+	path := writeArchive(t, dir, day, testRows(50))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll := func() error {
+		_, err := Read(path, func(Row) error { return nil })
+		return err
+	}
+
+	//the last eight bytes of a gzip file are the CRC-32 and size of what's in it
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-8] ^= 0xFF
+	if err := os.WriteFile(path, corrupted, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := readAll(); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("got %v, expected a checksum error", err)
+	}
+
+	//a file cut short never reaches its checksum
+	if err := os.WriteFile(path, data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := readAll(); err == nil {
+		t.Error("read a truncated archive without an error")
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := readAll(); err != nil {
+		t.Errorf("failed to read the intact archive: %v", err)
+	}
+}
+
+func TestReadChecksCount(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := Create(dir, Header{Table: "telemetry", From: day, To: day.Add(24 * time.Hour), Count: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the writer won't close with the wrong count, so one row is written where the header says two
+	writer.count = 1
+	writer.Write(testRows(1)[0])
+	path, err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(path, func(Row) error { return nil }); err == nil || !strings.Contains(err.Error(), "says 2 rows but has 1") {
+		t.Errorf("got %v, expected a count mismatch", err)
+	}
+}
+
+func TestReadRejectsNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemetry-2026-01-02.ndjson.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(file)
+	json.NewEncoder(gz).Encode(Header{Format: Format, Version: Version + 1, Table: "telemetry"})
+	gz.Close()
+	file.Close()
+
+	if _, err := Read(path, nil); err == nil || !strings.Contains(err.Error(), "unsupported archive") {
+		t.Errorf("got %v, expected an unsupported archive", err)
+	}
+	//List skips what it can't read
+	if infos, err := List(filepath.Dir(path)); err != nil || len(infos) != 0 {
+		t.Errorf("listed %v %v", infos, err)
+	}
+}
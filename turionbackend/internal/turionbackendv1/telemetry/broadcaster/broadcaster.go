@@ -5,19 +5,26 @@ import (
 	"encoding/json"
 	"github.com/gofiber/websocket/v2"
 	"sync"
+	"turiontakehome/turionbackend/internal/turionbackendv1/command/commandmodels"
+	"turiontakehome/turionbackend/internal/turionbackendv1/procedure/proceduremodels"
 	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/telemetrymodels"
 	"turiontakehome/turionbackend/utils/envelope"
 )
 
 type TelemetryBroadcaster struct {
-	clients  map[*websocket.Conn]bool
-	mu       sync.Mutex
-	envelope *envelope.ServiceEnvelope
-	events   chan telemetrymodels.Telemetry
+	clients    map[*websocket.Conn]bool
+	mu         sync.Mutex
+	envelope   *envelope.ServiceEnvelope
+	events     chan telemetrymodels.Telemetry
+	linkEvents chan telemetrymodels.LinkState
+	//command state changes
+	commandEvents chan commandmodels.Command
+	//procedure run progress
+	procedureEvents chan proceduremodels.ProcedureRunEvent
 }
 
-func NewTelemetryBroadcaster(eventsChan chan telemetrymodels.Telemetry, telemEnvelope *envelope.ServiceEnvelope) *TelemetryBroadcaster {
-	return &TelemetryBroadcaster{clients: make(map[*websocket.Conn]bool), envelope: telemEnvelope, events: eventsChan}
+func NewTelemetryBroadcaster(eventsChan chan telemetrymodels.Telemetry, linkEventsChan chan telemetrymodels.LinkState, commandEventsChan chan commandmodels.Command, procedureEventsChan chan proceduremodels.ProcedureRunEvent, telemEnvelope *envelope.ServiceEnvelope) *TelemetryBroadcaster {
+	return &TelemetryBroadcaster{clients: make(map[*websocket.Conn]bool), envelope: telemEnvelope, events: eventsChan, linkEvents: linkEventsChan, commandEvents: commandEventsChan, procedureEvents: procedureEventsChan}
 }
 
 func (b *TelemetryBroadcaster) Run(ctx context.Context) {
@@ -34,26 +41,38 @@ func (b *TelemetryBroadcaster) Run(ctx context.Context) {
 			b.mu.Unlock()
 			return
 		case message := <-b.events:
-			b.mu.Lock()
-			msg := message
-			for client := range b.clients {
-				b.envelope.Logger.Info("sending telemetry to client")
-				byteMsg, err := json.Marshal(msg)
-				if err != nil {
-					b.envelope.Logger.Error("Error marshalling telemetry to bytes: %s", err.Error())
-					continue
-				}
-				err = client.WriteMessage(websocket.TextMessage, byteMsg)
-				if err != nil {
-					client.Close()
-					delete(b.clients, client)
-					b.envelope.Logger.Info("Error sending message, client disconnected")
-				}
-			}
-			b.mu.Unlock()
+			//telemetry goes out as is, clients have always read it that way
+			b.broadcast(message)
+		case linkState := <-b.linkEvents:
+			b.broadcast(telemetrymodels.BroadcastMessage{Type: "link_state", Data: linkState})
+		case command := <-b.commandEvents:
+			b.broadcast(telemetrymodels.BroadcastMessage{Type: "command", Data: command})
+		case run := <-b.procedureEvents:
+			b.broadcast(telemetrymodels.BroadcastMessage{Type: "procedure_run", Data: run})
 		}
 	}
 }
+
+func (b *TelemetryBroadcaster) broadcast(msg interface{}) {
+	byteMsg, err := json.Marshal(msg)
+	if err != nil {
+		b.envelope.Logger.Errorf("Error marshalling message to bytes: %s", err.Error())
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for client := range b.clients {
+		b.envelope.Logger.Info("sending message to client")
+		err = client.WriteMessage(websocket.TextMessage, byteMsg)
+		if err != nil {
+			client.Close()
+			delete(b.clients, client)
+			b.envelope.Logger.Info("Error sending message, client disconnected")
+		}
+	}
+}
+
 func (b *TelemetryBroadcaster) Register(client *websocket.Conn) {
 	b.envelope.Logger.Info("Registering client")
 	b.mu.Lock()
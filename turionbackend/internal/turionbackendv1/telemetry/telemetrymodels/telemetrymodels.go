@@ -3,18 +3,21 @@ package telemetrymodels
 import "time"
 
 type Telemetry struct {
-	ID          int       `db:"id" json:"id"`
-	Timestamp   time.Time `db:"timestamp" json:"timestamp"`
-	PacketID    int       `db:"packet_id" json:"packet_id"`
-	SeqFlags    int       `db:"seq_flags" json:"seq_flags"`
-	SeqCount    int       `db:"seq_count" json:"seq_count"`
-	SubsystemID int       `db:"subsystem_id" json:"subsystem_id"`
-	Temperature float32   `db:"temperature" json:"temperature"`
-	Battery     float32   `db:"battery" json:"battery"`
-	Altitude    float32   `db:"altitude" json:"altitude"`
-	Signal      float32   `db:"signal" json:"signal"`
-	Anomalies   []string  `json:"anomalies"`
-	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	ID             int       `db:"id" json:"id"`
+	Timestamp      time.Time `db:"timestamp" json:"timestamp"`
+	PacketID       int       `db:"packet_id" json:"packet_id"`
+	SeqFlags       int       `db:"seq_flags" json:"seq_flags"`
+	SeqCount       int       `db:"seq_count" json:"seq_count"`
+	SubsystemID    int       `db:"subsystem_id" json:"subsystem_id"`
+	Temperature    float32   `db:"temperature" json:"temperature"`
+	Battery        float32   `db:"battery" json:"battery"`
+	Altitude       float32   `db:"altitude" json:"altitude"`
+	Signal         float32   `db:"signal" json:"signal"`
+	Anomalies      []string  `json:"anomalies"`
+	ReceivedAt     time.Time `db:"received_at" json:"received_at"`
+	GroundStation  string    `db:"ground_station" json:"ground_station"`
+	IngestInstance string    `db:"ingest_instance" json:"ingest_instance"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
 }
 
 type TelemetryResponse struct {
@@ -35,6 +38,12 @@ type TelemetryRequest struct {
 	EndTime   time.Time `query:"end_time" validate:"required"`
 }
 
+type TelemetryAnomaliesRequest struct {
+	StartTime time.Time `query:"start_time" validate:"required"`
+	EndTime   time.Time `query:"end_time" validate:"required"`
+	Limit     int       `query:"limit"` // only the most recent anomalies in the range, all of them when zero
+}
+
 type TelemetryAggregationRequest struct {
 	StartTime   time.Time `query:"start_time" validate:"required"`
 	EndTime     time.Time `query:"end_time" validate:"required"`
@@ -48,6 +57,132 @@ type TelemetryAggregationResponse struct {
 	Data    Aggregate `json:"data"`
 }
 
+type TelemetrySeriesRequest struct {
+	StartTime time.Time `query:"start_time" validate:"required"`
+	EndTime   time.Time `query:"end_time" validate:"required"`
+	MaxPoints int       `query:"max_points"`
+}
+
+type TelemetrySeriesResponse struct {
+	Status     int           `json:"status"`
+	Message    string        `json:"message,omitempty"`
+	Resolution string        `json:"resolution"`
+	Count      int           `json:"count"`
+	Data       []SeriesPoint `json:"data"`
+}
+
+// SeriesPoint is either a single raw packet or a rollup bucket starting at Timestamp. A raw packet has a Count of one
+// and the same Min, Max and Avg
+type SeriesPoint struct {
+	Timestamp    time.Time     `json:"timestamp"`
+	Count        int64         `json:"count"`
+	AnomalyCount int64         `json:"anomaly_count"`
+	Temperature  MetricSummary `json:"temperature"`
+	Battery      MetricSummary `json:"battery"`
+	Altitude     MetricSummary `json:"altitude"`
+	Signal       MetricSummary `json:"signal"`
+}
+
+type MetricSummary struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	Avg float64 `json:"avg"`
+}
+
+type TelemetryLatencyResponse struct {
+	Status  int            `json:"status"`
+	Message string         `json:"message,omitempty"`
+	Data    []LatencyStats `json:"data"`
+}
+
+// LatencyStats describes the downlink latency (ground receive time minus onboard time) seen through a ground station.
+// The onboard clock only has second resolution, so the smallest offset we see is our best estimate of clock skew
+type LatencyStats struct {
+	GroundStation    string  `json:"ground_station"`
+	Count            int     `json:"count"`
+	MinLatencyMs     float64 `json:"min_latency_ms"`
+	MaxLatencyMs     float64 `json:"max_latency_ms"`
+	AvgLatencyMs     float64 `json:"avg_latency_ms"`
+	P50LatencyMs     float64 `json:"p50_latency_ms"`
+	P95LatencyMs     float64 `json:"p95_latency_ms"`
+	JitterMs         float64 `json:"jitter_ms"`
+	ClockSkewMs      float64 `json:"clock_skew_ms"`
+	FutureTimestamps int     `json:"future_timestamps"`
+}
+
+// LinkState is whether an ingest instance is currently hearing from a telemetry stream, AOS (acquisition of signal) or
+// LOS (loss of signal)
+type LinkState struct {
+	Spacecraft     string    `db:"spacecraft" json:"spacecraft"`
+	APID           int       `db:"apid" json:"apid"`
+	IngestInstance string    `db:"ingest_instance" json:"ingest_instance"`
+	State          string    `db:"state" json:"state"`
+	LastPacketAt   time.Time `db:"last_packet_at" json:"last_packet_at"`
+	ChangedAt      time.Time `db:"changed_at" json:"changed_at"`
+}
+
+type LinkStateResponse struct {
+	Status  int         `json:"status"`
+	Message string      `json:"message,omitempty"`
+	Data    []LinkState `json:"data"`
+}
+
+type ArchiveListResponse struct {
+	Status  int       `json:"status"`
+	Message string    `json:"message,omitempty"`
+	Data    []Archive `json:"data"`
+}
+
+// Archive describes one cold archive file of telemetry that has aged out of the database
+type Archive struct {
+	File      string    `json:"file"`
+	SizeBytes int64     `json:"size_bytes"`
+	Table     string    `json:"table"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Count     int       `json:"count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ArchiveRestoreResponse struct {
+	Status  int      `json:"status"`
+	Message string   `json:"message,omitempty"`
+	Count   int64    `json:"count"`
+	Files   []string `json:"files"`
+}
+
+// states the postgres listener goes through
+const (
+	ListenerConnecting   = "connecting"
+	ListenerConnected    = "connected"
+	ListenerReconnecting = "reconnecting"
+	ListenerStopped      = "stopped"
+)
+
+// ListenerStatus is the state of the postgres connection live telemetry comes in on
+type ListenerStatus struct {
+	State               string     `json:"state"`
+	Since               time.Time  `json:"since"`
+	LastError           string     `json:"last_error,omitempty"`
+	Reconnects          int        `json:"reconnects"`
+	NextAttemptAt       *time.Time `json:"next_attempt_at,omitempty"`
+	LastDeliveredChange int64      `json:"last_delivered_change"`
+	BackfilledRows      int64      `json:"backfilled_rows"`
+	MissedChanges       int64      `json:"missed_changes"` // changes retention deleted before they could be delivered
+}
+
+type ListenerStatusResponse struct {
+	Status  int            `json:"status"`
+	Message string         `json:"message,omitempty"`
+	Data    ListenerStatus `json:"data"`
+}
+
+// BroadcastMessage wraps anything other than telemetry pushed to WebSocket clients so they can tell the two apart
+type BroadcastMessage struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
 type Aggregate struct {
 	Metric string  `json:"metric"`
 	Result float32 `json:"result"`
@@ -0,0 +1,261 @@
+package persistenttelemetry
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"sync"
+	"time"
+	"turiontakehome/telemetryingestion/pkg/anomaly"
+	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/telemetrymodels"
+)
+
+// changeGapTimeout is how long we keep looking for a change we skipped over. Change ids are handed out when a
+// transaction inserts, not when it commits, so a later id can become visible first. Past this the missing change is
+// taken to have been rolled back
+const changeGapTimeout = 30 * time.Second
+
+// maxTrackedGap stops a jump in the sequence from leaving us tracking an unbounded number of gaps
+const maxTrackedGap = 10000
+
+// changeCursor is how far through telemetry_changes we've delivered: everything up to last, except the gaps
+type changeCursor struct {
+	mu          sync.Mutex
+	initialized bool
+	last        int64
+	gaps        map[int64]time.Time
+}
+
+func newChangeCursor() *changeCursor {
+	return &changeCursor{gaps: make(map[int64]time.Time)}
+}
+
+func (c *changeCursor) gapIDs() []int64 {
+	ids := make([]int64, 0, len(c.gaps))
+	for id := range c.gaps {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// advance marks a change delivered, noting any ids we jumped over as gaps to keep an eye out for
+func (c *changeCursor) advance(id int64, now time.Time) {
+	if id <= c.last {
+		delete(c.gaps, id)
+		return
+	}
+	if id-c.last <= maxTrackedGap {
+		for gap := c.last + 1; gap < id; gap++ {
+			c.gaps[gap] = now
+		}
+	}
+	c.last = id
+}
+
+// changeFeedGap is a run of changes retention deleted before we delivered them, so clients missed their telemetry
+type changeFeedGap struct {
+	from int64
+	to   int64
+}
+
+func (g *changeFeedGap) Error() string {
+	return fmt.Sprintf("telemetry changes %d to %d were deleted before they were delivered, clients may have missed their telemetry; the listener was away longer than RETENTION_CHANGES", g.from, g.to)
+}
+
+// skipDeleted moves the cursor past changes that retention deleted before we delivered them. lastExists is whether
+// the change the cursor last delivered is still there, and next is the oldest change after it, or the next id to be
+// handed out if there isn't one. Ids before next are normally rolled back changes; only once our own change has been
+// deleted can they have been deleted too
+func (c *changeCursor) skipDeleted(lastExists bool, next int64) *changeFeedGap {
+	if lastExists || next <= c.last+1 {
+		return nil
+	}
+	gap := &changeFeedGap{from: c.last + 1, to: next - 1}
+	c.last = next - 1
+	c.gaps = make(map[int64]time.Time)
+	return gap
+}
+
+func (c *changeCursor) expireGaps(now time.Time) {
+	for id, since := range c.gaps {
+		if now.Sub(since) > changeGapTimeout {
+			delete(c.gaps, id)
+		}
+	}
+}
+
+type telemetryChange struct {
+	id           int64
+	ids          []int64
+	minTimestamp time.Time
+	maxTimestamp time.Time
+}
+
+// changeFeed reads the telemetry_changes outbox and the telemetry behind it
+type changeFeed interface {
+	// latestChange is the newest change, or the last id handed out once retention has deleted every change
+	latestChange(ctx context.Context) (int64, error)
+	// changesAfter is every change after last, plus any of the gaps that have since shown up, oldest first
+	changesAfter(ctx context.Context, last int64, gaps []int64) ([]telemetryChange, error)
+	// nextChange is whether change last is still there, and the oldest change after it, or the next id to be handed
+	// out if there isn't one
+	nextChange(ctx context.Context, last int64) (bool, int64, error)
+	// telemetry hands each of a change's rows to deliver in order, stopping at the first error
+	telemetry(ctx context.Context, change telemetryChange, deliver func(telemetrymodels.Telemetry) error) error
+}
+
+type postgresChangeFeed struct {
+	postgresClient *pgxpool.Pool
+}
+
+func (p postgresChangeFeed) latestChange(ctx context.Context) (int64, error) {
+	var latest int64
+	err := p.postgresClient.QueryRow(ctx,
+		`SELECT coalesce(max(id), (SELECT CASE WHEN is_called THEN last_value ELSE last_value - 1 END FROM telemetry_changes_id_seq))
+		FROM telemetry_changes`).Scan(&latest)
+	return latest, err
+}
+
+func (p postgresChangeFeed) changesAfter(ctx context.Context, last int64, gaps []int64) ([]telemetryChange, error) {
+	rows, err := p.postgresClient.Query(ctx,
+		`SELECT id, ids, min_timestamp, max_timestamp
+		FROM telemetry_changes
+		WHERE id > $1 OR id = ANY($2)
+		ORDER BY id ASC`,
+		last, gaps)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []telemetryChange
+	for rows.Next() {
+		var change telemetryChange
+		if err := rows.Scan(&change.id, &change.ids, &change.minTimestamp, &change.maxTimestamp); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+func (p postgresChangeFeed) nextChange(ctx context.Context, last int64) (bool, int64, error) {
+	var lastExists bool
+	var next int64
+	err := p.postgresClient.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM telemetry_changes WHERE id = $1),
+		coalesce((SELECT min(id) FROM telemetry_changes WHERE id > $1),
+			(SELECT CASE WHEN is_called THEN last_value + 1 ELSE last_value END FROM telemetry_changes_id_seq))`,
+		last).Scan(&lastExists, &next)
+	return lastExists, next, err
+}
+
+func (p postgresChangeFeed) telemetry(ctx context.Context, change telemetryChange, deliver func(telemetrymodels.Telemetry) error) error {
+	rows, err := p.postgresClient.Query(ctx,
+		`SELECT id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal, anomaly_flags,
+		received_at, ground_station, ingest_instance, created_at
+		FROM telemetry
+		WHERE id = ANY($1) AND timestamp >= $2 AND timestamp <= $3
+		ORDER BY id ASC`,
+		change.ids, change.minTimestamp, change.maxTimestamp)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var anomalyFlags uint32
+	for rows.Next() {
+		var telemetry telemetrymodels.Telemetry
+		err := rows.Scan(
+			&telemetry.ID, &telemetry.Timestamp, &telemetry.PacketID, &telemetry.SeqFlags, &telemetry.SeqCount,
+			&telemetry.SubsystemID, &telemetry.Temperature, &telemetry.Battery, &telemetry.Altitude, &telemetry.Signal,
+			&anomalyFlags, &telemetry.ReceivedAt, &telemetry.GroundStation, &telemetry.IngestInstance, &telemetry.CreatedAt)
+		if err != nil {
+			return err
+		}
+		telemetry.Anomalies = anomaly.DecodeAnomalies(anomalyFlags)
+		if err := deliver(telemetry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// deliverChanges sends the telemetry from every change after the cursor, plus any gaps that have since shown up, to
+// be broadcast, giving back how many rows it sent. The first call starts the cursor at the latest change, so we don't
+// replay history to clients
+func (t telemetryStorage) deliverChanges(ctx context.Context) (int64, error) {
+	cursor := t.changes
+	cursor.mu.Lock()
+	defer cursor.mu.Unlock()
+
+	if !cursor.initialized {
+		//with nothing left in the table, start from the last id handed out so older deleted changes aren't a gap
+		latest, err := t.feed.latestChange(ctx)
+		if err != nil {
+			return 0, err
+		}
+		cursor.last = latest
+		cursor.initialized = true
+		return 0, nil
+	}
+
+	changes, err := t.feed.changesAfter(ctx, cursor.last, cursor.gapIDs())
+	if err != nil {
+		return 0, err
+	}
+
+	var delivered int64
+	now := time.Now()
+	for _, change := range changes {
+		n, err := t.deliverChange(ctx, change)
+		delivered += n
+		if err != nil {
+			return delivered, err
+		}
+		cursor.advance(change.id, now)
+	}
+	cursor.expireGaps(now)
+	return delivered, nil
+}
+
+// skipDeletedChanges checks whether retention deleted changes after the cursor while we were disconnected. Clients
+// can't get those back, so the cursor skips to what's left and the gap is given back to be reported
+func (t telemetryStorage) skipDeletedChanges(ctx context.Context) (*changeFeedGap, error) {
+	cursor := t.changes
+	cursor.mu.Lock()
+	defer cursor.mu.Unlock()
+	if !cursor.initialized {
+		return nil, nil
+	}
+
+	lastExists, next, err := t.feed.nextChange(ctx, cursor.last)
+	if err != nil {
+		return nil, err
+	}
+	return cursor.skipDeleted(lastExists, next), nil
+}
+
+// lastDelivered is the latest change the cursor has delivered
+func (c *changeCursor) lastDelivered() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+// deliverChange sends a change's rows to be broadcast. A change that fails part way is delivered again in full, so
+// clients can see a row twice but never miss one
+func (t telemetryStorage) deliverChange(ctx context.Context, change telemetryChange) (int64, error) {
+	var delivered int64
+	err := t.feed.telemetry(ctx, change, func(telemetry telemetrymodels.Telemetry) error {
+		//send it to be broadcasted
+		select {
+		case t.events <- telemetry:
+			delivered++
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	return delivered, err
+}
@@ -0,0 +1,75 @@
+package persistenttelemetry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChangeCursorAdvanceTracksGaps(t *testing.T) {
+	cursor := newChangeCursor()
+	now := time.Now()
+
+	cursor.advance(3, now)
+	cursor.advance(6, now)
+	if cursor.last != 6 || len(cursor.gaps) != 4 {
+		t.Fatalf("cursor at %d with gaps %v, expected 6 with 1, 2, 4 and 5", cursor.last, cursor.gaps)
+	}
+
+	//a gap turning up is delivered and stops being tracked
+	cursor.advance(4, now)
+	if _, ok := cursor.gaps[4]; ok || cursor.last != 6 {
+		t.Errorf("cursor at %d with gaps %v after 4 showed up", cursor.last, cursor.gaps)
+	}
+
+	cursor.expireGaps(now.Add(changeGapTimeout + time.Second))
+	if len(cursor.gaps) != 0 {
+		t.Errorf("gaps %v weren't expired", cursor.gaps)
+	}
+
+	//too big a jump isn't tracked at all
+	cursor.advance(6+maxTrackedGap+1, now)
+	if len(cursor.gaps) != 0 {
+		t.Errorf("tracking %d gaps after a jump", len(cursor.gaps))
+	}
+}
+
+func TestChangeCursorSkipDeleted(t *testing.T) {
+	tests := []struct {
+		name       string
+		lastExists bool
+		next       int64
+		gap        *changeFeedGap
+	}{
+		{"new changes", true, 11, nil},
+		//the ids in between were rolled back, our own change is still there so retention hasn't got this far
+		{"rolled back", true, 15, nil},
+		//retention deleted our change, but the next one follows straight on
+		{"deleted but contiguous", false, 11, nil},
+		{"deleted", false, 15, &changeFeedGap{from: 11, to: 14}},
+	}
+	for _, test := range tests {
+		cursor := newChangeCursor()
+		cursor.initialized = true
+		cursor.last = 8
+		cursor.advance(10, time.Now())
+
+		gap := cursor.skipDeleted(test.lastExists, test.next)
+		switch {
+		case test.gap == nil && gap != nil:
+			t.Errorf("%s: reported gap %+v", test.name, gap)
+		case test.gap != nil && (gap == nil || *gap != *test.gap):
+			t.Errorf("%s: reported gap %+v, expected %+v", test.name, gap, test.gap)
+		}
+
+		if test.gap == nil {
+			if cursor.last != 10 || len(cursor.gaps) != 1 {
+				t.Errorf("%s: cursor moved to %d with gaps %v", test.name, cursor.last, cursor.gaps)
+			}
+			continue
+		}
+		//the next change is delivered after the cursor, and the gaps it was waiting on are gone with the rest
+		if cursor.last != test.next-1 || len(cursor.gaps) != 0 {
+			t.Errorf("%s: cursor at %d with gaps %v, expected %d with none", test.name, cursor.last, cursor.gaps, test.next-1)
+		}
+	}
+}
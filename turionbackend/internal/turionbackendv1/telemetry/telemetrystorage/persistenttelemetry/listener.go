@@ -0,0 +1,259 @@
+package persistenttelemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgconn"
+	"sync"
+	"time"
+	"turiontakehome/telemetryingestion/pkg/health"
+	"turiontakehome/turionbackend/internal/turionbackendv1/command/commandmodels"
+	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/telemetrymodels"
+)
+
+// reconnect backoff for the postgres listener. A connection that stays up for listenerStableAfter resets it
+const (
+	listenerInitialBackoff = time.Second
+	listenerMaxBackoff     = 30 * time.Second
+	listenerStableAfter    = time.Minute
+)
+
+// listenerState tracks the postgres listener's connection for readiness and the listener endpoint
+type listenerState struct {
+	mu             sync.Mutex
+	state          string
+	since          time.Time
+	lastError      string
+	reconnects     int
+	nextAttemptAt  time.Time
+	backfilledRows int64
+	missedChanges  int64
+}
+
+func (l *listenerState) set(state string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.state != state {
+		l.since = time.Now()
+	}
+	l.state = state
+	l.nextAttemptAt = time.Time{}
+	if err != nil {
+		l.lastError = err.Error()
+	}
+}
+
+func (l *listenerState) reconnecting(err error, nextAttemptAt time.Time) {
+	l.set(telemetrymodels.ListenerReconnecting, err)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reconnects++
+	l.nextAttemptAt = nextAttemptAt
+}
+
+func (l *listenerState) backfilled(rows int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.backfilledRows += rows
+}
+
+// missed records changes that were deleted before we could deliver them
+func (l *listenerState) missed(gap *changeFeedGap) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.missedChanges += gap.to - gap.from + 1
+	l.lastError = gap.Error()
+}
+
+func (l *listenerState) status() telemetrymodels.ListenerStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	status := telemetrymodels.ListenerStatus{
+		State:          l.state,
+		Since:          l.since,
+		LastError:      l.lastError,
+		Reconnects:     l.reconnects,
+		BackfilledRows: l.backfilledRows,
+		MissedChanges:  l.missedChanges,
+	}
+	if !l.nextAttemptAt.IsZero() {
+		nextAttemptAt := l.nextAttemptAt
+		status.NextAttemptAt = &nextAttemptAt
+	}
+	return status
+}
+
+// RunPostgresListener keeps a LISTEN connection up until ctx is done, reconnecting with exponential backoff whenever
+// it's lost. Every (re)connect picks the change feed up from the last change delivered, so clients get whatever was
+// inserted while we were away
+func (t telemetryStorage) RunPostgresListener(ctx context.Context) {
+	t.envelope.Logger.Info("starting postgres listener")
+	t.reconnect(ctx, t.listen, listenerInitialBackoff, listenerMaxBackoff)
+	t.envelope.Logger.Info("stopping postgres listener")
+}
+
+// reconnect runs listen until ctx is done, backing off from initialBackoff up to maxBackoff between attempts
+func (t telemetryStorage) reconnect(ctx context.Context, listen func(context.Context) error, initialBackoff, maxBackoff time.Duration) {
+	defer t.listener.set(telemetrymodels.ListenerStopped, nil)
+
+	backoff := initialBackoff
+	for {
+		connectedAt := time.Now()
+		err := listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(connectedAt) > listenerStableAfter {
+			backoff = initialBackoff
+		}
+		t.listener.reconnecting(err, time.Now().Add(backoff))
+		t.envelope.Logger.Errorf("postgres listener lost: %s, reconnecting in %v", err.Error(), backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// listen runs a single LISTEN connection until it fails or ctx is done
+func (t telemetryStorage) listen(ctx context.Context) error {
+	//get a postgres client from the pool
+	conn, err := t.postgresClient.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire postgres connection: %w", err)
+	}
+	//the connection holds our LISTENs and may be broken, so it never goes back into the pool for reuse
+	defer func() {
+		conn.Conn().Close(context.Background())
+		conn.Release()
+	}()
+
+	for _, channel := range []string{"telemetry_update", "link_state_update", "command_update"} {
+		if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+			return fmt.Errorf("failed to listen for %s: %w", channel, err)
+		}
+	}
+
+	//pick the change feed up now we're listening, so nothing committed from here on is missed
+	if err := t.backfill(ctx); err != nil {
+		return err
+	}
+
+	//good to go, lets listen...
+	t.envelope.Logger.Info("listening for telemetry updates")
+	t.listener.set(telemetrymodels.ListenerConnected, nil)
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("error waiting for notification: %w", err)
+		}
+		if err := t.notify(ctx, notification); err != nil {
+			return err
+		}
+	}
+}
+
+// backfill delivers every change after the last one we delivered
+func (t telemetryStorage) backfill(ctx context.Context) error {
+	//if we were away long enough for retention to delete changes we hadn't delivered, say so rather than pretend the
+	//backfill is complete
+	gap, err := t.skipDeletedChanges(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check the telemetry change feed for a gap: %w", err)
+	}
+	if gap != nil {
+		t.listener.missed(gap)
+		t.envelope.Logger.Errorf("telemetry change feed gap: %s", gap.Error())
+	}
+
+	backfilled, err := t.deliverChanges(ctx)
+	t.listener.backfilled(backfilled)
+	if err != nil {
+		return fmt.Errorf("failed to backfill the telemetry change feed: %w", err)
+	}
+	if backfilled > 0 {
+		t.envelope.Logger.Infof("backfilled %d telemetry rows missed while disconnected", backfilled)
+	}
+	return nil
+}
+
+// notify sends on whatever a notification is about, giving up if ctx is done before it's taken
+func (t telemetryStorage) notify(ctx context.Context, notification *pgconn.Notification) error {
+	switch notification.Channel {
+	//link state changes come one row at a time
+	case "link_state_update":
+		var linkState telemetrymodels.LinkState
+		if err := json.Unmarshal([]byte(notification.Payload), &linkState); err != nil {
+			t.envelope.Logger.Errorf("failed to unmarshal link state update: %s, payload: %s", err.Error(), notification.Payload)
+			return nil
+		}
+		select {
+		case t.linkEvents <- linkState:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+	//so are command state changes, from the uplink and from ingestion verifying them
+	case "command_update":
+		var command commandmodels.Command
+		if err := json.Unmarshal([]byte(notification.Payload), &command); err != nil {
+			t.envelope.Logger.Errorf("failed to unmarshal command update: %s, payload: %s", err.Error(), notification.Payload)
+			return nil
+		}
+		select {
+		case t.commandEvents <- command:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	//telemetry notifications only carry the id of the change; everything since the last change we delivered
+	//is read back from the outbox. If that fails we reconnect, and the backfill picks up where we stopped
+	if _, err := t.deliverChanges(ctx); err != nil {
+		return fmt.Errorf("failed to deliver telemetry changes: %w", err)
+	}
+	return nil
+}
+
+// CheckListener reports whether the LISTEN connection behind RunPostgresListener is up
+func (t telemetryStorage) CheckListener(ctx context.Context) (health.Details, error) {
+	status := t.listener.status()
+	details := health.Details{
+		"state":                 status.State,
+		"since":                 status.Since,
+		"reconnects":            status.Reconnects,
+		"last_delivered_change": t.changes.lastDelivered(),
+		"missed_changes":        status.MissedChanges,
+	}
+	if status.LastError != "" {
+		details["last_error"] = status.LastError
+	}
+	if status.State != telemetrymodels.ListenerConnected {
+		return details, errors.New("postgres listener is not connected")
+	}
+	return details, nil
+}
+
+func (t telemetryStorage) GetListenerStatus(c *fiber.Ctx) error {
+	//tracing
+	ctx, span := t.envelope.Tracer.Start(c.Context(), "GetListenerStatus")
+	defer span.End()
+	t.envelope.LogWithContext(ctx, "GetListenerStatus started")
+
+	var res telemetrymodels.ListenerStatusResponse
+	res.Status = fiber.StatusOK
+	res.Data = t.listener.status()
+	res.Data.LastDeliveredChange = t.changes.lastDelivered()
+
+	return c.JSON(res)
+}
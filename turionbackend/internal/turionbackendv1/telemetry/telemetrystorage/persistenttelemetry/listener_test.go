@@ -0,0 +1,385 @@
+package persistenttelemetry
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"turiontakehome/turionbackend/internal/turionbackendv1/command/commandmodels"
+	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/telemetrymodels"
+	"turiontakehome/turionbackend/utils/envelope"
+)
+
+// fakeChangeFeed is the telemetry_changes outbox in memory. Each change's rows have the change's id as their seq
+// count, and the next telemetry read fails after failAfter rows when it's set
+type fakeChangeFeed struct {
+	mu        sync.Mutex
+	handedOut int64
+	changes   map[int64]int
+	failAfter int
+}
+
+func newFakeChangeFeed() *fakeChangeFeed {
+	return &fakeChangeFeed{changes: make(map[int64]int)}
+}
+
+// insert commits a change of rows rows, giving back its id
+func (f *fakeChangeFeed) insert(rows int) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handedOut++
+	f.changes[f.handedOut] = rows
+	return f.handedOut
+}
+
+// expire deletes every change up to id, the way retention does
+func (f *fakeChangeFeed) expire(id int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for changeID := range f.changes {
+		if changeID <= id {
+			delete(f.changes, changeID)
+		}
+	}
+}
+
+func (f *fakeChangeFeed) latestChange(ctx context.Context) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.handedOut, nil
+}
+
+func (f *fakeChangeFeed) changesAfter(ctx context.Context, last int64, gaps []int64) ([]telemetryChange, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var changes []telemetryChange
+	for id := int64(1); id <= f.handedOut; id++ {
+		if _, ok := f.changes[id]; ok && id > last {
+			changes = append(changes, telemetryChange{id: id})
+		}
+	}
+	return changes, nil
+}
+
+func (f *fakeChangeFeed) nextChange(ctx context.Context, last int64) (bool, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, lastExists := f.changes[last]
+	for id := last + 1; id <= f.handedOut; id++ {
+		if _, ok := f.changes[id]; ok {
+			return lastExists, id, nil
+		}
+	}
+	return lastExists, f.handedOut + 1, nil
+}
+
+func (f *fakeChangeFeed) telemetry(ctx context.Context, change telemetryChange, deliver func(telemetrymodels.Telemetry) error) error {
+	f.mu.Lock()
+	rows := f.changes[change.id]
+	failAfter := f.failAfter
+	f.failAfter = 0
+	f.mu.Unlock()
+
+	for i := 0; i < rows; i++ {
+		if failAfter > 0 && i == failAfter {
+			return errors.New("connection reset")
+		}
+		if err := deliver(telemetrymodels.Telemetry{SeqCount: int(change.id)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newTestStorage(feed changeFeed) telemetryStorage {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return telemetryStorage{
+		envelope:      &envelope.ServiceEnvelope{Logger: logger},
+		events:        make(chan telemetrymodels.Telemetry, 100),
+		linkEvents:    make(chan telemetrymodels.LinkState),
+		commandEvents: make(chan commandmodels.Command),
+		listener:      &listenerState{state: telemetrymodels.ListenerConnecting, since: time.Now()},
+		changes:       newChangeCursor(),
+		feed:          feed,
+	}
+}
+
+// delivered takes the seq counts of everything sent to be broadcast so far
+func delivered(t telemetryStorage) []int {
+	var seqCounts []int
+	for {
+		select {
+		case telemetry := <-t.events:
+			seqCounts = append(seqCounts, telemetry.SeqCount)
+		default:
+			return seqCounts
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBackfillDeliversChangesMissedWhileDisconnected(t *testing.T) {
+	feed := newFakeChangeFeed()
+	storage := newTestStorage(feed)
+	ctx := context.Background()
+
+	//history from before we first connected isn't replayed
+	feed.insert(2)
+	if err := storage.backfill(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if seqCounts := delivered(storage); len(seqCounts) != 0 {
+		t.Fatalf("replayed %v on first connect", seqCounts)
+	}
+
+	//committed while we were away
+	feed.insert(2)
+	feed.insert(1)
+	if err := storage.backfill(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if seqCounts := delivered(storage); !equalInts(seqCounts, []int{2, 2, 3}) {
+		t.Errorf("backfilled %v, expected [2 2 3]", seqCounts)
+	}
+	status := storage.listener.status()
+	if status.BackfilledRows != 3 || status.MissedChanges != 0 {
+		t.Errorf("status %+v, expected 3 backfilled rows and nothing missed", status)
+	}
+	if last := storage.changes.lastDelivered(); last != 3 {
+		t.Errorf("last delivered change %d, expected 3", last)
+	}
+}
+
+func TestBackfillReportsChangesRetentionDeleted(t *testing.T) {
+	feed := newFakeChangeFeed()
+	storage := newTestStorage(feed)
+	ctx := context.Background()
+
+	feed.insert(1)
+	if err := storage.backfill(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	//away long enough for retention to delete the first two changes we hadn't delivered
+	for i := 0; i < 4; i++ {
+		feed.insert(1)
+	}
+	feed.expire(3)
+	if err := storage.backfill(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if seqCounts := delivered(storage); !equalInts(seqCounts, []int{4, 5}) {
+		t.Errorf("backfilled %v, expected what was left, [4 5]", seqCounts)
+	}
+	status := storage.listener.status()
+	if status.MissedChanges != 2 {
+		t.Errorf("counted %d missed changes, expected 2", status.MissedChanges)
+	}
+	if !strings.Contains(status.LastError, "changes 2 to 3 were deleted") {
+		t.Errorf("last error %q doesn't report the gap", status.LastError)
+	}
+
+	//retention catching up with changes we did deliver isn't a gap
+	feed.expire(5)
+	if err := storage.backfill(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if status := storage.listener.status(); status.MissedChanges != 2 {
+		t.Errorf("counted %d missed changes after expiring delivered ones, expected 2", status.MissedChanges)
+	}
+}
+
+func TestBackfillRedeliversAFailedChange(t *testing.T) {
+	feed := newFakeChangeFeed()
+	storage := newTestStorage(feed)
+	ctx := context.Background()
+
+	if err := storage.backfill(ctx); err != nil {
+		t.Fatal(err)
+	}
+	feed.insert(3)
+	feed.failAfter = 2
+	if err := storage.backfill(ctx); err == nil {
+		t.Fatal("expected the backfill to fail")
+	}
+	if last := storage.changes.lastDelivered(); last != 0 {
+		t.Errorf("cursor moved to %d past a change that failed", last)
+	}
+
+	//the reconnect's backfill sends the whole change again
+	if err := storage.backfill(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if seqCounts := delivered(storage); !equalInts(seqCounts, []int{1, 1, 1, 1, 1}) {
+		t.Errorf("delivered %v, expected the first 2 rows and then all 3 again", seqCounts)
+	}
+}
+
+func TestNotifyGivesUpOnceCanceled(t *testing.T) {
+	storage := newTestStorage(newFakeChangeFeed())
+	notifications := []*pgconn.Notification{
+		{Channel: "link_state_update", Payload: `{"spacecraft":"sc1","apid":1,"state":"los"}`},
+		{Channel: "command_update", Payload: `{"id":1,"status":"sent"}`},
+	}
+	for _, notification := range notifications {
+		//nobody is reading the broadcaster's channels
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- storage.notify(ctx, notification)
+		}()
+
+		cancel()
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("%s: got %v, expected the context's error", notification.Channel, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: notify blocked after the context was canceled", notification.Channel)
+		}
+	}
+}
+
+func TestNotifySkipsBadPayloads(t *testing.T) {
+	storage := newTestStorage(newFakeChangeFeed())
+	for _, channel := range []string{"link_state_update", "command_update"} {
+		if err := storage.notify(context.Background(), &pgconn.Notification{Channel: channel, Payload: "{"}); err != nil {
+			t.Errorf("%s: a bad payload dropped the connection: %v", channel, err)
+		}
+	}
+}
+
+func TestNotifyDeliversTelemetryChanges(t *testing.T) {
+	feed := newFakeChangeFeed()
+	storage := newTestStorage(feed)
+	ctx := context.Background()
+	if err := storage.backfill(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	id := feed.insert(2)
+	if err := storage.notify(ctx, &pgconn.Notification{Channel: "telemetry_update", Payload: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if seqCounts := delivered(storage); !equalInts(seqCounts, []int{int(id), int(id)}) {
+		t.Errorf("delivered %v, expected both rows of change %d", seqCounts, id)
+	}
+}
+
+func TestReconnectBacksOffUntilConnected(t *testing.T) {
+	feed := newFakeChangeFeed()
+	storage := newTestStorage(feed)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	//the first attempts fail, then we connect, backfill and wait on notifications
+	var attempts int
+	connected := make(chan struct{})
+	listen := func(ctx context.Context) error {
+		attempts++
+		if attempts <= 3 {
+			return errors.New("connection refused")
+		}
+		if err := storage.backfill(ctx); err != nil {
+			return err
+		}
+		storage.listener.set(telemetrymodels.ListenerConnected, nil)
+		close(connected)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	if err := storage.backfill(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	feed.insert(2)
+
+	done := make(chan struct{})
+	go func() {
+		storage.reconnect(ctx, listen, time.Millisecond, 4*time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("never reconnected")
+	}
+	status := storage.listener.status()
+	if status.State != telemetrymodels.ListenerConnected || status.Reconnects != 3 || status.LastError != "connection refused" {
+		t.Errorf("status %+v, expected connected after 3 reconnects", status)
+	}
+	if seqCounts := delivered(storage); !equalInts(seqCounts, []int{1, 1}) {
+		t.Errorf("backfilled %v after reconnecting, expected [1 1]", seqCounts)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("didn't stop once canceled")
+	}
+	if state := storage.listener.status().State; state != telemetrymodels.ListenerStopped {
+		t.Errorf("state %s, expected stopped", state)
+	}
+}
+
+func TestReconnectStopsDuringBackoff(t *testing.T) {
+	storage := newTestStorage(newFakeChangeFeed())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	failed := make(chan struct{}, 1)
+	listen := func(ctx context.Context) error {
+		failed <- struct{}{}
+		return errors.New("connection refused")
+	}
+	done := make(chan struct{})
+	go func() {
+		storage.reconnect(ctx, listen, time.Hour, time.Hour)
+		close(done)
+	}()
+
+	<-failed
+	waitForState(t, storage, telemetrymodels.ListenerReconnecting)
+	if next := storage.listener.status().NextAttemptAt; next == nil {
+		t.Error("next attempt wasn't reported while backing off")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("kept backing off after being canceled")
+	}
+	if state := storage.listener.status().State; state != telemetrymodels.ListenerStopped {
+		t.Errorf("state %s, expected stopped", state)
+	}
+}
+
+func waitForState(t *testing.T, storage telemetryStorage, state string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for storage.listener.status().State != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("listener never went %s", state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
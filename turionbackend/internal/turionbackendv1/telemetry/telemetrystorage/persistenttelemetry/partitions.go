@@ -0,0 +1,238 @@
+package persistenttelemetry
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/telemetrystorage"
+)
+
+// partitions we manage are named for the range they cover, e.g. telemetry_p20241201_20241202, so we know their bounds
+// without having to parse them back out of the catalog
+const (
+	partitionPrefix     = "telemetry_p"
+	partitionDateFormat = "20060102"
+)
+
+// telemetryPartition covers timestamps from From up to but not including To
+type telemetryPartition struct {
+	name string
+	from time.Time
+	to   time.Time
+}
+
+func newTelemetryPartition(from time.Time, to time.Time) telemetryPartition {
+	from, to = from.UTC(), to.UTC()
+	name := fmt.Sprintf("%s%s_%s", partitionPrefix, from.Format(partitionDateFormat), to.Format(partitionDateFormat))
+	return telemetryPartition{name: name, from: from, to: to}
+}
+
+// parseTelemetryPartition gives back the partition a table name describes, if it's one of ours
+func parseTelemetryPartition(name string) (telemetryPartition, bool) {
+	bounds := strings.Split(strings.TrimPrefix(name, partitionPrefix), "_")
+	if !strings.HasPrefix(name, partitionPrefix) || len(bounds) != 2 {
+		return telemetryPartition{}, false
+	}
+	from, err := time.Parse(partitionDateFormat, bounds[0])
+	if err != nil {
+		return telemetryPartition{}, false
+	}
+	to, err := time.Parse(partitionDateFormat, bounds[1])
+	if err != nil {
+		return telemetryPartition{}, false
+	}
+	return telemetryPartition{name: name, from: from, to: to}, true
+}
+
+func (p telemetryPartition) overlaps(other telemetryPartition) bool {
+	return p.from.Before(other.to) && other.from.Before(p.to)
+}
+
+// periodStart is the start of the partition period a time falls in. Days start at midnight UTC, and since Go's zero
+// time is a Monday, weeks start on Monday
+func periodStart(t time.Time, period time.Duration) time.Time {
+	return t.UTC().Truncate(period)
+}
+
+// RunPartitionMaintenance keeps telemetry partitioned. Every interval it makes sure there are partitions from the
+// current period through the policy's lookahead, moves anything that landed in the default partition into a partition
+// of its own, and detaches expired partitions the archiver has emptied
+func (t telemetryStorage) RunPartitionMaintenance(ctx context.Context, policy telemetrystorage.PartitionPolicy) {
+	t.envelope.Logger.Infof("starting partition maintenance every %v, partitioning by %v", policy.Interval, policy.Period)
+
+	ticker := time.NewTicker(policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := t.maintainPartitions(ctx, policy); err != nil && ctx.Err() == nil {
+			t.envelope.Logger.Errorf("failed to maintain telemetry partitions: %s", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			t.envelope.Logger.Info("stopping partition maintenance")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t telemetryStorage) maintainPartitions(ctx context.Context, policy telemetrystorage.PartitionPolicy) error {
+	now := time.Now().UTC()
+
+	existing, err := t.listPartitions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions: %w", err)
+	}
+
+	//periods that have rows sitting in the default partition, from before the partitions existed or a migration,
+	//as long as they're not so far in the future they're most likely a bad clock
+	var wanted []time.Time
+	rows, err := t.postgresClient.Query(ctx,
+		`SELECT DISTINCT date_trunc('day', timestamp AT TIME ZONE 'UTC') FROM telemetry_default WHERE timestamp < $1`,
+		now.Add(policy.Ahead))
+	if err != nil {
+		return fmt.Errorf("failed to query the default partition: %w", err)
+	}
+	for rows.Next() {
+		var day time.Time
+		if err := rows.Scan(&day); err != nil {
+			rows.Close()
+			return err
+		}
+		wanted = append(wanted, periodStart(time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC), policy.Period))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for start := periodStart(now, policy.Period); start.Before(now.Add(policy.Ahead)); start = start.Add(policy.Period) {
+		wanted = append(wanted, start)
+	}
+
+	sort.Slice(wanted, func(i, j int) bool { return wanted[i].Before(wanted[j]) })
+	for _, start := range wanted {
+		partition := newTelemetryPartition(start, start.Add(policy.Period))
+		if overlapsAny(partition, existing) {
+			continue
+		}
+		if err := t.createPartition(ctx, partition); err != nil {
+			return fmt.Errorf("failed to create partition %s: %w", partition.name, err)
+		}
+		existing = append(existing, partition)
+		t.envelope.Logger.Infof("created telemetry partition %s", partition.name)
+	}
+
+	if policy.Retention <= 0 {
+		return nil
+	}
+	//the archiver works in whole days, so a partition is only expired once the day it ends on has been
+	cutoff := now.Add(-policy.Retention).Truncate(24 * time.Hour)
+	for _, partition := range existing {
+		if partition.to.After(cutoff) {
+			continue
+		}
+		detached, err := t.detachPartition(ctx, partition)
+		if err != nil {
+			return fmt.Errorf("failed to detach partition %s: %w", partition.name, err)
+		}
+		if detached {
+			t.envelope.Logger.Infof("detached expired telemetry partition %s", partition.name)
+		}
+	}
+	return nil
+}
+
+func overlapsAny(partition telemetryPartition, partitions []telemetryPartition) bool {
+	for _, other := range partitions {
+		if partition.overlaps(other) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t telemetryStorage) listPartitions(ctx context.Context) ([]telemetryPartition, error) {
+	rows, err := t.postgresClient.Query(ctx,
+		`SELECT c.relname
+		FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'telemetry'::regclass`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partitions []telemetryPartition
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if partition, ok := parseTelemetryPartition(name); ok {
+			partitions = append(partitions, partition)
+		}
+	}
+	return partitions, rows.Err()
+}
+
+// createPartition builds the partition on its own, moves over any of its rows sitting in the default partition, and
+// then attaches it. Attaching checks that the default partition has nothing left in the range, so the move has to
+// come first
+func (t telemetryStorage) createPartition(ctx context.Context, partition telemetryPartition) error {
+	tx, err := t.postgresClient.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE %s (LIKE telemetry INCLUDING DEFAULTS INCLUDING CONSTRAINTS)`, partition.name),
+		fmt.Sprintf(`WITH moved AS (
+			DELETE FROM telemetry_default WHERE timestamp >= '%[2]s' AND timestamp < '%[3]s' RETURNING *
+		)
+		INSERT INTO %[1]s SELECT * FROM moved`,
+			partition.name, partition.from.Format(time.RFC3339), partition.to.Format(time.RFC3339)),
+		fmt.Sprintf(`ALTER TABLE telemetry ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')`,
+			partition.name, partition.from.Format(time.RFC3339), partition.to.Format(time.RFC3339)),
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// detachPartition detaches and drops an expired partition, but only once the archiver has emptied it. Anything still
+// in it hasn't been archived yet, and is left for a later pass
+func (t telemetryStorage) detachPartition(ctx context.Context, partition telemetryPartition) (bool, error) {
+	tx, err := t.postgresClient.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	//hold off inserts while we check, so nothing lands in it between the check and the detach
+	if _, err := tx.Exec(ctx, fmt.Sprintf(`LOCK TABLE %s IN ACCESS EXCLUSIVE MODE`, partition.name)); err != nil {
+		return false, err
+	}
+
+	var hasRows bool
+	if err := tx.QueryRow(ctx, fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s)`, partition.name)).Scan(&hasRows); err != nil {
+		return false, err
+	}
+	if hasRows {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(ctx, fmt.Sprintf(`ALTER TABLE telemetry DETACH PARTITION %s`, partition.name)); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, fmt.Sprintf(`DROP TABLE %s`, partition.name)); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
@@ -0,0 +1,112 @@
+package persistenttelemetry
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	day  = 24 * time.Hour
+	week = 7 * day
+)
+
+func date(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestTelemetryPartitionNameRoundTrips(t *testing.T) {
+	partition := newTelemetryPartition(date(2024, 12, 1), date(2024, 12, 2))
+	if partition.name != "telemetry_p20241201_20241202" {
+		t.Errorf("named %s", partition.name)
+	}
+	parsed, ok := parseTelemetryPartition(partition.name)
+	if !ok || parsed != partition {
+		t.Errorf("parsed %s as %+v %t", partition.name, parsed, ok)
+	}
+
+	//a partition made from a time in another zone is named and bounded in UTC
+	local := time.FixedZone("UTC+10", 10*60*60)
+	partition = newTelemetryPartition(time.Date(2024, 12, 2, 10, 0, 0, 0, local), time.Date(2024, 12, 3, 10, 0, 0, 0, local))
+	if partition.name != "telemetry_p20241202_20241203" || !partition.from.Equal(date(2024, 12, 2)) {
+		t.Errorf("made %+v", partition)
+	}
+}
+
+func TestParseTelemetryPartitionRejects(t *testing.T) {
+	for _, name := range []string{
+		"telemetry_default",
+		"telemetry_unpartitioned",
+		"telemetry_p20241201",
+		"telemetry_p20241201_20241202_1",
+		"telemetry_p2024121_20241202",
+		"telemetry_p20241301_20241302",
+		"telemetry_pyesterday_today",
+		"other_p20241201_20241202",
+		"telemetry_restored",
+	} {
+		if partition, ok := parseTelemetryPartition(name); ok {
+			t.Errorf("parsed %s as %+v", name, partition)
+		}
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	tests := []struct {
+		at       time.Time
+		period   time.Duration
+		expected time.Time
+	}{
+		{date(2024, 12, 4), day, date(2024, 12, 4)},
+		{time.Date(2024, 12, 4, 23, 59, 59, 999999999, time.UTC), day, date(2024, 12, 4)},
+		{time.Date(2024, 12, 5, 1, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60)), day, date(2024, 12, 4)},
+		//2024-12-02 is a Monday
+		{date(2024, 12, 2), week, date(2024, 12, 2)},
+		{time.Date(2024, 12, 4, 12, 0, 0, 0, time.UTC), week, date(2024, 12, 2)},
+		{time.Date(2024, 12, 8, 23, 59, 59, 0, time.UTC), week, date(2024, 12, 2)},
+		{date(2024, 12, 9), week, date(2024, 12, 9)},
+		//weeks run across the end of the year and leap days like any other
+		{date(2025, 1, 1), week, date(2024, 12, 30)},
+		{date(2024, 2, 29), week, date(2024, 2, 26)},
+	}
+	for _, test := range tests {
+		got := periodStart(test.at, test.period)
+		if !got.Equal(test.expected) {
+			t.Errorf("periodStart(%v, %v) = %v, expected %v", test.at, test.period, got, test.expected)
+		}
+		if test.period == week && got.Weekday() != time.Monday {
+			t.Errorf("week of %v starts on a %v", test.at, got.Weekday())
+		}
+	}
+}
+
+func TestOverlapsAny(t *testing.T) {
+	existing := []telemetryPartition{
+		newTelemetryPartition(date(2024, 12, 1), date(2024, 12, 2)),
+		newTelemetryPartition(date(2024, 12, 2), date(2024, 12, 3)),
+		newTelemetryPartition(date(2024, 12, 9), date(2024, 12, 16)),
+	}
+	tests := []struct {
+		partition telemetryPartition
+		overlaps  bool
+	}{
+		{newTelemetryPartition(date(2024, 12, 1), date(2024, 12, 2)), true},
+		//partitions only share a bound, they don't overlap
+		{newTelemetryPartition(date(2024, 12, 3), date(2024, 12, 4)), false},
+		{newTelemetryPartition(date(2024, 11, 30), date(2024, 12, 1)), false},
+		{newTelemetryPartition(date(2024, 12, 16), date(2024, 12, 23)), false},
+		//switching from days to weeks, a week overlapping day partitions isn't created over them
+		{newTelemetryPartition(date(2024, 11, 25), date(2024, 12, 2)), true},
+		{newTelemetryPartition(date(2024, 12, 2), date(2024, 12, 9)), true},
+		//and switching back, no day inside an existing week
+		{newTelemetryPartition(date(2024, 12, 12), date(2024, 12, 13)), true},
+		{newTelemetryPartition(date(2024, 12, 15), date(2024, 12, 16)), true},
+	}
+	for _, test := range tests {
+		if got := overlapsAny(test.partition, existing); got != test.overlaps {
+			t.Errorf("%s overlaps existing partitions: got %t, expected %t", test.partition.name, got, test.overlaps)
+		}
+	}
+	if overlapsAny(existing[0], nil) {
+		t.Error("overlaps with no partitions at all")
+	}
+}
@@ -1,14 +1,13 @@
 package persistenttelemetry
 
 import (
-	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"time"
 	"turiontakehome/telemetryingestion/pkg/anomaly"
+	"turiontakehome/turionbackend/internal/turionbackendv1/command/commandmodels"
 	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/telemetrymodels"
 	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/telemetrystorage"
 	"turiontakehome/turionbackend/utils/envelope"
@@ -20,11 +19,17 @@ type telemetryStorage struct {
 	postgresDatabase  string
 	envelope          *envelope.ServiceEnvelope
 	events            chan telemetrymodels.Telemetry
+	linkEvents        chan telemetrymodels.LinkState
+	commandEvents     chan commandmodels.Command
 	validAggregations map[string]struct{}
 	validMetrics      map[string]struct{}
+	listener          *listenerState
+	archiveDir        string
+	changes           *changeCursor
+	feed              changeFeed
 }
 
-func New(dbClient *pgxpool.Pool, postgresDatabase string, telemetryEnvelope *envelope.ServiceEnvelope, eventsChan chan telemetrymodels.Telemetry) telemetrystorage.TelemetryBackendStorage {
+func New(dbClient *pgxpool.Pool, postgresDatabase string, telemetryEnvelope *envelope.ServiceEnvelope, eventsChan chan telemetrymodels.Telemetry, linkEventsChan chan telemetrymodels.LinkState, commandEventsChan chan commandmodels.Command, archiveDir string) telemetrystorage.TelemetryBackendStorage {
 	aggregations := telemetry.ValidAggregates()
 	metrics := telemetry.ValidMetrics()
 
@@ -33,8 +38,14 @@ func New(dbClient *pgxpool.Pool, postgresDatabase string, telemetryEnvelope *env
 		postgresDatabase:  postgresDatabase,
 		envelope:          telemetryEnvelope,
 		events:            eventsChan,
+		linkEvents:        linkEventsChan,
+		commandEvents:     commandEventsChan,
 		validAggregations: aggregations,
 		validMetrics:      metrics,
+		listener:          &listenerState{state: telemetrymodels.ListenerConnecting, since: time.Now()},
+		archiveDir:        archiveDir,
+		changes:           newChangeCursor(),
+		feed:              postgresChangeFeed{postgresClient: dbClient},
 	}
 }
 
@@ -79,8 +90,9 @@ func (t telemetryStorage) GetTelemetry(c *fiber.Ctx) error {
 
 	// Query the database using pgxpool
 	rows, err := t.postgresClient.Query(c.Context(),
-		`SELECT id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal, anomaly_flags
-		FROM telemetry
+		`SELECT id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal, anomaly_flags,
+		received_at, ground_station, ingest_instance, created_at
+		FROM telemetry_history
 		WHERE timestamp >= $1 AND timestamp <= $2
 		ORDER BY timestamp ASC`,
 		startTime, endTime)
@@ -101,7 +113,7 @@ func (t telemetryStorage) GetTelemetry(c *fiber.Ctx) error {
 		err := rows.Scan(
 			&telemetry.ID, &telemetry.Timestamp, &telemetry.PacketID, &telemetry.SeqFlags, &telemetry.SeqCount,
 			&telemetry.SubsystemID, &telemetry.Temperature, &telemetry.Battery, &telemetry.Altitude, &telemetry.Signal,
-			&anomalyFlags)
+			&anomalyFlags, &telemetry.ReceivedAt, &telemetry.GroundStation, &telemetry.IngestInstance, &telemetry.CreatedAt)
 		if err != nil {
 			res.Status = fiber.StatusInternalServerError
 			res.Message = fmt.Sprintf("failed to scan telemetry data %s", err.Error())
@@ -131,12 +143,13 @@ func (t telemetryStorage) GetCurrentTelemetry(c *fiber.Ctx) error {
 	// Query the latest telemetry data
 	var telem telemetrymodels.Telemetry
 	err := t.postgresClient.QueryRow(c.Context(),
-		`SELECT id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal, anomaly_flags
+		`SELECT id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal, anomaly_flags,
+		received_at, ground_station, ingest_instance, created_at
 		FROM telemetry
 		ORDER BY timestamp DESC LIMIT 1`).Scan(
 		&telem.ID, &telem.Timestamp, &telem.PacketID, &telem.SeqFlags, &telem.SeqCount,
 		&telem.SubsystemID, &telem.Temperature, &telem.Battery, &telem.Altitude, &telem.Signal,
-		&anomalyFlags)
+		&anomalyFlags, &telem.ReceivedAt, &telem.GroundStation, &telem.IngestInstance, &telem.CreatedAt)
 
 	if err != nil {
 		res.Status = fiber.StatusInternalServerError
@@ -160,7 +173,7 @@ func (t telemetryStorage) GetAnomalies(c *fiber.Ctx) error {
 	defer span.End()
 	t.envelope.LogWithContext(ctx, "GetAnomalies started")
 
-	var req telemetrymodels.TelemetryRequest
+	var req telemetrymodels.TelemetryAnomaliesRequest
 	var res telemetrymodels.TelemetryResponse
 	if err := c.QueryParser(&req); err != nil {
 		res.Status = fiber.StatusBadRequest
@@ -189,13 +202,18 @@ func (t telemetryStorage) GetAnomalies(c *fiber.Ctx) error {
 		return c.JSON(res)
 	}
 
-	// Query the telemetry data with anomalies
-	rows, err := t.postgresClient.Query(c.Context(),
-		`SELECT id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal, anomaly_flags
-		FROM telemetry
+	// Query the telemetry data with anomalies, the most recent first so a limit keeps the latest ones
+	query := `SELECT id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal, anomaly_flags,
+		received_at, ground_station, ingest_instance, created_at
+		FROM telemetry_history
 		WHERE anomaly_flags > 0 AND timestamp >= $1 AND timestamp <= $2
-		ORDER BY timestamp ASC`,
-		startTime, endTime)
+		ORDER BY timestamp DESC`
+	args := []interface{}{startTime, endTime}
+	if req.Limit > 0 {
+		query += ` LIMIT $3`
+		args = append(args, req.Limit)
+	}
+	rows, err := t.postgresClient.Query(c.Context(), `SELECT * FROM (`+query+`) anomalies ORDER BY timestamp ASC`, args...)
 
 	if err != nil {
 		res.Status = fiber.StatusInternalServerError
@@ -214,13 +232,14 @@ func (t telemetryStorage) GetAnomalies(c *fiber.Ctx) error {
 		err := rows.Scan(
 			&anomalousTelemetry.ID, &anomalousTelemetry.Timestamp, &anomalousTelemetry.PacketID, &anomalousTelemetry.SeqFlags, &anomalousTelemetry.SeqCount,
 			&anomalousTelemetry.SubsystemID, &anomalousTelemetry.Temperature, &anomalousTelemetry.Battery, &anomalousTelemetry.Altitude, &anomalousTelemetry.Signal,
-			&anomalyFlags)
+			&anomalyFlags, &anomalousTelemetry.ReceivedAt, &anomalousTelemetry.GroundStation, &anomalousTelemetry.IngestInstance, &anomalousTelemetry.CreatedAt)
 		if err != nil {
 			res.Status = fiber.StatusInternalServerError
 			res.Message = fmt.Sprintf("failed to scan anomaly data %s", err.Error())
 			span.RecordError(errors.New(res.Message))
 			return c.JSON(res)
 		}
+		anomalousTelemetry.Anomalies = anomaly.DecodeAnomalies(anomalyFlags)
 
 		anomaliesList = append(anomaliesList, anomalousTelemetry)
 	}
@@ -304,54 +323,124 @@ func (t telemetryStorage) GetAggregations(c *fiber.Ctx) error {
 	return c.JSON(res)
 }
 
-func (t telemetryStorage) RunPostgresListener(ctx context.Context) {
-	t.envelope.Logger.Info("starting postgres listener")
+func (t telemetryStorage) GetLatency(c *fiber.Ctx) error {
+	//tracing
+	ctx, span := t.envelope.Tracer.Start(c.Context(), "GetLatency")
+	defer span.End()
+	t.envelope.LogWithContext(ctx, "GetLatency started")
+
+	var req telemetrymodels.TelemetryRequest
+	var res telemetrymodels.TelemetryLatencyResponse
+	if err := c.QueryParser(&req); err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid query parameters %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	startTimeStr, _ := req.StartTime.MarshalText()
+	endTimeStr, _ := req.EndTime.MarshalText()
+
+	// Parse the times from the query parameters
+	startTime, err := time.Parse(time.RFC3339, string(startTimeStr))
+	if err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid start_time format. Use ISO8601 %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	endTime, err := time.Parse(time.RFC3339, string(endTimeStr))
+	if err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid end_time format. Use ISO8601 %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	// latency is the ground receive time minus the onboard time; a negative offset means the onboard clock is ahead of ours
+	rows, err := t.postgresClient.Query(c.Context(),
+		`SELECT ground_station, count(*),
+			min(latency_ms), max(latency_ms), avg(latency_ms),
+			percentile_cont(0.5) WITHIN GROUP (ORDER BY latency_ms),
+			percentile_cont(0.95) WITHIN GROUP (ORDER BY latency_ms),
+			coalesce(stddev_samp(latency_ms), 0),
+			count(*) FILTER (WHERE latency_ms < 0)
+		FROM (
+			SELECT ground_station, EXTRACT(EPOCH FROM (received_at - timestamp)) * 1000 AS latency_ms
+			FROM telemetry
+			WHERE timestamp >= $1 AND timestamp <= $2
+		) latencies
+		GROUP BY ground_station
+		ORDER BY ground_station ASC`,
+		startTime, endTime)
 
-	//get a postgres client from the pool
-	conn, err := t.postgresClient.Acquire(ctx)
 	if err != nil {
-		t.envelope.Logger.Errorf("failed to acquire postgres connection: %s", err.Error())
-		return
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to query latency data %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
 	}
-	//defer the release
-	defer conn.Release()
+	defer rows.Close()
+
+	var latencyList []telemetrymodels.LatencyStats
+	for rows.Next() {
+		var stats telemetrymodels.LatencyStats
+		err := rows.Scan(
+			&stats.GroundStation, &stats.Count, &stats.MinLatencyMs, &stats.MaxLatencyMs, &stats.AvgLatencyMs,
+			&stats.P50LatencyMs, &stats.P95LatencyMs, &stats.JitterMs, &stats.FutureTimestamps)
+		if err != nil {
+			res.Status = fiber.StatusInternalServerError
+			res.Message = fmt.Sprintf("failed to scan latency data %s", err.Error())
+			span.RecordError(errors.New(res.Message))
+			return c.JSON(res)
+		}
+		//the least delayed packet is the closest we get to the raw clock offset between the spacecraft and the ground
+		stats.ClockSkewMs = stats.MinLatencyMs
+		latencyList = append(latencyList, stats)
+	}
+
+	res.Status = fiber.StatusOK
+	res.Data = latencyList
+
+	return c.JSON(res)
+}
+
+func (t telemetryStorage) GetLinkState(c *fiber.Ctx) error {
+	//tracing
+	ctx, span := t.envelope.Tracer.Start(c.Context(), "GetLinkState")
+	defer span.End()
+	t.envelope.LogWithContext(ctx, "GetLinkState started")
+
+	var res telemetrymodels.LinkStateResponse
 
-	//check for telemetry_update
-	_, err = conn.Exec(ctx, "LISTEN telemetry_update")
+	rows, err := t.postgresClient.Query(c.Context(),
+		`SELECT spacecraft, apid, ingest_instance, state, last_packet_at, changed_at
+		FROM link_state
+		ORDER BY spacecraft ASC, apid ASC, ingest_instance ASC`)
 	if err != nil {
-		t.envelope.Logger.Errorf("failed to acquire telemetry update: %s", err.Error())
-		return
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to query link state %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
 	}
+	defer rows.Close()
 
-	//good to go, lets listen...
-	t.envelope.Logger.Info("listening for telemetry updates")
-
-	//select setup for ctx.Done() and default case
-	for {
-		select {
-		case <-ctx.Done():
-			t.envelope.Logger.Info("stopping postgres listener")
-			return
-		default:
-			notification, err := conn.Conn().WaitForNotification(ctx)
-			if err != nil {
-				t.envelope.Logger.Errorf("error waiting for notification: %s", err.Error())
-				continue
-			}
-			t.envelope.Logger.Infof("received notification: %+v", notification)
-
-			//we're getting a batch payload from postgres notify, so we'll want to unmarshal to a slice
-			var notificationRows []telemetrymodels.Telemetry
-			if err := json.Unmarshal([]byte(notification.Payload), &notificationRows); err != nil {
-				t.envelope.Logger.Errorf("failed to unmarshal telemetry update: %s, payload: %s", err.Error(), notification.Payload)
-				continue
-			}
-
-			//send it to be broadcasted
-			for _, row := range notificationRows {
-				t.envelope.Logger.Infof("Telemetry Row: %+v", row)
-				t.events <- row
-			}
+	var linkStates []telemetrymodels.LinkState
+	for rows.Next() {
+		var linkState telemetrymodels.LinkState
+		err := rows.Scan(&linkState.Spacecraft, &linkState.APID, &linkState.IngestInstance, &linkState.State, &linkState.LastPacketAt, &linkState.ChangedAt)
+		if err != nil {
+			res.Status = fiber.StatusInternalServerError
+			res.Message = fmt.Sprintf("failed to scan link state %s", err.Error())
+			span.RecordError(errors.New(res.Message))
+			return c.JSON(res)
 		}
+		linkStates = append(linkStates, linkState)
 	}
+
+	res.Status = fiber.StatusOK
+	res.Data = linkStates
+
+	return c.JSON(res)
 }
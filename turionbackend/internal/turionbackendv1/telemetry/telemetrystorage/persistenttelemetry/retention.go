@@ -0,0 +1,342 @@
+package persistenttelemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"path/filepath"
+	"time"
+	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/archive"
+	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/telemetrymodels"
+	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/telemetrystorage"
+)
+
+// restoreBatchSize is how many archived rows go to postgres at a time on restore
+const restoreBatchSize = 1000
+
+// RunRetention enforces the retention policy every interval. Raw telemetry is archived a day at a time before it's
+// deleted; everything else can be rebuilt or isn't worth keeping, so it's just deleted
+func (t telemetryStorage) RunRetention(ctx context.Context, policy telemetrystorage.RetentionPolicy) {
+	t.envelope.Logger.Infof("starting retention every %v, archiving to %s", policy.Interval, t.archiveDir)
+
+	ticker := time.NewTicker(policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		t.applyRetention(ctx, policy)
+
+		select {
+		case <-ctx.Done():
+			t.envelope.Logger.Info("stopping retention")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t telemetryStorage) applyRetention(ctx context.Context, policy telemetrystorage.RetentionPolicy) {
+	now := time.Now().UTC()
+
+	if policy.Raw > 0 {
+		if err := t.archiveRaw(ctx, now.Add(-policy.Raw)); err != nil && ctx.Err() == nil {
+			t.envelope.Logger.Errorf("failed to archive telemetry: %s", err.Error())
+		}
+	}
+
+	expirations := []struct {
+		table     string
+		column    string
+		retention time.Duration
+	}{
+		{"telemetry_rollup_1m", "bucket", policy.Rollup1m},
+		{"telemetry_rollup_1h", "bucket", policy.Rollup1h},
+		{"telemetry_rollup_1d", "bucket", policy.Rollup1d},
+		{"rejected_packets", "received_at", policy.Rejected},
+		{"telemetry_restored", "restored_at", policy.Restored},
+		{"telemetry_changes", "created_at", policy.Changes},
+	}
+	for _, expiration := range expirations {
+		if expiration.retention <= 0 {
+			continue
+		}
+		query := fmt.Sprintf(`DELETE FROM %s WHERE %s < $1`, expiration.table, expiration.column)
+		tag, err := t.postgresClient.Exec(ctx, query, now.Add(-expiration.retention))
+		if err != nil {
+			if ctx.Err() == nil {
+				t.envelope.Logger.Errorf("failed to expire %s: %s", expiration.table, err.Error())
+			}
+			continue
+		}
+		if tag.RowsAffected() > 0 {
+			t.envelope.Logger.Infof("expired %d rows from %s", tag.RowsAffected(), expiration.table)
+		}
+	}
+}
+
+// archiveRaw archives and deletes raw telemetry older than the cutoff, one whole UTC day at a time so each archive
+// covers exactly one day
+func (t telemetryStorage) archiveRaw(ctx context.Context, cutoff time.Time) error {
+	cutoff = cutoff.Truncate(24 * time.Hour)
+
+	for ctx.Err() == nil {
+		var oldest *time.Time
+		err := t.postgresClient.QueryRow(ctx, `SELECT min(timestamp) FROM telemetry WHERE timestamp < $1`, cutoff).Scan(&oldest)
+		if err != nil {
+			return err
+		}
+		if oldest == nil {
+			return nil
+		}
+
+		day := oldest.UTC().Truncate(24 * time.Hour)
+		if err := t.archiveDay(ctx, day, day.Add(24*time.Hour)); err != nil {
+			return fmt.Errorf("archiving %s: %w", day.Format("2006-01-02"), err)
+		}
+	}
+	return ctx.Err()
+}
+
+// archiveDay exports a day of telemetry and then deletes it, all from one snapshot so we only ever delete rows that
+// made it into the archive. The archive is on disk before the delete commits; if the commit fails the rows are
+// archived again on the next pass and a restore skips the duplicates
+func (t telemetryStorage) archiveDay(ctx context.Context, from time.Time, to time.Time) error {
+	tx, err := t.postgresClient.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var count int
+	err = tx.QueryRow(ctx, `SELECT count(*) FROM telemetry WHERE timestamp >= $1 AND timestamp < $2`, from, to).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	writer, err := archive.Create(t.archiveDir, archive.Header{Table: "telemetry", From: from, To: to, Count: count})
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(ctx,
+		`SELECT id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal, anomaly_flags,
+		received_at, ground_station, ingest_instance, created_at
+		FROM telemetry
+		WHERE timestamp >= $1 AND timestamp < $2
+		ORDER BY timestamp ASC, id ASC`,
+		from, to)
+	if err != nil {
+		writer.Abort()
+		return err
+	}
+	for rows.Next() {
+		var row archive.Row
+		err := rows.Scan(&row.ID, &row.Timestamp, &row.PacketID, &row.SeqFlags, &row.SeqCount, &row.SubsystemID,
+			&row.Temperature, &row.Battery, &row.Altitude, &row.Signal, &row.AnomalyFlags,
+			&row.ReceivedAt, &row.GroundStation, &row.IngestInstance, &row.CreatedAt)
+		if err == nil {
+			err = writer.Write(row)
+		}
+		if err != nil {
+			rows.Close()
+			writer.Abort()
+			return err
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		writer.Abort()
+		return err
+	}
+
+	path, err := writer.Close()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM telemetry WHERE timestamp >= $1 AND timestamp < $2`, from, to); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	t.envelope.Logger.Infof("archived %d telemetry rows to %s", count, path)
+	return nil
+}
+
+func (t telemetryStorage) GetArchives(c *fiber.Ctx) error {
+	//tracing
+	ctx, span := t.envelope.Tracer.Start(c.Context(), "GetArchives")
+	defer span.End()
+	t.envelope.LogWithContext(ctx, "GetArchives started")
+
+	var res telemetrymodels.ArchiveListResponse
+
+	infos, err := archive.List(t.archiveDir)
+	if err != nil {
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to list archives %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	var archives []telemetrymodels.Archive
+	for _, info := range infos {
+		archives = append(archives, telemetrymodels.Archive{
+			File:      info.File,
+			SizeBytes: info.SizeBytes,
+			Table:     info.Header.Table,
+			From:      info.Header.From,
+			To:        info.Header.To,
+			Count:     info.Header.Count,
+			CreatedAt: info.Header.CreatedAt,
+		})
+	}
+
+	res.Status = fiber.StatusOK
+	res.Data = archives
+
+	return c.JSON(res)
+}
+
+// RestoreArchives loads the archived telemetry in a time range into telemetry_restored, where the history endpoints
+// serve it from alongside live telemetry until the restored retention runs out
+func (t telemetryStorage) RestoreArchives(c *fiber.Ctx) error {
+	//tracing
+	ctx, span := t.envelope.Tracer.Start(c.Context(), "RestoreArchives")
+	defer span.End()
+	t.envelope.LogWithContext(ctx, "RestoreArchives started")
+
+	var req telemetrymodels.TelemetryRequest
+	var res telemetrymodels.ArchiveRestoreResponse
+	if err := c.QueryParser(&req); err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid query parameters %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	startTimeStr, _ := req.StartTime.MarshalText()
+	endTimeStr, _ := req.EndTime.MarshalText()
+
+	// Parse the times from the query parameters
+	startTime, err := time.Parse(time.RFC3339, string(startTimeStr))
+	if err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid start_time format. Use ISO8601 %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	endTime, err := time.Parse(time.RFC3339, string(endTimeStr))
+	if err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid end_time format. Use ISO8601 %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	restored, files, err := t.restore(c.Context(), startTime, endTime)
+	if err != nil {
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to restore archives %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	res.Status = fiber.StatusOK
+	res.Count = restored
+	res.Files = files
+
+	return c.JSON(res)
+}
+
+func (t telemetryStorage) restore(ctx context.Context, startTime time.Time, endTime time.Time) (int64, []string, error) {
+	tx, err := t.postgresClient.Begin(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var restored int64
+	batch := &pgx.Batch{}
+	flush := func() error {
+		results := tx.SendBatch(ctx, batch)
+		for i := 0; i < batch.Len(); i++ {
+			tag, err := results.Exec()
+			if err != nil {
+				results.Close()
+				return err
+			}
+			restored += tag.RowsAffected()
+		}
+		batch = &pgx.Batch{}
+		return results.Close()
+	}
+
+	files, err := readArchived(t.archiveDir, startTime, endTime, func(row archive.Row) error {
+		batch.Queue(
+			`INSERT INTO telemetry_restored (id, timestamp, packet_id, seq_flags, seq_count, subsystem_id, temperature, battery, altitude, signal, anomaly_flags,
+			received_at, ground_station, ingest_instance, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+			ON CONFLICT (id, timestamp) DO NOTHING`,
+			row.ID, row.Timestamp, row.PacketID, row.SeqFlags, row.SeqCount, row.SubsystemID,
+			row.Temperature, row.Battery, row.Altitude, row.Signal, row.AnomalyFlags,
+			row.ReceivedAt, row.GroundStation, row.IngestInstance, row.CreatedAt)
+		if batch.Len() >= restoreBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := flush(); err != nil {
+		return 0, nil, err
+	}
+
+	//anything that's still live doesn't need restoring, and would show up twice in history. Only rows this restore
+	//inserted come out of its count: they're the ones restored_at the transaction's start
+	tag, err := tx.Exec(ctx, `DELETE FROM telemetry_restored r USING telemetry t
+		WHERE r.id = t.id AND r.timestamp = t.timestamp AND r.timestamp BETWEEN $1 AND $2 AND r.restored_at = now()`,
+		startTime, endTime)
+	if err != nil {
+		return 0, nil, err
+	}
+	restored -= tag.RowsAffected()
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, nil, err
+	}
+	return restored, files, nil
+}
+
+// readArchived calls fn with every archived telemetry row from startTime to endTime, giving back the archives they
+// came from
+func readArchived(dir string, startTime time.Time, endTime time.Time, fn func(archive.Row) error) ([]string, error) {
+	infos, err := archive.List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, info := range infos {
+		if info.Header.Table != "telemetry" || !info.Header.To.After(startTime) || info.Header.From.After(endTime) {
+			continue
+		}
+		files = append(files, info.File)
+
+		_, err := archive.Read(filepath.Join(dir, info.File), func(row archive.Row) error {
+			if row.Timestamp.Before(startTime) || row.Timestamp.After(endTime) {
+				return nil
+			}
+			return fn(row)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
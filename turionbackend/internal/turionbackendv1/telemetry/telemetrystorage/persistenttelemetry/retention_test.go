@@ -0,0 +1,74 @@
+package persistenttelemetry
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/archive"
+)
+
+// archiveDays writes an archive for each day from first, with a row every six hours, the way archiveDay does
+func archiveDays(t *testing.T, dir string, table string, first time.Time, days int) {
+	t.Helper()
+	id := int64(0)
+	for d := 0; d < days; d++ {
+		from := first.AddDate(0, 0, d)
+		writer, err := archive.Create(dir, archive.Header{Table: table, From: from, To: from.AddDate(0, 0, 1), Count: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for h := 0; h < 24; h += 6 {
+			id++
+			if err := writer.Write(archive.Row{ID: id, Timestamp: from.Add(time.Duration(h) * time.Hour)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadArchived(t *testing.T) {
+	dir := t.TempDir()
+	first := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	archiveDays(t, dir, "telemetry", first, 3)
+	archiveDays(t, dir, "link_events", first, 1)
+
+	//from the middle of the first day to the start of the second
+	start, end := first.Add(12*time.Hour), first.Add(24*time.Hour)
+	var ids []int64
+	files, err := readArchived(dir, start, end, func(row archive.Row) error {
+		if row.Timestamp.Before(start) || row.Timestamp.After(end) {
+			t.Errorf("restored row %d from %v, outside the range", row.ID, row.Timestamp)
+		}
+		ids = append(ids, row.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] != "telemetry-2026-01-01.ndjson.gz" || files[1] != "telemetry-2026-01-02.ndjson.gz" {
+		t.Errorf("restored from %v", files)
+	}
+	//12:00 and 18:00 on the first day, and midnight on the second
+	if len(ids) != 3 || ids[0] != 3 || ids[1] != 4 || ids[2] != 5 {
+		t.Errorf("restored rows %v, expected 3, 4 and 5", ids)
+	}
+}
+
+func TestReadArchivedStopsOnError(t *testing.T) {
+	dir := t.TempDir()
+	first := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	archiveDays(t, dir, "telemetry", first, 2)
+
+	failed := errors.New("batch failed")
+	calls := 0
+	_, err := readArchived(dir, first, first.AddDate(0, 0, 2), func(archive.Row) error {
+		calls++
+		return failed
+	})
+	if !errors.Is(err, failed) || calls != 1 {
+		t.Errorf("got %v after %d rows, expected to stop on the first", err, calls)
+	}
+}
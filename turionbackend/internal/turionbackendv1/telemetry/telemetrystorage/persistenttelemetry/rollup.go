@@ -0,0 +1,314 @@
+package persistenttelemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"strings"
+	"time"
+	"turiontakehome/turionbackend/internal/turionbackendv1/telemetry/telemetrymodels"
+	"turiontakehome/turionbackend/utils/telemetry"
+)
+
+// rawResolution is served straight from the telemetry table
+const rawResolution = "raw"
+
+// defaultMaxPoints is the point budget for a series request that doesn't give one
+const defaultMaxPoints = 1000
+
+// rollupResolution is one level of rollups. Each level is built from the one below it, the finest from raw telemetry
+type rollupResolution struct {
+	name   string
+	table  string
+	width  time.Duration
+	source string
+}
+
+// rollupResolutions goes from finest to coarsest, which is the order they're both built and considered for a series
+var rollupResolutions = []rollupResolution{
+	{name: "1m", table: "telemetry_rollup_1m", width: time.Minute, source: "telemetry"},
+	{name: "1h", table: "telemetry_rollup_1h", width: time.Hour, source: "telemetry_rollup_1m"},
+	{name: "1d", table: "telemetry_rollup_1d", width: 24 * time.Hour, source: "telemetry_rollup_1h"},
+}
+
+// upsertQuery recomputes every bucket at or after $1 from the source table, $2 being the bucket width in seconds
+func (r rollupResolution) upsertQuery() string {
+	fromRaw := r.source == "telemetry"
+
+	timeColumn := "bucket"
+	selects := []string{"", "sum(count)", "sum(anomaly_count)"}
+	if fromRaw {
+		timeColumn = "timestamp"
+		selects = []string{"", "count(*)", "count(*) FILTER (WHERE anomaly_flags > 0)"}
+	}
+	selects[0] = fmt.Sprintf("to_timestamp(floor(extract(epoch FROM %s)::double precision / $2) * $2)", timeColumn)
+
+	columns := []string{"bucket", "count", "anomaly_count"}
+	for _, metric := range telemetry.GetMetrics() {
+		columns = append(columns, metric+"_min", metric+"_max", metric+"_sum")
+		if fromRaw {
+			//sum of a real is a real, which loses precision quickly over a day of packets
+			selects = append(selects, "min("+metric+")", "max("+metric+")", "sum("+metric+"::double precision)")
+		} else {
+			selects = append(selects, "min("+metric+"_min)", "max("+metric+"_max)", "sum("+metric+"_sum)")
+		}
+	}
+
+	var updates []string
+	for _, column := range columns[1:] {
+		updates = append(updates, column+" = EXCLUDED."+column)
+	}
+
+	return fmt.Sprintf(`INSERT INTO %s (%s)
+		SELECT %s
+		FROM %s
+		WHERE %s >= $1
+		GROUP BY 1
+		ON CONFLICT (bucket) DO UPDATE SET %s`,
+		r.table, strings.Join(columns, ", "), strings.Join(selects, ", "), r.source, timeColumn, strings.Join(updates, ", "))
+}
+
+// RunRollups keeps the rollup tables up to date. Every interval each resolution recomputes its buckets from its
+// watermark, less the allowed lateness, onwards. Telemetry that turns up later than that isn't rolled up
+func (t telemetryStorage) RunRollups(ctx context.Context, interval time.Duration, lateness time.Duration) {
+	t.envelope.Logger.Infof("starting telemetry rollups every %v, allowing %v lateness", interval, lateness)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		//finest first, so each coarser rollup sees what was just rolled up beneath it
+		for _, resolution := range rollupResolutions {
+			if err := t.rollup(ctx, resolution, lateness); err != nil {
+				if ctx.Err() == nil {
+					t.envelope.Logger.Errorf("failed to roll up %s telemetry: %s", resolution.name, err.Error())
+				}
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			t.envelope.Logger.Info("stopping telemetry rollups")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t telemetryStorage) rollup(ctx context.Context, resolution rollupResolution, lateness time.Duration) error {
+	now := time.Now().UTC()
+
+	tx, err := t.postgresClient.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	//with no watermark yet we backfill everything
+	from := time.Unix(0, 0).UTC()
+	var rolledUpTo time.Time
+	err = tx.QueryRow(ctx, `SELECT rolled_up_to FROM telemetry_rollup_watermarks WHERE resolution = $1`, resolution.name).Scan(&rolledUpTo)
+	switch {
+	case err == nil:
+		from = rolledUpTo.Add(-lateness).Truncate(resolution.width)
+	case !errors.Is(err, pgx.ErrNoRows):
+		return fmt.Errorf("failed to read watermark: %w", err)
+	}
+
+	if _, err = tx.Exec(ctx, resolution.upsertQuery(), from, resolution.width.Seconds()); err != nil {
+		return fmt.Errorf("failed to upsert rollups: %w", err)
+	}
+
+	//the bucket we're in now is still filling, so it's where the next pass picks up
+	_, err = tx.Exec(ctx,
+		`INSERT INTO telemetry_rollup_watermarks (resolution, rolled_up_to) VALUES ($1, $2)
+		ON CONFLICT (resolution) DO UPDATE SET rolled_up_to = EXCLUDED.rolled_up_to`,
+		resolution.name, now.Truncate(resolution.width))
+	if err != nil {
+		return fmt.Errorf("failed to update watermark: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (t telemetryStorage) GetSeries(c *fiber.Ctx) error {
+	//tracing
+	ctx, span := t.envelope.Tracer.Start(c.Context(), "GetSeries")
+	defer span.End()
+	t.envelope.LogWithContext(ctx, "GetSeries started")
+
+	var req telemetrymodels.TelemetrySeriesRequest
+	var res telemetrymodels.TelemetrySeriesResponse
+	if err := c.QueryParser(&req); err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid query parameters %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	if req.MaxPoints < 0 {
+		res.Status = fiber.StatusBadRequest
+		res.Message = "max_points must be positive"
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+	if req.MaxPoints == 0 {
+		req.MaxPoints = defaultMaxPoints
+	}
+
+	startTimeStr, _ := req.StartTime.MarshalText()
+	endTimeStr, _ := req.EndTime.MarshalText()
+
+	// Parse the times from the query parameters
+	startTime, err := time.Parse(time.RFC3339, string(startTimeStr))
+	if err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid start_time format. Use ISO8601 %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	endTime, err := time.Parse(time.RFC3339, string(endTimeStr))
+	if err != nil {
+		res.Status = fiber.StatusBadRequest
+		res.Message = fmt.Sprintf("invalid end_time format. Use ISO8601 %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	resolution, err := t.selectResolution(c.Context(), startTime, endTime, req.MaxPoints)
+	if err != nil {
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to select a resolution %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	var series []telemetrymodels.SeriesPoint
+	if resolution.name == rawResolution {
+		series, err = t.rawSeries(c.Context(), startTime, endTime)
+	} else {
+		series, err = t.rollupSeries(c.Context(), resolution, startTime, endTime)
+	}
+	if err != nil {
+		res.Status = fiber.StatusInternalServerError
+		res.Message = fmt.Sprintf("failed to query telemetry series %s", err.Error())
+		span.RecordError(errors.New(res.Message))
+		return c.JSON(res)
+	}
+
+	res.Status = fiber.StatusOK
+	res.Resolution = resolution.name
+	res.Count = len(series)
+	res.Data = series
+
+	return c.JSON(res)
+}
+
+// selectResolution picks the finest resolution whose points in the range fit in the budget, falling back to the
+// coarsest. Points are only counted up to one past the budget, so a long range never has to scan all of raw telemetry
+func (t telemetryStorage) selectResolution(ctx context.Context, startTime time.Time, endTime time.Time, maxPoints int) (rollupResolution, error) {
+	return pickResolution(startTime, endTime, maxPoints, func(candidate rollupResolution, timeColumn string, from time.Time, limit int) (int, error) {
+		var points int
+		query := fmt.Sprintf(`SELECT count(*) FROM (SELECT 1 FROM %s WHERE %s >= $1 AND %s <= $2 LIMIT $3) points`,
+			candidate.table, timeColumn, timeColumn)
+		err := t.postgresClient.QueryRow(ctx, query, from, endTime, limit).Scan(&points)
+		return points, err
+	})
+}
+
+// pickResolution is selectResolution with countPoints counting, up to limit, a candidate's points from from to the
+// end of the range
+func pickResolution(startTime time.Time, endTime time.Time, maxPoints int,
+	countPoints func(candidate rollupResolution, timeColumn string, from time.Time, limit int) (int, error)) (rollupResolution, error) {
+	candidates := append([]rollupResolution{{name: rawResolution, table: "telemetry_history"}}, rollupResolutions...)
+
+	for _, candidate := range candidates {
+		timeColumn, from := "bucket", startTime.Truncate(candidate.width)
+		if candidate.name == rawResolution {
+			timeColumn, from = "timestamp", startTime
+		}
+
+		points, err := countPoints(candidate, timeColumn, from, maxPoints+1)
+		if err != nil {
+			return rollupResolution{}, err
+		}
+		if points <= maxPoints {
+			return candidate, nil
+		}
+	}
+	return rollupResolutions[len(rollupResolutions)-1], nil
+}
+
+func (t telemetryStorage) rawSeries(ctx context.Context, startTime time.Time, endTime time.Time) ([]telemetrymodels.SeriesPoint, error) {
+	rows, err := t.postgresClient.Query(ctx,
+		`SELECT timestamp, anomaly_flags, temperature, battery, altitude, signal
+		FROM telemetry_history
+		WHERE timestamp >= $1 AND timestamp <= $2
+		ORDER BY timestamp ASC`,
+		startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []telemetrymodels.SeriesPoint
+	for rows.Next() {
+		var timestamp time.Time
+		var anomalyFlags uint32
+		var temperature, battery, altitude, signal float64
+		if err := rows.Scan(&timestamp, &anomalyFlags, &temperature, &battery, &altitude, &signal); err != nil {
+			return nil, err
+		}
+
+		point := telemetrymodels.SeriesPoint{
+			Timestamp:   timestamp,
+			Count:       1,
+			Temperature: telemetrymodels.MetricSummary{Min: temperature, Max: temperature, Avg: temperature},
+			Battery:     telemetrymodels.MetricSummary{Min: battery, Max: battery, Avg: battery},
+			Altitude:    telemetrymodels.MetricSummary{Min: altitude, Max: altitude, Avg: altitude},
+			Signal:      telemetrymodels.MetricSummary{Min: signal, Max: signal, Avg: signal},
+		}
+		if anomalyFlags > 0 {
+			point.AnomalyCount = 1
+		}
+		series = append(series, point)
+	}
+	return series, rows.Err()
+}
+
+func (t telemetryStorage) rollupSeries(ctx context.Context, resolution rollupResolution, startTime time.Time, endTime time.Time) ([]telemetrymodels.SeriesPoint, error) {
+	//the bucket the start time falls in is included
+	query := fmt.Sprintf(`SELECT bucket, count, anomaly_count,
+		temperature_min, temperature_max, temperature_sum / count,
+		battery_min, battery_max, battery_sum / count,
+		altitude_min, altitude_max, altitude_sum / count,
+		signal_min, signal_max, signal_sum / count
+		FROM %s
+		WHERE bucket >= $1 AND bucket <= $2
+		ORDER BY bucket ASC`, resolution.table)
+
+	rows, err := t.postgresClient.Query(ctx, query, startTime.Truncate(resolution.width), endTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []telemetrymodels.SeriesPoint
+	for rows.Next() {
+		var point telemetrymodels.SeriesPoint
+		err := rows.Scan(&point.Timestamp, &point.Count, &point.AnomalyCount,
+			&point.Temperature.Min, &point.Temperature.Max, &point.Temperature.Avg,
+			&point.Battery.Min, &point.Battery.Max, &point.Battery.Avg,
+			&point.Altitude.Min, &point.Altitude.Max, &point.Altitude.Avg,
+			&point.Signal.Min, &point.Signal.Max, &point.Signal.Avg)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, point)
+	}
+	return series, rows.Err()
+}
@@ -0,0 +1,95 @@
+package persistenttelemetry
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// denseTelemetry counts points as if there were a packet every second, so every bucket of every rollup has data
+func denseTelemetry(endTime time.Time) func(rollupResolution, string, time.Time, int) (int, error) {
+	return func(candidate rollupResolution, timeColumn string, from time.Time, limit int) (int, error) {
+		width := candidate.width
+		if candidate.name == rawResolution {
+			width = time.Second
+		}
+		points := int(endTime.Sub(from)/width) + 1
+		if points > limit {
+			points = limit
+		}
+		return points, nil
+	}
+}
+
+func TestPickResolution(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		span      time.Duration
+		maxPoints int
+		expected  string
+	}{
+		{10 * time.Minute, 1000, "raw"},
+		{time.Hour, 1000, "1m"},
+		{time.Hour, 3601, "raw"},
+		{time.Hour, 60, "1h"},
+		//the range starts part way into a minute, so an hour of minutes is 61 buckets
+		{time.Hour, 61, "1m"},
+		{24 * time.Hour, 1000, "1h"},
+		{30 * 24 * time.Hour, 1000, "1h"},
+		{30 * 24 * time.Hour, 720, "1d"},
+		{60 * 24 * time.Hour, 1000, "1d"},
+		//nothing fits, so it's the coarsest there is
+		{5 * 365 * 24 * time.Hour, 1000, "1d"},
+		{time.Hour, 1, "1d"},
+	}
+	for _, test := range tests {
+		end := start.Add(test.span)
+		resolution, err := pickResolution(start, end, test.maxPoints, denseTelemetry(end))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resolution.name != test.expected {
+			t.Errorf("%v with a budget of %d: picked %s, expected %s", test.span, test.maxPoints, resolution.name, test.expected)
+		}
+	}
+}
+
+func TestPickResolutionCountsFromTheBucketStart(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var columns []string
+	var froms []time.Time
+	_, err := pickResolution(start, start.Add(time.Hour), 0, func(candidate rollupResolution, timeColumn string, from time.Time, limit int) (int, error) {
+		if limit != 1 {
+			t.Errorf("counted up to %d points, expected one past the budget", limit)
+		}
+		columns, froms = append(columns, timeColumn), append(froms, from)
+		return limit, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []time.Time{start, time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC), time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC),
+		time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)}
+	if len(froms) != len(expected) {
+		t.Fatalf("counted %d resolutions, expected %d", len(froms), len(expected))
+	}
+	for i := range expected {
+		if !froms[i].Equal(expected[i]) {
+			t.Errorf("counted resolution %d from %v, expected %v", i, froms[i], expected[i])
+		}
+	}
+	if columns[0] != "timestamp" || columns[1] != "bucket" {
+		t.Errorf("counted on %v", columns)
+	}
+}
+
+func TestPickResolutionFails(t *testing.T) {
+	failed := errors.New("query failed")
+	start := time.Now()
+	_, err := pickResolution(start, start.Add(time.Hour), 1000, func(rollupResolution, string, time.Time, int) (int, error) {
+		return 0, failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("got %v, expected the count's error", err)
+	}
+}
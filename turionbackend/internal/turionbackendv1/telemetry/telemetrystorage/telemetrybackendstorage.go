@@ -3,6 +3,8 @@ package telemetrystorage
 import (
 	"context"
 	"github.com/gofiber/fiber/v2"
+	"time"
+	"turiontakehome/telemetryingestion/pkg/health"
 )
 
 type TelemetryBackendStorage interface {
@@ -14,5 +16,36 @@ type telemetryStorage interface {
 	GetCurrentTelemetry(c *fiber.Ctx) error
 	GetAnomalies(c *fiber.Ctx) error
 	GetAggregations(c *fiber.Ctx) error
+	GetLatency(c *fiber.Ctx) error
+	GetLinkState(c *fiber.Ctx) error
+	GetSeries(c *fiber.Ctx) error
+	GetArchives(c *fiber.Ctx) error
+	RestoreArchives(c *fiber.Ctx) error
+	GetListenerStatus(c *fiber.Ctx) error
 	RunPostgresListener(ctx context.Context)
+	RunRollups(ctx context.Context, interval time.Duration, lateness time.Duration)
+	RunRetention(ctx context.Context, policy RetentionPolicy)
+	RunPartitionMaintenance(ctx context.Context, policy PartitionPolicy)
+	CheckListener(ctx context.Context) (health.Details, error)
+}
+
+// RetentionPolicy is how long each table keeps its rows. A retention of zero keeps them forever
+type RetentionPolicy struct {
+	Interval time.Duration
+	Raw      time.Duration
+	Rollup1m time.Duration
+	Rollup1h time.Duration
+	Rollup1d time.Duration
+	Rejected time.Duration
+	Restored time.Duration
+	Changes  time.Duration
+}
+
+// PartitionPolicy is how the telemetry table is partitioned and how far ahead partitions are made. Partitions that
+// end before the retention are detached once they've been archived; a retention of zero keeps them forever
+type PartitionPolicy struct {
+	Interval  time.Duration
+	Period    time.Duration
+	Ahead     time.Duration
+	Retention time.Duration
 }